@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	datagen "core-infra-project/internal/data"
+	kclient "core-infra-project/internal/kafka"
+	extSort "core-infra-project/internal/sort"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+func runBench(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: kss bench disk|kafka [flags]")
+	}
+	switch args[0] {
+	case "disk":
+		return benchDisk(args[1:])
+	case "kafka":
+		return benchKafka(args[1:])
+	default:
+		return fmt.Errorf("unknown bench target %q", args[0])
+	}
+}
+
+// benchDisk runs the spill I/O micro-benchmark against a directory so slow
+// volumes are detected before a real sort run.
+func benchDisk(args []string) error {
+	fs := flag.NewFlagSet("bench disk", flag.ExitOnError)
+	dir := fs.String("dir", os.TempDir(), "directory on the spill volume to benchmark")
+	records := fs.Int("records", 1_000_000, "number of synthetic records to write and read back")
+	fsyncs := fs.Int("fsyncs", 100, "number of 4KB write+fsync samples for latency measurement")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	fmt.Printf("[Bench] Generating %d synthetic records...\n", *records)
+	recs := make([][]byte, *records)
+	for i := range recs {
+		recs[i] = datagen.GenerateRandomRecord()
+	}
+
+	fmt.Printf("[Bench] Benchmarking spill volume at %s...\n", *dir)
+	res, err := extSort.BenchmarkDisk(*dir, recs, *fsyncs)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\n[Summary] Disk benchmark (%s)\n", *dir)
+	fmt.Printf("  - Data: %d records, %.1f MB\n", res.Records, float64(res.Bytes)/(1024*1024))
+	fmt.Printf("  - Sequential write: %.1f MB/sec (write %v + fsync %v)\n", res.WriteMBps(), res.WriteDuration, res.SyncDuration)
+	fmt.Printf("  - Sequential read: %.1f MB/sec (%v, likely served from page cache)\n", res.ReadMBps(), res.ReadDuration)
+	if res.FsyncSamples > 0 {
+		fmt.Printf("  - Fsync latency: avg %v, max %v over %d samples\n", res.FsyncAvg, res.FsyncMax, res.FsyncSamples)
+	}
+	return nil
+}
+
+// benchKafka produces and consumes synthetic messages through the same writer and
+// reader configs as the pipeline, reporting broker throughput and end-to-end latency.
+func benchKafka(args []string) error {
+	fs := flag.NewFlagSet("bench kafka", flag.ExitOnError)
+	brokers := fs.String("brokers", getenv("KAFKA_BROKERS", "kafka:9092"), "Kafka bootstrap broker")
+	topic := fs.String("topic", "kss-bench", "topic used for the round trip")
+	messages := fs.Int("messages", 100_000, "number of messages to produce and consume")
+	idle := fs.Duration("idle-timeout", 10*time.Second, "stop consuming after this long without a message")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// Tag every message with a run prefix so records from earlier runs on the same topic are ignored
+	runID := strconv.FormatInt(time.Now().UnixNano(), 10)
+	prefix := []byte("bench-" + runID + ",")
+
+	reader := kclient.NewReader([]string{*brokers}, *topic, "kss-bench-"+runID)
+	defer reader.Close()
+	writer := kclient.NewWriter([]string{*brokers}, *topic)
+
+	type consumeResult struct {
+		received  int
+		bytes     int64
+		latencies []time.Duration
+		first     time.Time
+		last      time.Time
+		err       error
+	}
+	done := make(chan consumeResult, 1)
+	go func() {
+		var res consumeResult
+		res.latencies = make([]time.Duration, 0, *messages)
+		for res.received < *messages {
+			ctx, cancel := context.WithTimeout(context.Background(), *idle)
+			msg, err := reader.ReadMessage(ctx)
+			cancel()
+			if err != nil {
+				res.err = err
+				break
+			}
+			if !bytes.HasPrefix(msg.Value, prefix) {
+				continue
+			}
+			now := time.Now()
+			rest := msg.Value[len(prefix):]
+			if i := bytes.IndexByte(rest, ','); i > 0 {
+				if sentNs, err := strconv.ParseInt(string(rest[:i]), 10, 64); err == nil {
+					res.latencies = append(res.latencies, now.Sub(time.Unix(0, sentNs)))
+				}
+			}
+			if res.received == 0 {
+				res.first = now
+			}
+			res.last = now
+			res.received++
+			res.bytes += int64(len(msg.Value))
+		}
+		done <- res
+	}()
+
+	fmt.Printf("[Bench] Producing %d messages to %s on %s...\n", *messages, *topic, *brokers)
+	ctx := context.Background()
+	produceStart := time.Now()
+	var producedBytes int64
+	batch := make([]gokafka.Message, 0, 1000)
+	for sent := 0; sent < *messages; {
+		batch = batch[:0]
+		for len(batch) < cap(batch) && sent < *messages {
+			val := append([]byte(nil), prefix...)
+			val = strconv.AppendInt(val, time.Now().UnixNano(), 10)
+			val = append(val, ',')
+			val = append(val, datagen.GenerateRandomRecord()...)
+			producedBytes += int64(len(val))
+			batch = append(batch, gokafka.Message{Value: val})
+			sent++
+		}
+		if err := writer.WriteMessages(ctx, batch...); err != nil {
+			writer.Close()
+			return fmt.Errorf("produce: %w", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	produceDuration := time.Since(produceStart)
+
+	res := <-done
+
+	fmt.Printf("\n[Summary] Kafka round-trip benchmark (%s)\n", *topic)
+	fmt.Printf("  - Produced: %d messages, %.1f MB in %v (%.0f msgs/sec)\n",
+		*messages, float64(producedBytes)/(1024*1024), produceDuration, float64(*messages)/produceDuration.Seconds())
+	consumeDuration := res.last.Sub(produceStart)
+	if res.received > 0 && consumeDuration > 0 {
+		fmt.Printf("  - Consumed: %d messages, %.1f MB in %v (%.0f msgs/sec)\n",
+			res.received, float64(res.bytes)/(1024*1024), consumeDuration, float64(res.received)/consumeDuration.Seconds())
+	} else {
+		fmt.Printf("  - Consumed: 0 messages\n")
+	}
+	if n := len(res.latencies); n > 0 {
+		sort.Slice(res.latencies, func(i, j int) bool { return res.latencies[i] < res.latencies[j] })
+		fmt.Printf("  - End-to-end latency: p50 %v, p99 %v, max %v\n",
+			res.latencies[n/2], res.latencies[n*99/100], res.latencies[n-1])
+	}
+	if res.received < *messages {
+		return fmt.Errorf("consumed %d of %d messages before idle timeout: %v", res.received, *messages, res.err)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	kmetrics "core-infra-project/internal/metrics"
+)
+
+// runDashboards writes Grafana dashboards wired to the metrics the producer (:6060)
+// and the sorters (:6061-6064) serve at /metrics, for import into Grafana.
+func runDashboards(args []string) error {
+	if len(args) < 1 || args[0] != "export" {
+		return fmt.Errorf("usage: kss dashboards export [--out kss.json] [--datasource prometheus] [--title kafka-stream-sorter]")
+	}
+	fs := flag.NewFlagSet("dashboards export", flag.ExitOnError)
+	out := fs.String("out", "-", "file to write the dashboard JSON to, - for stdout")
+	datasource := fs.String("datasource", "prometheus", "uid of the Prometheus data source scraping the binaries")
+	title := fs.String("title", "kafka-stream-sorter", "dashboard title")
+	window := fs.String("rate-window", "1m", "range of the rate() queries; at least twice the scrape interval")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(dashboard(*title, *datasource, *window), "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if *out == "-" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	if err := os.WriteFile(*out, b, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("[Dashboards] Wrote %s (%s) to %s; import it in Grafana under Dashboards > New > Import\n", *title, *datasource, *out)
+	return nil
+}
+
+// Grafana dashboard JSON, the subset the exported dashboard uses.
+type (
+	grafanaDashboard struct {
+		Title         string           `json:"title"`
+		UID           string           `json:"uid"`
+		Tags          []string         `json:"tags"`
+		Timezone      string           `json:"timezone"`
+		Refresh       string           `json:"refresh"`
+		Time          grafanaRange     `json:"time"`
+		SchemaVersion int              `json:"schemaVersion"`
+		Templating    grafanaVariables `json:"templating"`
+		Panels        []grafanaPanel   `json:"panels"`
+	}
+	grafanaRange struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	grafanaVariables struct {
+		List []grafanaVariable `json:"list"`
+	}
+	grafanaVariable struct {
+		Name       string            `json:"name"`
+		Label      string            `json:"label"`
+		Type       string            `json:"type"`
+		Datasource grafanaDatasource `json:"datasource"`
+		Query      string            `json:"query"`
+		Refresh    int               `json:"refresh"` // 2: on time range change
+		IncludeAll bool              `json:"includeAll"`
+		AllValue   string            `json:"allValue"`
+		Multi      bool              `json:"multi"`
+	}
+	grafanaDatasource struct {
+		Type string `json:"type"`
+		UID  string `json:"uid"`
+	}
+	grafanaPanel struct {
+		ID          int               `json:"id"`
+		Title       string            `json:"title"`
+		Description string            `json:"description,omitempty"`
+		Type        string            `json:"type"`
+		Datasource  grafanaDatasource `json:"datasource"`
+		GridPos     grafanaGridPos    `json:"gridPos"`
+		FieldConfig grafanaFields     `json:"fieldConfig"`
+		Targets     []grafanaTarget   `json:"targets"`
+	}
+	grafanaGridPos struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+		W int `json:"w"`
+		H int `json:"h"`
+	}
+	grafanaFields struct {
+		Defaults struct {
+			Unit string `json:"unit,omitempty"`
+		} `json:"defaults"`
+		Overrides []any `json:"overrides"`
+	}
+	grafanaTarget struct {
+		RefID        string `json:"refId"`
+		Expr         string `json:"expr"`
+		LegendFormat string `json:"legendFormat"`
+	}
+)
+
+// dashboard lays out the pipeline's panels two to a row: producer, sorter progress and
+// lag, then sorter phases and spill.
+func dashboard(title, datasource, window string) grafanaDashboard {
+	ds := grafanaDatasource{Type: "prometheus", UID: datasource}
+	producer := `{run_id=~"$run_id"}`
+	sorter := `{run_id=~"$sort_run_id"}`
+	rate := func(metric, sel string) string { return fmt.Sprintf("rate(%s%s[%s])", metric, sel, window) }
+
+	d := grafanaDashboard{
+		Title:         title,
+		UID:           "kss-pipeline",
+		Tags:          []string{"kafka-stream-sorter"},
+		Timezone:      "browser",
+		Refresh:       "10s",
+		Time:          grafanaRange{From: "now-1h", To: "now"},
+		SchemaVersion: 39,
+	}
+	variable := func(name, label, metric string) grafanaVariable {
+		return grafanaVariable{
+			Name: name, Label: label, Type: "query", Datasource: ds,
+			Query: fmt.Sprintf("label_values(%s, run_id)", metric), Refresh: 2,
+			IncludeAll: true, AllValue: ".*", Multi: true,
+		}
+	}
+	d.Templating.List = []grafanaVariable{
+		variable("run_id", "Producer run", kmetrics.ProducerWritten),
+		variable("sort_run_id", "Sorter run", kmetrics.SorterRecordsRead),
+	}
+
+	add := func(title, description, kind, unit string, targets ...grafanaTarget) {
+		n := len(d.Panels)
+		p := grafanaPanel{
+			ID: n + 1, Title: title, Description: description, Type: kind, Datasource: ds,
+			GridPos: grafanaGridPos{X: 12 * (n % 2), Y: 8 * (n / 2), W: 12, H: 8},
+			Targets: targets,
+		}
+		p.FieldConfig.Defaults.Unit = unit
+		p.FieldConfig.Overrides = []any{}
+		for i := range p.Targets {
+			p.Targets[i].RefID = string(rune('A' + i))
+		}
+		d.Panels = append(d.Panels, p)
+	}
+
+	add("Producer throughput", "Records acknowledged by Kafka per second.", "timeseries", "rps",
+		grafanaTarget{Expr: fmt.Sprintf("sum by (run_id) (%s%s)", kmetrics.ProducerThroughput, producer), LegendFormat: "{{run_id}} (10s gauge)"},
+		grafanaTarget{Expr: fmt.Sprintf("sum by (run_id) (%s)", rate(kmetrics.ProducerWritten, producer)), LegendFormat: "{{run_id}} written"},
+		grafanaTarget{Expr: fmt.Sprintf("sum by (run_id) (%s)", rate(kmetrics.ProducerGenerated, producer)), LegendFormat: "{{run_id}} generated"})
+	add("Producer errors", "Failed writes (retries included), records undelivered after retries and records spooled.", "timeseries", "rps",
+		grafanaTarget{Expr: fmt.Sprintf("sum by (run_id) (%s)", rate(kmetrics.ProducerWriteErrors, producer)), LegendFormat: "{{run_id}} write errors"},
+		grafanaTarget{Expr: fmt.Sprintf("sum by (run_id) (%s)", rate(kmetrics.ProducerFailed, producer)), LegendFormat: "{{run_id}} failed"},
+		grafanaTarget{Expr: fmt.Sprintf("sum by (run_id) (%s)", rate(kmetrics.ProducerSpooled, producer)), LegendFormat: "{{run_id}} spooled"})
+	add("Producer queues", "Fill of the queues between producer stages. A full queue waits on the stage after it: full records and batches queues point at Kafka writes, an empty records queue at generation.", "timeseries", "percentunit",
+		grafanaTarget{Expr: fmt.Sprintf("%s%s / %s%s", kmetrics.ProducerQueueLength, producer, kmetrics.ProducerQueueCap, producer), LegendFormat: "{{run_id}} {{queue}}"})
+	add("Sorter throughput", "Records read by the chunk phase and written by the merge, per sort key.", "timeseries", "rps",
+		grafanaTarget{Expr: fmt.Sprintf("sum by (key) (%s)", rate(kmetrics.SorterRecordsRead, sorter)), LegendFormat: "{{key}} read"},
+		grafanaTarget{Expr: fmt.Sprintf("sum by (key) (%s)", rate(kmetrics.SorterRecordsMerged, sorter)), LegendFormat: "{{key}} merged"})
+	add("Sorter lag", "Records the producer has written that each sorter has yet to read. Written counts once per SOURCE_TOPICS topic, so this assumes a single source topic.", "timeseries", "short",
+		grafanaTarget{Expr: fmt.Sprintf("scalar(sum(%s%s)) - sum by (key) (%s%s)", kmetrics.ProducerWritten, producer, kmetrics.SorterRecordsRead, sorter), LegendFormat: "{{key}}"})
+	add("Sorter phase", "The phase each sorter is in.", "state-timeline", "",
+		grafanaTarget{Expr: fmt.Sprintf("max by (key, phase) (%s%s) == 1", kmetrics.SorterPhase, sorter), LegendFormat: "{{key}} {{phase}}"})
+	add("Phase durations", "Duration of each sorter's last completed chunk and merge phases.", "bargauge", "s",
+		grafanaTarget{Expr: fmt.Sprintf("max by (key, phase) (%s%s)", kmetrics.SorterPhaseDuration, sorter), LegendFormat: "{{key}} {{phase}}"})
+	add("Spill bytes", "Bytes spilled to chunks in the current chunk phase, before and after spill compression.", "timeseries", "bytes",
+		grafanaTarget{Expr: fmt.Sprintf("sum by (key) (%s%s)", kmetrics.SorterSpillRawBytes, sorter), LegendFormat: "{{key}} raw"},
+		grafanaTarget{Expr: fmt.Sprintf("sum by (key) (%s%s)", kmetrics.SorterSpillDiskBytes, sorter), LegendFormat: "{{key}} on disk"})
+	add("Spill rate", "Spill bytes written per second, after compression.", "timeseries", "Bps",
+		grafanaTarget{Expr: fmt.Sprintf("sum by (key) (%s)", rate(kmetrics.SorterSpillDiskBytes, sorter)), LegendFormat: "{{key}}"})
+	return d
+}
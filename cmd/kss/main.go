@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: kss <command> [flags]
+
+commands:
+  bench disk         measure spill volume write/read throughput and fsync latency
+  bench kafka        produce and consume synthetic messages with the pipeline's client configs
+  dashboards export  write a Grafana dashboard for the producer and sorter /metrics
+  diff               merge two sorted generations of a dataset into a stream of added, removed and changed records
+  merge              k-way merge already-sorted inputs (chunk dirs, files, kafka:<topic>) into a topic or part files
+  offsets export     write a consumer group's committed offsets on a topic to a file
+  offsets import     seed a new consumer group from an exported offsets file
+  produce            regenerate a dataset from the descriptor a producer --descriptor run wrote
+  verify             check a sorted topic's order from a sample, with a confidence bound`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "bench":
+		err = runBench(os.Args[2:])
+	case "dashboards":
+		err = runDashboards(os.Args[2:])
+	case "diff":
+		err = runDiff(os.Args[2:])
+	case "merge":
+		err = runMerge(os.Args[2:])
+	case "offsets":
+		err = runOffsets(os.Args[2:])
+	case "produce":
+		err = runProduce(os.Args[2:])
+	case "verify":
+		err = runVerify(os.Args[2:])
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+		return
+	default:
+		fmt.Printf("unknown command %q\n\n%s\n", os.Args[1], usage)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
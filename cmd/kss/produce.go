@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	datagen "core-infra-project/internal/data"
+	kclient "core-infra-project/internal/kafka"
+)
+
+// runProduce regenerates the dataset a producer run described with --descriptor, by
+// running the producer with the descriptor's settings. The descriptor comes from a
+// file or, as kafka:<topic>, from the latest one published for that topic. Arguments
+// after the flags are passed on to the producer (e.g. --writers 4).
+func runProduce(args []string) error {
+	fs := flag.NewFlagSet("produce", flag.ExitOnError)
+	from := fs.String("from-descriptor", "", "dataset descriptor file, or kafka:<topic> for the latest one published for topic")
+	topic := fs.String("topic", "", "topic to write the dataset to (default: the descriptor's)")
+	brokers := fs.String("brokers", getenv("KAFKA_BROKERS", "kafka:9092"), "Kafka bootstrap broker")
+	producerBin := fs.String("producer-bin", "", "producer binary (default: producer next to kss, else on PATH)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" {
+		return fmt.Errorf("--from-descriptor is required")
+	}
+
+	var d *datagen.Descriptor
+	var err error
+	if base, ok := strings.CutPrefix(*from, "kafka:"); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		var b []byte
+		b, err = kclient.LatestDataset(ctx, []string{*brokers}, base)
+		cancel()
+		if err == nil {
+			d, err = datagen.ParseDescriptor(b)
+		}
+	} else {
+		d, err = datagen.ReadDescriptor(*from)
+	}
+	if err != nil {
+		return err
+	}
+	if *topic == "" {
+		*topic = d.Topic
+	}
+
+	producerArgs := []string{
+		"--records", strconv.FormatInt(d.Records, 10),
+		"--seed", strconv.FormatInt(d.Seed, 10),
+		"--format", d.Format,
+		"--distribution", d.Distribution,
+	}
+	if d.StartID > 0 {
+		producerArgs = append(producerArgs, "--start-id", strconv.FormatInt(d.StartID, 10))
+	}
+	if d.Template != "" {
+		producerArgs = append(producerArgs, "--template", d.Template)
+	}
+	if d.MalformedPercent > 0 {
+		producerArgs = append(producerArgs, "--malformed-percent", strconv.FormatFloat(d.MalformedPercent, 'g', -1, 64))
+	}
+	if d.ContinentWeights != "" {
+		producerArgs = append(producerArgs, "--continent-weights", d.ContinentWeights)
+	}
+	if d.DuplicatePercent > 0 {
+		producerArgs = append(producerArgs, "--duplicate-percent", strconv.FormatFloat(d.DuplicatePercent, 'g', -1, 64))
+	}
+	if d.KeyByID {
+		producerArgs = append(producerArgs, "--key-by-id")
+	}
+	if len(d.Schema) > 0 {
+		schema, err := os.CreateTemp("", "kss-dataset-*.avsc")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(schema.Name())
+		_, err = schema.Write(d.Schema)
+		if cerr := schema.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		producerArgs = append(producerArgs, "--schema", schema.Name())
+	}
+	producerArgs = append(producerArgs, fs.Args()...)
+
+	bin, err := findProducer(*producerBin)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[Produce] Regenerating dataset of run %s (%s) into %s: %s %s\n",
+		d.RunID, d.CreatedAt.Format(time.RFC3339), *topic, bin, strings.Join(producerArgs, " "))
+	cmd := exec.Command(bin, producerArgs...)
+	// SOURCE_TOPICS would fan the records out elsewhere
+	cmd.Env = append(os.Environ(), "SOURCE_TOPIC="+*topic, "SOURCE_TOPICS=", "KAFKA_BROKERS="+*brokers)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", bin, err)
+	}
+	return nil
+}
+
+// findProducer returns bin if set, else the producer binary next to this one, else
+// the one on PATH.
+func findProducer(bin string) (string, error) {
+	if bin != "" {
+		return bin, nil
+	}
+	if self, err := os.Executable(); err == nil {
+		sibling := filepath.Join(filepath.Dir(self), "producer")
+		if _, err := os.Stat(sibling); err == nil {
+			return sibling, nil
+		}
+	}
+	path, err := exec.LookPath("producer")
+	if err != nil {
+		return "", fmt.Errorf("producer binary not found next to kss or on PATH; pass --producer-bin")
+	}
+	return path, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"core-infra-project/internal/fastnum"
+	kclient "core-infra-project/internal/kafka"
+)
+
+// runVerify checks the order of a sorted topic from a uniform sample instead of a
+// full re-read. Each sampled record is compared with the one after it, and the
+// sampled records with each other in offset order: with no inversion among n pairs,
+// the fraction of out-of-order adjacent pairs is below 1-(1-confidence)^(1/n) at that
+// confidence, and disorder spanning many records (e.g. unmerged chunks) shows up in
+// the sampled sequence itself.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	topic := fs.String("topic", "", "sorted topic to check")
+	key := fs.String("key", "id", "sort key the topic is ordered by: id, name or continent")
+	brokers := fs.String("brokers", getenv("KAFKA_BROKERS", "kafka:9092"), "Kafka bootstrap broker")
+	confidence := fs.Float64("confidence", 0.99, "confidence of the bound on out-of-order pairs")
+	defectRate := fs.Float64("max-defect-rate", 0.001, "fraction of out-of-order adjacent pairs the sample must rule out; sets the sample size")
+	sampleRate := fs.Float64("sample-rate", 0, "sample this fraction of the topic's pairs instead, and report the bound it reaches")
+	ranges := fs.Bool("ranges", false, "the topic is range-partitioned (--range-partitions), so keys also ascend from each partition to the next")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "sample seed (printed, so a failure can be reproduced)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	sortIdx, ok := map[string]int{"id": 0, "name": 1, "continent": 3}[*key]
+	if !ok {
+		return fmt.Errorf("invalid --key %q; must be id, name, or continent", *key)
+	}
+	switch {
+	case *topic == "":
+		return fmt.Errorf("usage: kss verify --topic sorted_id [--key id|name|continent] [--confidence 0.99 --max-defect-rate 0.001 | --sample-rate 0.0001]")
+	case *confidence <= 0 || *confidence >= 1:
+		return fmt.Errorf("--confidence must be in (0, 1)")
+	case *defectRate <= 0 || *defectRate >= 1:
+		return fmt.Errorf("--max-defect-rate must be in (0, 1)")
+	case *sampleRate < 0 || *sampleRate > 1:
+		return fmt.Errorf("--sample-rate must be in [0, 1]")
+	}
+
+	size := func(pairs int64) int {
+		var n float64
+		if *sampleRate > 0 {
+			n = math.Ceil(*sampleRate * float64(pairs))
+		} else {
+			// The smallest n with (1-rate)^n <= 1-confidence
+			n = math.Ceil(math.Log(1-*confidence) / math.Log(1-*defectRate))
+		}
+		n = min(n, float64(pairs))
+		fmt.Printf("[Verify] Sampling %.0f of %d adjacent pairs in %s (seed %d)...\n", n, pairs, *topic, *seed)
+		return int(n)
+	}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	pairs, total, err := kclient.SamplePairs(ctx, []string{*brokers}, *topic, size, rand.New(rand.NewSource(*seed)))
+	if err != nil {
+		return err
+	}
+	if len(pairs) == 0 {
+		return fmt.Errorf("%s has no adjacent records to check", *topic)
+	}
+
+	for i, p := range pairs {
+		if p.Next != nil && compareKeys(p.Value, p.Next, sortIdx) > 0 {
+			return fmt.Errorf("%s/%d offset %d is out of order (seed %d): key %q sorts after the next record's %q",
+				*topic, p.Partition, p.Offset, *seed, keyField(p.Value, sortIdx), keyField(p.Next, sortIdx))
+		}
+		if i == 0 || (pairs[i-1].Partition != p.Partition && !*ranges) {
+			continue
+		}
+		if prev := pairs[i-1]; compareKeys(prev.Value, p.Value, sortIdx) > 0 {
+			return fmt.Errorf("%s/%d offset %d is out of order (seed %d): key %q sorts before %q at %s/%d offset %d",
+				*topic, p.Partition, p.Offset, *seed, keyField(p.Value, sortIdx), keyField(prev.Value, sortIdx), *topic, prev.Partition, prev.Offset)
+		}
+	}
+
+	bound := 1 - math.Pow(1-*confidence, 1/float64(len(pairs)))
+	if int64(len(pairs)) >= total {
+		bound = 0 // every pair was checked
+	}
+	fmt.Printf("\n[Summary] %s is in %s order at %d sampled pairs of %d in %v\n", *topic, *key, len(pairs), total, time.Since(start).Round(time.Millisecond))
+	fmt.Printf("  - At %g%% confidence, fewer than %.4f%% of adjacent pairs are out of order\n", *confidence*100, bound*100)
+	return nil
+}
+
+// compareKeys compares the sort keys of two CSV records: ids numerically, other
+// fields bytewise.
+func compareKeys(a, b []byte, sortIdx int) int {
+	if sortIdx == 0 {
+		x, y := fastnum.LeadingInt(a), fastnum.LeadingInt(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return bytes.Compare(keyField(a, sortIdx), keyField(b, sortIdx))
+}
+
+// keyField returns field idx of a CSV record, or nil if it has fewer fields.
+func keyField(rec []byte, idx int) []byte {
+	for i := 0; i < idx; i++ {
+		j := bytes.IndexByte(rec, ',')
+		if j < 0 {
+			return nil
+		}
+		rec = rec[j+1:]
+	}
+	if j := bytes.IndexByte(rec, ','); j >= 0 {
+		return rec[:j]
+	}
+	return rec
+}
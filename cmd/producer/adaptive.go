@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// minAdaptiveBatch and maxAdaptiveBatch bound --adaptive-batch; the upper bound is
+	// the writers' own BatchSize, past which a write is split anyway.
+	minAdaptiveBatch = 100
+	maxAdaptiveBatch = 10000
+	// adaptEvery spaces the size changes so each one can show in the acknowledgements
+	adaptEvery = 250 * time.Millisecond
+	// slowWrite is how long handing a batch to the writers may block before it counts
+	// as the lanes falling behind
+	slowWrite = 50 * time.Millisecond
+)
+
+// batchSizer sizes the publisher's batches from backpressure. The async writers
+// accept batches faster than the broker acknowledges them, so a fixed size lets
+// unacknowledged records pile up in memory; the sizer tracks those (handed to the
+// writers, neither written nor failed yet) against maxInflight and shrinks the
+// batches, halving them, when they or the time spent handing a batch over grow, and
+// grows them by a quarter while the pipeline has room. Past maxInflight the
+// publisher waits, so buffering stays bounded whatever the size.
+type batchSizer struct {
+	size        int
+	maxInflight int64
+	messages    int64 // per record: one per topic
+
+	lastChange      time.Time
+	lo, hi          int
+	grown, shrunk   int
+	waits           int
+	waited, blocked time.Duration
+}
+
+func newBatchSizer(size int, maxInflight int64, topics int) *batchSizer {
+	size = min(max(size, minAdaptiveBatch), maxAdaptiveBatch)
+	return &batchSizer{size: size, maxInflight: maxInflight, messages: int64(topics), lo: size, hi: size}
+}
+
+// inflight returns the messages of the first sent records not yet acknowledged.
+func (b *batchSizer) inflight(sent int) int64 {
+	return int64(sent)*b.messages - metrics.written.Load() - metrics.failed.Load()
+}
+
+// wait blocks while more than maxInflight messages are unacknowledged.
+func (b *batchSizer) wait(sent int) {
+	if b.inflight(sent) <= b.maxInflight {
+		return
+	}
+	start := time.Now()
+	b.waits++
+	for b.inflight(sent) > b.maxInflight {
+		time.Sleep(time.Millisecond)
+	}
+	b.waited += time.Since(start)
+}
+
+// observe adjusts the size after a batch that took took to hand to the writers, with
+// sent records handed over so far.
+func (b *batchSizer) observe(sent int, took time.Duration) {
+	b.blocked += took
+	if time.Since(b.lastChange) < adaptEvery {
+		return
+	}
+	inflight := b.inflight(sent)
+	switch {
+	case inflight > b.maxInflight/2 || took > slowWrite:
+		if b.size == minAdaptiveBatch {
+			return
+		}
+		b.size = max(b.size/2, minAdaptiveBatch)
+		b.shrunk++
+	case inflight < b.maxInflight/4:
+		if b.size == maxAdaptiveBatch {
+			return
+		}
+		b.size = min(b.size+b.size/4, maxAdaptiveBatch)
+		b.grown++
+	default:
+		return
+	}
+	b.lastChange = time.Now()
+	b.lo, b.hi = min(b.lo, b.size), max(b.hi, b.size)
+}
+
+func (b *batchSizer) printStats() {
+	fmt.Printf("  - Adaptive batching: final size %d (range %d-%d), grown %d times, shrunk %d times, blocked %v handing batches over\n",
+		b.size, b.lo, b.hi, b.grown, b.shrunk, b.blocked.Round(time.Millisecond))
+	if b.waits > 0 {
+		fmt.Printf("    - waited %d times (%v) for in-flight records to drop below %d\n", b.waits, b.waited.Round(time.Millisecond), b.maxInflight)
+	}
+}
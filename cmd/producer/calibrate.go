@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kclient "core-infra-project/internal/kafka"
+	"core-infra-project/internal/tune"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// calibrator runs short trials of the generate-and-publish pipeline for tune.Sweep.
+// Trials write to a scratch topic created like the source topic and deleted
+// afterwards, so the dataset only ever holds the run's own records.
+type calibrator struct {
+	brokers   []string // nil with --no-kafka: trials measure generation and batching only
+	source    string
+	topic     string // the scratch topic
+	writers   int
+	queueSize int
+	setup     func(*gokafka.Writer) // compression and balancer of the real writers
+	generate  func() []byte
+	trialTime time.Duration
+}
+
+// warmupShare of every trial is left out of its measurement, so connection setup
+// and the first batches' latency don't penalize whichever trial comes first.
+const warmupShare = 5
+
+// trial runs the pipeline with the given worker count and batch size for c.trialTime
+// and returns the records/sec acknowledged (with --no-kafka, batched) after warm-up.
+// Without a worker nothing feeds the batches, so it delivers nothing.
+func (c *calibrator) trial(workers, batchSize int) float64 {
+	if workers < 1 || batchSize < 1 {
+		return 0
+	}
+	var done atomic.Int64
+	var pool *writerPool
+	if c.brokers != nil {
+		pool = newWriterPool(c.writers, c.brokers, []string{c.topic}, func(w *gokafka.Writer) {
+			c.setup(w)
+			w.Completion = func(msgs []gokafka.Message, err error) {
+				if err == nil {
+					done.Add(int64(len(msgs)))
+				}
+			}
+		}, nil)
+	}
+	records := make(chan []byte, c.queueSize)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case records <- c.generate():
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
+	warmup := c.trialTime / warmupShare
+	deadline := time.Now().Add(c.trialTime)
+	measureFrom := time.Now().Add(warmup)
+	var before int64 = -1
+	batch := make([]gokafka.Message, 0, batchSize)
+	for time.Now().Before(deadline) {
+		if before < 0 && !time.Now().Before(measureFrom) {
+			before = done.Load()
+		}
+		batch = batch[:0]
+		for len(batch) < batchSize {
+			batch = append(batch, gokafka.Message{Value: <-records})
+		}
+		if pool != nil {
+			pool.WriteMessages(context.Background(), batch...)
+		} else {
+			done.Add(int64(len(batch)))
+		}
+	}
+	after := done.Load()
+	close(stop)
+	wg.Wait()
+	if pool != nil {
+		pool.Close() // flushes the trial's tail, outside the measurement
+	}
+	if before < 0 {
+		before = 0
+	}
+	return float64(after-before) / (c.trialTime - warmup).Seconds()
+}
+
+// calibrate sweeps worker counts and batch sizes and returns the best trial. Without
+// Kafka only the worker count is swept, since batches are discarded either way.
+func calibrate(c *calibrator, batchSize int) (tune.Trial, error) {
+	batchSizes := tune.BatchSizes
+	if c.brokers == nil {
+		batchSizes = nil
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := kclient.CreateTopicLike(ctx, c.brokers, c.source, c.topic); err != nil {
+			return tune.Trial{}, err
+		}
+		defer func() {
+			if err := kclient.DeleteTopic(context.Background(), c.brokers, c.topic); err != nil {
+				fmt.Fprintf(os.Stderr, "[WARN] Calibration topic left behind: %v\n", err)
+			}
+		}()
+		if _, err := kclient.WaitForTopic(ctx, c.brokers, c.topic, 200*time.Millisecond); err != nil {
+			return tune.Trial{}, err
+		}
+	}
+	workers := tune.WorkerCounts()
+	trials := len(workers)
+	for _, b := range batchSizes {
+		if b != batchSize { // the worker sweep's batch size is not run twice
+			trials++
+		}
+	}
+	fmt.Printf("[Producer] Calibrating: %d trials of %v", trials, c.trialTime)
+	if c.brokers != nil {
+		fmt.Printf(" writing to %s", c.topic)
+	}
+	fmt.Println()
+	best, all, err := tune.Sweep(workers, batchSizes, batchSize, c.trial)
+	if err != nil {
+		return best, err
+	}
+	tune.PrintSweep("[Producer]", all, best)
+	return best, nil
+}
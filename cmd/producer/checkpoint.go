@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	kclient "core-infra-project/internal/kafka"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// checkpoint is the progress of an interrupted run. Record indices below Done, plus
+// those in DoneAbove, were acknowledged by Kafka; everything else is produced again on
+// --resume. With a seed, record i is the same on every run, so the resumed dataset is
+// the one the first run would have written; unseeded runs only get the count right.
+type checkpoint struct {
+	Topic            string    `json:"topic"`
+	Total            int64     `json:"total"`
+	StartID          int64     `json:"start_id,omitempty"`
+	Seed             int64     `json:"seed,omitempty"`
+	Format           string    `json:"format"`
+	Template         string    `json:"template,omitempty"`
+	Distribution     string    `json:"distribution,omitempty"`
+	ContinentWeights string    `json:"continent_weights,omitempty"`
+	MalformedPercent float64   `json:"malformed_percent,omitempty"`
+	DuplicatePercent float64   `json:"duplicate_percent,omitempty"`
+	Done             int64     `json:"done"`
+	DoneAbove        []int64   `json:"done_above,omitempty"`
+	UpdatedAt        time.Time `json:"updated_at"`
+
+	// With --idempotent: the run id in the provenance headers, and the end offsets of
+	// the topic before the run, from which a resume scans for unacknowledged records
+	RunID        string        `json:"run_id,omitempty"`
+	StartOffsets map[int]int64 `json:"start_offsets,omitempty"`
+}
+
+// markDelivered adds record i to the acknowledged records unless it is there already,
+// and reports whether it was added.
+func (c *checkpoint) markDelivered(i int64) bool {
+	if i < c.Done || i >= c.Total {
+		return false
+	}
+	at := sort.Search(len(c.DoneAbove), func(j int) bool { return c.DoneAbove[j] >= i })
+	if at < len(c.DoneAbove) && c.DoneAbove[at] == i {
+		return false
+	}
+	c.DoneAbove = append(c.DoneAbove, 0)
+	copy(c.DoneAbove[at+1:], c.DoneAbove[at:])
+	c.DoneAbove[at] = i
+	return true
+}
+
+// produced returns the number of acknowledged records.
+func (c *checkpoint) produced() int64 {
+	return c.Done + int64(len(c.DoneAbove))
+}
+
+// complete reports whether every record was acknowledged.
+func (c *checkpoint) complete() bool {
+	return c.produced() >= c.Total
+}
+
+// pending calls f for every record index still to be produced, in order.
+func (c *checkpoint) pending(f func(i int64)) {
+	above := c.DoneAbove
+	for i := c.Done; i < c.Total; i++ {
+		if len(above) > 0 && above[0] == i {
+			above = above[1:]
+			continue
+		}
+		f(i)
+	}
+}
+
+func readCheckpoint(path string) (*checkpoint, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c checkpoint
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %w", path, err)
+	}
+	sort.Slice(c.DoneAbove, func(i, j int) bool { return c.DoneAbove[i] < c.DoneAbove[j] })
+	return &c, nil
+}
+
+// write replaces the checkpoint at path atomically, so an interrupt mid-write leaves
+// the previous checkpoint intact.
+func (c *checkpoint) write(path string) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ackTracker follows the writer's delivery reports. Each message carries its record
+// index in WriterData; acknowledged indices advance the contiguous watermark, and the
+// ones acknowledged out of order (other partitions, other batches) are kept until the
+// gap below them closes. Records in flight when the producer dies are not in the
+// checkpoint and are produced again, so delivery across a resume is at-least-once,
+// unless --idempotent finds them in the topic first (see prepareIdempotent).
+type ackTracker struct {
+	mu     sync.Mutex
+	done   int64
+	above  map[int64]struct{}
+	failed int64
+}
+
+func newAckTracker(c *checkpoint) *ackTracker {
+	t := &ackTracker{done: c.Done, above: make(map[int64]struct{}, len(c.DoneAbove))}
+	for _, i := range c.DoneAbove {
+		t.above[i] = struct{}{}
+	}
+	return t
+}
+
+// completion is installed as the writer's Completion callback.
+func (t *ackTracker) completion(msgs []gokafka.Message, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.failed += int64(len(msgs))
+		return
+	}
+	for _, m := range msgs {
+		i, ok := m.WriterData.(int64)
+		if !ok || i < t.done {
+			continue
+		}
+		t.above[i] = struct{}{}
+	}
+	for {
+		if _, ok := t.above[t.done]; !ok {
+			break
+		}
+		delete(t.above, t.done)
+		t.done++
+	}
+}
+
+// snapshot fills c with the acknowledged records so far.
+func (t *ackTracker) snapshot(c *checkpoint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c.Done = t.done
+	c.DoneAbove = c.DoneAbove[:0]
+	for i := range t.above {
+		c.DoneAbove = append(c.DoneAbove, i)
+	}
+	sort.Slice(c.DoneAbove, func(i, j int) bool { return c.DoneAbove[i] < c.DoneAbove[j] })
+	c.UpdatedAt = time.Now()
+}
+
+// failures returns the number of records whose delivery failed.
+func (t *ackTracker) failures() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.failed
+}
+
+// resumeScanTimeout bounds the scan of an --idempotent resume for records delivered
+// after the last checkpoint.
+const resumeScanTimeout = 10 * time.Minute
+
+// prepareIdempotent readies c for an --idempotent run. A fresh run records runID and
+// the topic's end offsets, and writes the checkpoint before producing anything, so a
+// crash at any point leaves a checkpoint to resume from. A resumed run scans the topic
+// from those offsets for records its predecessor delivered after its last checkpoint,
+// and adds them to c, which keeps its run id so the headers stay recognizable.
+func prepareIdempotent(c *checkpoint, brokers []string, runID, path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if c.StartOffsets == nil {
+		ends, err := kclient.LogEnds(ctx, brokers, c.Topic)
+		if err != nil {
+			// Most likely not created yet; a resume then scans from the first offsets
+			fmt.Printf("[Producer] --idempotent: no offsets for %s (%v); a resume will scan the whole topic\n", c.Topic, err)
+			ends = map[int]int64{}
+		}
+		c.RunID, c.StartOffsets = runID, ends
+		return c.write(path)
+	}
+	// The scan reads everything written since the run started, so it gets longer than
+	// the admin requests above, but a broker that stops answering still fails the resume
+	scanCtx, cancelScan := context.WithTimeout(context.Background(), resumeScanTimeout)
+	defer cancelScan()
+	var found int64
+	read, err := kclient.ScanRunIndices(scanCtx, brokers, c.Topic, c.RunID, c.StartOffsets, func(i int64) {
+		if c.markDelivered(i) {
+			found++
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("scanning %s for run %s: %w", c.Topic, c.RunID, err)
+	}
+	fmt.Printf("[Producer] --idempotent: scanned %d records of %s; %d of run %s were delivered after the checkpoint and will not be produced again\n",
+		read, c.Topic, found, c.RunID)
+	return nil
+}
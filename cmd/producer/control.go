@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// runControl lets an operator pause, resume or abort a run over HTTP, on the pprof
+// server: POST /pause, /resume and /abort, and GET /status. A pause stops the
+// publisher between batches, so the writers drain what they hold and the broker sees
+// no traffic (e.g. while partitions are reassigned); generation stops too once the
+// queues fill. An abort ends the run after the current batch, flushing the writers
+// and the checkpoint as a normal end would, so --resume can finish it later.
+type runControl struct {
+	mu        sync.Mutex
+	paused    chan struct{} // closed by resume; nil while running
+	pausedAt  time.Time
+	pausedFor time.Duration
+	abort     chan struct{}
+	total     int // records the run will produce, math.MaxInt if unbounded
+}
+
+func newRunControl() *runControl {
+	return &runControl{abort: make(chan struct{})}
+}
+
+// register installs the endpoints on the default mux.
+func (c *runControl) register() {
+	http.HandleFunc("/pause", c.post(c.pause))
+	http.HandleFunc("/resume", c.post(c.resume))
+	http.HandleFunc("/abort", c.post(c.stop))
+	http.HandleFunc("/status", c.serveStatus)
+}
+
+func (c *runControl) post(action func() string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		msg := action()
+		fmt.Printf("[Control] %s\n", msg)
+		fmt.Fprintln(w, msg)
+	}
+}
+
+func (c *runControl) pause() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused != nil {
+		return "already paused"
+	}
+	c.paused, c.pausedAt = make(chan struct{}), time.Now()
+	return "paused"
+}
+
+func (c *runControl) resume() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused == nil {
+		return "not paused"
+	}
+	close(c.paused)
+	c.paused = nil
+	d := time.Since(c.pausedAt)
+	c.pausedFor += d
+	return fmt.Sprintf("resumed after %v", d.Round(time.Millisecond))
+}
+
+func (c *runControl) stop() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.abort:
+		return "already aborting"
+	default:
+	}
+	close(c.abort)
+	return "aborting after the current batch"
+}
+
+// aborted reports whether /abort was called.
+func (c *runControl) aborted() bool {
+	select {
+	case <-c.abort:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait blocks while the run is paused and reports whether it may go on.
+func (c *runControl) wait() bool {
+	c.mu.Lock()
+	paused := c.paused
+	c.mu.Unlock()
+	if paused != nil {
+		select {
+		case <-paused:
+		case <-c.abort:
+		}
+	}
+	return !c.aborted()
+}
+
+// state returns running, paused or aborted.
+func (c *runControl) state() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.aborted():
+		return "aborted"
+	case c.paused != nil:
+		return "paused"
+	}
+	return "running"
+}
+
+// pauseTime returns the time spent paused so far.
+func (c *runControl) pauseTime() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := c.pausedFor
+	if c.paused != nil {
+		d += time.Since(c.pausedAt)
+	}
+	return d
+}
+
+func (c *runControl) serveStatus(w http.ResponseWriter, _ *http.Request) {
+	status := struct {
+		State      string  `json:"state"`
+		Total      int     `json:"total,omitempty"` // omitted when unbounded
+		Generated  int64   `json:"generated"`
+		Written    int64   `json:"written"`
+		Failed     int64   `json:"failed"`
+		Throughput float64 `json:"throughput_records_per_sec"`
+		PausedMs   int64   `json:"paused_ms"`
+	}{
+		State:      c.state(),
+		Generated:  metrics.generated.Load(),
+		Written:    metrics.written.Load(),
+		Failed:     metrics.failed.Load(),
+		Throughput: metrics.throughput(),
+		PausedMs:   c.pauseTime().Milliseconds(),
+	}
+	c.mu.Lock()
+	if c.total < math.MaxInt {
+		status.Total = c.total
+	}
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// setTotal records the records the run will produce, for /status.
+func (c *runControl) setTotal(n int) {
+	c.mu.Lock()
+	c.total = n
+	c.mu.Unlock()
+}
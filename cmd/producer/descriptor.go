@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	datagen "core-infra-project/internal/data"
+	kclient "core-infra-project/internal/kafka"
+)
+
+// saveDescriptor writes d to path and, unless brokers is nil, publishes it to the
+// dataset topic of every topic the records went to, each copy naming its topic.
+func saveDescriptor(d datagen.Descriptor, path string, brokers, topics []string) error {
+	b, err := d.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("[Producer] Dataset descriptor written to %s\n", path)
+	if brokers == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, t := range topics {
+		d.Topic = t
+		if b, err = d.Marshal(); err != nil {
+			return err
+		}
+		if err := kclient.PublishDataset(ctx, brokers, t, b); err != nil {
+			return fmt.Errorf("publishing to %s: %w", kclient.DatasetTopic(t), err)
+		}
+		fmt.Printf("[Producer] Dataset descriptor published to %s\n", kclient.DatasetTopic(t))
+	}
+	return nil
+}
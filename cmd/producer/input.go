@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	extSort "core-infra-project/internal/sort"
+)
+
+// inputDir is a directory of record files produced in place of generated records, e.g.
+// production extracts to benchmark the sorter against. Files are read in name order
+// with the sorter's archive reader, so each may be plain, gzip or zstd compressed (or
+// a tarball of such files); hidden files and subdirectories are skipped.
+type inputDir struct {
+	dir        string
+	files      []string
+	skipHeader bool
+}
+
+func openInputDir(dir string, skipHeader bool) (*inputDir, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	in := &inputDir{dir: dir, skipHeader: skipHeader}
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+			in.files = append(in.files, e.Name())
+		}
+	}
+	if len(in.files) == 0 {
+		return nil, fmt.Errorf("%s has no record files", dir)
+	}
+	sort.Strings(in.files)
+	return in, nil
+}
+
+// stream sends every record of every file to out, indexed in read order, then closes
+// out and returns the number of records sent.
+func (in *inputDir) stream(out chan<- indexedRecord) (int64, error) {
+	defer close(out)
+	var n int64
+	for _, name := range in.files {
+		src, err := extSort.OpenArchive(filepath.Join(in.dir, name), in.skipHeader)
+		if err != nil {
+			return n, err
+		}
+		fmt.Printf("[Input] Reading %s (%s)\n", name, src.Format())
+		for {
+			msg, err := src.ReadMessage(context.Background())
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				src.Close()
+				return n, err
+			}
+			out <- indexedRecord{index: n, value: msg.Value}
+			n++
+		}
+		src.Close()
+	}
+	return n, nil
+}
@@ -2,114 +2,1109 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	_ "net/http/pprof" // Enable pprof profiling endpoints
 	"os"
-	"runtime"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"core-infra-project/internal/avro"
+	"core-infra-project/internal/config"
 	datagen "core-infra-project/internal/data"
 	kclient "core-infra-project/internal/kafka"
+	"core-infra-project/internal/tune"
 
 	gokafka "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/compress"
 )
 
-const (
-	totalRecords = 50_000_000
-	// Larger queue smooths bursts between generators and writer
-	queueSize = 100_000
-)
+// defaultTotalRecords is the dataset size the pipeline and README benchmarks are built around.
+const defaultTotalRecords = 50_000_000
 
 func main() {
+	envRecords, envRecordsErr := strconv.Atoi(getenv("TOTAL_RECORDS", strconv.Itoa(defaultTotalRecords)))
+	if envRecordsErr != nil {
+		envRecords = defaultTotalRecords // reported by the validator below
+	}
+	totalRecords := flag.Int("records", envRecords, "number of records to generate (env TOTAL_RECORDS)")
+	// --no-kafka isolates generator throughput from broker throughput
+	noKafka := flag.Bool("no-kafka", false, "run the generation pipeline but discard records instead of writing to Kafka")
+	flag.BoolVar(noKafka, "dry-run", false, "alias for --no-kafka")
+	checkBrokers := flag.Bool("check-brokers", false, "fail at startup if a Kafka broker is unreachable")
+	waitForKafka := flag.Duration("wait-for-kafka", 0, "at startup, wait up to this long for the brokers (controller elected), the source topics (a leader per partition) and write ACLs on them to be ready (0 disables)")
+	waitBackoff := flag.Duration("wait-backoff", time.Second, "delay between --wait-for-kafka checks, doubled after each failed one (up to 10s)")
+	topicWait := flag.Duration("topic-wait", 0, "before the timed run, wait up to this long for every source partition to have a leader (0 disables)")
+	prewarm := flag.Bool("prewarm", false, "open connections to all partition leaders before the timed run (requires --topic-wait)")
+	autoTune := flag.Bool("auto-tune", false, "probe generator throughput and broker round trip at startup to pick workers, queue and batch sizes")
+	calibrateTrial := flag.Duration("calibrate", 0, "before the run, sweep worker counts (NumCPU/2 to 4x) and batch sizes (500 to 10000) with trials of this length writing to a scratch <topic>-calibration topic, and produce with the fastest (0 disables)")
+	profile := flag.String("profile", getenv("KSS_PROFILE", ""), "preset flag defaults: dev, staging or prod (explicit flags still win)")
+	batchSize := flag.Int("batch-size", 1000, "records per Kafka write (replaced by --auto-tune)")
+	adaptiveBatch := flag.Bool("adaptive-batch", false, "start at --batch-size and resize batches between 100 and 10000 records from backpressure (unacknowledged records, blocked writes)")
+	quotaBackoff := flag.Bool("quota-backoff", true, "pause between batches while brokers report produce quota throttling, instead of queueing writes until they time out")
+	maxInflight := flag.Int64("max-inflight-records", 200_000, "with --adaptive-batch, wait while more messages than this are unacknowledged")
+	tui := flag.Bool("tui", false, "replace the [Progress] lines with a status block redrawn every second: progress bar, throughput trend, ETA, queue depths and memory (needs a terminal)")
+	memStatsEvery := flag.Duration("mem-stats-every", 0, "log heap in use, GC cycles and pauses, goroutines and throughput at this interval, to match throughput dips with GC activity (0 disables)")
+	queueStatsEvery := flag.Duration("queue-stats-every", 0, "log how full the jobs, records and writer batch queues were at this interval, and which stage that makes the bottleneck (0 disables)")
+	statusTopic := flag.String("status-topic", getenv("STATUS_TOPIC", ""), "write a JSON heartbeat (phase, records written, host) keyed by producer run to this topic every --heartbeat-every (env STATUS_TOPIC)")
+	heartbeatEvery := flag.Duration("heartbeat-every", 30*time.Second, "interval between --status-topic heartbeats")
+	writers := flag.Int("writers", 1, "Kafka writers per topic, each fed batches by its own goroutine (more than 1 no longer keeps a partition's records in generation order)")
+	compression := flag.String("compression", getenv("KAFKA_COMPRESSION", "snappy"), "Kafka batch compression: none, gzip, snappy, lz4 or zstd (env KAFKA_COMPRESSION)")
+	format := flag.String("format", getenv("FORMAT", "csv"), "record format: csv, json or avro (env FORMAT)")
+	valueTemplate := flag.String("template", "", "render each generated record with this Go template over .ID, .Name, .Address and .Continent instead of --format, e.g. '{{.ID}}|{{.Name}}-{{.Continent}}'")
+	schemaPath := flag.String("schema", "schemas/record.avsc", "Avro schema (.avsc) for --format avro, registered under <topic>-value")
+	registryURL := flag.String("schema-registry", getenv("SCHEMA_REGISTRY_URL", ""), "Schema Registry URL used to register --schema")
+	seed := flag.Int64("seed", 0, "generate a reproducible dataset from this seed (0 = random records)")
+	descriptorPath := flag.String("descriptor", "", "after the run, write the dataset's definition (records, seed, layout, distribution) to this file and publish it to <topic>-dataset, for `kss produce --from-descriptor` (requires --seed)")
+	malformedPercent := flag.Float64("malformed-percent", 0, "break this percentage of generated records (dropped field, non-numeric id or embedded newline) to test how consumers handle bad input")
+	duplicatePercent := flag.Float64("duplicate-percent", 0, "make this percentage of records exact copies of one of the 1000 records before them, to test deduplication and the order of equal keys (requires --seed)")
+	continentWeights := flag.String("continent-weights", getenv("CONTINENT_WEIGHTS", ""), "draw continents with these weights instead of evenly, e.g. 'Asia:60,Europe:20,North America:20' (continents left out get no records; env CONTINENT_WEIGHTS)")
+	distribution := flag.String("distribution", "uniform", "key distribution: uniform, or zipf (a few very hot ids and most records on one continent, for skew testing)")
+	checkpointPath := flag.String("checkpoint", "", "periodically record acknowledged records in this file so an interrupted run can --resume")
+	checkpointEvery := flag.Duration("checkpoint-every", 10*time.Second, "interval between checkpoint writes")
+	rotateEvery := flag.Duration("rotate-every", 0, "keep running and emit a new dataset of --records records, tagged with the next date, at this interval (0 produces one dataset)")
+	datasets := flag.Int("datasets", 0, "stop after this many datasets with --rotate-every (0 runs until interrupted)")
+	datasetDate := flag.String("dataset-date", time.Now().UTC().Format(time.DateOnly), "date (YYYY-MM-DD) of the first dataset with --rotate-every; each later one is a day after")
+	endMarker := flag.Bool("end-marker", false, "after the last record, write an end-of-stream marker (kss-eos header carrying the record count) to every partition, which ends a sorter --end-markers read")
+	batchChecksums := flag.Bool("batch-checksums", false, "publish a CRC-32C of every acknowledged batch (partition, offsets) to <topic>-checksums, for sorter --verify-checksums to detect corruption between producer and sorter")
+	manifestTopic := flag.String("manifest-topic", getenv("MANIFEST_TOPIC", ""), "after the run, write a manifest of the dataset (run id, records, per-continent counts, order-independent value checksum) keyed by run id to this topic, e.g. manifests (env MANIFEST_TOPIC)")
+	startID := flag.Int64("start-id", 0, "index of the first generated record, so a run over the same topic and --seed continues a dataset instead of repeating it (records start-id to start-id+records-1)")
+	appendRun := flag.Bool("append", false, "grow the dataset whose latest manifest is in --manifest-topic: start at its next record id and write a manifest of the whole grown dataset")
+	resume := flag.Bool("resume", false, "continue the run recorded in --checkpoint instead of starting from zero")
+	idempotent := flag.Bool("idempotent", false, "write with acks=all and provenance headers, and on --resume skip the records the interrupted run delivered after its last checkpoint, so a restart leaves no duplicates (requires --checkpoint)")
+	provenance := flag.Bool("provenance-headers", false, "tag every message with producer-run-id and record-index headers")
+	runID := flag.String("run-id", config.DefaultRunID(), "id of this benchmark run (default $KSS_RUN_ID or the start time), written by --provenance-headers and shown at /debug/vars")
+	timestamps := flag.Bool("timestamps", false, "set each message's timestamp to when its record was generated rather than when the writer sends it")
+	inputPath := flag.String("input-dir", "", "produce the records of the files in this directory (CSV lines; gzip, zstd and tar are detected) instead of generating --records records")
+	inputHeader := flag.Bool("input-header", false, "skip the first line of every file in --input-dir")
+	retries := flag.Int("retries", 3, "times a failed Kafka write is retried, with exponential backoff, before its records are spooled")
+	retryBackoff := flag.Duration("retry-backoff", 500*time.Millisecond, "delay before the first retry of a failed write; doubled for each further one (up to 30s)")
+	spoolPath := flag.String("spool", "producer-spool.jsonl", "file that records still undelivered after --retries are appended to (created on the first failure)")
+	replayPath := flag.String("replay-spool", "", "send the records of this spool file to their topics and exit")
+	serveAddr := flag.String("serve", "", "run as a service accepting generation jobs over HTTP on this address (e.g. :8090) instead of producing --records once")
+	keyByID := flag.Bool("key-by-id", false, "set each message's key to its record id and partition by key hash, so equal ids share a partition")
+	flag.Parse()
+	var kafkaOnly []string
+	if *noKafka {
+		kafkaOnly = []string{"check-brokers", "topic-wait", "prewarm"}
+	}
+	profileErr := config.ApplyProfile(flag.CommandLine, *profile, kafkaOnly...)
+
+	brokers := getenv("KAFKA_BROKERS", "kafka:9092")
+	sourceTopic := getenv("SOURCE_TOPIC", "source")
+	// SOURCE_TOPICS fans the same records out to several topics, so concurrent sorter
+	// experiments each read identical data from their own topic
+	topics := []string{sourceTopic}
+	if list := os.Getenv("SOURCE_TOPICS"); list != "" {
+		topics = strings.Split(list, ",")
+		for i := range topics {
+			topics[i] = strings.TrimSpace(topics[i])
+		}
+		sourceTopic = topics[0]
+	}
+
+	// Validate everything up front so all configuration problems are reported together
+	var v config.Validator
+	v.Check(profileErr == nil, "--profile: %v", profileErr)
+	v.IntRange("--batch-size", int64(*batchSize), 1, 1_000_000)
+	v.Check(envRecordsErr == nil, "TOTAL_RECORDS must be an integer, got %q", os.Getenv("TOTAL_RECORDS"))
+	v.IntRange("--records", int64(*totalRecords), 1, 1<<40)
+	v.Check(!(*noKafka && *checkBrokers), "--check-brokers has no effect with --no-kafka")
+	v.Check(!(*noKafka && *topicWait > 0), "--topic-wait has no effect with --no-kafka")
+	v.Check(!*prewarm || *topicWait > 0, "--prewarm requires --topic-wait")
+	v.Check(*topicWait >= 0, "--topic-wait must not be negative")
+	v.Check(!(*noKafka && *waitForKafka > 0), "--wait-for-kafka has no effect with --no-kafka")
+	v.Check(*waitForKafka >= 0, "--wait-for-kafka must not be negative")
+	v.Check(*waitBackoff > 0, "--wait-backoff must be positive")
+	if *waitForKafka > 0 && !*noKafka && *waitBackoff > 0 {
+		// Before anything below reads from Kafka
+		ctx, cancel := context.WithTimeout(context.Background(), *waitForKafka)
+		waitStart := time.Now()
+		n, err := kclient.WaitForKafka(ctx, []string{brokers}, topics, gokafka.ACLOperationTypeWrite, *waitBackoff, func(format string, args ...any) {
+			fmt.Printf("[Producer] Waiting for Kafka: "+format+"\n", args...)
+		})
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] --wait-for-kafka: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("[Producer] Kafka ready after %d checks (%v)\n", n, time.Since(waitStart).Round(time.Millisecond))
+	}
+	v.Check(!*resume || *checkpointPath != "", "--resume requires --checkpoint")
+	seenTopics := map[string]bool{}
+	for _, t := range topics {
+		v.Check(t != "", "SOURCE_TOPICS has an empty topic name")
+		v.Check(t == "" || !seenTopics[t], "SOURCE_TOPICS lists %s twice", t)
+		seenTopics[t] = true
+	}
+	v.Check(len(topics) == 1 || *checkpointPath == "", "--checkpoint tracks the acknowledgements of one topic and cannot be used with several SOURCE_TOPICS")
+	v.Check(!(*noKafka && *checkpointPath != ""), "--checkpoint has no effect with --no-kafka")
+	v.Check(*checkpointEvery > 0, "--checkpoint-every must be positive")
+	recordFormat, formatErr := datagen.ParseFormat(*format)
+	v.Check(formatErr == nil, "--format: %v", formatErr)
+	var avroSchema *avro.Schema
+	if recordFormat == datagen.Avro {
+		v.Check(*noKafka || *registryURL != "", "--schema-registry (or SCHEMA_REGISTRY_URL) is required with --format avro")
+		b, err := os.ReadFile(*schemaPath)
+		if err == nil {
+			avroSchema, err = avro.ParseSchema(b)
+		}
+		if err == nil {
+			// Generated records must fit the schema; better to learn it now than mid-run
+			_, err = avroSchema.EncodeCSV(nil, datagen.GenerateRandomRecord())
+		}
+		v.Check(err == nil, "--schema: %v", err)
+	}
+	dist, distErr := datagen.ParseDistribution(*distribution)
+	v.Check(distErr == nil, "--distribution: %v", distErr)
+	var weights *datagen.ContinentWeights
+	if *continentWeights != "" {
+		var err error
+		weights, err = datagen.ParseContinentWeights(*continentWeights)
+		v.Check(err == nil, "--continent-weights: %v", err)
+	}
+	v.Check(*malformedPercent >= 0 && *malformedPercent <= 100, "--malformed-percent must be between 0 and 100")
+	if *malformedPercent > 0 {
+		v.Check(recordFormat != datagen.Avro, "--malformed-percent breaks csv or json records, not --format avro")
+		v.Check(*valueTemplate == "", "--malformed-percent breaks the csv or json layout and cannot be combined with --template")
+		v.Check(*inputPath == "", "--malformed-percent breaks generated records and has no effect with --input-dir")
+	}
+	malformer := datagen.Malformer{Percent: *malformedPercent, Seed: *seed}
+	var malformed [len(datagen.Malformations)]atomic.Int64
+	v.Check(*duplicatePercent >= 0 && *duplicatePercent <= 100, "--duplicate-percent must be between 0 and 100")
+	if *duplicatePercent > 0 {
+		v.Check(*seed != 0, "--duplicate-percent requires --seed (a duplicate is its original generated again)")
+		v.Check(*inputPath == "", "--duplicate-percent repeats generated records and has no effect with --input-dir")
+	}
+	duplicator := datagen.Duplicator{Percent: *duplicatePercent, Seed: *seed}
+	var duplicates atomic.Int64
+	var tmpl *datagen.Template
+	if *valueTemplate != "" {
+		v.Check(recordFormat == datagen.CSV, "--template sets the record layout and cannot be combined with --format %s", recordFormat)
+		v.Check(*inputPath == "", "--template shapes generated records and has no effect with --input-dir")
+		var err error
+		tmpl, err = datagen.ParseTemplate(*valueTemplate)
+		v.Check(err == nil, "--template: %v", err)
+	}
+	v.IntRange("--writers", int64(*writers), 1, 64)
+	if *adaptiveBatch {
+		v.Check(!*noKafka, "--adaptive-batch reacts to Kafka acknowledgements and has no effect with --no-kafka")
+		v.Check(*serveAddr == "", "--adaptive-batch cannot be used with --serve")
+		v.IntRange("--max-inflight-records", *maxInflight, maxAdaptiveBatch, 1<<30)
+	}
+	v.Check(!*tui || *serveAddr == "", "--tui shows the progress of one run and cannot be used with --serve")
+	v.Check(!*tui || isTerminal(os.Stdout), "--tui redraws the terminal in place, but stdout is not a terminal")
+	v.Check(*memStatsEvery == 0 || !*tui, "--tui already shows memory; --mem-stats-every lines would break its redraws")
+	v.Check(*memStatsEvery >= 0, "--mem-stats-every must not be negative")
+	v.Check(*queueStatsEvery == 0 || !*tui, "--tui already shows the queues; --queue-stats-every lines would break its redraws")
+	v.Check(*queueStatsEvery == 0 || *serveAddr == "", "--queue-stats-every watches the queues of a single run and cannot be used with --serve")
+	v.Check(*queueStatsEvery == 0 || *queueStatsEvery >= queueSampleEvery, "--queue-stats-every must be at least 100ms")
+	v.Check(*statusTopic == "" || !*noKafka, "--status-topic writes to Kafka and cannot be used with --no-kafka")
+	v.Check(*heartbeatEvery >= time.Second, "--heartbeat-every must be at least 1s")
+	var codec compress.Compression
+	if err := codec.UnmarshalText([]byte(*compression)); err != nil {
+		v.Check(false, "--compression: %v", err)
+	}
+	v.IntRange("--retries", int64(*retries), 0, 100)
+	v.Check(*retryBackoff > 0, "--retry-backoff must be positive")
+	v.Check(*spoolPath != "", "--spool must not be empty")
+	v.Check(*replayPath == "" || (!*noKafka && *serveAddr == ""), "--replay-spool cannot be used with --no-kafka or --serve")
+	if *serveAddr != "" {
+		// Jobs carry their own count, rate, layout and topic
+		v.Check(*checkpointPath == "" && !*resume, "--checkpoint cannot be used with --serve")
+		v.Check(*rotateEvery == 0, "--rotate-every cannot be used with --serve")
+		v.Check(*inputPath == "", "--input-dir cannot be used with --serve")
+		v.Check(recordFormat != datagen.Avro, "--serve jobs generate csv or json records, not --format avro")
+	}
+	v.Check(*runID != "", "--run-id must not be empty")
+	v.Check(*rotateEvery >= 0, "--rotate-every must not be negative")
+	v.Check(*datasets >= 0, "--datasets must not be negative")
+	v.Check(*datasets == 0 || *rotateEvery > 0, "--datasets requires --rotate-every")
+	v.Check(*rotateEvery == 0 || *checkpointPath == "", "--checkpoint cannot be combined with --rotate-every")
+	v.Check(!*idempotent || *checkpointPath != "", "--idempotent requires --checkpoint")
+	v.Check(!*endMarker || (!*noKafka && *serveAddr == "" && *rotateEvery == 0), "--end-marker marks the end of one dataset in Kafka and cannot be used with --no-kafka, --serve or --rotate-every")
+	v.Check(!*batchChecksums || (!*noKafka && *serveAddr == ""), "--batch-checksums checksums batches written to Kafka and cannot be used with --no-kafka or --serve")
+	v.Check(*manifestTopic == "" || (!*noKafka && *serveAddr == "" && *rotateEvery == 0 && !*resume), "--manifest-topic describes one whole dataset in Kafka and cannot be used with --no-kafka, --serve, --rotate-every or --resume")
+	var appendTo *kclient.Manifest
+	var appendChecksum kclient.ValueChecksum
+	if *appendRun {
+		v.Check(*manifestTopic != "", "--append requires --manifest-topic, which holds the dataset to grow")
+		v.Check(*startID == 0, "--append continues where the dataset ends and cannot be used with --start-id")
+		v.Check(!*endMarker, "--end-marker cannot be used with --append: the topic already holds the markers of the dataset appended to")
+		if *manifestTopic != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			m, err := kclient.LatestManifest(ctx, []string{brokers}, *manifestTopic, sourceTopic)
+			cancel()
+			switch {
+			case err != nil:
+				v.Check(false, "--append: %v", err)
+			case m == nil:
+				v.Check(false, "--append: %s holds no manifest of a dataset in %s", *manifestTopic, sourceTopic)
+			default:
+				v.Check(m.Seed == *seed, "--append: the dataset of run %s was produced with --seed %d, not %d", m.RunID, m.Seed, *seed)
+				if m.Format != "" {
+					v.Check(m.Format == recordFormat.String(), "--append: the dataset of run %s was produced with --format %s, not %s", m.RunID, m.Format, recordFormat)
+					v.Check(m.Distribution == dist.String(), "--append: the dataset of run %s was produced with a different --distribution", m.RunID)
+					v.Check(m.Template == *valueTemplate, "--append: the dataset of run %s was produced with --template %q, not %q", m.RunID, m.Template, *valueTemplate)
+				} else {
+					fmt.Printf("[Producer] --append: the manifest of run %s does not record --format, --distribution or --template; they are not checked\n", m.RunID)
+				}
+				appendChecksum, err = kclient.ParseValueChecksum(m.Checksum)
+				v.Check(err == nil, "--append: manifest of run %s: %v", m.RunID, err)
+				appendTo, *startID = m, m.NextID
+			}
+		}
+	}
+	v.Check(*startID >= 0, "--start-id must not be negative")
+	v.Check(*startID == 0 || (*inputPath == "" && *rotateEvery == 0 && *serveAddr == ""), "--start-id and --append number generated records and cannot be used with --input-dir, --rotate-every or --serve")
+	if *descriptorPath != "" {
+		// Only seeded records can be generated again
+		v.Check(*seed != 0, "--descriptor requires --seed")
+		v.Check(*inputPath == "" && *rotateEvery == 0 && *serveAddr == "", "--descriptor describes one generated dataset and cannot be used with --input-dir, --rotate-every or --serve")
+	}
+	var inDir *inputDir
+	if *inputPath != "" {
+		v.Check(*seed == 0, "--seed has no effect with --input-dir")
+		v.Check(dist == datagen.Uniform, "--distribution has no effect with --input-dir")
+		v.Check(weights == nil, "--continent-weights has no effect with --input-dir")
+		v.Check(*checkpointPath == "", "--checkpoint cannot be used with --input-dir")
+		v.Check(*rotateEvery == 0, "--rotate-every cannot be used with --input-dir")
+		var err error
+		inDir, err = openInputDir(*inputPath, *inputHeader)
+		v.Check(err == nil, "--input-dir: %v", err)
+	}
+	v.Check(!*inputHeader || *inputPath != "", "--input-header requires --input-dir")
+	if *calibrateTrial != 0 {
+		v.Check(!*autoTune, "--calibrate and --auto-tune both pick workers and batch size; use one")
+		v.Check(*inputPath == "" && *replayPath == "", "--calibrate measures generation and cannot be used with --input-dir or --replay-spool")
+		v.IntRange("--calibrate (ms)", calibrateTrial.Milliseconds(), 100, 60_000)
+	}
+	var rot *rotation
+	if *rotateEvery > 0 {
+		first, err := time.Parse(time.DateOnly, *datasetDate)
+		v.Check(err == nil, "--dataset-date must be YYYY-MM-DD, got %q", *datasetDate)
+		rot = &rotation{every: *rotateEvery, count: *datasets, first: first, records: int64(*totalRecords)}
+	}
+	// The run a checkpoint describes: resumed from the file, or a fresh one
+	progress := &checkpoint{Topic: sourceTopic, Total: int64(*totalRecords), StartID: *startID, Seed: *seed, Format: recordFormat.String(), Template: *valueTemplate}
+	if dist != datagen.Uniform {
+		progress.Distribution = dist.String() // older checkpoints, without one, are uniform
+	}
+	if weights != nil {
+		progress.ContinentWeights = weights.String()
+	}
+	progress.MalformedPercent = *malformedPercent
+	progress.DuplicatePercent = *duplicatePercent
+	if *checkpointPath != "" {
+		prev, err := readCheckpoint(*checkpointPath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			if *resume {
+				fmt.Printf("[Producer] No checkpoint at %s, starting from zero\n", *checkpointPath)
+			}
+		case err != nil:
+			v.Check(false, "--checkpoint: %v", err)
+		case !*resume && prev.complete():
+			// Left by a finished run that could not remove it; nothing to resume
+			fmt.Printf("[Producer] Checkpoint at %s records a finished run, starting a new one\n", *checkpointPath)
+		case !*resume:
+			v.Check(false, "--checkpoint: %s already records a run; pass --resume to continue it or remove the file", *checkpointPath)
+		default:
+			v.Check(prev.Topic == progress.Topic, "--resume: checkpoint is for topic %q, not %q", prev.Topic, progress.Topic)
+			v.Check(prev.Total == progress.Total, "--resume: checkpoint is for --records %d, not %d", prev.Total, progress.Total)
+			v.Check(prev.Seed == progress.Seed, "--resume: checkpoint is for --seed %d, not %d", prev.Seed, progress.Seed)
+			v.Check(prev.StartID == progress.StartID, "--resume: checkpoint is for --start-id %d, not %d", prev.StartID, progress.StartID)
+			v.Check(prev.Format == progress.Format, "--resume: checkpoint is for --format %s, not %s", prev.Format, progress.Format)
+			v.Check(prev.Template == progress.Template, "--resume: checkpoint is for --template %q, not %q", prev.Template, progress.Template)
+			v.Check(prev.Distribution == progress.Distribution, "--resume: checkpoint is for a different --distribution")
+			v.Check(prev.ContinentWeights == progress.ContinentWeights, "--resume: checkpoint is for --continent-weights %q, not %q", prev.ContinentWeights, progress.ContinentWeights)
+			v.Check(prev.MalformedPercent == progress.MalformedPercent, "--resume: checkpoint is for --malformed-percent %g, not %g", prev.MalformedPercent, progress.MalformedPercent)
+			v.Check(prev.DuplicatePercent == progress.DuplicatePercent, "--resume: checkpoint is for --duplicate-percent %g, not %g", prev.DuplicatePercent, progress.DuplicatePercent)
+			v.Check(!*idempotent || prev.StartOffsets != nil, "--resume: checkpoint was not written with --idempotent, so the records it missed cannot be found")
+			progress = prev
+		}
+	}
+	if *checkBrokers {
+		err := config.CheckBrokers([]string{brokers}, 5*time.Second)
+		v.Check(err == nil, "%v", err)
+	}
+	if err := v.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		os.Exit(1)
+	}
+	if *idempotent {
+		// The headers let a resume recognize this run's records in the topic
+		*provenance = true
+		if err := prepareIdempotent(progress, []string{brokers}, *runID, *checkpointPath); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] --idempotent: %v\n", err)
+			os.Exit(1)
+		}
+		*runID = progress.RunID
+	}
+
+	// Fixed worker/queue/batch sizes unless --auto-tune measures better ones
+	settings := tune.Defaults()
+	settings.BatchSize = *batchSize
+	if *autoTune {
+		var probes tune.Probes
+		probes.Generator = tune.ProbeGenerator(200 * time.Millisecond)
+		if !*noKafka {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			rtt, err := tune.ProbeBroker(ctx, []string{brokers}, 5)
+			cancel()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[WARN] Broker probe failed, keeping default batch size: %v\n", err)
+			}
+			probes.BrokerRTT = rtt
+		}
+		settings = tune.Choose(probes)
+		tune.Print("[Producer]", probes, settings)
+	}
+	if *calibrateTrial > 0 {
+		c := &calibrator{source: sourceTopic, topic: sourceTopic + "-calibration", writers: *writers, queueSize: settings.QueueSize,
+			trialTime: *calibrateTrial, setup: func(w *gokafka.Writer) {
+				w.Compression = codec
+				if *keyByID {
+					w.Balancer = &gokafka.Murmur2Balancer{}
+				}
+			}}
+		if !*noKafka {
+			c.brokers = []string{brokers}
+		}
+		c.generate = func() []byte { return recordFormat.Encode(dist.RandomFields()) }
+		if tmpl != nil {
+			c.generate = func() []byte {
+				b, _ := tmpl.Render(dist.RandomFields()) // the run reports any error
+				return b
+			}
+		}
+		if best, err := calibrate(c, settings.BatchSize); err != nil {
+			fmt.Fprintf(os.Stderr, "[WARN] Calibration failed, keeping workers=%d batch=%d: %v\n", settings.Workers, settings.BatchSize, err)
+		} else {
+			settings.Workers, settings.BatchSize = best.Workers, best.BatchSize
+		}
+	}
+
+	var eff config.Effective
+	eff.Add("KAFKA_BROKERS", brokers)
+	if len(topics) > 1 {
+		eff.Add("SOURCE_TOPICS", strings.Join(topics, ","))
+	} else {
+		eff.Add("SOURCE_TOPIC", sourceTopic)
+	}
+	eff.Add("records", *totalRecords)
+	if progress.produced() > 0 {
+		eff.Add("already produced", progress.produced())
+	}
+	if inDir != nil {
+		// Files are read by one reader anyway; one worker keeps their records in order
+		settings.Workers = 1
+	}
+	eff.Add("workers", settings.Workers)
+	eff.Add("queue size", settings.QueueSize)
+	eff.Add("batch size", settings.BatchSize)
+	eff.AddFlags(flag.CommandLine)
+	eff.Print(os.Stdout, "[Producer]")
+	expvar.NewString("run_id").Set(*runID)
+
+	var retry *retrier
+	if !*noKafka {
+		retry = &retrier{brokers: []string{brokers}, compression: codec, attempts: *retries, backoff: *retryBackoff, requireAll: *idempotent, spool: &spoolFile{path: *spoolPath}}
+	}
+	metrics.start(*runID, retry)
+	http.Handle("/metrics", &metrics)
+	var memLog *memLogger
+	if *memStatsEvery > 0 {
+		memLog = startMemLogger(*memStatsEvery)
+	}
+
 	// Start pprof HTTP server for profiling (requirement #6)
-	// Access profiling at: http://localhost:6060/debug/pprof/
+	// Access profiling at: http://localhost:6060/debug/pprof/ (Prometheus metrics at /metrics)
 	go func() {
 		log.Println("[pprof] Profiling server starting on :6060")
 		log.Println(http.ListenAndServe("0.0.0.0:6060", nil))
 	}()
 
+	if *replayPath != "" {
+		n, err := replaySpool(*replayPath, []string{brokers}, settings.BatchSize, codec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] --replay-spool: %v (%d records sent before it)\n", err, n)
+			os.Exit(1)
+		}
+		fmt.Printf("[Producer] Replayed %d records from %s; it can be removed\n", n, *replayPath)
+		return
+	}
+	control := newRunControl()
+	if *statusTopic != "" {
+		phase, total := "produce", int64(0)
+		switch {
+		case *serveAddr != "":
+			phase = "serving"
+		case rot == nil && inDir == nil:
+			total = (progress.Total - progress.produced()) * int64(len(topics)) // this run's share, like metrics.written
+		}
+		heartbeats := kclient.StartHeartbeats([]string{brokers}, *statusTopic, *heartbeatEvery, "producer:"+*runID, *runID, func(hb *kclient.Heartbeat) {
+			hb.Phase, hb.Records, hb.Total = phase, metrics.written.Load(), total
+			if s := control.state(); s != "running" {
+				hb.Phase = s
+			}
+		})
+		defer func() {
+			if control.aborted() {
+				heartbeats.Stop("aborted")
+			} else {
+				heartbeats.Stop("done")
+			}
+		}()
+		fmt.Printf("[Producer] Heartbeats every %v to %s\n", *heartbeatEvery, *statusTopic)
+	}
+	if *serveAddr != "" {
+		jobs := &jobServer{brokers: []string{brokers}, topic: sourceTopic, format: recordFormat,
+			compression: codec, batchSize: settings.BatchSize, writers: *writers, noKafka: *noKafka, retry: retry}
+		if err := jobs.serve(*serveAddr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(os.Stderr, "[ERROR] --serve: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	control.register()
+	fmt.Println("[Producer] Run control on :6060: POST /pause, /resume or /abort, GET /status")
+
+	// Broker warm-up happens before the clock starts so benchmarks measure steady state
+	if *topicWait > 0 {
+		for _, t := range topics {
+			if err := waitForTopic([]string{brokers}, t, *topicWait, *prewarm); err != nil {
+				fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	schemaID := 0
+	if avroSchema != nil && !*noKafka {
+		registry := avro.NewRegistryClient(*registryURL)
+		for i, t := range topics {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			id, err := registry.Register(ctx, t+"-value", avroSchema)
+			cancel()
+			if err == nil && i > 0 && id != schemaID {
+				// Records are encoded once for every topic, so their framing must agree
+				err = fmt.Errorf("schema registered as id %d under %s-value but %d under %s-value", schemaID, sourceTopic, id, t)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+				os.Exit(1)
+			}
+			schemaID = id
+			fmt.Printf("[Producer] Registered Avro schema %s under subject %s-value (id %d)\n", avroSchema.Name, t, id)
+		}
+	}
+
 	fmt.Println("[Producer] Starting generation and production pipeline...")
 	start := time.Now()
-	brokers := getenv("KAFKA_BROKERS", "kafka:9092")
-	sourceTopic := getenv("SOURCE_TOPIC", "source")
 
-	writer := kclient.NewWriter([]string{brokers}, sourceTopic)
-	// Don't use defer - we'll explicitly close after wg.Wait() to ensure flush
+	var pool *writerPool
+	var sampler *kclient.CompressionSampler
+	var quota *kclient.QuotaThrottle
+	var checksums *kclient.ChecksumPublisher
+	var acks *ackTracker
+	if *noKafka {
+		fmt.Println("[Producer] --no-kafka set: records will be generated and discarded")
+	} else {
+		if *checkpointPath != "" {
+			acks = newAckTracker(progress)
+		}
+		if *batchChecksums {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			var err error
+			checksums, err = kclient.NewChecksumPublisher(ctx, []string{brokers}, topics)
+			cancel()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[ERROR] --batch-checksums: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("[Producer] Publishing batch checksums to %s\n", kclient.ChecksumTopic(sourceTopic))
+		}
+		pool = newWriterPool(*writers, []string{brokers}, topics, func(w *gokafka.Writer) {
+			w.Compression = codec
+			if *idempotent {
+				w.RequiredAcks = gokafka.RequireAll
+			}
+			if *keyByID {
+				// The Java client's default partitioner, so other producers of the same ids agree
+				w.Balancer = &gokafka.Murmur2Balancer{}
+			}
+			var done func([]gokafka.Message, error)
+			if acks != nil {
+				done = acks.completion
+			}
+			done = metrics.completion(done)
+			if checksums != nil {
+				// Behind the retrier too, so it sees the offsets of first deliveries
+				done = checksums.Completion(done)
+			}
+			w.Completion = done
+		}, retry)
+		if len(topics) > 1 {
+			fmt.Printf("[Producer] Fanning out every record to %d topics: %s\n", len(topics), strings.Join(topics, ", "))
+		}
+		// Don't use defer - we'll explicitly close after wg.Wait() to ensure flush.
+		// Identical data goes to every topic, so each batch is sampled once.
+		var out kclient.MessageWriter = pool
+		if *quotaBackoff {
+			var all []*gokafka.Writer
+			for _, lane := range pool.lanes {
+				all = append(all, lane.writers...)
+			}
+			quota = kclient.NewQuotaThrottle(pool, "[Producer]", all...)
+			pool.quota = quota
+			out = quota
+		}
+		sampler = kclient.NewCompressionSampler(out, pool.lanes[0].writers[0].Compression, 10)
+	}
+	saveCheckpoint := func() {
+		acks.snapshot(progress)
+		if err := progress.write(*checkpointPath); err != nil {
+			fmt.Fprintf(os.Stderr, "[WARN] Failed to write checkpoint: %v\n", err)
+		}
+	}
+	stopCheckpoints := make(chan struct{})
+	if acks != nil {
+		if progress.produced() > 0 {
+			fmt.Printf("[Producer] Resuming from %s: %d / %d records already produced\n",
+				*checkpointPath, progress.produced(), progress.Total)
+		}
+		go func() {
+			tick := time.NewTicker(*checkpointEvery)
+			defer tick.Stop()
+			// An interrupt saves what was acknowledged so far rather than the last tick
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(interrupt)
+			for {
+				select {
+				case <-tick.C:
+					saveCheckpoint()
+				case sig := <-interrupt:
+					saveCheckpoint()
+					fmt.Fprintf(os.Stderr, "[Producer] %v: checkpoint written to %s (%d / %d records), rerun with --resume\n",
+						sig, *checkpointPath, progress.produced(), progress.Total)
+					os.Exit(130)
+				case <-stopCheckpoints:
+					return
+				}
+			}
+		}()
+	}
 
-	// Jobs channel to bound generation to exactly totalRecords
-	jobs := make(chan struct{}, queueSize)
-	records := make(chan []byte, queueSize)
-	// Slightly higher concurrency to better saturate CPU when generating
-	numWorkers := runtime.NumCPU() * 3
+	// Jobs channel to bound generation to exactly the records not yet produced
+	jobs := make(chan int64, settings.QueueSize)
+	records := make(chan indexedRecord, settings.QueueSize)
+	// Slightly higher concurrency (NumCPU*3 unless auto-tuned) to better saturate CPU when generating
+	numWorkers := settings.Workers
 
+	// finish keys, encodes and stamps a record as the flags ask
+	finish := func(r indexedRecord) indexedRecord {
+		metrics.generated.Add(1)
+		if *keyByID && r.key == nil {
+			r.key = recordFormat.ID(r.value)
+		}
+		if avroSchema != nil {
+			var err error
+			if r.value, err = avroSchema.EncodeCSV(avro.AppendFrame(make([]byte, 0, len(r.value)+8), schemaID), r.value); err != nil {
+				fmt.Fprintf(os.Stderr, "[ERROR] record %d: %v\n", r.index, err)
+				os.Exit(1)
+			}
+		}
+		if *timestamps {
+			r.time = time.Now()
+		}
+		return r
+	}
+	var input chan indexedRecord // records read from --input-dir
+	if inDir != nil {
+		input = make(chan indexedRecord, settings.QueueSize)
+	}
+
+	var dataset tally
 	var wg sync.WaitGroup
 	// Generators
 	wg.Add(numWorkers)
 	for i := 0; i < numWorkers; i++ {
 		go func() {
 			defer wg.Done()
-			for range jobs {
-				rec := datagen.GenerateRandomRecord()
-				records <- rec
+			var part *tallyPart
+			if *manifestTopic != "" {
+				part = &tallyPart{}
+				defer dataset.merge(part)
+			}
+			if input != nil {
+				for r := range input {
+					r = finish(r)
+					if part != nil {
+						part.add(r.value, "")
+					}
+					records <- r
+				}
+				return
+			}
+			for i := range jobs {
+				r := indexedRecord{index: i}
+				// A duplicate is generated as its original, malformation included
+				id := *startID + i
+				src := duplicator.Of(id)
+				if src != id {
+					duplicates.Add(1)
+				}
+				var fl datagen.Fields
+				if *seed != 0 {
+					fl = dist.SeededFields(*seed, src)
+				} else {
+					fl = dist.RandomFields()
+				}
+				weights.Apply(&fl, *seed, src)
+				if tmpl != nil {
+					var err error
+					if r.value, err = tmpl.Render(fl); err != nil {
+						fmt.Fprintf(os.Stderr, "[ERROR] record %d: %v\n", i, err)
+						os.Exit(1)
+					}
+					if *keyByID {
+						r.key = strconv.AppendInt(nil, int64(fl.ID), 10)
+					}
+				} else {
+					var kind int
+					if r.value, kind = malformer.Apply(recordFormat, recordFormat.Encode(fl), src); kind >= 0 {
+						malformed[kind].Add(1)
+					}
+				}
+				r = finish(r)
+				if part != nil {
+					part.add(r.value, fl.Continent)
+				}
+				records <- r
 			}
 		}()
 	}
-
-	// Enqueue exactly totalRecords generation jobs
+	// Closed once the workers are done, which ends an --input-dir run
 	go func() {
-		for i := 0; i < totalRecords; i++ {
-			jobs <- struct{}{}
-		}
-		close(jobs)
+		wg.Wait()
+		close(records)
 	}()
 
+	// Enqueue one generation job per record still to be produced
+	toProduce := int(progress.Total - progress.produced())
+	if inDir != nil {
+		toProduce = math.MaxInt // until the files run out
+		go func() {
+			n, err := inDir.stream(input)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[ERROR] --input-dir: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("[Input] Read %d records from %d files in %s\n", n, len(inDir.files), inDir.dir)
+		}()
+	} else if rot != nil {
+		rot.start = start
+		toProduce = *totalRecords * *datasets
+		if *datasets == 0 {
+			toProduce = math.MaxInt
+		}
+		go rot.enqueue(jobs)
+	} else {
+		go func() {
+			progress.pending(func(i int64) { jobs <- i })
+			close(jobs)
+		}()
+	}
+
+	control.setTotal(toProduce)
+
 	// Publisher with batching
 	fmt.Println("[Producer] Starting Kafka writes...")
 	publishStart := time.Now()
 
 	ctx := context.Background()
 	sent := 0
-	batch := make([]gokafka.Message, 0, 1000)
+	base := int(progress.produced())
+	var discardedBytes int64
+	batch := make([]gokafka.Message, 0, settings.BatchSize)
+	var sizer *batchSizer
+	if *adaptiveBatch {
+		sizer = newBatchSizer(settings.BatchSize, *maxInflight, len(topics))
+		batch = make([]gokafka.Message, 0, maxAdaptiveBatch)
+		fmt.Printf("[Producer] Adaptive batching from %d records, at most %d messages in flight\n", sizer.size, *maxInflight)
+	}
+	// Checkpoint logging (requirement #4): every 1M records, or every 5% of smaller runs
+	progressEvery := min(max(*totalRecords/20, 1), 1_000_000)
+	if inDir != nil {
+		progressEvery = 1_000_000
+	}
+	nextProgress := (base/progressEvery + 1) * progressEvery
+	var display *progressDisplay
+	if *tui {
+		display = &progressDisplay{out: os.Stdout, base: int64(base), perRec: int64(len(topics)), records: records, pool: pool, control: control}
+		if toProduce != math.MaxInt {
+			display.total = int64(base + toProduce)
+		}
+		if *noKafka {
+			display.perRec = 1 // discarded batches count once
+		}
+		display.run()
+	}
+	feed := &queue{name: "jobs", len: func() int { return len(jobs) }, cap: cap(jobs)}
+	if input != nil {
+		feed = &queue{name: "input", len: func() int { return len(input) }, cap: cap(input)}
+	}
+	queued := []*queue{feed, {name: "records", len: func() int { return len(records) }, cap: cap(records)}}
+	if pool != nil {
+		queued = append(queued, &queue{name: "batches", len: func() int { return len(pool.batches) }, cap: cap(pool.batches)})
+	}
+	queues := startQueueMonitor(*queueStatsEvery, queued...)
+	metrics.queues.Store(queues)
+	runHeader := gokafka.Header{Key: kclient.ProducerRunHeader, Value: []byte(*runID)}
 
-	for sent < totalRecords {
+	for sent < toProduce {
+		if !control.wait() {
+			break
+		}
 		// Collect batch
 		batch = batch[:0]
-		for len(batch) < cap(batch) && sent < totalRecords {
-			msg := <-records
-			batch = append(batch, gokafka.Message{Value: msg})
+		limit := cap(batch)
+		if sizer != nil {
+			sizer.wait(sent)
+			limit = sizer.size
+		}
+		for len(batch) < limit && sent < toProduce {
+			rec, ok := <-records
+			if !ok {
+				// --input-dir exhausted
+				toProduce = sent
+				break
+			}
+			msg := gokafka.Message{Key: rec.key, Value: rec.value, Time: rec.time, WriterData: rec.index}
+			if rot != nil {
+				msg.Headers = rot.headersFor(rec.index)
+			}
+			if *provenance {
+				// Capped so the shared dataset headers are copied, not appended to
+				msg.Headers = append(msg.Headers[:len(msg.Headers):len(msg.Headers)], runHeader,
+					gokafka.Header{Key: kclient.RecordIndexHeader, Value: strconv.AppendInt(nil, rec.index, 10)})
+			}
+			batch = append(batch, msg)
 			sent++
+			if rot != nil && sent%*totalRecords == 0 {
+				// Don't hold a dataset's last records back until the next one starts
+				break
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+		metrics.batches.Add(1)
+		if *noKafka {
+			for _, m := range batch {
+				discardedBytes += int64(len(m.Key) + len(m.Value))
+			}
+			metrics.written.Add(int64(len(batch)))
+		} else {
+			handed := time.Now()
+			if err := sampler.WriteMessages(ctx, batch...); err != nil {
+				fmt.Fprintf(os.Stderr, "[ERROR] Kafka write error: %v\n", err)
+			}
+			if sizer != nil {
+				sizer.observe(sent, time.Since(handed))
+			}
 		}
-		if err := writer.WriteMessages(ctx, batch...); err != nil {
-			fmt.Fprintf(os.Stderr, "[ERROR] Kafka write error: %v\n", err)
+		if display != nil {
+			display.sent.Store(int64(sent))
+		} else if base+sent >= nextProgress {
+			// With --rotate-every, progress is within the current dataset
+			done := base + sent
+			if rot != nil {
+				done = (sent-1)%*totalRecords + 1
+			}
+			if inDir != nil {
+				fmt.Printf("[Progress] Produced %d records\n", done)
+			} else if rot == nil || done < *totalRecords {
+				fmt.Printf("[Progress] Produced %d / %d records (%.1f%%)\n",
+					done, *totalRecords, float64(done)/float64(*totalRecords)*100)
+			}
+			for nextProgress <= base+sent {
+				nextProgress += progressEvery
+			}
 		}
-		// Checkpoint logging every 1M records (requirement #4)
-		if sent%1_000_000 == 0 {
-			fmt.Printf("[Progress] Produced %d / %d records (%.1f%%)\n",
-				sent, totalRecords, float64(sent)/float64(totalRecords)*100)
+		if rot != nil && sent%*totalRecords == 0 {
+			fmt.Printf("[Dataset] Dataset %s complete: %d records (%d records in %d datasets so far)\n",
+				rot.label(int64(sent / *totalRecords - 1)), *totalRecords, sent, sent / *totalRecords)
 		}
 	}
 
-	close(records)
-	wg.Wait()
+	if control.aborted() {
+		// Generated records still queued were never handed to the writers; the
+		// workers are left blocked, since the jobs of a rotation never run out
+		toProduce = sent
+		fmt.Printf("[Producer] Aborted after %d records\n", sent)
+	} else {
+		wg.Wait()
+	}
 
 	// Ensure all async writes are flushed before exiting
-	fmt.Println("[Producer] Flushing remaining Kafka writes...")
-	if err := writer.Close(); err != nil {
-		fmt.Fprintf(os.Stderr, "[ERROR] Failed to flush Kafka writer: %v\n", err)
+	if pool != nil {
+		fmt.Println("[Producer] Flushing remaining Kafka writes...")
+		pool.Close()
+		quota.Stop()
+		retry.close()
+		if checksums != nil {
+			if err := checksums.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "[ERROR] Failed to flush batch checksums: %v\n", err)
+			}
+		}
+	}
+	if acks != nil {
+		close(stopCheckpoints)
+		saveCheckpoint()
+		if n := acks.failures(); n > 0 {
+			fmt.Fprintf(os.Stderr, "[ERROR] %d records were not delivered; rerun with --resume to produce them\n", n)
+		} else if progress.complete() {
+			// Nothing is left to resume, and the file would stop the next run
+			if err := os.Remove(*checkpointPath); err != nil {
+				fmt.Fprintf(os.Stderr, "[WARN] Failed to remove the finished checkpoint: %v\n", err)
+			}
+		}
+	}
+	display.close()
+	memLog.close()
+	queues.close()
+	// Markers and the manifest promise a complete dataset, and a --resume run would
+	// append after the markers
+	var undelivered int64
+	if retry != nil {
+		undelivered = retry.spooled.Load() + retry.lost.Load()
+	}
+	if acks != nil {
+		undelivered += acks.failures()
+	}
+	datasetRecords := int64(*totalRecords)
+	if inDir != nil {
+		datasetRecords = int64(sent)
+	}
+	if *endMarker && !control.aborted() {
+		if undelivered > 0 {
+			fmt.Fprintf(os.Stderr, "[WARN] Not writing end-of-stream markers: %d records were not delivered\n", undelivered)
+		} else {
+			for _, t := range topics {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				n, err := kclient.WriteEndMarkers(ctx, []string{brokers}, t, datasetRecords)
+				cancel()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "[ERROR] End-of-stream markers for %s: %v\n", t, err)
+					os.Exit(1)
+				}
+				fmt.Printf("[Producer] End-of-stream marker (%d records) written to %d partitions of %s\n", datasetRecords, n, t)
+			}
+		}
+	}
+	if *manifestTopic != "" && !control.aborted() {
+		if undelivered > 0 {
+			fmt.Fprintf(os.Stderr, "[WARN] Not writing the manifest: %d records were not delivered\n", undelivered)
+		} else {
+			m := kclient.Manifest{
+				RunID: *runID, Topics: topics, Records: datasetRecords, Continents: dataset.continents,
+				Checksum: dataset.checksum.String(), Seed: *seed, CreatedAt: time.Now().UTC(),
+				StartID: *startID, NextID: *startID + datasetRecords,
+			}
+			if inDir == nil {
+				m.Format, m.Distribution, m.Template = recordFormat.String(), dist.String(), *valueTemplate
+			}
+			if appendTo != nil {
+				// Checksums and counts of disjoint records add up
+				m.Records += appendTo.Records
+				m.Checksum = (appendChecksum + dataset.checksum).String()
+				m.StartID = appendTo.StartID
+				m.Runs = append(append([]string(nil), appendTo.Runs...), *runID)
+				if len(appendTo.Runs) == 0 {
+					m.Runs = []string{appendTo.RunID, *runID}
+				}
+				if appendTo.Continents == nil {
+					m.Continents = nil // unknown for part of the dataset
+				}
+				for c, n := range appendTo.Continents {
+					if m.Continents != nil {
+						m.Continents[c] += n
+					}
+				}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err := kclient.WriteManifest(ctx, []string{brokers}, *manifestTopic, m)
+			cancel()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[ERROR] Manifest: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("[Producer] Manifest of %d records (checksum %s) written to %s\n", m.Records, m.Checksum, *manifestTopic)
+		}
 	}
 
 	publishDuration := time.Since(publishStart)
 	totalDuration := time.Since(start)
 
 	// Performance summary (requirement #7)
-	fmt.Printf("\n[Summary] Producer completed successfully\n")
-	fmt.Printf("  - Total records: %d\n", totalRecords)
+	if control.aborted() {
+		fmt.Printf("\n[Summary] Producer aborted\n")
+	} else {
+		fmt.Printf("\n[Summary] Producer completed successfully\n")
+	}
+	if rot != nil {
+		// An unbounded (--datasets 0) or aborted rotation ends part way
+		done, of := sent / *totalRecords, " (unbounded)"
+		if *datasets > 0 {
+			of = fmt.Sprintf(" of %d", *datasets)
+		}
+		if done > 0 {
+			fmt.Printf("  - Datasets: %d%s complete, %d records each (%s to %s)\n", done, of, *totalRecords, rot.label(0), rot.label(int64(done-1)))
+		} else {
+			fmt.Printf("  - Datasets: none%s complete, %d records each\n", of, *totalRecords)
+		}
+	}
+	fmt.Printf("  - Run id: %s\n", *runID)
+	fmt.Printf("  - Total records: %d\n", toProduce)
+	if appendTo != nil {
+		fmt.Printf("  - Appended: record ids %d-%d to the dataset of run %s, now %d records\n",
+			*startID, *startID+int64(toProduce)-1, appendTo.RunID, appendTo.Records+int64(toProduce))
+	} else if *startID > 0 {
+		fmt.Printf("  - Record ids: %d-%d\n", *startID, *startID+int64(toProduce)-1)
+	}
+	if dist != datagen.Uniform {
+		fmt.Printf("  - Key distribution: %s\n", dist)
+	}
+	if weights != nil {
+		fmt.Printf("  - Continent weights: %s\n", weights)
+	}
+	if *malformedPercent > 0 {
+		var total int64
+		kinds := make([]string, len(malformed))
+		for k := range malformed {
+			total += malformed[k].Load()
+			kinds[k] = fmt.Sprintf("%s %d", datagen.Malformations[k], malformed[k].Load())
+		}
+		fmt.Printf("  - Malformed: %d records (%s)\n", total, strings.Join(kinds, ", "))
+	}
+	if *duplicatePercent > 0 {
+		fmt.Printf("  - Duplicates: %d records repeat an earlier one\n", duplicates.Load())
+	}
+	if inDir != nil {
+		fmt.Printf("  - Input: %d files in %s\n", len(inDir.files), inDir.dir)
+	}
+	if len(topics) > 1 {
+		fmt.Printf("  - Topics: %s (every record written to each, %d messages in total)\n",
+			strings.Join(topics, ", "), toProduce*len(topics))
+	}
+	if base > 0 {
+		fmt.Printf("  - Resumed after: %d records\n", base)
+	}
 	fmt.Printf("  - Total time: %v\n", totalDuration)
 	fmt.Printf("  - Publish time: %v\n", publishDuration)
-	fmt.Printf("  - Throughput: %.0f records/sec\n", float64(totalRecords)/totalDuration.Seconds())
+	if d := control.pauseTime(); d > 0 {
+		fmt.Printf("  - Paused: %v\n", d.Round(time.Millisecond))
+	}
+	fmt.Printf("  - Throughput: %.0f records/sec\n", float64(toProduce)/totalDuration.Seconds())
+	if sampler != nil {
+		fmt.Printf("  - Compression (%s, sampled): ratio %.2f (%d -> ~%d bytes)\n",
+			sampler.Compression(), sampler.Ratio(), sampler.RawBytes(), sampler.CompressedBytes())
+	}
+	if pool != nil && len(pool.lanes) > 1 {
+		pool.printStats(publishDuration)
+	}
+	if sizer != nil {
+		sizer.printStats()
+	}
+	if retry != nil {
+		retry.printStats()
+	}
+	if checksums != nil {
+		st := checksums.Stats()
+		fmt.Printf("  - Batch checksums: %d batches (%d records) published to %s", st.Batches, st.Records, kclient.ChecksumTopic(sourceTopic))
+		if st.Unchecked > 0 {
+			fmt.Printf(", %d retried records without one", st.Unchecked)
+		}
+		if st.Failed > 0 {
+			fmt.Printf(", %d could not be written", st.Failed)
+		}
+		fmt.Println()
+	}
+	queues.printSummary()
+	if st := quota.Stats(); st.Events > 0 {
+		fmt.Printf("  - Quota throttling: %d events, brokers throttled %v in total (max %v), %d batches paused for %v (peak pause %v)\n",
+			st.Events, st.Throttled, st.MaxThrottle, st.Pauses, st.Paced.Round(time.Millisecond), st.PeakDelay)
+	}
+	if *noKafka {
+		fmt.Printf("  - Discarded bytes: %d (%.1f MB/sec)\n",
+			discardedBytes, float64(discardedBytes)/(1024*1024)/totalDuration.Seconds())
+	}
+
+	if *descriptorPath != "" && !control.aborted() {
+		d := datagen.Descriptor{
+			Topic: sourceTopic, Records: int64(*totalRecords), StartID: *startID, Seed: *seed, Format: recordFormat.String(), Template: *valueTemplate,
+			Distribution: dist.String(), ContinentWeights: progress.ContinentWeights,
+			MalformedPercent: *malformedPercent, DuplicatePercent: *duplicatePercent, KeyByID: *keyByID,
+			RunID: *runID, CreatedAt: time.Now().UTC(),
+		}
+		if avroSchema != nil {
+			d.Schema, _ = os.ReadFile(*schemaPath) // parsed at startup
+		}
+		var publishTo []string
+		if !*noKafka {
+			publishTo = []string{brokers}
+		}
+		if err := saveDescriptor(d, *descriptorPath, publishTo, topics); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] --descriptor: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// indexedRecord is a generated record and its position in the dataset, which the
+// writer's delivery reports map back to the checkpoint.
+type indexedRecord struct {
+	index int64
+	key   []byte // record id with --key-by-id
+	value []byte
+	time  time.Time // generation time with --timestamps
+}
+
+// waitForTopic blocks until all partitions of topic have leaders, optionally
+// pre-opening connections to every leader.
+func waitForTopic(brokers []string, topic string, timeout time.Duration, prewarm bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	fmt.Printf("[Producer] Waiting up to %v for topic %s to be ready...\n", timeout, topic)
+	waitStart := time.Now()
+	partitions, err := kclient.WaitForTopic(ctx, brokers, topic, 500*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[Producer] Topic %s ready: %d partitions with leaders (%v)\n", topic, len(partitions), time.Since(waitStart))
+
+	if prewarm {
+		n, err := kclient.PrewarmBrokers(ctx, partitions)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("[Producer] Pre-warmed connections to %d brokers\n", n)
+	}
+	return nil
 }
 
 func getenv(k, def string) string {
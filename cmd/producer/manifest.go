@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sync"
+
+	kclient "core-infra-project/internal/kafka"
+)
+
+// tally accumulates the --manifest-topic checksum and continent counts. Each worker
+// keeps its own part and adds it once it is done, so the hot loop takes no lock.
+type tally struct {
+	mu         sync.Mutex
+	checksum   kclient.ValueChecksum
+	continents map[string]int64 // of generated records, so nil for --input-dir
+}
+
+// tallyPart is one worker's share of a tally.
+type tallyPart struct {
+	checksum   kclient.ValueChecksum
+	continents map[string]int64
+}
+
+func (p *tallyPart) add(value []byte, continent string) {
+	p.checksum.Add(value)
+	if continent != "" {
+		if p.continents == nil {
+			p.continents = make(map[string]int64)
+		}
+		p.continents[continent]++
+	}
+}
+
+func (t *tally) merge(p *tallyPart) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.checksum += p.checksum
+	for c, n := range p.continents {
+		if t.continents == nil {
+			t.continents = make(map[string]int64)
+		}
+		t.continents[c] += n
+	}
+}
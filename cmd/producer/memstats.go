@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// memLogger logs a runtime.MemStats snapshot every interval, with the GC activity and
+// the records written since the previous one, so a throughput dip in the log can be
+// matched with the collections around it without attaching pprof.
+type memLogger struct {
+	every time.Duration
+	last  runtime.MemStats
+	at    time.Time
+	acked int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func startMemLogger(every time.Duration) *memLogger {
+	l := &memLogger{every: every, at: time.Now(), stop: make(chan struct{}), done: make(chan struct{})}
+	runtime.ReadMemStats(&l.last)
+	go func() {
+		defer close(l.done)
+		tick := time.NewTicker(every)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				l.log()
+			case <-l.stop:
+				return
+			}
+		}
+	}()
+	return l
+}
+
+// close stops logging. A nil logger does nothing.
+func (l *memLogger) close() {
+	if l == nil {
+		return
+	}
+	close(l.stop)
+	<-l.done
+}
+
+func (l *memLogger) log() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	now := time.Now()
+	acked := metrics.written.Load()
+	// PauseNs is a ring of the last 256 pauses; cycle n's is at (n+255)%256
+	cycles := ms.NumGC - l.last.NumGC
+	var maxPause time.Duration
+	for n := ms.NumGC; n > l.last.NumGC && n+256 > ms.NumGC; n-- {
+		maxPause = max(maxPause, time.Duration(ms.PauseNs[(n+255)%256]))
+	}
+	fmt.Printf("[Memory] heap in use %.1f MB (%d objects), from OS %.1f MB; %d GCs in %v (%d total), pauses %v max, %v total; GC CPU %.2f%% of the run; %d goroutines; %.0f records/sec\n",
+		float64(ms.HeapInuse)/(1<<20), ms.HeapObjects, float64(ms.Sys)/(1<<20), cycles, l.every, ms.NumGC,
+		maxPause, time.Duration(ms.PauseTotalNs-l.last.PauseTotalNs), ms.GCCPUFraction*100, runtime.NumGoroutine(),
+		float64(acked-l.acked)/now.Sub(l.at).Seconds())
+	l.last, l.at, l.acked = ms, now, acked
+}
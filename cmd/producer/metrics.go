@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kmetrics "core-infra-project/internal/metrics"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// throughputWindow is the span the throughput gauge averages over.
+const throughputWindow = 10 * time.Second
+
+// metrics are the producer's counters, served in the Prometheus text format at
+// /metrics on the pprof server, so long runs can be graphed instead of scraped from
+// the progress logs. Serve mode jobs count into the same metrics.
+var metrics producerMetrics
+
+type producerMetrics struct {
+	runID string
+	retry *retrier // nil with --no-kafka
+
+	generated atomic.Int64 // records generated or read from --input-dir
+	batches   atomic.Int64 // batches handed to the writers
+	written   atomic.Int64 // records acknowledged by Kafka (discarded with --no-kafka)
+	failed    atomic.Int64 // records undelivered after retries
+	queues    atomic.Pointer[queueMonitor]
+
+	mu      sync.Mutex
+	samples []int64 // written, once a second, newest last
+}
+
+// start samples written every second for the throughput gauge.
+func (m *producerMetrics) start(runID string, retry *retrier) {
+	m.runID, m.retry = runID, retry
+	go func() {
+		tick := time.NewTicker(time.Second)
+		defer tick.Stop()
+		for range tick.C {
+			m.mu.Lock()
+			m.samples = append(m.samples, m.written.Load())
+			if len(m.samples) > int(throughputWindow/time.Second)+1 {
+				m.samples = m.samples[1:]
+			}
+			m.mu.Unlock()
+		}
+	}()
+}
+
+// completion counts the outcome of a delivery, then hands it to next (if any). It is
+// installed as the writers' Completion, behind the retrier, so it sees final outcomes.
+func (m *producerMetrics) completion(next func([]gokafka.Message, error)) func([]gokafka.Message, error) {
+	return func(msgs []gokafka.Message, err error) {
+		if err != nil {
+			m.failed.Add(int64(len(msgs)))
+		} else {
+			m.written.Add(int64(len(msgs)))
+		}
+		if next != nil {
+			next(msgs, err)
+		}
+	}
+}
+
+// throughput returns the written records per second over the sampled window.
+func (m *producerMetrics) throughput() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.samples) < 2 {
+		return 0
+	}
+	return float64(m.samples[len(m.samples)-1]-m.samples[0]) / float64(len(m.samples)-1)
+}
+
+func (m *producerMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	labels := kmetrics.Labels("run_id", m.runID)
+	metric := func(name, kind, help string, v float64) { kmetrics.Write(w, name, kind, help, labels, v) }
+	metric(kmetrics.ProducerGenerated, "counter", "Records generated, or read from --input-dir.", float64(m.generated.Load()))
+	metric(kmetrics.ProducerBatches, "counter", "Batches handed to the Kafka writers.", float64(m.batches.Load()))
+	metric(kmetrics.ProducerWritten, "counter", "Records acknowledged by Kafka, once per SOURCE_TOPICS topic (discarded with --no-kafka).", float64(m.written.Load()))
+	metric(kmetrics.ProducerFailed, "counter", "Records still undelivered after --retries.", float64(m.failed.Load()))
+	var writeErrors, spooled int64
+	if m.retry != nil {
+		writeErrors, spooled = m.retry.failures.Load(), m.retry.spooled.Load()
+	}
+	metric(kmetrics.ProducerWriteErrors, "counter", "Failed Kafka writes, retries included.", float64(writeErrors))
+	metric(kmetrics.ProducerSpooled, "counter", "Undelivered records appended to --spool.", float64(spooled))
+	metric(kmetrics.ProducerThroughput, "gauge", "Records written per second over the last 10s.", m.throughput())
+	if q := m.queues.Load(); q != nil {
+		kmetrics.Family(w, kmetrics.ProducerQueueLength, "gauge", "Entries queued between producer stages: record indices (jobs) or records read (input), generated records (records), batches for the writers (batches).")
+		q.lengths(func(name string, n, _ int) {
+			kmetrics.Sample(w, kmetrics.ProducerQueueLength, kmetrics.Labels("run_id", m.runID, "queue", name), float64(n))
+		})
+		kmetrics.Family(w, kmetrics.ProducerQueueCap, "gauge", "Capacity of the queues between producer stages.")
+		q.lengths(func(name string, _, capacity int) {
+			kmetrics.Sample(w, kmetrics.ProducerQueueCap, kmetrics.Labels("run_id", m.runID, "queue", name), float64(capacity))
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	kclient "core-infra-project/internal/kafka"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// writerPool spreads the publisher's batches over several writers, since a single
+// gokafka.Writer serializes WriteMessages on its own lock and caps throughput at high
+// generation rates. Each lane is a goroutine owning one writer per topic (all sharing
+// kafka-go's default transport, so connections are pooled rather than multiplied);
+// lanes take batches in turn, so with more than one the records of different batches
+// may reach a partition out of order.
+type writerPool struct {
+	lanes   []*writerLane
+	batches chan []gokafka.Message
+	wg      sync.WaitGroup
+	retry   *retrier               // nil: failed writes are left to the writers' Completion
+	quota   *kclient.QuotaThrottle // if set, reads the writers' stats
+}
+
+type writerLane struct {
+	writers []*gokafka.Writer   // one per topic, in SOURCE_TOPICS order
+	stats   gokafka.WriterStats // of the first topic's writer, taken at Close
+}
+
+// newWriterPool starts n lanes writing to topics; setup adjusts every writer before
+// use (balancer, delivery callback), and retry, if not nil, then takes the failures.
+func newWriterPool(n int, brokers, topics []string, setup func(*gokafka.Writer), retry *retrier) *writerPool {
+	p := &writerPool{batches: make(chan []gokafka.Message, n), retry: retry}
+	for i := 0; i < n; i++ {
+		lane := &writerLane{}
+		for _, t := range topics {
+			w := kclient.NewWriter(brokers, t)
+			setup(w)
+			if retry != nil {
+				retry.wrap(w)
+			}
+			lane.writers = append(lane.writers, w)
+		}
+		p.lanes = append(p.lanes, lane)
+		p.wg.Add(1)
+		go p.run(lane)
+	}
+	return p
+}
+
+func (p *writerPool) run(lane *writerLane) {
+	defer p.wg.Done()
+	for batch := range p.batches {
+		for _, w := range lane.writers {
+			if err := w.WriteMessages(context.Background(), batch...); err != nil {
+				fmt.Fprintf(os.Stderr, "[ERROR] Kafka write error (%s): %v\n", w.Topic, err)
+				// Async writers fail some writes (e.g. metadata lookups) before queueing
+				// anything, so report them like failed deliveries, for retries and acks
+				if w.Completion != nil {
+					w.Completion(batch, err)
+				}
+			}
+		}
+	}
+}
+
+// WriteMessages implements kclient.MessageWriter by handing a copy of msgs to the next
+// free lane, so the caller may reuse msgs. Write errors are logged by the lane.
+func (p *writerPool) WriteMessages(_ context.Context, msgs ...gokafka.Message) error {
+	p.batches <- append([]gokafka.Message(nil), msgs...)
+	return nil
+}
+
+// Close waits for the lanes to write every queued batch, then flushes and closes
+// their writers and waits for the retries of any failed writes.
+func (p *writerPool) Close() {
+	close(p.batches)
+	p.wg.Wait()
+	for _, lane := range p.lanes {
+		for _, w := range lane.writers {
+			if err := w.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "[ERROR] Failed to flush Kafka writer for %s: %v\n", w.Topic, err)
+			}
+		}
+		if p.quota != nil {
+			lane.stats = p.quota.WriterStats(lane.writers[0])
+		} else {
+			lane.stats = lane.writers[0].Stats()
+		}
+	}
+	if p.retry != nil {
+		p.retry.wait()
+	}
+}
+
+// printStats prints each lane's share of the first topic's messages after Close.
+func (p *writerPool) printStats(d time.Duration) {
+	fmt.Printf("  - Writers: %d per topic\n", len(p.lanes))
+	for i, lane := range p.lanes {
+		s := lane.stats
+		fmt.Printf("    - writer %d: %d messages (%.1f MB) in %d requests, %.0f records/sec, %d errors\n",
+			i, s.Messages, float64(s.Bytes)/(1024*1024), s.Writes, float64(s.Messages)/d.Seconds(), s.Errors)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// queueSampleEvery is how often the queue monitor reads the queues' lengths.
+const queueSampleEvery = 100 * time.Millisecond
+
+// Average fill above which a queue counts as backed up, and below which as starved.
+const (
+	queueBusy = 0.75
+	queueIdle = 0.25
+)
+
+// queue is one of the channels between the producer's stages, as sampled.
+type queue struct {
+	name string // label in logs and /metrics
+	len  func() int
+	cap  int
+
+	window fillStats // since the last [Queues] line
+	run    fillStats
+}
+
+// fillStats aggregates samples of a queue's fill, 0 (empty) to 1 (full).
+type fillStats struct {
+	samples   int64
+	sum       float64
+	low, high float64
+}
+
+func (s *fillStats) add(fill float64) {
+	if s.samples == 0 || fill < s.low {
+		s.low = fill
+	}
+	s.high = max(s.high, fill)
+	s.sum += fill
+	s.samples++
+}
+
+func (s fillStats) avg() float64 {
+	if s.samples == 0 {
+		return 0
+	}
+	return s.sum / float64(s.samples)
+}
+
+// queueMonitor samples how full the producer's queues are, to tell which stage holds
+// the run back. Records flow from the job feed (record indices, or records read from
+// --input-dir) through the generators into the records queue, and from the publisher
+// as batches through the writer lanes to Kafka: a queue stays full while the stage
+// draining it is the slower one, and empty while the stage filling it is. Channels
+// can't be resized in place, so the monitor reports rather than adapts; --queue-size
+// and --workers (or --auto-tune) are the knobs it points at.
+type queueMonitor struct {
+	every time.Duration // between [Queues] lines, 0 if not logged
+
+	mu     sync.Mutex
+	queues []*queue // feed, records, then batches if writing to Kafka
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startQueueMonitor starts sampling queues, logging their fill every interval if not 0.
+func startQueueMonitor(every time.Duration, queues ...*queue) *queueMonitor {
+	m := &queueMonitor{every: every, queues: queues, stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(m.done)
+		tick := time.NewTicker(queueSampleEvery)
+		defer tick.Stop()
+		var logAt time.Time
+		if every > 0 {
+			logAt = time.Now().Add(every)
+		}
+		for {
+			select {
+			case now := <-tick.C:
+				m.sample()
+				if !logAt.IsZero() && !now.Before(logAt) {
+					m.log()
+					logAt = logAt.Add(every)
+				}
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// close stops sampling. A nil monitor does nothing.
+func (m *queueMonitor) close() {
+	if m == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+}
+
+func (m *queueMonitor) sample() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, q := range m.queues {
+		fill := float64(q.len()) / float64(q.cap)
+		q.window.add(fill)
+		q.run.add(fill)
+	}
+}
+
+func (m *queueMonitor) log() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	parts := make([]string, len(m.queues))
+	for i, q := range m.queues {
+		parts[i] = fmt.Sprintf("%s %.0f%% full (%.0f-%.0f%%)", q.name, q.window.avg()*100, q.window.low*100, q.window.high*100)
+	}
+	fmt.Printf("[Queues] %s over %v; bottleneck: %s\n", strings.Join(parts, ", "), m.every, m.bottleneck(func(q *queue) fillStats { return q.window }))
+	for _, q := range m.queues {
+		q.window = fillStats{}
+	}
+}
+
+// bottleneck names the stage the fill levels point at. m.mu is held.
+func (m *queueMonitor) bottleneck(stats func(*queue) fillStats) string {
+	feed, records := m.queues[0], m.queues[1]
+	if len(m.queues) > 2 && stats(m.queues[2]).avg() >= queueBusy {
+		return "Kafka writes (writer lanes busy; see --writers, --batch-size and broker throughput)"
+	}
+	switch {
+	case stats(records).avg() >= queueBusy:
+		return "publisher (records wait to be batched; paused, or --adaptive-batch holding back)"
+	case stats(records).avg() > queueIdle:
+		return "none (queues partly filled)"
+	case stats(feed).avg() > queueIdle:
+		return "generation (see --workers or --auto-tune)"
+	case feed.name == "input":
+		return "reading --input-dir"
+	}
+	return "job feed (waiting for the next --rotate-every dataset)"
+}
+
+// lengths returns each queue's name, current length and capacity.
+func (m *queueMonitor) lengths(fn func(name string, n, capacity int)) {
+	for _, q := range m.queues {
+		fn(q.name, q.len(), q.cap)
+	}
+}
+
+// printSummary prints the run's average fill and bottleneck as a summary line.
+func (m *queueMonitor) printSummary() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.queues[0].run.samples == 0 {
+		return // shorter than a sample
+	}
+	parts := make([]string, len(m.queues))
+	for i, q := range m.queues {
+		parts[i] = fmt.Sprintf("%s %.0f%%", q.name, q.run.avg()*100)
+	}
+	fmt.Printf("  - Queues: %s full on average; bottleneck: %s\n", strings.Join(parts, ", "), m.bottleneck(func(q *queue) fillStats { return q.run }))
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kclient "core-infra-project/internal/kafka"
+
+	gokafka "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/compress"
+)
+
+// maxRetryBackoff caps the doubling delay between retries.
+const maxRetryBackoff = 30 * time.Second
+
+// retrier re-sends the messages of failed writes, which the async writers only report
+// to their Completion callback, with exponential backoff. Messages still failing after
+// the last attempt are appended to the spool for --replay-spool rather than lost.
+type retrier struct {
+	brokers     []string
+	compression compress.Compression
+	attempts    int
+	backoff     time.Duration // before the first retry
+	requireAll  bool          // acks=all, for --idempotent
+	spool       *spoolFile
+
+	mu      sync.Mutex
+	writers map[string]*gokafka.Writer // synchronous, one per topic
+	wg      sync.WaitGroup
+
+	failures  atomic.Int64 // failed writes, retries included
+	retried   atomic.Int64 // records whose write failed at least once
+	recovered atomic.Int64
+	spooled   atomic.Int64
+	lost      atomic.Int64 // neither delivered nor spooled
+}
+
+// wrap puts r in front of w's Completion callback: failed messages are retried in the
+// background, and the original callback only learns their final outcome.
+func (r *retrier) wrap(w *gokafka.Writer) {
+	done := w.Completion
+	w.Completion = func(msgs []gokafka.Message, err error) {
+		if err == nil {
+			if done != nil {
+				done(msgs, nil)
+			}
+			return
+		}
+		r.failures.Add(1)
+		// Copies, cleared of what the writer assigns, since a message naming its
+		// topic cannot go through a writer that does too
+		failed := make([]gokafka.Message, len(msgs))
+		for i, m := range msgs {
+			m.Topic, m.Partition, m.Offset = "", 0, 0
+			failed[i] = m
+		}
+		msgs = failed
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			err := r.resend(w.Topic, msgs, err)
+			if done != nil {
+				done(msgs, err)
+			}
+		}()
+	}
+}
+
+func (r *retrier) resend(topic string, msgs []gokafka.Message, err error) error {
+	r.retried.Add(int64(len(msgs)))
+	delay := r.backoff
+	for attempt := 1; attempt <= r.attempts; attempt++ {
+		fmt.Fprintf(os.Stderr, "[WARN] Kafka write of %d records to %s failed (%v), retry %d/%d in %v\n",
+			len(msgs), topic, err, attempt, r.attempts, delay)
+		time.Sleep(delay)
+		delay = min(delay*2, maxRetryBackoff)
+		if err = r.writer(topic, msgs[0].Key != nil).WriteMessages(context.Background(), msgs...); err == nil {
+			r.recovered.Add(int64(len(msgs)))
+			return nil
+		}
+		r.failures.Add(1)
+	}
+	if r.spool != nil {
+		if serr := r.spool.write(topic, msgs); serr != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Failed to spool %d records: %v\n", len(msgs), serr)
+		} else {
+			r.spooled.Add(int64(len(msgs)))
+			fmt.Fprintf(os.Stderr, "[ERROR] %d records to %s failed after %d retries (%v), spooled to %s\n",
+				len(msgs), topic, r.attempts, err, r.spool.path)
+			return err
+		}
+	}
+	r.lost.Add(int64(len(msgs)))
+	fmt.Fprintf(os.Stderr, "[ERROR] %d records to %s failed after %d retries: %v\n", len(msgs), topic, r.attempts, err)
+	return err
+}
+
+// writer returns the synchronous writer retries to topic go through, so each attempt's
+// error is seen directly.
+func (r *retrier) writer(topic string, keyed bool) *gokafka.Writer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, ok := r.writers[topic]
+	if !ok {
+		w = kclient.NewWriter(r.brokers, topic)
+		w.Compression = r.compression
+		w.Async = false
+		w.MaxAttempts = 1 // attempts are counted by resend
+		if r.requireAll {
+			w.RequiredAcks = gokafka.RequireAll
+		}
+		if keyed {
+			// Keys come from --key-by-id, so keep its partitioning
+			w.Balancer = &gokafka.Murmur2Balancer{}
+		}
+		if r.writers == nil {
+			r.writers = make(map[string]*gokafka.Writer)
+		}
+		r.writers[topic] = w
+	}
+	return w
+}
+
+// wait blocks until every retry started so far has been delivered or given up.
+func (r *retrier) wait() {
+	r.wg.Wait()
+}
+
+// close waits for retries, then closes the retry writers and the spool.
+func (r *retrier) close() {
+	r.wait()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, w := range r.writers {
+		w.Close()
+	}
+	if r.spool != nil {
+		if err := r.spool.close(); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Failed to close spool %s: %v\n", r.spool.path, err)
+		}
+	}
+}
+
+// printStats prints the retry summary lines, if any write failed.
+func (r *retrier) printStats() {
+	if r.retried.Load() == 0 {
+		return
+	}
+	fmt.Printf("  - Retried: %d records (%d recovered)\n", r.retried.Load(), r.recovered.Load())
+	if n := r.spooled.Load(); n > 0 {
+		fmt.Printf("  - Spooled: %d undelivered records to %s (send them with --replay-spool %s)\n", n, r.spool.path, r.spool.path)
+	}
+	if n := r.lost.Load(); n > 0 {
+		fmt.Printf("  - Lost: %d undelivered records\n", n)
+	}
+}
+
+// spoolFile appends undeliverable messages as JSON lines, one spooledMessage each.
+// It is created on the first failure, so runs without one leave no file behind.
+type spoolFile struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+}
+
+type spooledMessage struct {
+	Topic   string           `json:"topic"`
+	Key     []byte           `json:"key,omitempty"`
+	Value   []byte           `json:"value"`
+	Headers []gokafka.Header `json:"headers,omitempty"`
+	Time    time.Time        `json:"time"`
+}
+
+func (s *spoolFile) write(topic string, msgs []gokafka.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		s.f, s.w = f, bufio.NewWriter(f)
+	}
+	enc := json.NewEncoder(s.w)
+	for _, m := range msgs {
+		if err := enc.Encode(spooledMessage{Topic: topic, Key: m.Key, Value: m.Value, Headers: m.Headers, Time: m.Time}); err != nil {
+			return err
+		}
+	}
+	// Flushed per batch so a crash loses at most the batch being written
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *spoolFile) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
+
+// replaySpool sends every message of the spool at path to its topic, synchronously
+// in batches of batchSize compressed with codec, and returns the number sent.
+func replaySpool(path string, brokers []string, batchSize int, codec compress.Compression) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	writers := make(map[string]*gokafka.Writer)
+	pending := make(map[string][]gokafka.Message)
+	defer func() {
+		for _, w := range writers {
+			w.Close()
+		}
+	}()
+	var n int64
+	flush := func(topic string) error {
+		if err := writers[topic].WriteMessages(context.Background(), pending[topic]...); err != nil {
+			return fmt.Errorf("%d records to %s: %w", len(pending[topic]), topic, err)
+		}
+		n += int64(len(pending[topic]))
+		pending[topic] = pending[topic][:0]
+		return nil
+	}
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for line := 1; ; line++ {
+		var m spooledMessage
+		if err := dec.Decode(&m); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return n, fmt.Errorf("%s: record %d: %w", path, line, err)
+		}
+		if _, ok := writers[m.Topic]; !ok {
+			w := kclient.NewWriter(brokers, m.Topic)
+			w.Compression = codec
+			w.Async = false
+			if m.Key != nil {
+				// Spooled keys come from --key-by-id, so keep its partitioning
+				w.Balancer = &gokafka.Murmur2Balancer{}
+			}
+			writers[m.Topic] = w
+		}
+		pending[m.Topic] = append(pending[m.Topic], gokafka.Message{Key: m.Key, Value: m.Value, Headers: m.Headers, Time: m.Time})
+		if len(pending[m.Topic]) == batchSize {
+			if err := flush(m.Topic); err != nil {
+				return n, err
+			}
+		}
+	}
+	for topic, msgs := range pending {
+		if len(msgs) > 0 {
+			if err := flush(topic); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	kclient "core-infra-project/internal/kafka"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// rotation emits the dataset as a series of daily batches: batch k holds --records
+// records tagged with a DatasetHeader of first+k days and starts k intervals after the
+// run, so a day of batches can be replayed in minutes. Record indices continue across
+// batches, which keeps seeded batches distinct yet reproducible.
+type rotation struct {
+	every   time.Duration
+	count   int // 0 runs until interrupted
+	first   time.Time
+	records int64
+	start   time.Time
+
+	headers []gokafka.Header // of the batch last asked for
+	batch   int64
+}
+
+// label names batch k by its date.
+func (r *rotation) label(k int64) string {
+	return r.first.AddDate(0, 0, int(k)).Format(time.DateOnly)
+}
+
+// headersFor returns the headers of the record with index i. Consecutive records
+// share one slice, which the writer only reads.
+func (r *rotation) headersFor(i int64) []gokafka.Header {
+	k := i / r.records
+	if r.headers == nil || k != r.batch {
+		r.batch = k
+		r.headers = []gokafka.Header{{Key: kclient.DatasetHeader, Value: []byte(r.label(k))}}
+	}
+	return r.headers
+}
+
+// enqueue sends the record indices of every batch to jobs, waiting for each batch's
+// start time, and closes jobs after the last one.
+func (r *rotation) enqueue(jobs chan<- int64) {
+	for k := int64(0); r.count == 0 || k < int64(r.count); k++ {
+		if k > 0 {
+			due := r.start.Add(time.Duration(k) * r.every)
+			if wait := time.Until(due); wait > 0 {
+				fmt.Printf("[Dataset] Next dataset %s in %v\n", r.label(k), wait.Round(time.Second))
+				time.Sleep(wait)
+			} else {
+				fmt.Printf("[Dataset] Warning: dataset %s starts %v late; the previous one took longer than --rotate-every\n",
+					r.label(k), (-wait).Round(time.Second))
+			}
+		}
+		fmt.Printf("[Dataset] Producing dataset %s (%d records)\n", r.label(k), r.records)
+		for j := int64(0); j < r.records; j++ {
+			jobs <- k*r.records + j
+		}
+	}
+	close(jobs)
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	datagen "core-infra-project/internal/data"
+
+	gokafka "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/compress"
+)
+
+// jobSpec is a generation job as posted to /jobs. Records 0 with a rate keeps
+// producing until the job is cancelled, for steady background load.
+type jobSpec struct {
+	Records          int64   `json:"records"`
+	Rate             float64 `json:"rate,omitempty"`              // records/sec; 0 produces as fast as possible
+	Topic            string  `json:"topic,omitempty"`             // default SOURCE_TOPIC
+	Format           string  `json:"format,omitempty"`            // csv or json; default --format
+	Template         string  `json:"template,omitempty"`          // as --template
+	Distribution     string  `json:"distribution,omitempty"`      // as --distribution; default uniform
+	MalformedPercent float64 `json:"malformed_percent,omitempty"` // as --malformed-percent
+	DuplicatePercent float64 `json:"duplicate_percent,omitempty"` // as --duplicate-percent
+	Seed             int64   `json:"seed,omitempty"`
+	KeyByID          bool    `json:"key_by_id,omitempty"`
+}
+
+// job is one accepted jobSpec and its progress.
+type job struct {
+	id     string
+	spec   jobSpec
+	cancel context.CancelFunc
+	sent   atomic.Int64
+
+	// guarded by jobServer.mu
+	state    string // running, done, cancelled or failed
+	err      error
+	started  time.Time
+	finished time.Time
+}
+
+// jobStatus is the JSON view of a job.
+type jobStatus struct {
+	ID       string     `json:"id"`
+	Spec     jobSpec    `json:"spec"`
+	State    string     `json:"state"`
+	Error    string     `json:"error,omitempty"`
+	Sent     int64      `json:"sent"`
+	Rate     float64    `json:"records_per_sec"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// jobServer runs the producer as a long-lived service (--serve): generation jobs are
+// posted over HTTP and run concurrently, each with its own writer pool, so load tests
+// can be scripted against running producers instead of launching one per run.
+//
+//	POST   /jobs       start a job from a jobSpec; responds with its status
+//	GET    /jobs       list every job
+//	GET    /jobs/<id>  one job's status
+//	DELETE /jobs/<id>  cancel a running job
+type jobServer struct {
+	brokers     []string
+	topic       string
+	format      datagen.Format
+	batchSize   int
+	compression compress.Compression
+	writers     int
+	noKafka     bool
+	retry       *retrier // nil with --no-kafka
+
+	mu     sync.Mutex
+	jobs   []*job
+	nextID int
+	wg     sync.WaitGroup
+}
+
+// serve handles requests on addr until SIGINT or SIGTERM, then cancels the running
+// jobs and waits for their writers to flush.
+func (s *jobServer) serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", s.handleJobs)
+	mux.HandleFunc("/jobs/", s.handleJob)
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	fmt.Printf("[Jobs] Accepting generation jobs on %s (POST /jobs)\n", addr)
+
+	select {
+	case err := <-errc:
+		return err
+	case sig := <-interrupt:
+		fmt.Printf("[Jobs] %v: cancelling running jobs\n", sig)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	s.mu.Lock()
+	for _, j := range s.jobs {
+		j.cancel()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	if s.retry != nil {
+		s.retry.close()
+	}
+	return err
+}
+
+func (s *jobServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		list := make([]jobStatus, len(s.jobs))
+		for i, j := range s.jobs {
+			list[i] = s.status(j)
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		var spec jobSpec
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&spec); err != nil {
+			http.Error(w, "invalid job: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		j, err := s.start(spec)
+		if err != nil {
+			http.Error(w, "invalid job: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		st := s.status(j)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusAccepted, st)
+	default:
+		http.Error(w, "use GET or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *jobServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var j *job
+	for _, c := range s.jobs {
+		if c.id == id {
+			j = c
+		}
+	}
+	if j == nil {
+		http.Error(w, "no job "+id, http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.status(j))
+	case http.MethodDelete:
+		j.cancel()
+		writeJSON(w, http.StatusOK, s.status(j))
+	default:
+		http.Error(w, "use GET or DELETE", http.StatusMethodNotAllowed)
+	}
+}
+
+// status returns j's JSON view; s.mu must be held.
+func (s *jobServer) status(j *job) jobStatus {
+	st := jobStatus{ID: j.id, Spec: j.spec, State: j.state, Sent: j.sent.Load(), Started: j.started}
+	end := time.Now()
+	if !j.finished.IsZero() {
+		end = j.finished
+		st.Finished = &j.finished
+	}
+	if d := end.Sub(j.started).Seconds(); d > 0 {
+		st.Rate = float64(st.Sent) / d
+	}
+	if j.err != nil {
+		st.Error = j.err.Error()
+	}
+	return st
+}
+
+// start validates spec and runs it in the background.
+func (s *jobServer) start(spec jobSpec) (*job, error) {
+	if spec.Topic == "" {
+		spec.Topic = s.topic
+	}
+	if spec.Format == "" {
+		spec.Format = s.format.String()
+	}
+	switch {
+	case spec.Records < 0:
+		return nil, errors.New("records must not be negative")
+	case spec.Rate < 0:
+		return nil, errors.New("rate must not be negative")
+	case spec.Records == 0 && spec.Rate == 0:
+		return nil, errors.New("records is required unless a rate is given (which then runs until cancelled)")
+	case spec.MalformedPercent < 0 || spec.MalformedPercent > 100:
+		return nil, errors.New("malformed_percent must be between 0 and 100")
+	case spec.MalformedPercent > 0 && spec.Template != "":
+		return nil, errors.New("malformed_percent breaks the csv or json layout and cannot be combined with template")
+	case spec.DuplicatePercent < 0 || spec.DuplicatePercent > 100:
+		return nil, errors.New("duplicate_percent must be between 0 and 100")
+	case spec.DuplicatePercent > 0 && spec.Seed == 0:
+		return nil, errors.New("duplicate_percent requires a seed (a duplicate is its original generated again)")
+	}
+	format, err := datagen.ParseFormat(spec.Format)
+	if err != nil {
+		return nil, err
+	}
+	if format == datagen.Avro {
+		return nil, errors.New("format avro is not supported by jobs (it needs a registered schema)")
+	}
+	if spec.Distribution == "" {
+		spec.Distribution = datagen.Uniform.String()
+	}
+	dist, err := datagen.ParseDistribution(spec.Distribution)
+	if err != nil {
+		return nil, err
+	}
+	var tmpl *datagen.Template
+	if spec.Template != "" {
+		if format != datagen.CSV {
+			return nil, fmt.Errorf("template sets the record layout and cannot be combined with format %s", format)
+		}
+		if tmpl, err = datagen.ParseTemplate(spec.Template); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.nextID++
+	j := &job{id: fmt.Sprintf("job-%d", s.nextID), spec: spec, cancel: cancel, state: "running", started: time.Now()}
+	s.jobs = append(s.jobs, j)
+	s.mu.Unlock()
+
+	fmt.Printf("[Jobs] %s started: %s\n", j.id, describeJob(spec))
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		err := s.run(ctx, j, format, dist, tmpl)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		j.finished = time.Now()
+		switch {
+		case errors.Is(err, context.Canceled):
+			j.state = "cancelled"
+		case err != nil:
+			j.state, j.err = "failed", err
+		default:
+			j.state = "done"
+		}
+		fmt.Printf("[Jobs] %s %s: %d records in %v\n", j.id, j.state, j.sent.Load(), j.finished.Sub(j.started).Round(time.Millisecond))
+	}()
+	return j, nil
+}
+
+// run generates and writes j's records, pacing batches to the job's rate.
+func (s *jobServer) run(ctx context.Context, j *job, format datagen.Format, dist datagen.Distribution, tmpl *datagen.Template) error {
+	var pool *writerPool
+	if !s.noKafka {
+		pool = newWriterPool(s.writers, s.brokers, []string{j.spec.Topic}, func(w *gokafka.Writer) {
+			w.Compression = s.compression
+			if j.spec.KeyByID {
+				w.Balancer = &gokafka.Murmur2Balancer{}
+			}
+			w.Completion = metrics.completion(nil)
+		}, s.retry)
+		defer pool.Close()
+	}
+
+	batch := make([]gokafka.Message, 0, s.batchSize)
+	start := time.Now()
+	for i := int64(0); j.spec.Records == 0 || i < j.spec.Records; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		for len(batch) < cap(batch) && (j.spec.Records == 0 || i < j.spec.Records) {
+			msg, err := generateMessage(j.spec, format, dist, tmpl, i)
+			if err != nil {
+				return fmt.Errorf("record %d: %w", i, err)
+			}
+			batch = append(batch, msg)
+			i++
+		}
+		metrics.generated.Add(int64(len(batch)))
+		metrics.batches.Add(1)
+		if pool != nil {
+			pool.WriteMessages(ctx, batch...)
+		} else {
+			metrics.written.Add(int64(len(batch)))
+		}
+		j.sent.Store(i)
+		if j.spec.Rate > 0 {
+			due := start.Add(time.Duration(float64(i) / j.spec.Rate * float64(time.Second)))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Until(due)):
+			}
+		}
+	}
+	return nil
+}
+
+// generateMessage returns record i of a job, as the one-shot producer would generate
+// it with the same flags.
+func generateMessage(spec jobSpec, format datagen.Format, dist datagen.Distribution, tmpl *datagen.Template, i int64) (gokafka.Message, error) {
+	var msg gokafka.Message
+	var fl datagen.Fields
+	i = datagen.Duplicator{Percent: spec.DuplicatePercent, Seed: spec.Seed}.Of(i)
+	if spec.Seed != 0 {
+		fl = dist.SeededFields(spec.Seed, i)
+	} else {
+		fl = dist.RandomFields()
+	}
+	if tmpl != nil {
+		var err error
+		if msg.Value, err = tmpl.Render(fl); err != nil {
+			return msg, err
+		}
+		if spec.KeyByID {
+			msg.Key = strconv.AppendInt(nil, int64(fl.ID), 10)
+		}
+		return msg, nil
+	}
+	msg.Value, _ = datagen.Malformer{Percent: spec.MalformedPercent, Seed: spec.Seed}.Apply(format, format.Encode(fl), i)
+	if spec.KeyByID {
+		msg.Key = format.ID(msg.Value)
+	}
+	return msg, nil
+}
+
+func describeJob(spec jobSpec) string {
+	n := "unbounded"
+	if spec.Records > 0 {
+		n = strconv.FormatInt(spec.Records, 10)
+	}
+	rate := "unthrottled"
+	if spec.Rate > 0 {
+		rate = fmt.Sprintf("%.0f records/sec", spec.Rate)
+	}
+	return fmt.Sprintf("%s %s records to %s, %s", n, spec.Format, spec.Topic, rate)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// trendWindow is how many seconds of throughput the display's sparkline shows.
+const trendWindow = 60
+
+// progressDisplay redraws a live status block on a terminal every second in place of
+// the [Progress] lines: a progress bar, throughput now, over 10s and over the run with
+// a sparkline of the last minute, the ETA, the queues between generation and the
+// broker, and memory use. Progress counts acknowledged records, not handed ones.
+type progressDisplay struct {
+	out     io.Writer
+	base    int64 // records produced before a --resume
+	total   int64 // records of the whole run, base included; 0 if unbounded
+	perRec  int64 // acknowledgements per record: one per topic
+	records chan indexedRecord
+	pool    *writerPool // nil with --no-kafka
+	control *runControl
+
+	sent  atomic.Int64 // records handed to the writers, set by the publisher
+	start time.Time
+	lines int     // drawn by the last frame, to move back over
+	trend []int64 // records acknowledged per second, newest last
+	last  int64
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// isTerminal reports whether f is a character device, so cursor movement works.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// run draws a frame every second until close.
+func (d *progressDisplay) run() {
+	d.start, d.stop = time.Now(), make(chan struct{})
+	d.done.Add(1)
+	go func() {
+		defer d.done.Done()
+		tick := time.NewTicker(time.Second)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				d.draw()
+			case <-d.stop:
+				d.draw()
+				return
+			}
+		}
+	}()
+}
+
+// close draws the final frame and stops redrawing, so the summary prints below it.
+func (d *progressDisplay) close() {
+	if d == nil {
+		return
+	}
+	close(d.stop)
+	d.done.Wait()
+}
+
+func (d *progressDisplay) draw() {
+	acked := metrics.written.Load() / d.perRec
+	d.trend = append(d.trend, acked-d.last)
+	if len(d.trend) > trendWindow {
+		d.trend = d.trend[1:]
+	}
+	d.last = acked
+	elapsed := time.Since(d.start)
+	now := float64(d.trend[len(d.trend)-1])
+	avg := metrics.throughput() / float64(d.perRec)
+	overall := float64(acked) / elapsed.Seconds()
+
+	var b strings.Builder
+	if d.lines > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", d.lines) // back to the first line of the last frame
+	}
+	line := func(format string, args ...any) {
+		b.WriteString("\x1b[2K") // clear the line, which may be longer than this one
+		fmt.Fprintf(&b, format, args...)
+		b.WriteByte('\n')
+	}
+	done := d.base + acked
+	state := d.control.state()
+	if d.total > 0 {
+		const width = 30
+		frac := min(float64(done)/float64(d.total), 1)
+		filled := int(frac * width)
+		line("[Producer] %s%s %5.1f%%  %s / %s records  %s", strings.Repeat("█", filled), strings.Repeat("░", width-filled),
+			frac*100, shortCount(done), shortCount(d.total), state)
+	} else {
+		line("[Producer] %s records  %s", shortCount(done), state)
+	}
+	eta := "-"
+	if d.total > 0 && avg > 0 {
+		eta = (time.Duration(float64(d.total-done)/avg) * time.Second).Round(time.Second).String()
+	}
+	line("  throughput  %s/s now, %s/s 10s avg, %s/s overall  elapsed %v  ETA %s",
+		shortCount(int64(now)), shortCount(int64(avg)), shortCount(int64(overall)), elapsed.Round(time.Second), eta)
+	line("  last %-3ds   %s", len(d.trend), sparkline(d.trend))
+	queued := fmt.Sprintf("generated %d/%d", len(d.records), cap(d.records))
+	if d.pool != nil {
+		unacked := d.sent.Load()*d.perRec - metrics.written.Load() - metrics.failed.Load()
+		queued += fmt.Sprintf(", writer batches %d/%d, unacknowledged %s messages", len(d.pool.batches), cap(d.pool.batches), shortCount(max(unacked, 0)))
+	}
+	line("  queues      %s", queued)
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	line("  memory      heap %.0f MB, from OS %.0f MB, %d GCs, %d goroutines",
+		float64(ms.HeapAlloc)/(1<<20), float64(ms.Sys)/(1<<20), ms.NumGC, runtime.NumGoroutine())
+	d.lines = 5
+	io.WriteString(d.out, b.String())
+}
+
+// sparkline draws each value as a bar scaled to the largest.
+func sparkline(values []int64) string {
+	bars := []rune("▁▂▃▄▅▆▇█")
+	var peak int64
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+	s := make([]rune, len(values))
+	for i, v := range values {
+		s[i] = bars[0]
+		if peak > 0 {
+			s[i] = bars[int(v*int64(len(bars)-1)/peak)]
+		}
+	}
+	return string(s)
+}
+
+// shortCount formats n with k, M or G, e.g. 1.25M.
+func shortCount(n int64) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.2fG", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.2fM", float64(n)/1e6)
+	case n >= 1e4:
+		return fmt.Sprintf("%.1fk", float64(n)/1e3)
+	}
+	return fmt.Sprint(n)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	kclient "core-infra-project/internal/kafka"
+	extSort "core-infra-project/internal/sort"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// checksumSource verifies the records read from the source topic against the batch
+// checksums the producer published with --batch-checksums, failing the read at the
+// first batch whose records don't match what the broker acknowledged.
+type checksumSource struct {
+	extSort.Source
+	verifier *kclient.ChecksumVerifier
+}
+
+// ReadMessage implements extSort.Source.
+func (c *checksumSource) ReadMessage(ctx context.Context) (gokafka.Message, error) {
+	msg, err := c.Source.ReadMessage(ctx)
+	if err != nil {
+		return msg, err
+	}
+	if err := c.verifier.Observe(msg); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+// print writes the verification counts as a summary line.
+func (c *checksumSource) print() {
+	v := c.verifier
+	fmt.Printf("  - Batch checksums: %d batches (%d records) verified, %d records unchecked\n",
+		v.Verified, v.VerifiedRecords, v.Unchecked+v.Pending())
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"core-infra-project/internal/config"
+)
+
+// runScheduled runs the sort of this command line at every time sched matches, until
+// interrupted, and returns the exit code. Each run is a child sorter process with the
+// same flags, its own --run-id (the scheduled time) and, with reportPath, its own
+// report (the run id inserted before the extension). Runs of one key share the temp
+// directory and the destination, so a run that is due while the previous one is still
+// going is skipped rather than started alongside it.
+func runScheduled(sched *config.Schedule, key, reportPath, maskSecret string) int {
+	bin, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] --cron: %v\n", err)
+		return 1
+	}
+	// The flags as set, minus the ones every run gets its own value of and the mask
+	// secret, which goes through the environment rather than the logged command line
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "cron" && f.Name != "run-id" && f.Name != "report" && f.Name != "mask-secret" {
+			args = append(args, "--"+f.Name+"="+f.Value.String())
+		}
+	})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	var (
+		cmd     *exec.Cmd // the run in progress, if any
+		runID   string
+		started time.Time
+		done    = make(chan error, 1)
+
+		runs, failed, skipped int
+	)
+	finished := func(err error) {
+		if err != nil {
+			failed++
+			fmt.Printf("[Cron:%s] Run %s failed after %v: %v\n", key, runID, time.Since(started).Round(time.Millisecond), err)
+		} else {
+			fmt.Printf("[Cron:%s] Run %s completed in %v\n", key, runID, time.Since(started).Round(time.Millisecond))
+		}
+		cmd = nil
+	}
+
+	next := sched.Next(time.Now())
+	fmt.Printf("[Cron:%s] Scheduled %q; first run at %s\n", key, sched, next.Format(time.RFC3339))
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if cmd != nil {
+				skipped++
+				fmt.Printf("[Cron:%s] Skipping the %s run: run %s, started %s, is still going\n",
+					key, next.Format(time.RFC3339), runID, started.Format(time.RFC3339))
+			} else {
+				runs++
+				runID, started = config.RunIDAt(next), time.Now()
+				runArgs := append(append([]string(nil), args...), "--run-id", runID)
+				if reportPath != "" {
+					ext := filepath.Ext(reportPath)
+					runArgs = append(runArgs, "--report", strings.TrimSuffix(reportPath, ext)+"-"+runID+ext)
+				}
+				runArgs = append(runArgs, flag.Args()...)
+				fmt.Printf("[Cron:%s] Starting run %s: %s %s\n", key, runID, bin, strings.Join(runArgs, " "))
+				cmd = exec.Command(bin, runArgs...)
+				cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+				if maskSecret != "" {
+					cmd.Env = append(os.Environ(), "MASK_SECRET="+maskSecret)
+				}
+				if err := cmd.Start(); err != nil {
+					finished(err)
+				} else {
+					go func(c *exec.Cmd) { done <- c.Wait() }(cmd)
+				}
+			}
+			next = sched.Next(time.Now())
+			if next.IsZero() {
+				fmt.Printf("[Cron:%s] %q matches no later time; stopping after the current run\n", key, sched)
+				if cmd != nil {
+					finished(<-done)
+				}
+				return 0
+			}
+			fmt.Printf("[Cron:%s] Next run at %s\n", key, next.Format(time.RFC3339))
+			timer.Reset(time.Until(next))
+		case err := <-done:
+			finished(err)
+		case sig := <-interrupt:
+			if cmd != nil {
+				// The run handles the signal like a foreground sorter would
+				fmt.Printf("[Cron:%s] %v: waiting for run %s to stop\n", key, sig, runID)
+				cmd.Process.Signal(sig)
+				finished(<-done)
+			}
+			fmt.Printf("[Cron:%s] Stopped: %d runs (%d failed), %d skipped as overlapping\n", key, runs, failed, skipped)
+			return 130
+		}
+	}
+}
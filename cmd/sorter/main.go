@@ -1,76 +1,1194 @@
 package main
 
 import (
+	"context"
+	"expvar"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	_ "net/http/pprof" // Enable pprof profiling endpoints
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"core-infra-project/internal/avro"
+	"core-infra-project/internal/config"
+	datagen "core-infra-project/internal/data"
 	kclient "core-infra-project/internal/kafka"
 	extSort "core-infra-project/internal/sort"
+	"core-infra-project/internal/testutil"
+	"core-infra-project/internal/tune"
+
+	gokafka "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/compress"
+	"golang.org/x/text/language"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("usage: sorter [id|name|continent]")
+	keyFlag := flag.String("key", getenv("SORT_KEY", ""), "sort key: id, name or continent, or several in turn such as continent,-id (- sorts a field descending); CSV field indices with a type, such as 2:float or -4:int, may stand in for names (env SORT_KEY; a positional argument is accepted too)")
+	keyIndex := flag.Int("key-index", -1, "sort by the CSV field at this 0-based index instead of --key, for CSV records of any shape (-1 uses --key)")
+	keyType := flag.String("key-type", "", "with --key-index, compare the field as a string (the default), an int or a float")
+	brokerList := flag.String("brokers", getenv("KAFKA_BROKERS", "kafka:9092"), "Kafka bootstrap broker address (env KAFKA_BROKERS)")
+	sourceFlag := flag.String("source-topic", getenv("SOURCE_TOPIC", "source"), "topic to sort (env SOURCE_TOPIC)")
+	destFlag := flag.String("dest-topic", "", "topic receiving the sorted records (default $TOPIC_ID, $TOPIC_NAME or $TOPIC_CONTINENT by key, else sorted_<key>)")
+	tempFlag := flag.String("temp-dir", "", "directory under which to spill chunks, into extsort_<key> (with _p<partitions> for --partitions) (default $TMPDIR)")
+	pprofAddr := flag.String("pprof-addr", "", "address of the pprof, expvar and /metrics server (default 0.0.0.0:6061, 6062 or 6064 by key)")
+	// --discard-output isolates sort performance from destination broker performance
+	discardOutput := flag.Bool("discard-output", false, "consume, sort, spill and merge but discard the output instead of writing to Kafka")
+	logChunkRanges := flag.Bool("log-chunk-ranges", false, "log min/max key and byte size of every spilled chunk")
+	reportPath := flag.String("report", "", "write a JSON run report (phase timings, merge counters) to this path")
+	statusTopic := flag.String("status-topic", getenv("STATUS_TOPIC", ""), "write a JSON heartbeat (phase, progress, host) keyed by sorter to this topic every --heartbeat-every (env STATUS_TOPIC)")
+	heartbeatEvery := flag.Duration("heartbeat-every", 30*time.Second, "interval between --status-topic heartbeats")
+	quantiles := flag.String("quantiles", "", "comma-separated quantiles of the sort key to compute exactly during the merge, e.g. 0.5,0.9,0.99 (logged and in --report)")
+	emit := flag.String("emit", "records", "merge output: records, keys (just the sorted keys) or counts (one key,count per distinct key)")
+	ties := flag.String("ties", "any", "order of equal keys: any, record (whole record bytes, like GNU sort) or input (read order, like sort -s)")
+	deterministic := flag.Bool("deterministic", false, "make two runs over the same input write identical destination records, for golden-file tests: ties by record bytes, fixed fault seed, and one synchronous produce request per --batch-size merge batch")
+	outputSchema := flag.String("output-schema", "", "Avro schema file (.avsc); CSV records are converted to Confluent-framed Avro on output")
+	registryURL := flag.String("schema-registry", getenv("SCHEMA_REGISTRY_URL", ""), "Schema Registry URL used to register --output-schema and to fetch source schemas with --format avro")
+	maskSpec := flag.String("mask", "", "redact CSV output fields for sharing, e.g. address=null,name=hash,id=truncate:3 (hash: keyed by --mask-secret, else plain SHA-256)")
+	maskSecret := flag.String("mask-secret", getenv("MASK_SECRET", ""), "HMAC key of --mask hash, so hashed values cannot be reversed by hashing guesses (env MASK_SECRET)")
+	// Hidden: wraps source and sink with testutil fault injectors to exercise error handling
+	injectFaults := flag.String("inject-faults", "", "")
+	maxAttempts := flag.Int("max-attempts", 1, "re-run the whole sort from scratch up to this many times on retryable failures")
+	retryBackoff := flag.Duration("retry-backoff", 5*time.Second, "delay before the first retry, doubled after each failed attempt (max 1m)")
+	recoverAttempts := flag.Int("recover-attempts", 5, "on a source read or destination write failing with a connection, credential or file error, run the matching recovery (reconnect, reopen) and retry up to this many times before failing (0 disables)")
+	recoverBackoff := flag.Duration("recover-backoff", time.Second, "delay before the first --recover-attempts retry, doubled after each (max 30s)")
+	readDeadlineMin := flag.Duration("read-deadline-min", 5*time.Second, "shortest wait for the next source record before the chunk is spilled; the wait adapts to the gaps between fetches (0 with --read-deadline-max 0 keeps a fixed 5s per chunk)")
+	readDeadlineMax := flag.Duration("read-deadline-max", 30*time.Second, "longest wait for the next source record, used until the gaps between fetches are known and before an empty chunk treats the topic as drained")
+	retentionMs := flag.Int64("dest-retention-ms", 0, "required retention.ms of the destination topic (-1 unlimited, 0 skips the check)")
+	retentionBytes := flag.Int64("dest-retention-bytes", 0, "required retention.bytes of the destination topic (-1 unlimited, 0 skips the check)")
+	retentionMode := flag.String("retention-mode", "validate", "validate: fail if destination retention is too small; configure: set it before writing")
+	rangePartitions := flag.Int("range-partitions", 0, "spread the sorted output over this many partitions as contiguous key ranges (0 writes one total order)")
+	partitionMode := flag.String("partition-mode", "warn", "warn: report a destination partition count that breaks the output order; configure: create or resize the topic to match")
+	outputCompression := flag.String("output-compression", getenv("KAFKA_COMPRESSION", "snappy"), "Kafka batch compression of the sorted output: none, gzip, snappy, lz4 or zstd (env KAFKA_COMPRESSION)")
+	spillCompression := flag.String("spill-compression", "none", "compress chunk files: none, gzip, snappy, lz4 or zstd")
+	spillFormat := flag.Int("spill-format", extSort.SpillFormatVersion, "chunk and manifest format version to write; during a rolling upgrade, the version of the oldest sorter that may merge or resume the chunks (1 for sorters from before versioning)")
+	spillMedium := flag.String("spill-medium", "auto", "chunk layout for the temp directory: auto (memory on tmpfs/ramfs), disk, or memory (uncompressed, larger, memory-mapped chunks)")
+	encryptSpill := flag.Bool("encrypt-spill", false, "encrypt chunk files with a per-job key held only in memory (chunks of a failed run become unreadable)")
+	shredSpill := flag.Bool("shred-spill", false, "overwrite chunk files with zeros and release their blocks (TRIM where supported) before deleting them")
+	waitForKafka := flag.Duration("wait-for-kafka", 0, "at startup, wait up to this long for the brokers (controller elected), the source topic (a leader per partition) and read ACLs on it to be ready (0 disables)")
+	waitBackoff := flag.Duration("wait-backoff", time.Second, "delay between --wait-for-kafka checks, doubled after each failed one (up to 10s)")
+	checkBrokers := flag.Bool("check-brokers", false, "fail at startup if a Kafka broker is unreachable")
+	indexTopic := flag.String("index-topic", "", "write a key index (every --index-every-th key -> destination partition/offset) to this topic")
+	format := flag.String("format", getenv("FORMAT", "csv"), "source record format: csv, json objects or Confluent-framed avro records with id/name/address/continent fields (env FORMAT)")
+	keyPath := flag.String("key-path", "", "read the sort key from this dotted path in JSON values (e.g. after.id for Debezium) instead of the CSV field")
+	keyNormalize := flag.String("key-normalize", "", "normalize name/continent keys before comparing: comma-separated trim, fold, pad=W (zero-pad all-digit keys)")
+	locale := flag.String("locale", "", "collate name/continent keys by this locale's rules (BCP 47 tag, e.g. de, sv, or und for no locale in particular) instead of comparing bytes")
+	ignoreCase := flag.Bool("ignore-case", false, "compare name/continent keys case-insensitively, so apple and Apple sort together (shorthand for --key-normalize fold)")
+	valueEncoding := flag.String("value-encoding", "", "per-record wrapping of source values to undo before key extraction: comma-separated base64, gzip, snappy, lz4, zstd (applied in order)")
+	reencode := flag.Bool("reencode-output", false, "re-apply --value-encoding to output values")
+	valuePrefix := flag.Int("value-prefix-bytes", 0, "skip this many leading value bytes (e.g. 5 for Confluent framing) before extracting the key")
+	tombstones := flag.String("tombstones", "include", "null-value records of compacted topics: include (sort as empty), skip or dlq")
+	dlqTopic := flag.String("dlq-topic", "", "topic receiving tombstones with --tombstones dlq, records over --max-record-bytes, and bad keys with --key-coercion dlq")
+	keyCoercion := flag.String("key-coercion", "warn", "numeric keys that do not parse (e.g. 12a), which sort as their leading digits (floats as 0): warn (count and log examples), fail or dlq")
+	maxRecordBytes := flag.Int("max-record-bytes", 0, "drop records with larger values, forwarding them to --dlq-topic if set, instead of sorting them (0 disables)")
+	carryHeaders := flag.Bool("carry-headers", false, "carry source record headers and timestamps (e.g. producer provenance headers) through to the sorted output")
+	latestPerKey := flag.Bool("latest-per-key", false, "keep only the latest record per message key, as a compacted source topic would")
+	quotaBackoff := flag.Bool("quota-backoff", true, "pause between output writes while brokers report produce quota throttling, instead of queueing writes until they time out")
+	maxInflight := flag.Int64("max-inflight-bytes", 0, "block the merge while this many output bytes await broker acknowledgement (0 is unlimited)")
+	seqHeaders := flag.Bool("seq-headers", false, "stamp output records with their merge position so a failed run can be repaired with --repair")
+	repair := flag.Bool("repair", false, "repair a partially written destination: find its valid sequence prefix, mark the rest invalid and resume the merge from the kept chunks")
+	runTopic := flag.Bool("run-topic", false, "write to <dest>-<run-id> (created like <dest>) and record the run in <dest>-runs")
+	runMeta := flag.Bool("run-meta", false, "write a run metadata message (kss-meta header: sort key, source topic, run id, record count) to every destination partition before the sorted records")
+	runID := flag.String("run-id", config.DefaultRunID(), "id of this benchmark run (default $KSS_RUN_ID or the start time): names the --run-topic run and tags run metadata, the report and /debug/vars")
+	verifyChecksums := flag.Bool("verify-checksums", false, "check the records read against the batch checksums of producer --batch-checksums in <source>-checksums, failing on the first corrupted batch")
+	adoptRunID := flag.Bool("adopt-run-id", false, "once the source is read, take the run id from the producer-run-id header of its records (producer --provenance-headers)")
+	autoTune := flag.Bool("auto-tune", false, "probe spill disk bandwidth and broker round trip at startup to pick I/O buffer and batch sizes")
+	payloadStore := flag.String("payload-store", "", "directory of a payload log shared across sort keys; later keys read it instead of the source topic")
+	indexEvery := flag.Int("index-every", 10000, "index one in this many output records with --index-topic")
+	partitions := flag.String("partitions", "", "read only these comma-separated source partitions, without a consumer group (to shard a sort across machines)")
+	endMarkers := flag.Bool("end-markers", false, "read until every source partition delivered the producer's end-of-stream marker (producer --end-marker), so the sort can start while the topic is still being filled")
+	sourceArchive := flag.String("source-archive", "", "sort CSV records from this .gz, .zst or (compressed) tar archive instead of the source topic (- reads stdin)")
+	archiveHeader := flag.Bool("archive-header", false, "skip the first line of every CSV file in --source-archive")
+	startOffsets := flag.String("start-offsets", "", "seed each run's fresh consumer group from this offsets file (from kss offsets export) instead of starting at the earliest offsets")
+	profile := flag.String("profile", getenv("KSS_PROFILE", ""), "preset flag defaults: dev, staging or prod (explicit flags still win)")
+	batchSize := flag.Int("batch-size", 1000, "merged records per destination write (replaced by --auto-tune)")
+	batchLinger := flag.Duration("batch-linger", 0, "also write a partial merge batch once its oldest record has waited this long (0 waits for a full batch)")
+	cron := flag.String("cron", "", "stay running and sort at the times this cron expression matches (local time, e.g. \"0 2 * * *\"), each run with its own --run-id and --report, skipping a run while the previous one is still going")
+	mergeWriters := flag.Int("merge-writers", 1, "goroutines writing merge batches, so the merge runs ahead of a slow destination; the topic still receives them in order. Only synchronous writes (--deterministic) wait on the broker, so it has no effect otherwise")
+	flag.Usage = usage
+	flag.Parse()
+	profileErr := config.ApplyProfile(flag.CommandLine, *profile, profileConflicts(*emit)...)
+
+	// The key may also come positionally, as in `sorter id`. SORT_KEY is only a default:
+	// a positional key or --key-index replaces it, where an explicit --key conflicts
+	var keySet bool
+	flag.Visit(func(f *flag.Flag) { keySet = keySet || f.Name == "key" })
+	key := strings.ToLower(*keyFlag)
+	if !keySet && (flag.NArg() == 1 || *keyIndex >= 0) {
+		key = ""
+	}
+	switch {
+	case flag.NArg() > 1:
+		fmt.Fprintf(os.Stderr, "unexpected arguments after the key: %s\n", strings.Join(flag.Args()[1:], " "))
+		os.Exit(1)
+	case flag.NArg() == 1 && key != "" && key != strings.ToLower(flag.Arg(0)):
+		fmt.Fprintf(os.Stderr, "--key %s and the positional key %s disagree\n", key, flag.Arg(0))
+		os.Exit(1)
+	case flag.NArg() == 1:
+		key = strings.ToLower(flag.Arg(0))
+	case key == "" && *keyIndex < 0:
+		usage()
 		os.Exit(1)
 	}
-	key := strings.ToLower(os.Args[1])
 	sortIdx := map[string]int{"id": 0, "name": 1, "continent": 3}[key]
-	if sortIdx == 0 && key != "id" {
-		fmt.Println("invalid key; must be id, name, or continent")
+	var keyParts []extSort.KeyPart
+	if *keyIndex >= 0 {
+		if key != "" {
+			fmt.Fprintf(os.Stderr, "--key-index replaces the key name; drop --key %s\n", key)
+			os.Exit(1)
+		}
+		// Names the destination, temp directory and logs like a key would
+		key, sortIdx = "col"+strconv.Itoa(*keyIndex), *keyIndex
+	} else if strings.ContainsAny(key, ",-:") {
+		name, parts, err := parseCompositeKey(key)
+		if err != nil {
+			fmt.Printf("invalid key %q: %v\n", key, err)
+			os.Exit(1)
+		}
+		key, keyParts, sortIdx = name, parts, parts[0].Index
+	} else if sortIdx == 0 && key != "id" {
+		fmt.Printf("invalid key %q; must be id, name, or continent\n", key)
 		os.Exit(1)
 	}
 
 	// Start pprof HTTP server for profiling (requirement #6)
 	// Each sorter uses a different port to avoid conflicts
-	pprofPort := fmt.Sprintf("0.0.0.0:%d", 6061+sortIdx)
-	go func() {
-		log.Printf("[pprof] Profiling server for '%s' sorter starting on %s\n", key, pprofPort)
-		log.Println(http.ListenAndServe(pprofPort, nil))
-	}()
+	pprofPort := *pprofAddr
+	if pprofPort == "" {
+		pprofPort = fmt.Sprintf("0.0.0.0:%d", 6061+sortIdx)
+	}
+	if *cron == "" { // the scheduled runs serve it
+		go func() {
+			log.Printf("[pprof] Profiling server for '%s' sorter starting on %s\n", key, pprofPort)
+			log.Println(http.ListenAndServe(pprofPort, nil))
+		}()
+	}
 
 	fmt.Printf("[Sorter:%s] Starting external sort pipeline...\n", key)
 
-	brokers := getenv("KAFKA_BROKERS", "kafka:9092")
-	sourceTopic := getenv("SOURCE_TOPIC", "source")
-	destTopic := map[string]string{
-		"id":        getenv("TOPIC_ID", "sorted_id"),
-		"name":      getenv("TOPIC_NAME", "sorted_name"),
-		"continent": getenv("TOPIC_CONTINENT", "sorted_continent"),
-	}[key]
-
-	// Use a unique consumer group per run to start from earliest offsets (fresh group)
-	uniqueGroup := "sorter-" + key + "-" + strconv.FormatInt(time.Now().UnixNano(), 10)
-	fmt.Printf("  - Consumer group: %s\n", uniqueGroup)
-	reader := kclient.NewReader([]string{brokers}, sourceTopic, uniqueGroup)
-	writer := kclient.NewWriter([]string{brokers}, destTopic)
-	defer reader.Close()
-	defer writer.Close()
-
-	tempDir := filepath.Join(os.TempDir(), "extsort_"+key)
-
-	fmt.Printf("[Sorter:%s] Configuration:\n", key)
-	fmt.Printf("  - Source topic: %s\n", sourceTopic)
-	fmt.Printf("  - Destination topic: %s\n", destTopic)
-	fmt.Printf("  - Temp directory: %s\n", tempDir)
-	fmt.Printf("  - Sort key: %s (index: %d)\n", key, sortIdx)
+	brokers := *brokerList
+	sourceTopic := *sourceFlag
+	destTopic := *destFlag
+	if destTopic == "" {
+		destTopic = getenv("TOPIC_"+strings.ToUpper(key), "sorted_"+key)
+	}
+
+	baseTopic := destTopic
+	if *runTopic {
+		destTopic = baseTopic + "-" + *runID
+	}
+
+	partitionSet, partitionsErr := parsePartitions(*partitions)
+	tempBase := *tempFlag
+	if tempBase == "" {
+		tempBase = os.TempDir()
+	}
+	tempDir := spillDir(tempBase, key, partitionSet)
+
+	// Validate everything up front so all configuration problems are reported together
+	var v config.Validator
+	v.Check(profileErr == nil, "--profile: %v", profileErr)
+	v.Check(brokers != "", "--brokers (or KAFKA_BROKERS) must not be empty")
+	v.Check(sourceTopic != "" && destTopic != "", "--source-topic and --dest-topic must not be empty")
+	v.Check(*discardOutput || *sourceArchive != "" || sourceTopic != destTopic, "--dest-topic %s is the source topic; the sorted records would be read back", destTopic)
+	v.IntRange("--batch-size", int64(*batchSize), 1, 1_000_000)
+	v.Check(*batchLinger >= 0, "--batch-linger must not be negative")
+	v.IntRange("--merge-writers", int64(*mergeWriters), 1, 64)
+	v.Check(*outputSchema == "" || *registryURL != "", "--schema-registry (or SCHEMA_REGISTRY_URL) is required with --output-schema")
+	v.IntRange("--max-attempts", int64(*maxAttempts), 1, 100)
+	v.Check(*retryBackoff >= 0, "--retry-backoff must not be negative")
+	v.IntRange("--recover-attempts", int64(*recoverAttempts), 0, 100)
+	v.Check(*recoverBackoff > 0, "--recover-backoff must be positive")
+	v.Check(*readDeadlineMin == 0 && *readDeadlineMax == 0 || *readDeadlineMin > 0 && *readDeadlineMin <= *readDeadlineMax,
+		"--read-deadline-min must be positive and at most --read-deadline-max (or both 0), got %v and %v", *readDeadlineMin, *readDeadlineMax)
+	v.Check(*heartbeatEvery >= time.Second, "--heartbeat-every must be at least 1s")
+	v.Check(*retentionMode == "validate" || *retentionMode == "configure", "--retention-mode must be validate or configure, got %q", *retentionMode)
+	v.Check(*partitionMode == "warn" || *partitionMode == "configure", "--partition-mode must be warn or configure, got %q", *partitionMode)
+	v.IntRange("--range-partitions", int64(*rangePartitions), 0, 10_000)
+	v.Check(*rangePartitions == 0 || !*repair, "--repair cannot resume a --range-partitions run (ranges are assigned by output position)")
+	v.Check(*indexTopic == "" || !*discardOutput, "--index-topic has no effect with --discard-output")
+	v.Check(*indexTopic != destTopic, "--index-topic must differ from the destination topic")
+	v.Check(*payloadStore == "" || !strings.HasPrefix(filepath.Clean(*payloadStore)+"/", tempDir+"/"),
+		"--payload-store must be outside the per-key temp directory %s", tempDir)
+	v.Check(*keyPath == "" || !strings.Contains("."+*keyPath+".", ".."), "--key-path %q has an empty field", *keyPath)
+	recordFormat, formatErr := datagen.ParseFormat(*format)
+	v.Check(formatErr == nil, "--format: %v", formatErr)
+	v.Check(recordFormat == datagen.CSV || *keyPath == "", "--format %s reads the key from its field; --key-path is for other JSON documents", recordFormat)
+	v.Check(recordFormat == datagen.CSV || *outputSchema == "", "--output-schema converts CSV records and cannot be used with --format %s", recordFormat)
+	v.Check(recordFormat != datagen.Avro || *registryURL != "", "--schema-registry (or SCHEMA_REGISTRY_URL) is required with --format avro")
+	// Binary values may contain newlines, which chunk files then escape
+	binaryValues := recordFormat == datagen.Avro || *valuePrefix > 0
+	v.Check(!binaryValues || *payloadStore == "", "--payload-store cannot hold binary values (--format avro or --value-prefix-bytes)")
+	v.Check(recordFormat != datagen.Avro || *valuePrefix == 0, "--format avro parses the Confluent framing itself; drop --value-prefix-bytes")
+	v.Check(*keyPath == "" || *outputSchema == "", "--key-path (JSON values) cannot be used with --output-schema (CSV to Avro)")
+	var normalize extSort.KeyNormalization
+	if err := normalize.UnmarshalText([]byte(*keyNormalize)); err != nil {
+		v.Check(false, "--key-normalize: %v", err)
+	}
+	// Folded once as keys are extracted, so comparisons stay on the precomputed keys
+	normalize.Fold = normalize.Fold || *ignoreCase
+	var keyKind extSort.KeyType
+	if *keyIndex >= 0 {
+		keyKind = extSort.KeyString
+		if *keyType != "" {
+			if err := keyKind.UnmarshalText([]byte(*keyType)); err != nil {
+				v.Check(false, "--key-type: %v", err)
+			}
+		}
+		v.Check(recordFormat == datagen.CSV && *keyPath == "", "--key-index reads a CSV field and cannot be used with --format %s or --key-path", recordFormat)
+	} else {
+		v.Check(*keyType == "", "--key-type applies to the field of --key-index")
+	}
+	stringKey := keyKind == extSort.KeyString || keyKind == extSort.KeyAuto && sortIdx != 0
+	if keyParts != nil {
+		v.Check(recordFormat == datagen.CSV && *keyPath == "", "a composite --key reads CSV fields and cannot be used with --format %s or --key-path", recordFormat)
+		stringKey = slices.ContainsFunc(keyParts, func(p extSort.KeyPart) bool { return !p.Int && !p.Float })
+	}
+	v.Check(*keyNormalize == "" || stringKey, "--key-normalize applies to string keys (name, continent, --key-type string), not %s", key)
+	v.Check(!*ignoreCase || stringKey, "--ignore-case applies to string keys (name, continent, --key-type string), not %s", key)
+	if *locale != "" {
+		v.Check(stringKey && keyParts == nil, "--locale applies to single string keys (name, continent, --key-type string), not %s", key)
+		if _, err := language.Parse(*locale); err != nil {
+			v.Check(false, "--locale: %v", err)
+		}
+	}
+	numericKey := keyParts == nil && !stringKey || slices.ContainsFunc(keyParts, func(p extSort.KeyPart) bool { return p.Int || p.Float })
+	var valueEnc extSort.ValueEncoding
+	if err := valueEnc.UnmarshalText([]byte(*valueEncoding)); err != nil {
+		v.Check(false, "--value-encoding: %v", err)
+	}
+	v.Check(!*reencode || *valueEncoding != "", "--reencode-output requires --value-encoding")
+	v.IntRange("--value-prefix-bytes", int64(*valuePrefix), 0, 1<<20)
+	quantileList, quantilesErr := parseQuantiles(*quantiles)
+	v.Check(quantilesErr == nil, "--quantiles: %v", quantilesErr)
+	v.Check(quantileList == nil || !*latestPerKey, "--quantiles needs the output size up front, which --latest-per-key only knows after the merge")
+	var emitMode extSort.Emit
+	if err := emitMode.UnmarshalText([]byte(*emit)); err != nil {
+		v.Check(false, "--emit: %v", err)
+	}
+	v.Check(emitMode == extSort.EmitRecords || *outputSchema == "", "--output-schema converts records and cannot be used with --emit %s", emitMode)
+	if emitMode == extSort.EmitKeyCounts {
+		v.Check(!*seqHeaders && !*repair, "--emit counts has no per-record output positions for --seq-headers or --repair")
+		v.Check(*rangePartitions == 0, "--emit counts writes fewer messages than records and cannot be spread with --range-partitions")
+		v.Check(!*carryHeaders, "--emit counts writes one message per key and cannot carry record headers")
+	}
+	var mask *extSort.Mask
+	if *maskSpec != "" {
+		var err error
+		if mask, err = extSort.ParseMask(*maskSpec, []byte(*maskSecret)); err != nil {
+			v.Check(false, "--mask: %v", err)
+		}
+		v.Check(recordFormat == datagen.CSV && *keyPath == "", "--mask redacts CSV fields and cannot be used with --format %s or --key-path", recordFormat)
+		v.Check(*valuePrefix == 0, "--mask redacts CSV fields and cannot be used with --value-prefix-bytes")
+		v.Check(*keyIndex < 0, "--mask names the fields of id,name,address,continent records and cannot be used with --key-index")
+		v.Check(emitMode == extSort.EmitRecords, "--mask redacts records and cannot be used with --emit %s", emitMode)
+	}
+	v.Check(*maskSecret == "" || *maskSpec != "", "--mask-secret requires --mask")
+	var tieBreak extSort.TieBreak
+	if err := tieBreak.UnmarshalText([]byte(*ties)); err != nil {
+		v.Check(false, "--ties: %v", err)
+	}
+	if *deterministic {
+		// Partitions interleave differently on every read, so only an order that
+		// depends on the records alone is repeatable
+		v.Check(tieBreak != extSort.TiesInput, "--deterministic orders equal keys by record bytes; --ties input follows the read order, which varies between runs")
+		tieBreak = extSort.TiesRecord
+		v.Check(!*autoTune, "--deterministic fixes the batch size, which --auto-tune picks from probes")
+		v.Check(*batchLinger == 0, "--deterministic cannot use --batch-linger, which ends batches on a timer")
+		v.Check(!*runMeta, "--deterministic cannot use --run-meta, which stamps the run id and start time")
+		v.Check(!*carryHeaders, "--deterministic cannot use --carry-headers (identical records may carry different headers)")
+	}
+	v.Check(tieBreak != extSort.TiesRecord || *payloadStore == "", "--ties record (and --deterministic) compare whole records, which --payload-store keeps out of the chunks")
+	var medium extSort.SpillMedium
+	if err := medium.UnmarshalText([]byte(*spillMedium)); err != nil {
+		v.Check(false, "--spill-medium: %v", err)
+	}
+	var tombstonePolicy extSort.TombstonePolicy
+	if err := tombstonePolicy.UnmarshalText([]byte(*tombstones)); err != nil {
+		v.Check(false, "--tombstones: %v", err)
+	}
+	v.Check(tombstonePolicy != extSort.TombstonesDLQ || *dlqTopic != "", "--dlq-topic is required with --tombstones dlq")
+	var coercionPolicy extSort.KeyCoercionPolicy
+	if err := coercionPolicy.UnmarshalText([]byte(*keyCoercion)); err != nil {
+		v.Check(false, "--key-coercion: %v", err)
+	}
+	v.Check(coercionPolicy == extSort.CoercionWarn || numericKey && *keyPath == "", "--key-coercion applies to numeric CSV keys (id, :int or :float fields, --key-type int or float), not %s", key)
+	v.Check(coercionPolicy != extSort.CoercionDLQ || *dlqTopic != "", "--dlq-topic is required with --key-coercion dlq")
+	v.Check(*dlqTopic == "" || tombstonePolicy == extSort.TombstonesDLQ || coercionPolicy == extSort.CoercionDLQ || *maxRecordBytes > 0, "--dlq-topic is only used by --tombstones dlq, --key-coercion dlq and --max-record-bytes")
+	v.IntRange("--max-record-bytes", int64(*maxRecordBytes), 0, 1<<30)
+	v.Check(*dlqTopic != destTopic && *dlqTopic != sourceTopic, "--dlq-topic must differ from the source and destination topics")
+	v.Check(!*carryHeaders || *payloadStore == "", "--carry-headers cannot be used with --payload-store (the store keeps no headers)")
+	v.Check(!*carryHeaders || !*encryptSpill, "--carry-headers cannot be used with --encrypt-spill (header sidecars are not encrypted)")
+	v.Check(!*latestPerKey || *payloadStore == "", "--latest-per-key cannot be used with --payload-store (the store keeps no message keys)")
+	v.Check(*maxInflight >= 0, "--max-inflight-bytes must not be negative")
+	v.Check(!*repair || !*discardOutput, "--repair has no effect with --discard-output")
+	v.Check(!*repair || !*latestPerKey, "--repair cannot resume a --latest-per-key run")
+	v.Check(!*repair || !*encryptSpill, "--repair cannot resume a --encrypt-spill run (its key is discarded)")
+	v.Check(!*encryptSpill || *payloadStore == "", "--encrypt-spill cannot be used with --payload-store (the store outlives the job key)")
+	v.Check(!*adoptRunID || !*runTopic, "--adopt-run-id cannot be used with --run-topic, whose topic --run-id names before the source is read")
+	v.Check(!*runTopic || validTopicName.MatchString(*runID), "--run-id %q may only contain letters, digits, '.', '_' and '-'", *runID)
+	v.Check(!*runMeta || !*discardOutput, "--run-meta has no effect with --discard-output")
+	v.Check(!*runTopic || !*discardOutput, "--run-topic has no effect with --discard-output")
+	v.IntRange("--index-every", int64(*indexEvery), 1, 1<<31-1)
+	v.Check(*retentionMs >= -1 && *retentionBytes >= -1, "--dest-retention-ms/--dest-retention-bytes must be >= -1")
+	var outputCodec compress.Compression
+	if err := outputCodec.UnmarshalText([]byte(*outputCompression)); err != nil {
+		v.Check(false, "--output-compression: %v", err)
+	}
+	var spillCodec compress.Compression
+	if err := spillCodec.UnmarshalText([]byte(*spillCompression)); err != nil {
+		v.Check(false, "--spill-compression: %v", err)
+	}
+	v.IntRange("--spill-format", int64(*spillFormat), extSort.MinSpillFormat, extSort.SpillFormatVersion)
+	v.Check(partitionsErr == nil, "--partitions: %v", partitionsErr)
+	v.Check(*partitions == "" || *startOffsets == "", "--partitions reads without a consumer group and cannot be seeded with --start-offsets")
+	if *sourceArchive != "" {
+		v.Check(*partitions == "" && *startOffsets == "", "--source-archive replaces the source topic and cannot be used with --partitions or --start-offsets")
+		v.Check(!*latestPerKey, "--latest-per-key needs message keys, which archived CSV records do not have")
+		v.Check(!*carryHeaders, "--carry-headers has no effect with --source-archive (archived CSV records have no headers)")
+		v.Check(!*adoptRunID, "--adopt-run-id has no effect with --source-archive (archived CSV records have no headers)")
+		v.Check(!*endMarkers, "--end-markers has no effect with --source-archive")
+		v.Check(!*verifyChecksums, "--verify-checksums checks records read from Kafka and cannot be used with --source-archive")
+		v.Check(*sourceArchive != "-" || *maxAttempts == 1, "--max-attempts cannot re-read --source-archive from stdin")
+		if *sourceArchive != "-" {
+			_, err := os.Stat(*sourceArchive)
+			v.Check(err == nil, "--source-archive: %v", err)
+		}
+	}
+	v.Check(!*archiveHeader || *sourceArchive != "", "--archive-header requires --source-archive")
+	var seedOffsets *kclient.GroupOffsets
+	if *startOffsets != "" {
+		var err error
+		if seedOffsets, err = kclient.ReadGroupOffsets(*startOffsets); err != nil {
+			v.Check(false, "--start-offsets: %v", err)
+		} else {
+			v.Check(seedOffsets.Topic == sourceTopic, "--start-offsets: file holds offsets of %q, not the source topic %q", seedOffsets.Topic, sourceTopic)
+		}
+	}
+	var faultCfg testutil.FaultConfig
+	if *injectFaults != "" {
+		var err error
+		faultCfg, err = testutil.ParseFaultConfig(*injectFaults)
+		v.Check(err == nil, "--inject-faults: %v", err)
+		v.FloatRange("--inject-faults error+partial", faultCfg.ErrorRate+faultCfg.PartialWriteRate, 0, 1)
+		if *deterministic && faultCfg.Seed == 0 {
+			faultCfg.Seed = 1
+		}
+	}
+	var schedule *config.Schedule
+	if *cron != "" {
+		var err error
+		if schedule, err = config.ParseCron(*cron); err != nil {
+			v.Check(false, "--cron: %v", err)
+		} else {
+			v.Check(!schedule.Next(time.Now()).IsZero(), "--cron: %q matches no time in the next five years", *cron)
+		}
+		runIDSet := false
+		flag.Visit(func(f *flag.Flag) { runIDSet = runIDSet || f.Name == "run-id" })
+		v.Check(!runIDSet, "--cron gives every run its own --run-id (its scheduled time) and cannot be used with --run-id")
+		v.Check(!*repair, "--repair fixes one failed run and cannot be scheduled with --cron")
+		v.Check(*sourceArchive != "-", "--cron cannot re-read --source-archive from stdin")
+	}
+	v.Check(*waitForKafka >= 0, "--wait-for-kafka must not be negative")
+	v.Check(*waitBackoff > 0, "--wait-backoff must be positive")
+	if *checkBrokers {
+		err := config.CheckBrokers([]string{brokers}, 5*time.Second)
+		v.Check(err == nil, "%v", err)
+	}
+	if err := v.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		os.Exit(1)
+	}
+	if *waitForKafka > 0 && schedule == nil {
+		// The source topic is all a run needs to exist up front; with --source-archive only the brokers
+		var topics []string
+		if *sourceArchive == "" {
+			topics = []string{sourceTopic}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), *waitForKafka)
+		waitStart := time.Now()
+		n, err := kclient.WaitForKafka(ctx, []string{brokers}, topics, gokafka.ACLOperationTypeRead, *waitBackoff, func(format string, args ...any) {
+			fmt.Printf("[Sorter:"+key+"] Waiting for Kafka: "+format+"\n", args...)
+		})
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] --wait-for-kafka: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("[Sorter:%s] Kafka ready after %d checks (%v)\n", key, n, time.Since(waitStart).Round(time.Millisecond))
+	}
+	if schedule != nil {
+		os.Exit(runScheduled(schedule, key, *reportPath, *maskSecret))
+	}
+
+	var eff config.Effective
+	if keyParts != nil {
+		eff.Add("sort key", fmt.Sprintf("%s (fields %s)", key, extSort.FormatKeys(keyParts)))
+	} else if keyKind != extSort.KeyAuto {
+		eff.Add("sort key", fmt.Sprintf("%s (index %d, %s)", key, sortIdx, keyKind))
+	} else {
+		eff.Add("sort key", fmt.Sprintf("%s (index %d)", key, sortIdx))
+	}
+	eff.Add("destination topic", destTopic)
+	eff.Add("temp directory", tempDir)
+	eff.AddFlags(flag.CommandLine, "inject-faults")
+	eff.Print(os.Stdout, fmt.Sprintf("[Sorter:%s]", key))
+	runIDVar := expvar.NewString("run_id")
+	runIDVar.Set(*runID)
+	http.Handle("/metrics", sorterMetrics{key: key, runID: runIDVar})
+
+	var heartbeats *kclient.Heartbeats
+	if *statusTopic != "" {
+		job := "sorter:" + strings.TrimPrefix(filepath.Base(tempDir), "extsort_")
+		heartbeats = kclient.StartHeartbeats([]string{brokers}, *statusTopic, *heartbeatEvery, job, *runID, func(hb *kclient.Heartbeat) {
+			hb.RunID = runIDVar.Value() // --adopt-run-id may change it
+			phase, read, merged := extSort.Progress()
+			switch phase {
+			case "":
+				hb.Phase = "starting"
+			case "chunk":
+				hb.Phase, hb.Records = phase, read
+			default:
+				hb.Phase, hb.Records, hb.Total = phase, merged, read
+			}
+		})
+		defer heartbeats.Stop("done")
+		fmt.Printf("[Sorter:%s] Heartbeats every %v to %s as %s\n", key, *heartbeatEvery, *statusTopic, job)
+	}
+
+	if *runTopic {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := kclient.CreateTopicLike(ctx, []string{brokers}, baseTopic, destTopic)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Run topic: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("[Sorter:%s] Writing run %s to %s\n", key, *runID, destTopic)
+	}
+
+	if !*discardOutput {
+		if err := checkPartitions([]string{brokers}, destTopic, *rangePartitions, *partitionMode == "configure"); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Destination partitions: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	required := kclient.Retention{Ms: *retentionMs, Bytes: *retentionBytes}
+	if !*discardOutput && required != (kclient.Retention{}) {
+		if err := ensureRetention([]string{brokers}, destTopic, required, *retentionMode == "configure"); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Destination retention: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var sink extSort.Sink
+	var discard *extSort.DiscardSink
+	var sampler *kclient.CompressionSampler
+	var writer *gokafka.Writer
+	var index *kclient.KeyIndex
+	var limiter *kclient.InflightLimiter
+	var quota *kclient.QuotaThrottle
+	var ranges *kclient.RangeBalancer
+	if *discardOutput {
+		discard = &extSort.DiscardSink{}
+		sink = discard
+	} else {
+		writer = kclient.NewWriter([]string{brokers}, destTopic)
+		writer.Compression = outputCodec
+		if *deterministic {
+			// One produce request per merge batch, instead of whatever the batch timeout cut
+			writer.Async = false
+			writer.BatchSize = *batchSize
+		}
+		if writer.Async && *mergeWriters > 1 {
+			fmt.Printf("[WARN] --merge-writers %d has no effect: the async destination writer queues batches without waiting on the broker (only --deterministic writes synchronously)\n", *mergeWriters)
+		}
+		if *rangePartitions > 0 {
+			ranges = &kclient.RangeBalancer{}
+			writer.Balancer = ranges
+		}
+		if *indexTopic != "" {
+			index = &kclient.KeyIndex{}
+			writer.Completion = index.Completion
+		}
+		var out kclient.MessageWriter = writer
+		if *maxInflight > 0 {
+			limiter = kclient.NewInflightLimiter(writer, *maxInflight)
+			out = limiter
+		}
+		if *quotaBackoff {
+			quota = kclient.NewQuotaThrottle(out, fmt.Sprintf("[Sorter:%s]", key), writer)
+			defer quota.Stop()
+			out = quota
+		}
+		// Wraps the writer (or the pass-through limiter) so the estimate reflects exactly what the writer compresses
+		sampler = kclient.NewCompressionSampler(out, writer.Compression, 10)
+		sink = sampler
+	}
+	if *reencode {
+		// Inside the Avro codec, so the framed Avro value is what gets re-wrapped
+		sink = extSort.NewEncodingSink(sink, valueEnc)
+	}
+
+	if *outputSchema != "" {
+		codec, err := newAvroSink(sink, *outputSchema, *registryURL, destTopic+"-value")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Avro output: %v\n", err)
+			os.Exit(1)
+		}
+		sink = codec
+	}
+	if mask != nil {
+		// Outside the Avro codec, so it masks the CSV record before conversion
+		fmt.Printf("[Sorter:%s] Masking output fields: %s\n", key, mask)
+		sink = extSort.NewMaskSink(sink, mask)
+	}
 
+	var faultySink *testutil.FaultySink
+	if *injectFaults != "" {
+		fmt.Printf("[Sorter:%s] Fault injection enabled: %+v\n", key, faultCfg)
+		faultySink = testutil.NewFaultySink(sink, faultCfg)
+		sink = faultySink
+	}
+
+	sortOpts := extSort.Options{
+		LogChunkRanges:   *logChunkRanges,
+		SpillCompression: spillCodec,
+		SpillFormat:      *spillFormat,
+		KeyPath:          sortKeyPath(recordFormat, key, *keyPath),
+		ValuePrefixBytes: *valuePrefix,
+		BinaryValues:     binaryValues,
+		Normalize:        normalize,
+		Locale:           *locale,
+		KeyType:          keyKind,
+		Keys:             keyParts,
+		Tombstones:       tombstonePolicy,
+		MaxRecordBytes:   *maxRecordBytes,
+		KeyCoercion:      coercionPolicy,
+		LatestPerKey:     *latestPerKey,
+		CarryHeaders:     *carryHeaders,
+		Emit:             emitMode,
+		Ties:             tieBreak,
+		SpillMedium:      medium,
+		Quantiles:        quantileList,
+		SeqHeaders:       *seqHeaders || *repair,
+		BatchSize:        *batchSize,
+		BatchLinger:      *batchLinger,
+		Writers:          *mergeWriters,
+		IsEndMarker:      kclient.EndMarker,
+		IsControl:        kclient.IsControl,
+		EncryptSpill:     *encryptSpill,
+		ShredSpill:       *shredSpill,
+		Recovery:         extSort.RecoveryPolicy{Attempts: *recoverAttempts, Backoff: *recoverBackoff},
+		ReadDeadlineMin:  *readDeadlineMin,
+		ReadDeadlineMax:  *readDeadlineMax,
+	}
+	if writer != nil && !writer.Async {
+		// The sink passed to the sort wraps the writer. An async writer's WriteMessages
+		// only queues, so its delivery errors never reach the sort to recover from
+		sortOpts.Recovery.Sink = kclient.WriterHooks{Writer: writer}
+	}
+	if *autoTune {
+		var probes tune.Probes
+		var err error
+		if probes.DiskWriteMBps, probes.DiskReadMBps, err = tune.ProbeDisk(tempDir, 200_000); err != nil {
+			fmt.Fprintf(os.Stderr, "[WARN] Disk probe failed, keeping default I/O buffer: %v\n", err)
+		}
+		if !*discardOutput {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if probes.BrokerRTT, err = tune.ProbeBroker(ctx, []string{brokers}, 5); err != nil {
+				fmt.Fprintf(os.Stderr, "[WARN] Broker probe failed, keeping default batch size: %v\n", err)
+			}
+			cancel()
+		}
+		settings := tune.Choose(probes)
+		tune.Print(fmt.Sprintf("[Sorter:%s]", key), probes, settings)
+		sortOpts.IOBufferSize = settings.IOBufferSize
+		sortOpts.BatchSize = settings.BatchSize
+	}
+	if recordFormat == datagen.Avro {
+		// Writer schemas are fetched by the id in each value's framing
+		sortOpts.Decoder = avro.NewDecoder(avro.NewRegistryClient(*registryURL))
+	}
+	if *dlqTopic != "" {
+		dlq := kclient.NewWriter([]string{brokers}, *dlqTopic)
+		defer dlq.Close()
+		sortOpts.DeadLetters = dlq
+	}
+	if index != nil {
+		sortOpts.IndexEvery = *indexEvery
+	}
+	if *payloadStore != "" {
+		store, err := extSort.OpenPayloadStore(*payloadStore)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Payload store: %v\n", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+		sortOpts.Payloads = store
+	}
+
+	sourceName := sourceTopic
+	if *sourceArchive != "" {
+		sourceName = *sourceArchive
+	}
+	policy := extSort.RetryPolicy{MaxAttempts: *maxAttempts, Backoff: *retryBackoff, MaxBackoff: time.Minute}
 	start := time.Now()
-	if err := extSort.ExternalSort(reader, writer, sortIdx, tempDir); err != nil {
+	var provenance *provenanceSource // of the last attempt
+	var checked *checksumSource      // of the last attempt
+	// Runs once the record count is known, before the first merged record is written
+	sortOpts.OnMerge = func(records int64) error {
+		if *adoptRunID {
+			switch runs := provenance.runIDs(); len(runs) {
+			case 1:
+				*runID = runs[0]
+				runIDVar.Set(*runID)
+				fmt.Printf("[Sorter:%s] Adopted producer run id %s\n", key, *runID)
+			default:
+				fmt.Printf("[WARN] --adopt-run-id: source records come from %d producer runs; keeping run id %s\n", len(runs), *runID)
+			}
+		}
+		if ranges != nil {
+			ranges.SetTotal(records)
+		}
+		if !*runMeta {
+			return nil
+		}
+		meta := kclient.RunMeta{
+			RunID:       *runID,
+			SourceTopic: sourceName,
+			SortKey:     key,
+			Direction:   sortDirection(keyParts),
+			Records:     records,
+			Layout:      "single",
+			StartedAt:   start,
+		}
+		if ranges != nil {
+			meta.Layout = "range"
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		n, err := kclient.WriteRunMeta(ctx, []string{brokers}, destTopic, meta)
+		if err != nil {
+			return fmt.Errorf("run metadata: %w", err)
+		}
+		fmt.Printf("[Sorter:%s] Run metadata written to %d partitions of %s\n", key, n, destTopic)
+		return nil
+	}
+
+	var report *extSort.Report
+	var err error
+	if *repair {
+		// Every attempt rescans the destination for its gap-free prefix, so a retry
+		// resumes after whatever the failed one wrote
+		err = policy.Do(func(attempt int) error {
+			var err error
+			report, err = repairOutput(sink, []string{brokers}, destTopic, sortIdx, tempDir, sortOpts)
+			if report != nil {
+				report.Attempt = attempt
+			}
+			return err
+		}, extSort.IsRetryable)
+	} else {
+		err = policy.Do(func(attempt int) error {
+			if attempt > 1 {
+				// Retry from scratch: drop spilled chunks and re-read the topic with a fresh group
+				fmt.Printf("[Sorter:%s] Attempt %d: cleaning temp state in %s\n", key, attempt, tempDir)
+				if err := extSort.RemoveSpillDir(tempDir, *shredSpill); err != nil {
+					return err
+				}
+			}
+
+			attemptOpts := sortOpts
+			var source extSort.Source
+			if *sourceArchive != "" {
+				archive, err := extSort.OpenArchive(*sourceArchive, *archiveHeader)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					fmt.Printf("  - Archive: read %d records from %d files\n", archive.Records(), archive.Files())
+					archive.Close()
+				}()
+				fmt.Printf("  - Source archive: %s (%s, attempt %d)\n", *sourceArchive, archive.Format(), attempt)
+				source = archive
+				attemptOpts.Recovery.Source = archive
+			} else if partitionSet != nil {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				ps, err := kclient.OpenPartitionSet(ctx, []string{brokers}, sourceTopic, partitionSet)
+				cancel()
+				if err != nil {
+					return err
+				}
+				defer ps.Close()
+				attemptOpts.EndOffsets = ps.EndOffsets()
+				fmt.Printf("  - Partitions %v, %d with records to read (no consumer group, attempt %d)\n", partitionSet, len(ps.EndOffsets()), attempt)
+				if *endMarkers {
+					attemptOpts.EndMarkers = len(partitionSet)
+				}
+				source = ps
+				attemptOpts.Recovery.Source = ps
+			} else {
+				// Use a unique consumer group per run to start from earliest offsets (fresh group)
+				uniqueGroup := "sorter-" + key + "-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+				fmt.Printf("  - Consumer group: %s (attempt %d)\n", uniqueGroup, attempt)
+				if seedOffsets != nil {
+					ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+					err := kclient.ImportGroupOffsets(ctx, []string{brokers}, uniqueGroup, seedOffsets)
+					cancel()
+					if err != nil {
+						return fmt.Errorf("seeding consumer group: %w", err)
+					}
+					fmt.Printf("  - Start offsets: seeded from %s (group %s)\n", *startOffsets, seedOffsets.Group)
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				if *endMarkers {
+					// Read until the producer marks every partition complete; the end offsets
+					// of a topic still being filled would end the read early
+					n, err := kclient.PartitionCount(ctx, []string{brokers}, sourceTopic)
+					cancel()
+					if err != nil {
+						return fmt.Errorf("counting source partitions for --end-markers: %w", err)
+					}
+					attemptOpts.EndMarkers = n
+					fmt.Printf("  - Reading until end-of-stream markers arrive on all %d partitions\n", n)
+				} else {
+					// Read until every partition reaches its current end, not until the first idle timeout
+					ends, err := kclient.EndOffsets(ctx, []string{brokers}, sourceTopic, uniqueGroup)
+					cancel()
+					if err != nil {
+						fmt.Printf("  - End offsets unavailable, falling back to read timeouts: %v\n", err)
+					} else {
+						attemptOpts.EndOffsets = ends
+						fmt.Printf("  - Partitions with records to read: %d\n", len(ends))
+					}
+				}
+				reader := kclient.NewReader([]string{brokers}, sourceTopic, uniqueGroup)
+				defer reader.Close()
+				source = reader
+			}
+			if *verifyChecksums {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+				verifier, err := kclient.LoadChecksums(ctx, []string{brokers}, sourceTopic)
+				cancel()
+				if err != nil {
+					return fmt.Errorf("loading batch checksums: %w", err)
+				}
+				fmt.Printf("  - Batch checksums: %d loaded from %s\n", verifier.Batches(), kclient.ChecksumTopic(sourceTopic))
+				checked = &checksumSource{Source: source, verifier: verifier}
+				source = checked
+			}
+			if *sourceArchive == "" {
+				provenance = newProvenanceSource(source)
+				source = provenance
+			}
+			if !valueEnc.IsZero() {
+				source = extSort.NewDecodingSource(source, valueEnc)
+			}
+			if *injectFaults != "" {
+				fs := testutil.NewFaultySource(source, faultCfg)
+				defer func() { fmt.Printf("[Faults] attempt %d source: %+v\n", attempt, fs.Stats()) }()
+				source = fs
+			}
+
+			var err error
+			report, err = extSort.ExternalSort(source, sink, sortIdx, tempDir, attemptOpts)
+			if report != nil {
+				report.Attempt = attempt
+			}
+			return err
+		}, func(err error) bool {
+			// Once merged output reached the sink a rerun would duplicate records downstream
+			if report != nil && report.Merge.Records > 0 {
+				fmt.Printf("[Retry] Not retrying: %d records were already written to the destination\n", report.Merge.Records)
+				if sortOpts.SeqHeaders {
+					fmt.Printf("[Retry] Chunks kept in %s; rerun with --repair to resume the merge\n", tempDir)
+				}
+				return false
+			}
+			return extSort.IsRetryable(err)
+		})
+	}
+	if faultySink != nil {
+		fmt.Printf("[Faults] sink: %+v\n", faultySink.Stats())
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "[ERROR] Sort error: %v\n", err)
+		if heartbeats != nil {
+			heartbeats.Stop("failed")
+		}
 		os.Exit(1)
 	}
+	if writer != nil {
+		// Flushes the async writer, so every completion has been recorded before the
+		// index and run pointer describe the output
+		if err := writer.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Failed to flush the destination writer: %v\n", err)
+			if heartbeats != nil {
+				heartbeats.Stop("failed")
+			}
+			os.Exit(1)
+		}
+	}
+
+	if index != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		n, err := index.Publish(ctx, []string{brokers}, *indexTopic)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Failed to write key index: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("[Sorter:%s] Key index: %d entries written to %s\n", key, n, *indexTopic)
+	}
+
+	if *runTopic {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := kclient.PublishRunPointer(ctx, []string{brokers}, kclient.RunPointer{
+			RunID: *runID, BaseTopic: baseTopic, Topic: destTopic, SortKey: key,
+			Records: report.Merge.Records, StartedAt: start, FinishedAt: time.Now(),
+		})
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Failed to publish run pointer: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("[Sorter:%s] Run pointer published to %s\n", key, kclient.RunPointerTopic(baseTopic))
+	}
 
 	duration := time.Since(start)
 	fmt.Printf("\n[Summary] Sorter '%s' completed successfully in %v\n", key, duration)
+	fmt.Printf("  - Run id: %s\n", *runID)
+	report.RunID = *runID
+	if provenance != nil {
+		report.ProducerRuns = provenance.runIDs()
+	}
+	if report.SpillMedium == extSort.MediumMemory.String() {
+		fmt.Printf("  - Spill medium: memory (%d bytes of chunks held in RAM, uncompressed)\n", report.SpillDiskBytes)
+	} else if report.SpillRawBytes > 0 {
+		fmt.Printf("  - Spill compression (%s): ratio %.2f (%d -> %d bytes)\n",
+			spillCodec, report.SpillCompressionRatio(), report.SpillRawBytes, report.SpillDiskBytes)
+	}
+	if sampler != nil {
+		report.OutputRawBytes = sampler.RawBytes()
+		report.OutputCompressedBytes = sampler.CompressedBytes()
+		fmt.Printf("  - Output compression (%s, sampled): ratio %.2f (%d -> ~%d bytes)\n",
+			sampler.Compression(), report.OutputCompressionRatio(), report.OutputRawBytes, report.OutputCompressedBytes)
+	}
+	if report.Oversized > 0 {
+		fmt.Printf("  - Oversized records: %d over %d bytes, not sorted\n", report.Oversized, *maxRecordBytes)
+	}
+	if report.BadNumericKeys > 0 {
+		fate := "sorted as their leading digits (floats as 0)"
+		if coercionPolicy == extSort.CoercionDLQ {
+			fate = "dead-lettered to " + *dlqTopic
+		}
+		fmt.Printf("  - Bad numeric keys: %d did not parse or were missing, %s\n", report.BadNumericKeys, fate)
+	}
+	if *endMarkers {
+		read := report.RecordsRead + report.Tombstones + report.Oversized
+		if coercionPolicy == extSort.CoercionDLQ {
+			read += report.BadNumericKeys
+		}
+		fmt.Printf("  - End-of-stream markers: producer wrote %d records, %d read\n", report.EndMarkerRecords, read)
+		if read != report.EndMarkerRecords && partitionSet == nil && seedOffsets == nil {
+			fmt.Printf("[WARN] Read %d records but the producer's end-of-stream markers count %d\n", read, report.EndMarkerRecords)
+		}
+	}
+	if limiter != nil {
+		st := limiter.Stats()
+		fmt.Printf("  - In-flight output: peak %d bytes (cap %d), merge blocked %d times for %v\n",
+			st.PeakBytes, *maxInflight, st.Waits, st.Waited)
+	}
+	if st := quota.Stats(); st.Events > 0 {
+		fmt.Printf("  - Quota throttling: %d events, brokers throttled %v in total (max %v), %d writes paused for %v (peak pause %v)\n",
+			st.Events, st.Throttled, st.MaxThrottle, st.Pauses, st.Paced.Round(time.Millisecond), st.PeakDelay)
+	}
+	if report.Recoveries > 0 || report.Merge.Recoveries > 0 {
+		fmt.Printf("  - Recoveries: %d source reads, %d destination writes succeeded after a reconnect or reopen\n", report.Recoveries, report.Merge.Recoveries)
+	}
+	if *reportPath != "" {
+		if err := report.WriteFile(*reportPath); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Failed to write report: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("  - Run report: %s\n", *reportPath)
+	}
+	if provenance != nil {
+		provenance.print()
+	}
+	if checked != nil {
+		checked.print()
+	}
+	if discard != nil {
+		fmt.Printf("  - Discarded output: %d records, %d bytes\n", discard.Records, discard.Bytes)
+	}
 }
 
+// newAvroSink loads the schema at path, registers it under subject and returns a
+// sink that converts CSV values to Confluent-framed Avro before writing to next.
+func newAvroSink(next extSort.Sink, path, registryURL, subject string) (*avro.CSVSink, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := avro.ParseSchema(b)
+	if err != nil {
+		return nil, err
+	}
+	id, err := avro.NewRegistryClient(registryURL).Register(context.Background(), subject, schema)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("  - Registered Avro schema %s under subject %s (id %d)\n", schema.Name, subject, id)
+	return avro.NewCSVSink(next, schema, id), nil
+}
+
+// checkPartitions compares the destination's partition count with the layout the sort
+// writes: a single partition for one total order, or rangeParts contiguous key ranges.
+// Without configure a mismatch is only reported, since consumers may not need order.
+func checkPartitions(brokers []string, topic string, rangeParts int, configure bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	want := max(rangeParts, 1)
+	n, err := kclient.PartitionCount(ctx, brokers, topic)
+	if err != nil {
+		return err
+	}
+	if n == want {
+		fmt.Printf("  - Destination partitions: %d\n", n)
+		return nil
+	}
+	if configure {
+		if err := kclient.SetPartitionCount(ctx, brokers, topic, want); err != nil {
+			return err
+		}
+		fmt.Printf("  - Destination partitions: %d (was %d)\n", want, n)
+		return nil
+	}
+	switch {
+	case n == 0:
+		fmt.Printf("[WARN] Destination %s does not exist and will get the broker's default partition count (want %d; use --partition-mode configure)\n", topic, want)
+	case rangeParts == 0:
+		fmt.Printf("[WARN] Destination %s has %d partitions: each is sorted, but global order is lost across them (use --range-partitions %d, or --partition-mode configure for one partition)\n", topic, n, n)
+	default:
+		fmt.Printf("[WARN] Destination %s has %d partitions, not %d; the output is split into %d ranges instead (use --partition-mode configure)\n", topic, n, want, n)
+	}
+	return nil
+}
+
+// ensureRetention validates (or, with configure, sets) the destination topic's retention
+// so a large sorted output is not truncated by broker defaults mid-verification.
+func ensureRetention(brokers []string, topic string, required kclient.Retention, configure bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	current, err := kclient.DescribeRetention(ctx, brokers, topic)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  - Destination retention: %s (required: %s)\n", current, required)
+	if current.Satisfies(required) {
+		return nil
+	}
+	if !configure {
+		return fmt.Errorf("topic %s has %s, below required %s (use --retention-mode configure to fix)", topic, current, required)
+	}
+	if err := kclient.SetRetention(ctx, brokers, topic, required); err != nil {
+		return err
+	}
+	fmt.Printf("  - Destination retention updated to %s\n", required)
+	return nil
+}
+
+// repairOutput finds the longest gap-free prefix of sequence numbers in the destination
+// topic, marks every partition so records past it are ignored, and resumes the merge from
+// the chunks the failed run left in tempDir, writing only the missing records.
+func repairOutput(sink extSort.Sink, brokers []string, topic string, sortIdx int, tempDir string, opts extSort.Options) (*extSort.Report, error) {
+	ctx := context.Background()
+	fmt.Printf("[Repair] Scanning %s for %s headers...\n", topic, extSort.SeqHeader)
+	scan, err := kclient.ScanSeqPrefix(ctx, brokers, topic, extSort.SeqHeader)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("[Repair] %d records found; valid prefix is [0, %d), highest sequence %d\n", scan.Records, scan.Prefix, scan.Max)
+
+	if scan.Max >= scan.Prefix {
+		n, err := kclient.WriteTruncateMarkers(ctx, brokers, topic, scan.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("write truncate markers: %w", err)
+		}
+		fmt.Printf("[Repair] Marked records at or above %d invalid in %d partitions (%s header)\n", scan.Prefix, n, kclient.TruncateHeader)
+	}
+
+	opts.ResumeFrom = scan.Prefix
+	return extSort.ResumeMerge(sink, sortIdx, tempDir, opts)
+}
+
+// usage prints the command line help, leaving out hidden testing flags.
+// profileConflicts returns the profile flags that the chosen mode rules out, which
+// --profile leaves unset rather than failing validation over a flag never given.
+func profileConflicts(emit string) []string {
+	if emit == extSort.EmitKeyCounts.String() {
+		return []string{"seq-headers"} // counts have no per-record output positions
+	}
+	return nil
+}
+
+// spillDir returns the directory a run spills into: extsort_<key> under base, with
+// _p<partitions> for a shard, since shards of the same key may share a machine. A
+// retry deletes the whole directory, so it is never base itself.
+func spillDir(base, key string, partitions []int) string {
+	name := "extsort_" + key
+	if partitions != nil {
+		ids := make([]string, len(partitions))
+		for i, p := range partitions {
+			ids[i] = strconv.Itoa(p)
+		}
+		name += "_p" + strings.Join(ids, "-")
+	}
+	return filepath.Join(base, name)
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "usage: sorter --key id|name|continent [flags]   (or: sorter [flags] id|name|continent)")
+	fmt.Fprintln(out, "       sorter --key continent,-id [flags]   (several keys in turn; - sorts one descending)")
+	fmt.Fprintln(out, "       sorter --key 2:float,-0:int [flags]   (CSV field indices, typed int, float or string)")
+	fmt.Fprintln(out, "       sorter --key-index N [--key-type string|int|float] [flags]")
+	visible := flag.NewFlagSet("sorter", flag.ContinueOnError)
+	visible.SetOutput(out)
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name != "inject-faults" {
+			visible.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	visible.PrintDefaults()
+}
+
+// parseCompositeKey parses keys such as continent,-id or 2:float,name: key names or
+// CSV field indices in turn, each optionally typed with :int, :float or :string (names
+// default to their type, indices to string) and prefixed with - to sort descending. It
+// returns the run's name, e.g. continent_id-desc or col2-float_name, which names the
+// destination topic and temp directory.
+func parseCompositeKey(spec string) (string, []extSort.KeyPart, error) {
+	var names []string
+	var parts []extSort.KeyPart
+	seen := map[int]bool{}
+	for _, f := range strings.Split(spec, ",") {
+		f = strings.TrimSpace(f)
+		field, desc := strings.CutPrefix(f, "-")
+		field, typ, typed := strings.Cut(field, ":")
+		name := field
+		idx, ok := map[string]int{"id": 0, "name": 1, "continent": 3}[field]
+		if !ok {
+			n, err := strconv.Atoi(field)
+			if err != nil || n < 0 {
+				return "", nil, fmt.Errorf("unknown key %q; must be id, name, continent or a field index, optionally typed (:int, :float, :string) and prefixed with -", f)
+			}
+			idx, name = n, "col"+field
+		}
+		if seen[idx] {
+			return "", nil, fmt.Errorf("field %d listed twice", idx)
+		}
+		seen[idx] = true
+		part := extSort.KeyPart{Index: idx, Int: name == "id", Desc: desc}
+		if typed {
+			part.Int = false
+			switch typ {
+			case "int":
+				part.Int = true
+			case "float":
+				part.Float = true
+			case "string":
+			default:
+				return "", nil, fmt.Errorf("unknown type %q in key %q; must be int, float or string", typ, f)
+			}
+			name += "-" + typ
+		}
+		parts = append(parts, part)
+		if desc {
+			name += "-desc"
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, "_"), parts, nil
+}
+
+// sortDirection describes the order of composite key parts for run metadata: asc or
+// desc when every part sorts that way, else the parts as FormatKeys spells them.
+func sortDirection(parts []extSort.KeyPart) string {
+	desc := func(p extSort.KeyPart) bool { return p.Desc }
+	switch {
+	case !slices.ContainsFunc(parts, desc):
+		return "asc"
+	case !slices.ContainsFunc(parts, func(p extSort.KeyPart) bool { return !desc(p) }):
+		return "desc"
+	}
+	return extSort.FormatKeys(parts)
+}
+
+// sortKeyPath returns the key path for the sort key: the record field itself with
+// --format json or avro, else whatever --key-path says.
+func sortKeyPath(format datagen.Format, key, keyPath string) string {
+	if format != datagen.CSV {
+		return key
+	}
+	return keyPath
+}
+
+// parsePartitions parses a comma-separated list of distinct partition numbers.
+// An empty list selects every partition and returns nil.
+func parsePartitions(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	seen := map[int]bool{}
+	var parts []int
+	for _, f := range strings.Split(s, ",") {
+		p, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || p < 0 {
+			return nil, fmt.Errorf("invalid partition %q", f)
+		}
+		if seen[p] {
+			return nil, fmt.Errorf("partition %d listed twice", p)
+		}
+		seen[p] = true
+		parts = append(parts, p)
+	}
+	sort.Ints(parts)
+	return parts, nil
+}
+
+// parseQuantiles parses a comma-separated list of quantiles in (0, 1]. An empty list
+// returns nil.
+func parseQuantiles(s string) ([]float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var qs []float64
+	for _, f := range strings.Split(s, ",") {
+		q, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil || !(q > 0 && q <= 1) {
+			return nil, fmt.Errorf("invalid quantile %q (want a number in (0, 1], e.g. 0.99)", f)
+		}
+		qs = append(qs, q)
+	}
+	return qs, nil
+}
+
+// validTopicName matches the characters Kafka allows in topic names.
+var validTopicName = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
 func getenv(k, def string) string {
 	if v := os.Getenv(k); v != "" {
 		return v
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"core-infra-project/internal/config"
+	extSort "core-infra-project/internal/sort"
+)
+
+// TestRetryKeepsTempDir cleans a run's spill directory the way a retry does and
+// checks that the rest of --temp-dir is left alone.
+func TestRetryKeepsTempDir(t *testing.T) {
+	base := t.TempDir()
+	unrelated := filepath.Join(base, "unrelated.txt")
+	if err := os.WriteFile(unrelated, []byte("keep me"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := spillDir(base, "id", []int{0, 3})
+	if want := filepath.Join(base, "extsort_id_p0-3"); dir != want {
+		t.Fatalf("spill dir %s, want %s", dir, want)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "chunk_0.txt"), []byte("1,a,x,EU\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, shred := range []bool{true, false} {
+		if err := extSort.RemoveSpillDir(dir, shred); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatalf("shred %v: spill dir still there: %v", shred, err)
+		}
+		b, err := os.ReadFile(unrelated)
+		if err != nil || string(b) != "keep me" {
+			t.Fatalf("shred %v: unrelated file is %q, %v", shred, b, err)
+		}
+	}
+}
+
+// TestProfileWithEmitCounts applies the profiles that turn on --seq-headers to a
+// --emit counts run, which cannot have them.
+func TestProfileWithEmitCounts(t *testing.T) {
+	for _, profile := range []string{"staging", "prod"} {
+		fs := flag.NewFlagSet("sorter", flag.ContinueOnError)
+		emit := fs.String("emit", "records", "")
+		seqHeaders := fs.Bool("seq-headers", false, "")
+		batchSize := fs.Int("batch-size", 1000, "")
+		if err := fs.Parse([]string{"--emit", "counts"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := config.ApplyProfile(fs, profile, profileConflicts(*emit)...); err != nil {
+			t.Fatal(err)
+		}
+		if *seqHeaders {
+			t.Errorf("--profile %s set --seq-headers for --emit counts", profile)
+		}
+		if want, _ := strconv.Atoi(config.Profiles[profile]["batch-size"]); *batchSize != want {
+			t.Errorf("--profile %s: --batch-size %d, want %d", profile, *batchSize, want)
+		}
+	}
+}
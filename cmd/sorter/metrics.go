@@ -0,0 +1,42 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+
+	kmetrics "core-infra-project/internal/metrics"
+	extSort "core-infra-project/internal/sort"
+)
+
+// sortPhases are the values of the phase label, in the order a sort goes through them.
+var sortPhases = []string{"chunk", "merge", "cleanup"}
+
+// sorterMetrics serves the live sort counters in the Prometheus text format at
+// /metrics on the pprof server, next to the expvars at /debug/vars.
+type sorterMetrics struct {
+	key   string
+	runID *expvar.String // --adopt-run-id may change it
+}
+
+func (m sorterMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	c := extSort.LiveCounters()
+	runID := m.runID.Value()
+	labels := kmetrics.Labels("run_id", runID, "key", m.key)
+	kmetrics.Write(w, kmetrics.SorterRecordsRead, "counter", "Records read by the chunk phase.", labels, float64(c.RecordsRead))
+	kmetrics.Write(w, kmetrics.SorterRecordsMerged, "counter", "Records written by the merge phase.", labels, float64(c.RecordsMerged))
+	kmetrics.Write(w, kmetrics.SorterSpillRawBytes, "counter", "Bytes spilled to chunks before spill compression.", labels, float64(c.SpillRawBytes))
+	kmetrics.Write(w, kmetrics.SorterSpillDiskBytes, "counter", "Bytes spilled to chunks after spill compression.", labels, float64(c.SpillDiskBytes))
+
+	kmetrics.Family(w, kmetrics.SorterPhase, "gauge", "1 for the phase the sort is in, 0 for the others.")
+	for _, phase := range sortPhases {
+		v := 0.0
+		if phase == c.Phase {
+			v = 1
+		}
+		kmetrics.Sample(w, kmetrics.SorterPhase, kmetrics.Labels("run_id", runID, "key", m.key, "phase", phase), v)
+	}
+	kmetrics.Family(w, kmetrics.SorterPhaseDuration, "gauge", "Duration of the last completed chunk and merge phases.")
+	kmetrics.Sample(w, kmetrics.SorterPhaseDuration, kmetrics.Labels("run_id", runID, "key", m.key, "phase", "chunk"), c.ChunkSeconds)
+	kmetrics.Sample(w, kmetrics.SorterPhaseDuration, kmetrics.Labels("run_id", runID, "key", m.key, "phase", "merge"), c.MergeSeconds)
+}
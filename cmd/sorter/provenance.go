@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"core-infra-project/internal/fastnum"
+	kclient "core-infra-project/internal/kafka"
+	extSort "core-infra-project/internal/sort"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// provenanceSource tallies the producer runs (ProducerRunHeader) of the records read
+// from the source and the record indices each contributed, so the summary names the
+// producer runs a sorted output was built from.
+type provenanceSource struct {
+	extSort.Source
+	runs     map[string]*producerRun
+	untagged int64
+}
+
+// producerRun is what one producer run contributed to the source.
+type producerRun struct {
+	records            int64
+	minIndex, maxIndex int64 // -1 when its records carry no RecordIndexHeader
+}
+
+func newProvenanceSource(source extSort.Source) *provenanceSource {
+	return &provenanceSource{Source: source, runs: map[string]*producerRun{}}
+}
+
+// ReadMessage implements extSort.Source.
+func (p *provenanceSource) ReadMessage(ctx context.Context) (gokafka.Message, error) {
+	msg, err := p.Source.ReadMessage(ctx)
+	if err != nil {
+		return msg, err
+	}
+	var runID string
+	index := int64(-1)
+	for _, h := range msg.Headers {
+		switch h.Key {
+		case kclient.ProducerRunHeader:
+			runID = string(h.Value)
+		case kclient.RecordIndexHeader:
+			if i, err := fastnum.ParseInt(h.Value); err == nil {
+				index = i
+			}
+		}
+	}
+	if runID == "" {
+		p.untagged++
+		return msg, nil
+	}
+	r := p.runs[runID]
+	if r == nil {
+		r = &producerRun{minIndex: index, maxIndex: index}
+		p.runs[runID] = r
+	}
+	r.records++
+	if index >= 0 {
+		if r.minIndex < 0 || index < r.minIndex {
+			r.minIndex = index
+		}
+		r.maxIndex = max(r.maxIndex, index)
+	}
+	return msg, nil
+}
+
+// runIDs returns the producer run ids seen so far, sorted; none for a nil p.
+func (p *provenanceSource) runIDs() []string {
+	if p == nil {
+		return nil
+	}
+	ids := make([]string, 0, len(p.runs))
+	for id := range p.runs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// print writes the tally as summary lines; sources without provenance headers print
+// nothing.
+func (p *provenanceSource) print() {
+	if len(p.runs) == 0 {
+		return
+	}
+	for _, id := range p.runIDs() {
+		r := p.runs[id]
+		if r.minIndex < 0 {
+			fmt.Printf("  - Source provenance: %d records from producer run %s\n", r.records, id)
+			continue
+		}
+		fmt.Printf("  - Source provenance: %d records from producer run %s (record-index %d..%d)\n",
+			r.records, id, r.minIndex, r.maxIndex)
+	}
+	if p.untagged > 0 {
+		fmt.Printf("  - Source provenance: %d records without a %s header\n", p.untagged, kclient.ProducerRunHeader)
+	}
+}
@@ -3,7 +3,11 @@ module core-infra-project
 go 1.21
 
 require (
-    github.com/segmentio/kafka-go v0.4.47
+	github.com/segmentio/kafka-go v0.4.47
+	golang.org/x/text v0.13.0
 )
 
-
+require (
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+)
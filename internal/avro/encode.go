@@ -0,0 +1,104 @@
+package avro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// EncodeCSV converts a comma-separated record into the Avro binary encoding of s,
+// appending to dst. Fields map to schema fields by position; an empty value in a
+// nullable field is encoded as null.
+func (s *Schema) EncodeCSV(dst, rec []byte) ([]byte, error) {
+	for i, f := range s.Fields {
+		var val []byte
+		if i < len(s.Fields)-1 {
+			j := bytes.IndexByte(rec, ',')
+			if j == -1 {
+				return dst, fmt.Errorf("avro: record has %d fields, schema %s expects %d", i+1, s.Name, len(s.Fields))
+			}
+			val, rec = rec[:j], rec[j+1:]
+		} else {
+			val = rec
+		}
+
+		if f.Nullable {
+			isNull := len(val) == 0
+			// Union branch index: position of the chosen branch in the declared union
+			branch := int64(0)
+			if isNull != f.NullFirst {
+				branch = 1
+			}
+			dst = appendLong(dst, branch)
+			if isNull {
+				continue
+			}
+		}
+
+		var err error
+		dst, err = appendValue(dst, f.Type, val)
+		if err != nil {
+			return dst, fmt.Errorf("avro: field %q: %w", f.Name, err)
+		}
+	}
+	return dst, nil
+}
+
+func appendValue(dst []byte, typ string, val []byte) ([]byte, error) {
+	switch typ {
+	case TypeString, TypeBytes:
+		dst = appendLong(dst, int64(len(val)))
+		return append(dst, val...), nil
+	case TypeInt:
+		n, err := strconv.ParseInt(string(val), 10, 32)
+		if err != nil {
+			return dst, err
+		}
+		return appendLong(dst, n), nil
+	case TypeLong:
+		n, err := strconv.ParseInt(string(val), 10, 64)
+		if err != nil {
+			return dst, err
+		}
+		return appendLong(dst, n), nil
+	case TypeFloat:
+		f, err := strconv.ParseFloat(string(val), 32)
+		if err != nil {
+			return dst, err
+		}
+		return binary.LittleEndian.AppendUint32(dst, math.Float32bits(float32(f))), nil
+	case TypeDouble:
+		f, err := strconv.ParseFloat(string(val), 64)
+		if err != nil {
+			return dst, err
+		}
+		return binary.LittleEndian.AppendUint64(dst, math.Float64bits(f)), nil
+	case TypeBoolean:
+		b, err := strconv.ParseBool(string(val))
+		if err != nil {
+			return dst, err
+		}
+		if b {
+			return append(dst, 1), nil
+		}
+		return append(dst, 0), nil
+	}
+	return dst, fmt.Errorf("unsupported type %q", typ)
+}
+
+// appendLong appends n as a zig-zag varint (Avro int and long encoding).
+func appendLong(dst []byte, n int64) []byte {
+	return binary.AppendUvarint(dst, uint64((n<<1)^(n>>63)))
+}
+
+// magicByte prefixes every Confluent wire-format message.
+const magicByte = 0
+
+// AppendFrame appends the Confluent wire-format header (magic byte + big-endian
+// schema id) to dst.
+func AppendFrame(dst []byte, schemaID int) []byte {
+	dst = append(dst, magicByte)
+	return binary.BigEndian.AppendUint32(dst, uint32(schemaID))
+}
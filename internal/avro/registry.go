@@ -0,0 +1,83 @@
+package avro
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const registryContentType = "application/vnd.schemaregistry.v1+json"
+
+// RegistryClient talks to a Confluent-compatible Schema Registry.
+type RegistryClient struct {
+	baseURL string
+	http    *http.Client
+}
+
+// NewRegistryClient returns a client for the registry at baseURL (e.g. http://schema-registry:8081).
+func NewRegistryClient(baseURL string) *RegistryClient {
+	return &RegistryClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Register registers schema under subject and returns its global schema id.
+// Registering an identical schema again returns the existing id.
+func (c *RegistryClient) Register(ctx context.Context, subject string, s *Schema) (int, error) {
+	body, err := json.Marshal(map[string]string{"schema": s.Canonical})
+	if err != nil {
+		return 0, err
+	}
+	u := fmt.Sprintf("%s/subjects/%s/versions", c.baseURL, url.PathEscape(subject))
+	var resp struct {
+		ID int `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, u, body, &resp); err != nil {
+		return 0, fmt.Errorf("avro: register subject %q: %w", subject, err)
+	}
+	return resp.ID, nil
+}
+
+func (c *RegistryClient) do(ctx context.Context, method, u string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", registryContentType)
+	req.Header.Set("Accept", registryContentType)
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("registry returned %s: %s", res.Status, strings.TrimSpace(string(b)))
+	}
+	return json.Unmarshal(b, out)
+}
+
+// SchemaByID fetches the schema registered under the global id.
+func (c *RegistryClient) SchemaByID(ctx context.Context, id int) (*Schema, error) {
+	var resp struct {
+		Schema string `json:"schema"`
+	}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/schemas/ids/%d", c.baseURL, id), nil, &resp); err != nil {
+		return nil, fmt.Errorf("avro: fetch schema %d: %w", id, err)
+	}
+	s, err := ParseSchema([]byte(resp.Schema))
+	if err != nil {
+		return nil, fmt.Errorf("avro: schema %d: %w", id, err)
+	}
+	return s, nil
+}
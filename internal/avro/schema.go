@@ -0,0 +1,115 @@
+// Package avro implements the small subset of Apache Avro needed by the pipeline:
+// flat record schemas of primitive (optionally nullable) fields, binary encoding,
+// Confluent wire-format framing and a Schema Registry client.
+package avro
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Supported primitive field types.
+const (
+	TypeBoolean = "boolean"
+	TypeInt     = "int"
+	TypeLong    = "long"
+	TypeFloat   = "float"
+	TypeDouble  = "double"
+	TypeString  = "string"
+	TypeBytes   = "bytes"
+)
+
+// Field is one field of a flat record schema.
+type Field struct {
+	Name string
+	Type string
+	// Nullable marks a ["null", Type] union; NullFirst records the branch order.
+	Nullable  bool
+	NullFirst bool
+}
+
+// Schema is a parsed flat Avro record schema.
+type Schema struct {
+	Name      string
+	Namespace string
+	Fields    []Field
+	// Canonical is the compact JSON form registered with the Schema Registry.
+	Canonical string
+}
+
+type jsonField struct {
+	Name string          `json:"name"`
+	Type json.RawMessage `json:"type"`
+}
+
+type jsonSchema struct {
+	Type      string      `json:"type"`
+	Name      string      `json:"name"`
+	Namespace string      `json:"namespace"`
+	Fields    []jsonField `json:"fields"`
+}
+
+// ParseSchema parses an .avsc document describing a record of primitive fields.
+// Nested records, arrays, maps, enums and unions other than ["null", T] are rejected.
+func ParseSchema(b []byte) (*Schema, error) {
+	var js jsonSchema
+	if err := json.Unmarshal(b, &js); err != nil {
+		return nil, fmt.Errorf("avro: parse schema: %w", err)
+	}
+	if js.Type != "record" {
+		return nil, fmt.Errorf("avro: top-level type must be record, got %q", js.Type)
+	}
+	if js.Name == "" || len(js.Fields) == 0 {
+		return nil, fmt.Errorf("avro: record must have a name and at least one field")
+	}
+
+	s := &Schema{Name: js.Name, Namespace: js.Namespace}
+	for _, jf := range js.Fields {
+		f := Field{Name: jf.Name}
+		var prim string
+		var union []string
+		switch {
+		case json.Unmarshal(jf.Type, &prim) == nil:
+			f.Type = prim
+		case json.Unmarshal(jf.Type, &union) == nil && len(union) == 2 && (union[0] == "null" || union[1] == "null"):
+			f.Nullable = true
+			f.NullFirst = union[0] == "null"
+			if f.NullFirst {
+				f.Type = union[1]
+			} else {
+				f.Type = union[0]
+			}
+		default:
+			return nil, fmt.Errorf("avro: field %q: unsupported type %s", jf.Name, jf.Type)
+		}
+		if !isPrimitive(f.Type) {
+			return nil, fmt.Errorf("avro: field %q: unsupported type %q", jf.Name, f.Type)
+		}
+		s.Fields = append(s.Fields, f)
+	}
+
+	canonical, err := json.Marshal(js)
+	if err != nil {
+		return nil, err
+	}
+	s.Canonical = string(canonical)
+	return s, nil
+}
+
+// FieldIndex returns the position of the named field, or -1.
+func (s *Schema) FieldIndex(name string) int {
+	for i, f := range s.Fields {
+		if f.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func isPrimitive(t string) bool {
+	switch t {
+	case TypeBoolean, TypeInt, TypeLong, TypeFloat, TypeDouble, TypeString, TypeBytes:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package avro
+
+import (
+	"context"
+
+	extSort "core-infra-project/internal/sort"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// CSVSink converts CSV record values to Confluent-framed Avro before handing them
+// to the wrapped sink. It is the sorter's Avro output codec.
+type CSVSink struct {
+	next     extSort.Sink
+	schema   *Schema
+	schemaID int
+	out      []gokafka.Message
+}
+
+// NewCSVSink wraps next, encoding every value with schema under the registered schemaID.
+func NewCSVSink(next extSort.Sink, schema *Schema, schemaID int) *CSVSink {
+	return &CSVSink{next: next, schema: schema, schemaID: schemaID}
+}
+
+// WriteMessages implements extSort.Sink.
+func (s *CSVSink) WriteMessages(ctx context.Context, msgs ...gokafka.Message) error {
+	s.out = s.out[:0]
+	for _, m := range msgs {
+		val, err := s.schema.EncodeCSV(AppendFrame(make([]byte, 0, len(m.Value)+8), s.schemaID), m.Value)
+		if err != nil {
+			return err
+		}
+		m.Value = val
+		s.out = append(s.out, m)
+	}
+	return s.next.WriteMessages(ctx, s.out...)
+}
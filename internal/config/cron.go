@@ -0,0 +1,152 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed five-field cron expression: minute, hour, day of month, month
+// and day of week, each *, a value, a range a-b or a comma-separated list of them,
+// optionally stepped with /n. Months and weekdays also take three-letter names, and
+// Sunday is 0 or 7. As in cron, a day matches if either day field does when both are
+// restricted. @hourly, @daily (@midnight), @weekly, @monthly and @yearly (@annually)
+// are shorthands.
+type Schedule struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64 // bit i set: value i matches
+	domStar, dowStar              bool
+}
+
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// ParseCron parses a cron expression.
+func ParseCron(spec string) (*Schedule, error) {
+	expr := strings.TrimSpace(spec)
+	if m, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q has %d fields, want 5 (minute hour day-of-month month day-of-week)", spec, len(fields))
+	}
+	s := &Schedule{spec: spec}
+	var err error
+	if s.minute, err = cronField(fields[0], "minute", 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if s.hour, err = cronField(fields[1], "hour", 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if s.dom, err = cronField(fields[2], "day of month", 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if s.month, err = cronField(fields[3], "month", 1, 12, monthNames); err != nil {
+		return nil, err
+	}
+	if s.dow, err = cronField(fields[4], "day of week", 0, 7, dayNames); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is Sunday too
+	}
+	s.domStar, s.dowStar = strings.HasPrefix(fields[2], "*"), strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// cronField parses one field into a bit set of the values in [lo, hi] it matches.
+// names, if any, spell the values from lo.
+func cronField(field, what string, lo, hi int, names []string) (uint64, error) {
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(s, name) {
+				return lo + i, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < lo || n > hi {
+			return 0, fmt.Errorf("cron %s %q is not in %d-%d", what, s, lo, hi)
+		}
+		return n, nil
+	}
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, stepStr, stepped := strings.Cut(item, "/")
+		step := 1
+		if stepped {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("cron %s step %q is not a positive integer", what, stepStr)
+			}
+		}
+		from, to := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = value(a); err != nil {
+				return 0, err
+			}
+			to = from
+			if isRange {
+				if to, err = value(b); err != nil {
+					return 0, err
+				}
+			} else if stepped {
+				to = hi // a/n runs from a to the end
+			}
+			if to < from {
+				return 0, fmt.Errorf("cron %s range %q is backwards", what, rng)
+			}
+		}
+		for i := from; i <= to; i += step {
+			bits |= 1 << i
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first time after t that the schedule matches, in t's location, or
+// the zero time if there is none within five years (e.g. 30 2 31 2 *).
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<t.Month()) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom, dow := s.dom&(1<<t.Day()) != 0, s.dow&(1<<t.Weekday()) != 0
+	if !s.domStar && !s.dowStar {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+func (s *Schedule) String() string { return s.spec }
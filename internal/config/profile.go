@@ -0,0 +1,71 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Profiles bundle flag defaults per environment. A profile only fills in flags that were
+// not given on the command line, and entries for flags a binary does not define are
+// ignored, so one table serves both binaries.
+var Profiles = map[string]map[string]string{
+	// Small batches and verbose logging for quick local iterations
+	"dev": {
+		"batch-size":       "200",
+		"log-chunk-ranges": "true",
+		"check-brokers":    "true",
+		"topic-wait":       "30s",
+	},
+	"staging": {
+		"batch-size":    "1000",
+		"check-brokers": "true",
+		"max-attempts":  "2",
+		"seq-headers":   "true",
+		"topic-wait":    "60s",
+	},
+	// Big batches, retries and a run report for metrics collection
+	"prod": {
+		"batch-size":        "5000",
+		"check-brokers":     "true",
+		"max-attempts":      "3",
+		"seq-headers":       "true",
+		"spill-compression": "lz4",
+		"report":            "run-report.json",
+		"topic-wait":        "120s",
+		"prewarm":           "true",
+	},
+}
+
+// ApplyProfile sets the flags of the named profile that were not set explicitly in fs,
+// leaving the flags named in skip alone (e.g. ones that conflict with the chosen mode).
+// It must run after fs.Parse and before any flag value is read.
+func ApplyProfile(fs *flag.FlagSet, name string, skip ...string) error {
+	if name == "" {
+		return nil
+	}
+	p, ok := Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(Profiles))
+		for n := range Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown profile %q (want %s)", name, strings.Join(names, ", "))
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, s := range skip {
+		set[s] = true
+	}
+	for flagName, value := range p {
+		if set[flagName] || fs.Lookup(flagName) == nil {
+			continue
+		}
+		if err := fs.Set(flagName, value); err != nil {
+			return fmt.Errorf("profile %s: --%s=%s: %w", name, flagName, value, err)
+		}
+	}
+	return nil
+}
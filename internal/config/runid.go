@@ -0,0 +1,24 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// RunIDEnv names the variable holding a benchmark run's shared id. A harness that
+// exports it before starting the producer and the sorters gets one id across their
+// headers, summaries, reports and /debug/vars.
+const RunIDEnv = "KSS_RUN_ID"
+
+// DefaultRunID returns $KSS_RUN_ID, or else a new id from the current UTC time.
+func DefaultRunID() string {
+	if id := os.Getenv(RunIDEnv); id != "" {
+		return id
+	}
+	return RunIDAt(time.Now())
+}
+
+// RunIDAt returns the id of a run started at t, as DefaultRunID makes them.
+func RunIDAt(t time.Time) string {
+	return t.UTC().Format("20060102t150405")
+}
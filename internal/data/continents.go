@@ -0,0 +1,79 @@
+package data
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ContinentWeights redraws record continents with configured weights, e.g.
+// "Asia:60,Europe:20,North America:20", so sorting by continent sees realistic skew.
+// Continents left out get no records. Only the continent changes: the other fields
+// of record i stay those of (seed, i), and with a seed the continent too depends
+// only on (seed, i).
+type ContinentWeights struct {
+	names []string
+	upTo  []int // cumulative weights
+}
+
+// ParseContinentWeights parses comma-separated name:weight pairs of the generator's
+// continents, with non-negative integer weights that are not all zero.
+func ParseContinentWeights(s string) (*ContinentWeights, error) {
+	w := &ContinentWeights{}
+	total := 0
+	for _, pair := range strings.Split(s, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("continent weight %q is not name:weight", pair)
+		}
+		if !slices.Contains(continents, name) {
+			return nil, fmt.Errorf("unknown continent %q (want one of %s)", name, strings.Join(continents, ", "))
+		}
+		if slices.Contains(w.names, name) {
+			return nil, fmt.Errorf("continent %q is weighted twice", name)
+		}
+		n, err := strconv.Atoi(weight)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("weight of %s must be a non-negative integer, got %q", name, weight)
+		}
+		total += n
+		w.names = append(w.names, name)
+		w.upTo = append(w.upTo, total)
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("continent weights must not all be zero")
+	}
+	return w, nil
+}
+
+// String returns the weights in the form ParseContinentWeights reads.
+func (w *ContinentWeights) String() string {
+	parts := make([]string, len(w.names))
+	prev := 0
+	for i, name := range w.names {
+		parts[i] = fmt.Sprintf("%s:%d", name, w.upTo[i]-prev)
+		prev = w.upTo[i]
+	}
+	return strings.Join(parts, ",")
+}
+
+// Apply sets the continent of fl, the fields of record i, by the weights; seed 0
+// draws it at random.
+func (w *ContinentWeights) Apply(fl *Fields, seed, i int64) {
+	if w == nil {
+		return
+	}
+	var r rng = globalRNG{}
+	if seed != 0 {
+		// A stream of its own, so the other fields stay those of (seed, i)
+		r = seededRNG(seed^0x7f4a7c159e3779b9, i)
+	}
+	p := r.Intn(w.upTo[len(w.upTo)-1])
+	for j, upTo := range w.upTo {
+		if p < upTo {
+			fl.Continent = w.names[j]
+			return
+		}
+	}
+}
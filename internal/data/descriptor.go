@@ -0,0 +1,66 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Descriptor is everything needed to regenerate a seeded dataset: seeded record i
+// depends only on (Seed, i) and the layout, so a producer run with these settings
+// writes the same records again, for benchmarks that must compare like with like.
+type Descriptor struct {
+	Topic            string          `json:"topic"`
+	Records          int64           `json:"records"`
+	StartID          int64           `json:"start_id,omitempty"` // of the first record, for a run that grew a dataset
+	Seed             int64           `json:"seed"`
+	Format           string          `json:"format"`
+	Template         string          `json:"template,omitempty"`
+	Distribution     string          `json:"distribution"`
+	ContinentWeights string          `json:"continent_weights,omitempty"`
+	MalformedPercent float64         `json:"malformed_percent,omitempty"`
+	DuplicatePercent float64         `json:"duplicate_percent,omitempty"`
+	KeyByID          bool            `json:"key_by_id,omitempty"`
+	Schema           json.RawMessage `json:"schema,omitempty"` // the Avro schema of --format avro
+	RunID            string          `json:"run_id,omitempty"` // of the run that wrote it
+	CreatedAt        time.Time       `json:"created_at"`
+}
+
+// ParseDescriptor decodes and checks a descriptor written by Marshal.
+func ParseDescriptor(b []byte) (*Descriptor, error) {
+	var d Descriptor
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, fmt.Errorf("dataset descriptor: %w", err)
+	}
+	if d.Seed == 0 || d.Records < 1 || d.StartID < 0 {
+		return nil, fmt.Errorf("dataset descriptor: needs a seed, a positive record count and a non-negative start id")
+	}
+	if _, err := ParseFormat(d.Format); err != nil {
+		return nil, fmt.Errorf("dataset descriptor: %w", err)
+	}
+	if _, err := ParseDistribution(d.Distribution); err != nil {
+		return nil, fmt.Errorf("dataset descriptor: %w", err)
+	}
+	if d.ContinentWeights != "" {
+		if _, err := ParseContinentWeights(d.ContinentWeights); err != nil {
+			return nil, fmt.Errorf("dataset descriptor: %w", err)
+		}
+	}
+	return &d, nil
+}
+
+// ReadDescriptor reads a descriptor file.
+func ReadDescriptor(path string) (*Descriptor, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDescriptor(b)
+}
+
+// Marshal encodes d as indented JSON.
+func (d *Descriptor) Marshal() ([]byte, error) {
+	b, err := json.MarshalIndent(d, "", "  ")
+	return append(b, '\n'), err
+}
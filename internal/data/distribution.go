@@ -0,0 +1,85 @@
+package data
+
+import (
+	"fmt"
+	"math"
+)
+
+// Distribution shapes the generated ids and continents. Uniform records have all
+// distinct-ish ids and evenly spread continents, which hides the pathologies of real
+// data; Zipf draws ids from a small pool of keys where a few are very hot, and puts
+// most records on one continent, so chunks are lopsided and merges see long runs of
+// equal keys.
+type Distribution int
+
+const (
+	Uniform Distribution = iota // ids uniform over int32, continents equally likely
+	Zipf                        // ids Zipfian over zipfKeys keys, continents skewed
+)
+
+const (
+	// zipfKeys is the number of distinct ids Zipf draws from, and zipfExponent the
+	// skew: the hottest id is about 14% of the records, the top 100 about two thirds.
+	zipfKeys     = 1 << 20
+	zipfExponent = 1.2
+)
+
+// zipfContinents are the cumulative percentages of Zipf records per continent.
+var zipfContinents = [...]struct {
+	name string
+	upTo int
+}{
+	{"Asia", 60}, {"Europe", 80}, {"North America", 90}, {"South America", 95}, {"Africa", 99}, {"Australia", 100},
+}
+
+// ParseDistribution parses "uniform" or "zipf".
+func ParseDistribution(s string) (Distribution, error) {
+	switch s {
+	case "uniform":
+		return Uniform, nil
+	case "zipf":
+		return Zipf, nil
+	}
+	return Uniform, fmt.Errorf("unknown key distribution %q (want uniform or zipf)", s)
+}
+
+func (d Distribution) String() string {
+	if d == Zipf {
+		return "zipf"
+	}
+	return "uniform"
+}
+
+// RandomFields returns the fields of a random record drawn from d.
+func (d Distribution) RandomFields() Fields {
+	return generateFields(globalRNG{}, d)
+}
+
+// SeededFields returns the fields of record i of the dataset identified by seed and
+// drawn from d; Uniform gives the values Format.Seeded encodes.
+func (d Distribution) SeededFields(seed, i int64) Fields {
+	return generateFields(seededRNG(seed, i), d)
+}
+
+// zipfID draws an id: a Zipfian rank in [0, zipfKeys) by inverting the continuous
+// approximation of its CDF, scattered over the positive int32 range so the hot ids
+// are not simply the smallest.
+func zipfID(r rng) int32 {
+	const a = 1 - zipfExponent
+	n := math.Pow(zipfKeys, a)
+	rank := int64(math.Pow(1+r.Float64()*(n-1), 1/a)) - 1
+	rank = min(max(rank, 0), zipfKeys-1)
+	// Odd multiplier: a bijection on the low 31 bits
+	return int32(uint32(rank*2654435761) & math.MaxInt32)
+}
+
+// zipfContinent draws a continent with the zipfContinents weights.
+func zipfContinent(r rng) string {
+	p := r.Intn(100)
+	for _, c := range zipfContinents {
+		if p < c.upTo {
+			return c.name
+		}
+	}
+	return zipfContinents[len(zipfContinents)-1].name
+}
@@ -0,0 +1,65 @@
+package data
+
+// DuplicateWindow is how far back Duplicator reaches for the record it repeats.
+const DuplicateWindow = 1000
+
+// duplicatePicks is how many random records of the window Duplicator tries before
+// it scans the whole window for the originals.
+const duplicatePicks = 8
+
+// Duplicator makes a percentage of seeded records exact copies of earlier ones, to
+// exercise deduplication and the order of equal keys. Which records repeat, and
+// which record each repeats, depend only on (Seed, i), so a copy is made by
+// generating the original again.
+type Duplicator struct {
+	Percent float64 // of records, in [0, 100]
+	Seed    int64
+}
+
+// Of returns the record that record i repeats, one of the DuplicateWindow records
+// before it, or i itself if it is not a duplicate. Only records that are not
+// duplicates are repeated, so the result never repeats anything itself; a duplicate
+// whose whole window repeats (near 100%) stays an original.
+func (d Duplicator) Of(i int64) int64 {
+	if d.Percent <= 0 || i == 0 {
+		return i
+	}
+	r := d.rng(i)
+	if !d.repeats(r) {
+		return i
+	}
+	window := min(i, DuplicateWindow)
+	for pick := 0; pick < duplicatePicks; pick++ {
+		if j := i - 1 - int64(r.Intn(int(window))); d.original(j) {
+			return j
+		}
+	}
+	// The picks were duplicates too: choose evenly among the window's originals in
+	// one pass (reservoir sampling) rather than following copies back
+	src, seen := i, 0
+	for j := i - window; j < i; j++ {
+		if d.original(j) {
+			seen++
+			if r.Intn(seen) == 0 {
+				src = j
+			}
+		}
+	}
+	return src
+}
+
+// rng returns the stream deciding record i's duplication, one of its own so the
+// record's fields and malformation stay those of (Seed, i).
+func (d Duplicator) rng(i int64) rng {
+	return seededRNG(d.Seed^0x2545f4914f6cdd1d, i)
+}
+
+// original reports whether record j is not a duplicate; record 0 never is.
+func (d Duplicator) original(j int64) bool {
+	return j == 0 || !d.repeats(d.rng(j))
+}
+
+// repeats draws from a record's stream whether it is a duplicate.
+func (d Duplicator) repeats(r rng) bool {
+	return r.Float64()*100 < d.Percent
+}
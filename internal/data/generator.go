@@ -1,9 +1,13 @@
 package data
 
 import (
+    "bytes"
+    "fmt"
     "math/rand"
     "strings"
     "time"
+
+    "core-infra-project/internal/fastnum"
 )
 
 var (
@@ -17,65 +21,199 @@ func init() {
     rand.Seed(time.Now().UnixNano())
 }
 
+// rng is the subset of math/rand used by the generator, so records can come from the
+// shared global source or from a per-record seeded one.
+type rng interface {
+    Int31() int32
+    Intn(n int) int
+    Float64() float64
+}
+
+// globalRNG forwards to the math/rand top-level functions (safe for concurrent use).
+type globalRNG struct{}
+
+func (globalRNG) Int31() int32   { return rand.Int31() }
+func (globalRNG) Intn(n int) int { return rand.Intn(n) }
+func (globalRNG) Float64() float64 { return rand.Float64() }
+
+// Format is the encoding of generated records.
+type Format int
+
+const (
+    CSV  Format = iota // id,name,address,continent
+    JSON               // {"id":..,"name":..,"address":..,"continent":..}
+    // Avro records are generated as CSV; the caller encodes them with its schema
+    // (avro.Schema.EncodeCSV), which needs a Schema Registry id for the framing.
+    Avro
+)
+
+// ParseFormat parses "csv", "json" or "avro" (the FORMAT setting).
+func ParseFormat(s string) (Format, error) {
+    switch s {
+    case "csv":
+        return CSV, nil
+    case "json":
+        return JSON, nil
+    case "avro":
+        return Avro, nil
+    }
+    return CSV, fmt.Errorf("unknown record format %q (want csv, json or avro)", s)
+}
+
+func (f Format) String() string {
+    switch f {
+    case JSON:
+        return "json"
+    case Avro:
+        return "avro"
+    }
+    return "csv"
+}
+
+// Random returns a random record in format f.
+func (f Format) Random() []byte {
+    return f.Encode(RandomFields())
+}
+
+// Seeded returns record i of the dataset identified by seed in format f; see
+// GenerateSeededRecord.
+func (f Format) Seeded(seed, i int64) []byte {
+    return f.Encode(SeededFields(seed, i))
+}
+
+// ID returns the id field of rec, a record generated in format f (CSV for Avro,
+// which is encoded later). It shares rec's memory.
+func (f Format) ID(rec []byte) []byte {
+    if f == JSON {
+        rec = bytes.TrimPrefix(rec, []byte(`{"id":`))
+    }
+    if i := bytes.IndexByte(rec, ','); i >= 0 {
+        return rec[:i]
+    }
+    return rec
+}
+
 // GenerateRandomRecord returns a CSV record as []byte: id,name,address,continent
 // Optimized to minimize allocations by using a strings.Builder with preallocation.
 func GenerateRandomRecord() []byte {
+    return CSV.Random()
+}
+
+// GenerateSeededRecord returns record i of the dataset identified by seed. The same
+// (seed, i) always yields the same record, whichever worker generates it, so a seeded
+// dataset can be regenerated (or a partial run resumed) exactly.
+func GenerateSeededRecord(seed, i int64) []byte {
+    return CSV.Seeded(seed, i)
+}
+
+func seededRNG(seed, i int64) rng {
+    return rand.New(&splitMix64{state: uint64(seed) ^ uint64(i)*0xd1b54a32d192ed03})
+}
+
+// Fields are the generated values of one record, before encoding.
+type Fields struct {
+    ID        int32
+    Name      string
+    Address   string
+    Continent string
+}
+
+// RandomFields returns the fields of a random record.
+func RandomFields() Fields {
+    return Uniform.RandomFields()
+}
+
+// SeededFields returns the fields of record i of the dataset identified by seed, the
+// values Format.Seeded encodes.
+func SeededFields(seed, i int64) Fields {
+    return Uniform.SeededFields(seed, i)
+}
+
+func generateFields(r rng, d Distribution) Fields {
     // id
-    id := rand.Int31()
+    var id int32
+    if d == Zipf {
+        id = zipfID(r)
+    } else {
+        id = r.Int31()
+    }
 
     // name 10-15 letters
-    nameLen := 10 + rand.Intn(6)
+    nameLen := 10 + r.Intn(6)
     var nameBuilder strings.Builder
     nameBuilder.Grow(nameLen)
     for i := 0; i < nameLen; i++ {
-        nameBuilder.WriteRune(letters[rand.Intn(len(letters))])
+        nameBuilder.WriteRune(letters[r.Intn(len(letters))])
     }
 
     // address 15-20 alnum+space
-    addrLen := 15 + rand.Intn(6)
+    addrLen := 15 + r.Intn(6)
     var addrBuilder strings.Builder
     addrBuilder.Grow(addrLen)
     for i := 0; i < addrLen; i++ {
-        addrBuilder.WriteRune(alnumSpace[rand.Intn(len(alnumSpace))])
+        addrBuilder.WriteRune(alnumSpace[r.Intn(len(alnumSpace))])
+    }
+
+    var continent string
+    if d == Zipf {
+        continent = zipfContinent(r)
+    } else {
+        continent = continents[r.Intn(len(continents))]
     }
 
-    continent := continents[rand.Intn(len(continents))]
+    return Fields{ID: id, Name: nameBuilder.String(), Address: addrBuilder.String(), Continent: continent}
+}
+
+// Encode returns the record holding fl in format f (CSV for Avro).
+func (f Format) Encode(fl Fields) []byte {
+    if f == JSON {
+        // Generated fields never need escaping (letters, digits and spaces only)
+        var b strings.Builder
+        b.Grow(len(`{"id":,"name":"","address":"","continent":""}`) + 11 + len(fl.Name) + len(fl.Address) + len(fl.Continent))
+        b.WriteString(`{"id":`)
+        var id [11]byte // -2147483648
+        b.Write(fastnum.AppendInt(id[:0], int64(fl.ID)))
+        b.WriteString(`,"name":"`)
+        b.WriteString(fl.Name)
+        b.WriteString(`","address":"`)
+        b.WriteString(fl.Address)
+        b.WriteString(`","continent":"`)
+        b.WriteString(fl.Continent)
+        b.WriteString(`"}`)
+        return []byte(b.String())
+    }
 
     // CSV: id,name,address,continent
     // Estimate: id up to 10 chars + commas + name + address + continent
     var b strings.Builder
-    b.Grow(10 + 1 + nameLen + 1 + addrLen + 1 + len(continent))
+    b.Grow(10 + 1 + len(fl.Name) + 1 + len(fl.Address) + 1 + len(fl.Continent))
     // Write int32 without fmt to avoid allocations
-    writeInt32(&b, id)
+    var id [11]byte // -2147483648
+    b.Write(fastnum.AppendInt(id[:0], int64(fl.ID)))
     b.WriteByte(',')
-    b.WriteString(nameBuilder.String())
+    b.WriteString(fl.Name)
     b.WriteByte(',')
-    b.WriteString(addrBuilder.String())
+    b.WriteString(fl.Address)
     b.WriteByte(',')
-    b.WriteString(continent)
+    b.WriteString(fl.Continent)
 
     return []byte(b.String())
 }
 
-func writeInt32(b *strings.Builder, v int32) {
-    // Convert positive int32 to decimal without fmt
-    if v == 0 {
-        b.WriteByte('0')
-        return
-    }
-    // handle negative
-    if v < 0 {
-        b.WriteByte('-')
-        v = -v
-    }
-    var buf [11]byte // enough for -2147483648
-    i := len(buf)
-    for v > 0 {
-        i--
-        buf[i] = byte('0' + (v % 10))
-        v /= 10
-    }
-    b.Write(buf[i:])
+// splitMix64 is a tiny rand.Source64; unlike rand.NewSource it costs no 5KB state
+// per record, so seeding one per generated record stays cheap.
+type splitMix64 struct {
+    state uint64
+}
+
+func (m *splitMix64) Uint64() uint64 {
+    m.state += 0x9e3779b97f4a7c15
+    z := m.state
+    z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+    z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+    return z ^ (z >> 31)
 }
 
+func (m *splitMix64) Int63() int64 { return int64(m.Uint64() >> 1) }
 
+func (m *splitMix64) Seed(seed int64) { m.state = uint64(seed) }
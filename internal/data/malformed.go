@@ -0,0 +1,65 @@
+package data
+
+import "bytes"
+
+// Malformations names the ways Malformer breaks a record, by kind.
+var Malformations = [...]string{"missing-field", "non-numeric-id", "embedded-newline"}
+
+// Malformer breaks a percentage of generated records, to check that consumers reject
+// or quarantine them instead of mis-sorting them or crashing. With a nonzero Seed the
+// records broken, and how, depend only on (Seed, i), like seeded records themselves.
+type Malformer struct {
+	Percent float64 // of records, in [0, 100]
+	Seed    int64
+}
+
+// Apply returns record i, rec in CSV or JSON format f, as is with kind -1, or broken
+// as Malformations[kind] describes:
+//
+//	missing-field     the continent field is dropped
+//	non-numeric-id    the id is prefixed with "id-" (a JSON string in JSON records)
+//	embedded-newline  a newline is inserted into the name
+func (m Malformer) Apply(f Format, rec []byte, i int64) ([]byte, int) {
+	if m.Percent <= 0 {
+		return rec, -1
+	}
+	var r rng = globalRNG{}
+	if m.Seed != 0 {
+		// A stream of its own, so the record's fields stay those of (Seed, i)
+		r = seededRNG(^m.Seed, i)
+	}
+	if r.Float64()*100 >= m.Percent {
+		return rec, -1
+	}
+	kind := r.Intn(len(Malformations))
+	return malform(f, rec, kind), kind
+}
+
+func malform(f Format, rec []byte, kind int) []byte {
+	out := make([]byte, 0, len(rec)+8)
+	switch kind {
+	case 0:
+		if f == JSON {
+			i := bytes.LastIndex(rec, []byte(`,"continent":`))
+			return append(append(out, rec[:i]...), '}')
+		}
+		return append(out, rec[:bytes.LastIndexByte(rec, ',')]...)
+	case 1:
+		id := f.ID(rec)
+		start := len(rec) - len(bytes.TrimPrefix(rec, []byte(`{"id":`)))
+		rest := rec[start+len(id):]
+		out = append(out, rec[:start]...)
+		if f == JSON {
+			return append(append(append(append(out, `"id-`...), id...), '"'), rest...)
+		}
+		return append(append(append(out, "id-"...), id...), rest...)
+	default:
+		name := bytes.IndexByte(rec, ',') + 1
+		if f == JSON {
+			name = bytes.Index(rec, []byte(`"name":"`)) + len(`"name":"`)
+		}
+		// Names have at least 10 letters, so the newline lands inside one
+		cut := name + 3
+		return append(append(append(out, rec[:cut]...), '\n'), rec[cut:]...)
+	}
+}
@@ -0,0 +1,43 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// Template renders generated Fields with a text/template, so the record layout can be
+// reshaped without code: e.g. `{{.ID}}|{{.Name}}-{{.Continent}}` for another
+// delimiter, or `{"after":{"id":{{.ID}},"name":"{{.Name}}"}}` to exercise the
+// sorter's --key-path. Generated fields are letters, digits and spaces, so they need
+// no escaping in CSV or JSON layouts.
+type Template struct {
+	t *template.Template
+}
+
+// ParseTemplate parses text, rendering it once so that references to unknown fields
+// fail here rather than on the first record.
+func ParseTemplate(text string) (*Template, error) {
+	t, err := template.New("record").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &Template{t: t}
+	rec, err := tmpl.Render(Fields{})
+	if err != nil {
+		return nil, err
+	}
+	if len(rec) == 0 {
+		return nil, fmt.Errorf("template %q renders empty records", text)
+	}
+	return tmpl, nil
+}
+
+// Render returns the record for fl.
+func (t *Template) Render(fl Fields) ([]byte, error) {
+	var b bytes.Buffer
+	if err := t.t.Execute(&b, fl); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,93 @@
+// Package fastnum parses and formats the decimal integers of records without
+// allocating: the ids the generator writes, and the ids, offsets and sequence numbers
+// the sorter and the repair scan read back. Eight digits at a time are validated and
+// converted in one 64-bit word (SWAR), loaded little-endian so the same code is fast
+// on amd64 and arm64 alike.
+package fastnum
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+const (
+	zeros  = 0x3030303030303030 // "00000000"
+	high   = 0xF0F0F0F0F0F0F0F0
+	digits = 0x3333333333333333
+)
+
+// AppendInt appends the decimal form of v to dst.
+func AppendInt(dst []byte, v int64) []byte {
+	return strconv.AppendInt(dst, v, 10)
+}
+
+// LeadingInt parses the optionally negative decimal integer at the start of b, up to
+// its first non-digit (e.g. the id field of a CSV record), and returns 0 when there is
+// none. It does not detect overflow: more than 19 digits wrap around.
+func LeadingInt(b []byte) int64 {
+	neg := len(b) > 0 && b[0] == '-'
+	if neg {
+		b = b[1:]
+	}
+	n, _ := leadingDigits(b)
+	if neg {
+		return -int64(n)
+	}
+	return int64(n)
+}
+
+// ParseInt is strconv.ParseInt(string(b), 10, 64) without the conversion's allocation.
+func ParseInt(b []byte) (int64, error) {
+	s := b
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		s = s[1:]
+	}
+	// Up to 18 digits cannot overflow; leave longer input, and errors, to strconv
+	if len(s) == 0 || len(s) > 18 {
+		return strconv.ParseInt(string(b), 10, 64)
+	}
+	n, used := leadingDigits(s)
+	if used != len(s) {
+		return strconv.ParseInt(string(b), 10, 64)
+	}
+	if b[0] == '-' {
+		return -int64(n), nil
+	}
+	return int64(n), nil
+}
+
+// leadingDigits returns the value of the digits at the start of b and their count.
+func leadingDigits(b []byte) (uint64, int) {
+	var n uint64
+	i := 0
+	for ; len(b)-i >= 8; i += 8 {
+		w := binary.LittleEndian.Uint64(b[i:])
+		if !allDigits(w) {
+			break
+		}
+		n = n*100_000_000 + parse8(w)
+	}
+	for ; i < len(b); i++ {
+		c := b[i] - '0'
+		if c > 9 {
+			break
+		}
+		n = n*10 + uint64(c)
+	}
+	return n, i
+}
+
+// allDigits reports whether every byte of w is an ASCII digit: each must have a high
+// nibble of 3, and still have it after adding 6 (so the low nibble is at most 9).
+func allDigits(w uint64) bool {
+	return (w&high)|((w+0x0606060606060606)&high)>>4 == digits
+}
+
+// parse8 converts the eight ASCII digits in w, the first in the lowest byte, by
+// combining adjacent pairs of digits, then of 2-digit and of 4-digit numbers.
+func parse8(w uint64) uint64 {
+	w -= zeros
+	w = (w*10 + w>>8) & 0x00FF00FF00FF00FF
+	w = (w*100 + w>>16) & 0x0000FFFF0000FFFF
+	return (w*10000 + w>>32) & 0x00000000FFFFFFFF
+}
@@ -0,0 +1,110 @@
+package fastnum_test
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	datagen "core-infra-project/internal/data"
+	"core-infra-project/internal/fastnum"
+)
+
+func TestParseInt(t *testing.T) {
+	cases := []string{
+		"0", "-0", "+0", "7", "-7", "+42", "12345678", "123456789", "-1234567890123",
+		"999999999999999999", "-999999999999999999", // 18 digits, the fast path's limit
+		"9223372036854775807", "-9223372036854775808", "9223372036854775808", "00000000000000000001",
+		"", "-", "+", "12a", "a12", "1 2", "--1", "1234567x", "12345678x", "1.5",
+	}
+	for _, s := range cases {
+		want, wantErr := strconv.ParseInt(s, 10, 64)
+		got, err := fastnum.ParseInt([]byte(s))
+		if got != want || (err == nil) != (wantErr == nil) {
+			t.Errorf("ParseInt(%q) = %d, %v; strconv gives %d, %v", s, got, err, want, wantErr)
+		}
+	}
+}
+
+func TestLeadingInt(t *testing.T) {
+	cases := map[string]int64{
+		"": 0, "x": 0, "-": 0, "42": 42, "-42,name": -42, "12345678,a": 12345678,
+		"1234567890123,b,c": 1234567890123, "007x": 7,
+	}
+	for s, want := range cases {
+		if got := fastnum.LeadingInt([]byte(s)); got != want {
+			t.Errorf("LeadingInt(%q) = %d, want %d", s, got, want)
+		}
+	}
+	// The ids of generated records, as the sorter reads them
+	for i := int64(0); i < 10_000; i++ {
+		rec := datagen.CSV.Seeded(1, i)
+		want, err := strconv.ParseInt(string(rec[:bytes.IndexByte(rec, ',')]), 10, 64)
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if got := fastnum.LeadingInt(rec); got != want {
+			t.Fatalf("record %d: LeadingInt = %d, strconv = %d", i, got, want)
+		}
+	}
+}
+
+func TestAppendInt(t *testing.T) {
+	for _, v := range []int64{0, -1, 42, 1 << 40, -1 << 63, 1<<63 - 1} {
+		if got, want := string(fastnum.AppendInt([]byte("x"), v)), "x"+strconv.FormatInt(v, 10); got != want {
+			t.Errorf("AppendInt(%d) = %q, want %q", v, got, want)
+		}
+	}
+}
+
+// records returns generated CSV records, whose ids the benchmarks parse.
+func records(b *testing.B) [][]byte {
+	recs := make([][]byte, 100_000)
+	for i := range recs {
+		recs[i] = datagen.CSV.Seeded(1, int64(i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	return recs
+}
+
+var sink int64
+
+func BenchmarkParseIntStrconv(b *testing.B) {
+	recs := records(b)
+	for n := 0; n < b.N; n++ {
+		rec := recs[n%len(recs)]
+		v, _ := strconv.ParseInt(string(rec[:bytes.IndexByte(rec, ',')]), 10, 64)
+		sink += v
+	}
+}
+
+func BenchmarkParseInt(b *testing.B) {
+	recs := records(b)
+	for n := 0; n < b.N; n++ {
+		rec := recs[n%len(recs)]
+		v, _ := fastnum.ParseInt(rec[:bytes.IndexByte(rec, ',')])
+		sink += v
+	}
+}
+
+func BenchmarkLeadingInt(b *testing.B) {
+	recs := records(b)
+	for n := 0; n < b.N; n++ {
+		sink += fastnum.LeadingInt(recs[n%len(recs)])
+	}
+}
+
+func BenchmarkItoa(b *testing.B) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		sink += int64(len(strconv.Itoa(n)))
+	}
+}
+
+func BenchmarkAppendInt(b *testing.B) {
+	b.ReportAllocs()
+	var buf [20]byte
+	for n := 0; n < b.N; n++ {
+		sink += int64(len(fastnum.AppendInt(buf[:0], int64(n))))
+	}
+}
@@ -0,0 +1,206 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"sync/atomic"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// ChecksumTopic returns the topic holding the batch checksums of the records written
+// to base.
+func ChecksumTopic(base string) string { return base + "-checksums" }
+
+// BatchChecksum is the control message describing one batch the broker acknowledged:
+// Count records at offsets First.. of a partition, and the CRC-32C of their keys and
+// values in offset order (see BatchCRC). A consumer that computes the same over the
+// records it reads detects corruption anywhere between the producing client and itself.
+type BatchChecksum struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	First     int64  `json:"first_offset"`
+	Count     int    `json:"count"`
+	CRC       uint32 `json:"crc32c"`
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// BatchCRC folds a record into a batch's running checksum. Lengths are folded in
+// too, so bytes moving between a key and a value, or between records, change it.
+func BatchCRC(crc uint32, key, value []byte) uint32 {
+	var n [16]byte
+	binary.BigEndian.PutUint64(n[:8], uint64(len(key)))
+	binary.BigEndian.PutUint64(n[8:], uint64(len(value)))
+	crc = crc32.Update(crc, castagnoli, n[:])
+	crc = crc32.Update(crc, castagnoli, key)
+	return crc32.Update(crc, castagnoli, value)
+}
+
+// ChecksumPublisher writes a BatchChecksum to ChecksumTopic of its topic for every
+// batch an async writer delivers, as the writer's Completion reports it. Batches
+// delivered by retries through other writers carry no offsets and are left out.
+type ChecksumPublisher struct {
+	w *gokafka.Writer
+
+	batches   atomic.Int64
+	records   atomic.Int64
+	unchecked atomic.Int64 // delivered without offsets
+	failed    atomic.Int64 // checksums the broker did not take
+}
+
+// NewChecksumPublisher creates the checksum topics of topics, each like its topic, and
+// returns a publisher writing to them.
+func NewChecksumPublisher(ctx context.Context, brokers, topics []string) (*ChecksumPublisher, error) {
+	for _, t := range topics {
+		if err := CreateTopicLike(ctx, brokers, t, ChecksumTopic(t)); err != nil {
+			return nil, err
+		}
+	}
+	p := &ChecksumPublisher{}
+	p.w = NewWriter(brokers, "")
+	p.w.Balancer = &gokafka.Hash{} // a partition's checksums stay in order
+	p.w.Completion = func(msgs []gokafka.Message, err error) {
+		if err != nil {
+			p.failed.Add(int64(len(msgs)))
+		}
+	}
+	return p, nil
+}
+
+// Completion returns a writer Completion callback that publishes the checksums of
+// delivered batches, then calls next (if any).
+func (p *ChecksumPublisher) Completion(next func([]gokafka.Message, error)) func([]gokafka.Message, error) {
+	return func(msgs []gokafka.Message, err error) {
+		if err == nil && len(msgs) > 0 {
+			p.publish(msgs)
+		}
+		if next != nil {
+			next(msgs, err)
+		}
+	}
+}
+
+func (p *ChecksumPublisher) publish(msgs []gokafka.Message) {
+	if msgs[0].Topic == "" {
+		p.unchecked.Add(int64(len(msgs)))
+		return
+	}
+	sum := BatchChecksum{Topic: msgs[0].Topic, Partition: msgs[0].Partition, First: msgs[0].Offset, Count: len(msgs)}
+	for _, m := range msgs {
+		sum.CRC = BatchCRC(sum.CRC, m.Key, m.Value)
+	}
+	b, err := json.Marshal(sum)
+	if err != nil {
+		panic(err) // plain fields
+	}
+	key := sum.Topic + "/" + strconv.Itoa(sum.Partition)
+	if err := p.w.WriteMessages(context.Background(), gokafka.Message{Topic: ChecksumTopic(sum.Topic), Key: []byte(key), Value: b}); err != nil {
+		p.failed.Add(1)
+		return
+	}
+	p.batches.Add(1)
+	p.records.Add(int64(len(msgs)))
+}
+
+// Close flushes the checksums written so far.
+func (p *ChecksumPublisher) Close() error { return p.w.Close() }
+
+// ChecksumPublisherStats counts what a ChecksumPublisher did.
+type ChecksumPublisherStats struct {
+	Batches, Records int64 // checksummed
+	Unchecked        int64 // records delivered without offsets (retries)
+	Failed           int64 // checksums that could not be written
+}
+
+// Stats returns the counts so far.
+func (p *ChecksumPublisher) Stats() ChecksumPublisherStats {
+	return ChecksumPublisherStats{Batches: p.batches.Load(), Records: p.records.Load(), Unchecked: p.unchecked.Load(), Failed: p.failed.Load()}
+}
+
+// ChecksumVerifier checks records read from a topic against its batch checksums. Feed
+// it every record of a partition in offset order; a batch is verified once all its
+// records have been seen in a row, and records outside a batch read from its start
+// (markers, retried writes, a read starting mid-batch) go unchecked.
+type ChecksumVerifier struct {
+	batches map[batchStart]BatchChecksum
+	open    map[int]*openBatch // by partition
+
+	Verified, VerifiedRecords int64
+	Unchecked                 int64 // records not covered by a complete batch
+}
+
+type batchStart struct {
+	partition int
+	first     int64
+}
+
+type openBatch struct {
+	sum  BatchChecksum
+	crc  uint32
+	next int64
+}
+
+// LoadChecksums reads the batch checksums of topic.
+func LoadChecksums(ctx context.Context, brokers []string, topic string) (*ChecksumVerifier, error) {
+	v := &ChecksumVerifier{batches: map[batchStart]BatchChecksum{}, open: map[int]*openBatch{}}
+	err := scanTopic(ctx, brokers, ChecksumTopic(topic), func(msg gokafka.Message) error {
+		var sum BatchChecksum
+		if err := json.Unmarshal(msg.Value, &sum); err != nil {
+			return fmt.Errorf("batch checksum at offset %d: %w", msg.Offset, err)
+		}
+		if sum.Topic == topic {
+			v.batches[batchStart{sum.Partition, sum.First}] = sum
+		}
+		return nil
+	})
+	return v, err
+}
+
+// Batches returns how many batch checksums were loaded.
+func (v *ChecksumVerifier) Batches() int { return len(v.batches) }
+
+// Observe checks a record read from the topic, returning an error when it completes
+// a batch whose checksum does not match.
+func (v *ChecksumVerifier) Observe(msg gokafka.Message) error {
+	b := v.open[msg.Partition]
+	if b == nil || msg.Offset != b.next {
+		if b != nil {
+			v.Unchecked += b.next - b.sum.First // a gap: the batch can't be verified
+		}
+		sum, ok := v.batches[batchStart{msg.Partition, msg.Offset}]
+		if !ok {
+			delete(v.open, msg.Partition)
+			v.Unchecked++
+			return nil
+		}
+		b = &openBatch{sum: sum, next: sum.First}
+		v.open[msg.Partition] = b
+	}
+	b.crc = BatchCRC(b.crc, msg.Key, msg.Value)
+	b.next++
+	if b.next < b.sum.First+int64(b.sum.Count) {
+		return nil
+	}
+	delete(v.open, msg.Partition)
+	if b.crc != b.sum.CRC {
+		return fmt.Errorf("batch checksum mismatch in partition %d, offsets %d-%d: records read have CRC-32C %08x, the producer's acknowledged batch had %08x (corrupted between producer and consumer)",
+			msg.Partition, b.sum.First, b.next-1, b.crc, b.sum.CRC)
+	}
+	v.Verified++
+	v.VerifiedRecords += int64(b.sum.Count)
+	return nil
+}
+
+// Pending returns the records of batches begun but not finished, which are unchecked.
+func (v *ChecksumVerifier) Pending() int64 {
+	var n int64
+	for _, b := range v.open {
+		n += b.next - b.sum.First
+	}
+	return n
+}
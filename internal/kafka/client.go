@@ -7,6 +7,8 @@ import (
 	gokafka "github.com/segmentio/kafka-go"
 )
 
+// NewWriter returns an async writer with the pipeline's batching defaults and Snappy
+// compression, which the binaries override from KAFKA_COMPRESSION.
 func NewWriter(brokers []string, topic string) *gokafka.Writer {
 	return &gokafka.Writer{
 		Addr:         gokafka.TCP(brokers...),
@@ -45,3 +47,20 @@ func CloseWriter(ctx context.Context, w *gokafka.Writer) error {
 	_ = w.Close()
 	return nil
 }
+
+// WriterHooks gives a writer the sort pipeline's recovery hooks (sort.RecoveryPolicy).
+type WriterHooks struct{ Writer *gokafka.Writer }
+
+// Reconnect implements sort.Reconnector: it drops the idle connections of the writer's
+// transport, so the retried write dials the brokers again rather than reusing
+// connections a broker restart left dead.
+func (h WriterHooks) Reconnect(ctx context.Context) error {
+	transport := h.Writer.Transport
+	if transport == nil {
+		transport = gokafka.DefaultTransport
+	}
+	if t, ok := transport.(*gokafka.Transport); ok {
+		t.CloseIdleConnections()
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package kafka
+
+import (
+	"context"
+	"sync/atomic"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// MessageWriter is the subset of *gokafka.Writer used by the pipeline.
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...gokafka.Message) error
+}
+
+// CompressionSampler forwards writes to next while estimating the achieved
+// compression ratio of codec. kafka-go's WriterStats only report uncompressed
+// bytes, so every Nth batch is compressed client-side with the same codec.
+type CompressionSampler struct {
+	next      MessageWriter
+	comp      gokafka.Compression
+	codec     gokafka.CompressionCodec
+	every     int64
+	batches   int64
+	rawBytes  int64
+	sampleRaw int64
+	sampleOut int64
+}
+
+// NewCompressionSampler wraps next, sampling one in every batches with c.
+// With compression none, only raw bytes are counted.
+func NewCompressionSampler(next MessageWriter, c gokafka.Compression, every int) *CompressionSampler {
+	if every < 1 {
+		every = 1
+	}
+	return &CompressionSampler{next: next, comp: c, codec: c.Codec(), every: int64(every)}
+}
+
+// Compression returns the codec being sampled.
+func (s *CompressionSampler) Compression() gokafka.Compression { return s.comp }
+
+// WriteMessages implements MessageWriter.
+func (s *CompressionSampler) WriteMessages(ctx context.Context, msgs ...gokafka.Message) error {
+	var raw int64
+	for _, m := range msgs {
+		raw += int64(len(m.Key) + len(m.Value))
+	}
+	atomic.AddInt64(&s.rawBytes, raw)
+	// Sample the first batch and every Nth one after it
+	if s.codec != nil && (atomic.AddInt64(&s.batches, 1)-1)%s.every == 0 {
+		out := s.compressedSize(msgs)
+		atomic.AddInt64(&s.sampleRaw, raw)
+		atomic.AddInt64(&s.sampleOut, out)
+	}
+	return s.next.WriteMessages(ctx, msgs...)
+}
+
+func (s *CompressionSampler) compressedSize(msgs []gokafka.Message) int64 {
+	var cw countingWriter
+	w := s.codec.NewWriter(&cw)
+	for _, m := range msgs {
+		w.Write(m.Key)
+		w.Write(m.Value)
+	}
+	w.Close()
+	return cw.n
+}
+
+// RawBytes returns the uncompressed key+value bytes written so far.
+func (s *CompressionSampler) RawBytes() int64 { return atomic.LoadInt64(&s.rawBytes) }
+
+// Ratio returns the sampled raw/compressed ratio, or 0 if nothing was sampled.
+func (s *CompressionSampler) Ratio() float64 {
+	out := atomic.LoadInt64(&s.sampleOut)
+	if out == 0 {
+		return 0
+	}
+	return float64(atomic.LoadInt64(&s.sampleRaw)) / float64(out)
+}
+
+// CompressedBytes extrapolates the compressed size of everything written from the sampled ratio.
+func (s *CompressionSampler) CompressedBytes() int64 {
+	r := s.Ratio()
+	if r == 0 {
+		return 0
+	}
+	return int64(float64(s.RawBytes()) / r)
+}
+
+type countingWriter struct{ n int64 }
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,91 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// DatasetTopic returns the topic holding the dataset descriptors of base, keyed by
+// base, so the latest one describes what base was last filled with.
+func DatasetTopic(base string) string { return base + "-dataset" }
+
+// PublishDataset appends descriptor to the dataset topic of base, creating the topic
+// if needed.
+func PublishDataset(ctx context.Context, brokers []string, base string, descriptor []byte) error {
+	topic := DatasetTopic(base)
+	if err := CreateTopicLike(ctx, brokers, "", topic); err != nil {
+		return err
+	}
+	w := &gokafka.Writer{
+		Addr:         gokafka.TCP(brokers...),
+		Topic:        topic,
+		RequiredAcks: gokafka.RequireAll,
+		Balancer:     &gokafka.Hash{},
+	}
+	defer w.Close()
+	return w.WriteMessages(ctx, gokafka.Message{Key: []byte(base), Value: descriptor})
+}
+
+// LatestDataset returns the latest descriptor published for base.
+func LatestDataset(ctx context.Context, brokers []string, base string) ([]byte, error) {
+	topic := DatasetTopic(base)
+	var latest []byte
+	err := scanTopic(ctx, brokers, topic, func(msg gokafka.Message) error {
+		// Keys hash to one partition, so its last match is the latest
+		if string(msg.Key) == base {
+			latest = msg.Value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no dataset descriptor for %s in %s", base, topic)
+	}
+	return latest, nil
+}
+
+// scanTopic calls fn with every message currently in topic, partition by partition,
+// and stops at the first error.
+func scanTopic(ctx context.Context, brokers []string, topic string, fn func(gokafka.Message) error) error {
+	offsets, err := partitionOffsets(ctx, brokers, topic)
+	if err != nil {
+		return err
+	}
+	for _, po := range offsets {
+		if po.LastOffset <= po.FirstOffset {
+			continue
+		}
+		r := gokafka.NewReader(gokafka.ReaderConfig{
+			Brokers:   brokers,
+			Topic:     topic,
+			Partition: po.Partition,
+			MinBytes:  1,
+			MaxBytes:  1 << 20,
+		})
+		if err := r.SetOffset(po.FirstOffset); err != nil {
+			r.Close()
+			return err
+		}
+		for {
+			msg, err := r.ReadMessage(ctx)
+			if err == nil {
+				err = fn(msg)
+			} else {
+				err = fmt.Errorf("%s partition %d: %w", topic, po.Partition, err)
+			}
+			if err != nil {
+				r.Close()
+				return err
+			}
+			if msg.Offset >= po.LastOffset-1 {
+				break
+			}
+		}
+		r.Close()
+	}
+	return nil
+}
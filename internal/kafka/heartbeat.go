@@ -0,0 +1,138 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	extSort "core-infra-project/internal/sort"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// Heartbeat is a status record a long-running job writes to a status topic at a fixed
+// interval, keyed by Job, so a monitor can alert when a job's heartbeats stop arriving
+// without scraping its metrics. The last heartbeat of a job that ends has Final set.
+type Heartbeat struct {
+	Job        string    `json:"job"` // e.g. "sorter:id"; the message key
+	RunID      string    `json:"run_id"`
+	Host       string    `json:"host"`
+	PID        int       `json:"pid"`
+	Seq        int64     `json:"seq"`
+	Phase      string    `json:"phase"`
+	Records    int64     `json:"records"`         // done in the current phase
+	Total      int64     `json:"total,omitempty"` // expected in the current phase, if known
+	IntervalMs int64     `json:"interval_ms"`     // until the next heartbeat
+	StartedAt  time.Time `json:"started_at"`
+	Time       time.Time `json:"time"`
+	Final      bool      `json:"final,omitempty"`
+}
+
+// Heartbeats writes a Heartbeat every interval until Stop. Write failures are logged
+// and otherwise ignored: losing heartbeats must not fail the job they report on.
+type Heartbeats struct {
+	w        heartbeatWriter
+	topic    string
+	base     Heartbeat
+	interval time.Duration
+	status   func(*Heartbeat) // fills Phase, Records and Total
+	clock    extSort.Clock
+	stop     chan struct{}
+	done     chan struct{}
+	mu       sync.Mutex // serializes writes, so Seq increases across the topic
+}
+
+type heartbeatWriter interface {
+	MessageWriter
+	Close() error
+}
+
+// StartHeartbeats creates topic if needed and starts writing heartbeats for job to
+// it, the first one immediately; status is called before each write to fill in the
+// job's progress.
+func StartHeartbeats(brokers []string, topic string, interval time.Duration, job, runID string, status func(*Heartbeat)) *Heartbeats {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := CreateTopicLike(ctx, brokers, "", topic); err != nil {
+		fmt.Fprintf(os.Stderr, "[WARN] Status topic %s: %v\n", topic, err)
+	}
+	cancel()
+	w := &gokafka.Writer{
+		Addr:         gokafka.TCP(brokers...),
+		Topic:        topic,
+		RequiredAcks: gokafka.RequireOne,
+		Balancer:     &gokafka.Hash{},
+	}
+	return startHeartbeats(w, topic, interval, job, runID, status, extSort.SystemClock)
+}
+
+// startHeartbeats is StartHeartbeats writing to w, timed by clock.
+func startHeartbeats(w heartbeatWriter, topic string, interval time.Duration, job, runID string, status func(*Heartbeat), clock extSort.Clock) *Heartbeats {
+	host, _ := os.Hostname()
+	h := &Heartbeats{
+		w:     w,
+		topic: topic,
+		base: Heartbeat{
+			Job: job, RunID: runID, Host: host, PID: os.Getpid(),
+			IntervalMs: interval.Milliseconds(), StartedAt: clock.Now().UTC(),
+		},
+		interval: interval,
+		status:   status,
+		clock:    clock,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go h.run()
+	return h
+}
+
+func (h *Heartbeats) run() {
+	defer close(h.done)
+	for {
+		h.beat(false)
+		tick := make(chan struct{})
+		t := h.clock.AfterFunc(h.interval, func() { close(tick) })
+		select {
+		case <-h.stop:
+			t.Stop()
+			return
+		case <-tick:
+		}
+	}
+}
+
+// beat writes the next heartbeat; a timeout of one interval keeps a stuck broker from
+// delaying the following ones.
+func (h *Heartbeats) beat(final bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hb := h.base
+	h.status(&hb)
+	hb.Time, hb.Final = h.clock.Now().UTC(), final
+	h.base.Seq++
+	b, err := json.Marshal(hb)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), max(h.interval, 5*time.Second))
+	defer cancel()
+	if err := h.w.WriteMessages(ctx, gokafka.Message{Key: []byte(hb.Job), Value: b}); err != nil {
+		fmt.Fprintf(os.Stderr, "[WARN] Heartbeat %d to %s: %v\n", hb.Seq, h.topic, err)
+	}
+}
+
+// Stop stops the heartbeats and writes the final one, with phase as its Phase (e.g.
+// "done" or "failed").
+func (h *Heartbeats) Stop(phase string) {
+	close(h.stop)
+	<-h.done
+	status := h.status
+	h.status = func(hb *Heartbeat) {
+		status(hb)
+		hb.Phase = phase
+	}
+	h.beat(true)
+	h.w.Close()
+}
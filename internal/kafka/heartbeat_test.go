@@ -0,0 +1,90 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"core-infra-project/internal/testutil"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// recordingWriter keeps the heartbeats written to it.
+type recordingWriter struct {
+	mu   sync.Mutex
+	sent []Heartbeat
+}
+
+func (w *recordingWriter) WriteMessages(_ context.Context, msgs ...gokafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, m := range msgs {
+		var hb Heartbeat
+		if err := json.Unmarshal(m.Value, &hb); err != nil {
+			return err
+		}
+		w.sent = append(w.sent, hb)
+	}
+	return nil
+}
+
+func (w *recordingWriter) Close() error { return nil }
+
+func (w *recordingWriter) waitFor(t *testing.T, n int) []Heartbeat {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		w.mu.Lock()
+		sent := append([]Heartbeat(nil), w.sent...)
+		w.mu.Unlock()
+		if len(sent) >= n {
+			return sent
+		}
+	}
+	t.Fatalf("fewer than %d heartbeats written", n)
+	return nil
+}
+
+func TestHeartbeatsEveryInterval(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := testutil.NewFakeClock(start)
+	w := &recordingWriter{}
+	records := int64(0)
+	h := startHeartbeats(w, "status", 10*time.Second, "sorter:id", "run-1", func(hb *Heartbeat) {
+		hb.Phase, hb.Records = "chunk", records
+	}, clock)
+
+	w.waitFor(t, 1)
+	for i := 1; i <= 3; i++ {
+		clock.WaitPending(1)
+		records += 100
+		clock.Advance(10 * time.Second)
+		w.waitFor(t, i+1)
+	}
+	clock.WaitPending(1)
+	h.Stop("done")
+
+	sent := w.waitFor(t, 5)
+	if len(sent) != 5 {
+		t.Fatalf("got %d heartbeats, want 5", len(sent))
+	}
+	for i, hb := range sent[:4] {
+		if hb.Seq != int64(i) || hb.Final {
+			t.Errorf("heartbeat %d: seq %d, final %v", i, hb.Seq, hb.Final)
+		}
+		if want := start.Add(time.Duration(i) * 10 * time.Second); !hb.Time.Equal(want) {
+			t.Errorf("heartbeat %d at %v, want %v", i, hb.Time, want)
+		}
+		if hb.Records != int64(i)*100 || hb.Job != "sorter:id" || hb.IntervalMs != 10_000 {
+			t.Errorf("heartbeat %d: %+v", i, hb)
+		}
+	}
+	if last := sent[4]; !last.Final || last.Phase != "done" || last.Seq != 4 {
+		t.Errorf("final heartbeat: %+v", last)
+	}
+	if n := clock.Pending(); n != 0 {
+		t.Errorf("%d timers left after Stop", n)
+	}
+}
@@ -0,0 +1,106 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// IndexEntry maps a sort key to the partition and offset it was written at in the
+// destination topic. Every partition of a sorted topic is itself in key order, so a
+// consumer can seek each partition to its last entry at or below a key.
+type IndexEntry struct {
+	Key       string `json:"key"`
+	Partition int    `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+// KeyIndex collects index entries from writer completions. Messages are indexed
+// when their WriterData holds the key as a string (set by the merge every Nth record).
+type KeyIndex struct {
+	mu      sync.Mutex
+	entries []IndexEntry
+}
+
+// Completion is installed as the destination writer's Completion callback, which is
+// the only place the broker-assigned partition and offset are visible.
+func (x *KeyIndex) Completion(msgs []gokafka.Message, err error) {
+	if err != nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, m := range msgs {
+		if key, ok := m.WriterData.(string); ok {
+			x.entries = append(x.entries, IndexEntry{Key: key, Partition: m.Partition, Offset: m.Offset})
+		}
+	}
+}
+
+// Entries returns the collected entries ordered by partition and offset.
+func (x *KeyIndex) Entries() []IndexEntry {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	out := append([]IndexEntry(nil), x.entries...)
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Partition != out[j].Partition {
+			return out[i].Partition < out[j].Partition
+		}
+		return out[i].Offset < out[j].Offset
+	})
+	return out
+}
+
+// Publish writes the entries to topic, keyed by sort key with a JSON value.
+// It must run after the destination writer is closed so every completion has been seen.
+func (x *KeyIndex) Publish(ctx context.Context, brokers []string, topic string) (int, error) {
+	entries := x.Entries()
+	msgs := make([]gokafka.Message, 0, len(entries))
+	for _, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return 0, err
+		}
+		msgs = append(msgs, gokafka.Message{Key: []byte(e.Key), Value: b})
+	}
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	// Synchronous and single-partition so the index reads back in partition/offset order
+	w := &gokafka.Writer{
+		Addr:         gokafka.TCP(brokers...),
+		Topic:        topic,
+		RequiredAcks: gokafka.RequireAll,
+		Balancer:     gokafka.BalancerFunc(func(gokafka.Message, ...int) int { return 0 }),
+	}
+	defer w.Close()
+	if err := w.WriteMessages(ctx, msgs...); err != nil {
+		return 0, err
+	}
+	return len(msgs), nil
+}
+
+// LoadKeyIndex reads the entries Publish wrote to topic, ordered by partition and offset.
+func LoadKeyIndex(ctx context.Context, brokers []string, topic string) ([]IndexEntry, error) {
+	var entries []IndexEntry
+	err := scanTopic(ctx, brokers, topic, func(msg gokafka.Message) error {
+		var e IndexEntry
+		if err := json.Unmarshal(msg.Value, &e); err != nil {
+			return fmt.Errorf("index entry at offset %d: %w", msg.Offset, err)
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Partition != entries[j].Partition {
+			return entries[i].Partition < entries[j].Partition
+		}
+		return entries[i].Offset < entries[j].Offset
+	})
+	return entries, err
+}
@@ -0,0 +1,114 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// InflightLimiter caps the bytes an async writer holds between WriteMessages and
+// the broker's acknowledgement. In async mode WriteMessages returns immediately, so
+// a slow broker would otherwise let batches pile up in memory during the merge.
+// Writes block once the cap is reached and resume as completions release bytes.
+type InflightLimiter struct {
+	next  MessageWriter
+	max   int64
+	async func() bool // whether completions, rather than returns, end writes
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	inflight int64
+	peak     int64
+	waits    int64
+	waited   time.Duration
+}
+
+// NewInflightLimiter wraps w, limiting it to max in-flight key+value bytes. It installs
+// itself as w's Completion callback, chaining any callback already set, so it must be
+// created before w is first used.
+func NewInflightLimiter(w *gokafka.Writer, max int64) *InflightLimiter {
+	l := newInflightLimiter(w, max, func() bool { return w.Async })
+	prev := w.Completion
+	w.Completion = func(msgs []gokafka.Message, err error) {
+		l.completed(msgs)
+		if prev != nil {
+			prev(msgs, err)
+		}
+	}
+	return l
+}
+
+func newInflightLimiter(next MessageWriter, max int64, async func() bool) *InflightLimiter {
+	l := &InflightLimiter{next: next, max: max, async: async}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// WriteMessages implements MessageWriter. A single write larger than the cap is let
+// through once nothing else is in flight, so oversized batches cannot deadlock.
+func (l *InflightLimiter) WriteMessages(ctx context.Context, msgs ...gokafka.Message) error {
+	n := messageBytes(msgs)
+	l.mu.Lock()
+	if l.inflight > 0 && l.inflight+n > l.max {
+		start := time.Now()
+		l.waits++
+		for l.inflight > 0 && l.inflight+n > l.max {
+			l.cond.Wait()
+		}
+		l.waited += time.Since(start)
+	}
+	l.inflight += n
+	if l.inflight > l.peak {
+		l.peak = l.inflight
+	}
+	l.mu.Unlock()
+
+	err := l.next.WriteMessages(ctx, msgs...)
+	if err != nil || !l.async() {
+		// A synchronous write is over when it returns, whether or not its batches
+		// reached Completion; an async writer only fails writes it never queued,
+		// which never reach Completion
+		l.release(msgs)
+	}
+	return err
+}
+
+// completed is the writer's Completion callback: it ends async writes only, since
+// synchronous ones are released as WriteMessages returns.
+func (l *InflightLimiter) completed(msgs []gokafka.Message) {
+	if l.async() {
+		l.release(msgs)
+	}
+}
+
+func (l *InflightLimiter) release(msgs []gokafka.Message) {
+	n := messageBytes(msgs)
+	l.mu.Lock()
+	l.inflight -= n
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// InflightStats summarizes how much the limiter throttled the writer.
+type InflightStats struct {
+	PeakBytes int64
+	Waits     int64
+	Waited    time.Duration
+}
+
+// Stats returns the peak in-flight bytes and the time writes spent blocked.
+func (l *InflightLimiter) Stats() InflightStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return InflightStats{PeakBytes: l.peak, Waits: l.waits, Waited: l.waited}
+}
+
+func messageBytes(msgs []gokafka.Message) int64 {
+	var n int64
+	for _, m := range msgs {
+		n += int64(len(m.Key) + len(m.Value))
+	}
+	return n
+}
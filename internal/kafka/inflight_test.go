@@ -0,0 +1,43 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// failingWriter fails every write the way a synchronous kafka-go Writer does once
+// its batches were sent: Completion reports the error, then WriteMessages returns it.
+type failingWriter struct {
+	completion func(msgs []gokafka.Message, err error)
+}
+
+func (w *failingWriter) WriteMessages(_ context.Context, msgs ...gokafka.Message) error {
+	err := errors.New("broker unavailable")
+	w.completion(msgs, err)
+	return err
+}
+
+func TestInflightLimiterReleasesFailedSyncWritesOnce(t *testing.T) {
+	w := &failingWriter{}
+	l := newInflightLimiter(w, 10, func() bool { return false })
+	w.completion = func(msgs []gokafka.Message, _ error) { l.completed(msgs) }
+
+	msg := gokafka.Message{Key: []byte("k"), Value: []byte("value")}
+	for i := 0; i < 3; i++ {
+		if err := l.WriteMessages(context.Background(), msg); err == nil {
+			t.Fatal("write succeeded")
+		}
+		l.mu.Lock()
+		inflight := l.inflight
+		l.mu.Unlock()
+		if inflight != 0 {
+			t.Fatalf("after failed write %d: %d bytes in flight, want 0", i+1, inflight)
+		}
+	}
+	if peak := l.Stats().PeakBytes; peak != 6 {
+		t.Fatalf("peak %d bytes, want 6", peak)
+	}
+}
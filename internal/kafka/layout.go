@@ -0,0 +1,145 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// PartitionCount returns the number of partitions of topic, or 0 if it does not exist.
+func PartitionCount(ctx context.Context, brokers []string, topic string) (int, error) {
+	client := &gokafka.Client{Addr: gokafka.TCP(brokers...)}
+	res, err := client.Metadata(ctx, &gokafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return 0, err
+	}
+	if len(res.Topics) == 1 && errors.Is(res.Topics[0].Error, gokafka.UnknownTopicOrPartition) {
+		return 0, nil
+	}
+	if err := topicReady(res, topic); err != nil {
+		return 0, err
+	}
+	return len(res.Topics[0].Partitions), nil
+}
+
+// PartitionBounds returns the first and end offsets of every partition of topic,
+// ordered by partition. Sorted output is laid out in partition order (see
+// RangeBalancer), so this is also the order of its key ranges.
+func PartitionBounds(ctx context.Context, brokers []string, topic string) ([]gokafka.PartitionOffsets, error) {
+	offsets, err := partitionOffsets(ctx, brokers, topic)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i].Partition < offsets[j].Partition })
+	return offsets, nil
+}
+
+// SetPartitionCount gives topic exactly n partitions: it creates a missing topic, adds
+// partitions to grow one, and, because Kafka cannot remove partitions, deletes and
+// recreates it to shrink it. Shrinking is refused unless the topic holds no records.
+func SetPartitionCount(ctx context.Context, brokers []string, topic string, n int) error {
+	current, err := PartitionCount(ctx, brokers, topic)
+	if err != nil {
+		return err
+	}
+	client := &gokafka.Client{Addr: gokafka.TCP(brokers...)}
+	switch {
+	case current == n:
+		return nil
+	case current == 0:
+		return createTopic(ctx, client, topic, n)
+	case current < n:
+		res, err := client.CreatePartitions(ctx, &gokafka.CreatePartitionsRequest{
+			Topics: []gokafka.TopicPartitionsConfig{{Name: topic, Count: int32(n)}},
+		})
+		if err != nil {
+			return fmt.Errorf("add partitions to %q: %w", topic, err)
+		}
+		if err := res.Errors[topic]; err != nil {
+			return fmt.Errorf("add partitions to %q: %w", topic, err)
+		}
+		return nil
+	}
+
+	offsets, err := partitionOffsets(ctx, brokers, topic)
+	if err != nil {
+		return err
+	}
+	for _, po := range offsets {
+		if po.LastOffset > po.FirstOffset {
+			return fmt.Errorf("topic %q has %d partitions and holds records; Kafka cannot remove partitions, so delete it (or write to a new topic) to get %d", topic, current, n)
+		}
+	}
+	res, err := client.DeleteTopics(ctx, &gokafka.DeleteTopicsRequest{Topics: []string{topic}})
+	if err != nil {
+		return fmt.Errorf("delete topic %q: %w", topic, err)
+	}
+	if err := res.Errors[topic]; err != nil {
+		return fmt.Errorf("delete topic %q: %w", topic, err)
+	}
+	// Deletion completes asynchronously; the name is taken until it does
+	for {
+		err := createTopic(ctx, client, topic, n)
+		if !errors.Is(err, gokafka.TopicAlreadyExists) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("recreate topic %q: %w", topic, ctx.Err())
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+func createTopic(ctx context.Context, client *gokafka.Client, topic string, partitions int) error {
+	res, err := client.CreateTopics(ctx, &gokafka.CreateTopicsRequest{Topics: []gokafka.TopicConfig{{
+		Topic:             topic,
+		NumPartitions:     partitions,
+		ReplicationFactor: -1,
+	}}})
+	if err != nil {
+		return fmt.Errorf("create topic %q: %w", topic, err)
+	}
+	if err := res.Errors[topic]; err != nil {
+		return fmt.Errorf("create topic %q: %w", topic, err)
+	}
+	return nil
+}
+
+// RangeBalancer spreads an ordered stream over the partitions in contiguous runs: of
+// total messages, message i goes to partition i*len(partitions)/total. Fed with sorted
+// output, partition 0 holds the smallest keys and reading the partitions one after
+// another yields the global order. It counts messages in the order Balance is called,
+// so it must only be used by a writer with a single caller of WriteMessages.
+type RangeBalancer struct {
+	total atomic.Int64
+	sent  int64
+}
+
+// SetTotal sets the number of messages the stream will hold and restarts the count;
+// it must be called before the first message is balanced.
+func (b *RangeBalancer) SetTotal(n int64) {
+	b.total.Store(n)
+	b.sent = 0
+}
+
+// Balance implements gokafka.Balancer.
+func (b *RangeBalancer) Balance(_ gokafka.Message, partitions ...int) int {
+	total := b.total.Load()
+	i := b.sent
+	b.sent++
+	if total <= 0 {
+		return partitions[0]
+	}
+	p := int(i * int64(len(partitions)) / total)
+	if p >= len(partitions) {
+		// More messages than announced; keep them in the last range
+		p = len(partitions) - 1
+	}
+	return partitions[p]
+}
@@ -0,0 +1,102 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"time"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// Manifest describes a produced dataset, so verification tooling can check that a
+// sorted topic holds exactly the records the producer wrote. The producer's
+// --manifest-topic writes one at the end of a run, keyed by RunID. A run with
+// --append writes the manifest of the grown dataset: the counts and the checksum
+// cover every run in Runs.
+type Manifest struct {
+	RunID      string           `json:"run_id"`
+	Topics     []string         `json:"topics"` // every record was written to each
+	Records    int64            `json:"records"`
+	Continents map[string]int64 `json:"continents,omitempty"` // of the generated records; absent for --input-dir
+	Checksum   string           `json:"checksum"`             // ValueChecksum of every record value
+	Seed       int64            `json:"seed,omitempty"`
+	CreatedAt  time.Time        `json:"created_at"`
+
+	// The record indices [StartID, NextID) the dataset covers; an --append run
+	// continues at NextID. Runs lists the runs that produced it when there are several.
+	StartID int64    `json:"start_id,omitempty"`
+	NextID  int64    `json:"next_id"`
+	Runs    []string `json:"runs,omitempty"`
+
+	// How the records were generated, which an --append run must repeat; empty for
+	// --input-dir runs and in manifests of older producers.
+	Format       string `json:"format,omitempty"`
+	Distribution string `json:"distribution,omitempty"`
+	Template     string `json:"template,omitempty"`
+}
+
+// ValueChecksum is an order-independent checksum of record values: the sum, mod 2^64,
+// of their 64-bit FNV-1a hashes. Any permutation of the values has the same checksum,
+// so a sorted topic must match the dataset it was sorted from, while a lost, extra or
+// altered record changes it. Checksums of disjoint parts add up to the whole.
+type ValueChecksum uint64
+
+// Add folds value into the checksum.
+func (c *ValueChecksum) Add(value []byte) {
+	h := fnv.New64a()
+	h.Write(value)
+	*c += ValueChecksum(h.Sum64())
+}
+
+// String returns the checksum as 16 hex digits, as a Manifest carries it.
+func (c ValueChecksum) String() string { return fmt.Sprintf("%016x", uint64(c)) }
+
+// ParseValueChecksum parses a checksum in the form String returns.
+func ParseValueChecksum(s string) (ValueChecksum, error) {
+	n, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("checksum %q is not 16 hex digits", s)
+	}
+	return ValueChecksum(n), nil
+}
+
+// WriteManifest creates topic if needed and writes m to it, keyed by m.RunID.
+func WriteManifest(ctx context.Context, brokers []string, topic string, m Manifest) error {
+	if err := CreateTopicLike(ctx, brokers, "", topic); err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	w := &gokafka.Writer{
+		Addr:         gokafka.TCP(brokers...),
+		Topic:        topic,
+		RequiredAcks: gokafka.RequireAll,
+		Balancer:     &gokafka.Hash{},
+	}
+	defer w.Close()
+	return w.WriteMessages(ctx, gokafka.Message{Key: []byte(m.RunID), Value: b})
+}
+
+// LatestManifest returns the latest manifest in topic of a dataset written to
+// dataTopic, or nil if there is none.
+func LatestManifest(ctx context.Context, brokers []string, topic, dataTopic string) (*Manifest, error) {
+	var latest *Manifest
+	err := scanTopic(ctx, brokers, topic, func(msg gokafka.Message) error {
+		var m Manifest
+		if err := json.Unmarshal(msg.Value, &m); err != nil {
+			return fmt.Errorf("manifest of %s: %w", msg.Key, err)
+		}
+		// Runs hash to different partitions, so the order is by time
+		if slices.Contains(m.Topics, dataTopic) && (latest == nil || m.CreatedAt.After(latest.CreatedAt)) {
+			latest = &m
+		}
+		return nil
+	})
+	return latest, err
+}
@@ -0,0 +1,122 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// MetaHeader marks a run metadata message. The sorter writes one to every partition of
+// the destination right before the merged records, with a RunMeta JSON value, so
+// consumers can check which sort configuration produced the records that follow it.
+// Consumers of sorted data should skip messages carrying this header.
+const MetaHeader = "kss-meta"
+
+// EndMarkerHeader marks an end-of-stream marker. The producer's --end-marker writes
+// one to every partition of a topic after its last record, with the number of records
+// in the dataset as the header value, so a sorter reading with --end-markers knows
+// when the topic is complete instead of waiting for read timeouts.
+const EndMarkerHeader = "kss-eos"
+
+// DatasetHeader carries the date (YYYY-MM-DD) of the logical dataset a source record
+// belongs to, set by the producer's --rotate-every mode.
+const DatasetHeader = "kss-dataset"
+
+// Diff headers mark the messages of a kss diff stream: DiffHeader holds the kind of
+// difference (added, removed or changed) and, for changed records, DiffOldHeader the
+// old record the message value replaces.
+const (
+	DiffHeader    = "kss-diff"
+	DiffOldHeader = "kss-diff-old"
+)
+
+// Provenance headers the producer sets with --provenance-headers: the id of the
+// producer run and the record's index in its dataset, so a sorted record can be traced
+// back to the run and position that generated it.
+const (
+	ProducerRunHeader = "producer-run-id"
+	RecordIndexHeader = "record-index"
+)
+
+// RunMeta describes the sort run whose records follow a metadata message.
+type RunMeta struct {
+	RunID       string    `json:"run_id"`
+	SourceTopic string    `json:"source_topic"`
+	SortKey     string    `json:"sort_key"`
+	Direction   string    `json:"direction"` // asc, desc, or each key part's as in "3,-0:int"
+	Records     int64     `json:"records"`   // records the merge writes
+	Partitions  int       `json:"partitions"`
+	Layout      string    `json:"layout"` // "single" or "range"
+	StartedAt   time.Time `json:"started_at"`
+}
+
+// WriteRunMeta writes m, with MetaHeader set, to every partition of topic and returns
+// the number of partitions written. m.Partitions is filled in.
+func WriteRunMeta(ctx context.Context, brokers []string, topic string, m RunMeta) (int, error) {
+	offsets, err := partitionOffsets(ctx, brokers, topic)
+	if err != nil {
+		return 0, err
+	}
+	m.Partitions = len(offsets)
+	b, err := json.Marshal(m)
+	if err != nil {
+		return 0, err
+	}
+	msg := gokafka.Message{Key: []byte(MetaHeader), Value: b, Headers: []gokafka.Header{{Key: MetaHeader, Value: []byte("1")}}}
+	return writeToPartitions(ctx, brokers, topic, offsets, msg)
+}
+
+// WriteEndMarkers writes an end-of-stream marker for a dataset of records to every
+// partition of topic and returns the number of partitions written.
+func WriteEndMarkers(ctx context.Context, brokers []string, topic string, records int64) (int, error) {
+	offsets, err := partitionOffsets(ctx, brokers, topic)
+	if err != nil {
+		return 0, err
+	}
+	msg := gokafka.Message{Key: []byte(EndMarkerHeader), Headers: []gokafka.Header{{Key: EndMarkerHeader, Value: strconv.AppendInt(nil, records, 10)}}}
+	return writeToPartitions(ctx, brokers, topic, offsets, msg)
+}
+
+// EndMarker reports whether msg is an end-of-stream marker and returns the record
+// count it carries.
+func EndMarker(msg gokafka.Message) (int64, bool) {
+	n, ok, err := headerInt(msg, EndMarkerHeader)
+	return n, ok && err == nil
+}
+
+// IsControl reports whether msg is a control message (run metadata, a repair marker or
+// an end-of-stream marker) rather than a record.
+func IsControl(msg gokafka.Message) bool {
+	for _, h := range msg.Headers {
+		if h.Key == MetaHeader || h.Key == TruncateHeader || h.Key == EndMarkerHeader {
+			return true
+		}
+	}
+	return false
+}
+
+// writeToPartitions writes a copy of msg to each partition in offsets and waits for
+// every copy to be acknowledged.
+func writeToPartitions(ctx context.Context, brokers []string, topic string, offsets []gokafka.PartitionOffsets, msg gokafka.Message) (int, error) {
+	msgs := make([]gokafka.Message, 0, len(offsets))
+	for _, po := range offsets {
+		m := msg
+		m.WriterData = po.Partition
+		msgs = append(msgs, m)
+	}
+	w := &gokafka.Writer{
+		Addr:         gokafka.TCP(brokers...),
+		Topic:        topic,
+		RequiredAcks: gokafka.RequireAll,
+		// Route each copy to the partition carried in its WriterData
+		Balancer: gokafka.BalancerFunc(func(m gokafka.Message, _ ...int) int { return m.WriterData.(int) }),
+	}
+	defer w.Close()
+	if err := w.WriteMessages(ctx, msgs...); err != nil {
+		return 0, err
+	}
+	return len(msgs), nil
+}
@@ -0,0 +1,125 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// GroupOffsets is a snapshot of a consumer group's committed offsets on one topic.
+// Partitions without a committed offset are recorded as -1.
+type GroupOffsets struct {
+	Group      string            `json:"group"`
+	Topic      string            `json:"topic"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Partitions []PartitionOffset `json:"partitions"`
+}
+
+// PartitionOffset is the committed offset of one partition.
+type PartitionOffset struct {
+	Partition int   `json:"partition"`
+	Offset    int64 `json:"offset"`
+}
+
+// ExportGroupOffsets fetches group's committed offsets for every partition of topic.
+func ExportGroupOffsets(ctx context.Context, brokers []string, group, topic string) (*GroupOffsets, error) {
+	client := &gokafka.Client{Addr: gokafka.TCP(brokers...)}
+	meta, err := client.Metadata(ctx, &gokafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return nil, err
+	}
+	if err := topicReady(meta, topic); err != nil {
+		return nil, err
+	}
+	var parts []int
+	for _, p := range meta.Topics[0].Partitions {
+		parts = append(parts, p.ID)
+	}
+	res, err := client.OffsetFetch(ctx, &gokafka.OffsetFetchRequest{GroupID: group, Topics: map[string][]int{topic: parts}})
+	if err != nil {
+		return nil, fmt.Errorf("fetch offsets of group %q: %w", group, err)
+	}
+	if res.Error != nil {
+		return nil, fmt.Errorf("fetch offsets of group %q: %w", group, res.Error)
+	}
+	out := &GroupOffsets{Group: group, Topic: topic, ExportedAt: time.Now().UTC()}
+	for _, p := range res.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("fetch offsets of group %q partition %d: %w", group, p.Partition, p.Error)
+		}
+		out.Partitions = append(out.Partitions, PartitionOffset{Partition: p.Partition, Offset: p.CommittedOffset})
+	}
+	sort.Slice(out.Partitions, func(i, j int) bool { return out.Partitions[i].Partition < out.Partitions[j].Partition })
+	return out, nil
+}
+
+// ImportGroupOffsets commits the offsets in o for group, which must have no committed
+// offsets on o.Topic yet. A consumer joining group then starts at exactly those
+// offsets; partitions recorded as -1 fall back to the reader's StartOffset.
+func ImportGroupOffsets(ctx context.Context, brokers []string, group string, o *GroupOffsets) error {
+	existing, err := ExportGroupOffsets(ctx, brokers, group, o.Topic)
+	if err != nil {
+		return err
+	}
+	for _, p := range existing.Partitions {
+		if p.Offset >= 0 {
+			return fmt.Errorf("group %q already has committed offsets on %q; seed a new group", group, o.Topic)
+		}
+	}
+
+	var commits []gokafka.OffsetCommit
+	for _, p := range o.Partitions {
+		if p.Offset >= 0 {
+			commits = append(commits, gokafka.OffsetCommit{Partition: p.Partition, Offset: p.Offset})
+		}
+	}
+	if len(commits) == 0 {
+		return nil
+	}
+	client := &gokafka.Client{Addr: gokafka.TCP(brokers...)}
+	// Generation -1 with no member id is a standalone commit for a group without members
+	res, err := client.OffsetCommit(ctx, &gokafka.OffsetCommitRequest{
+		GroupID:      group,
+		GenerationID: -1,
+		Topics:       map[string][]gokafka.OffsetCommit{o.Topic: commits},
+	})
+	if err != nil {
+		return fmt.Errorf("commit offsets of group %q: %w", group, err)
+	}
+	for _, p := range res.Topics[o.Topic] {
+		if p.Error != nil {
+			return fmt.Errorf("commit offsets of group %q partition %d: %w", group, p.Partition, p.Error)
+		}
+	}
+	return nil
+}
+
+// WriteGroupOffsets writes o to path as indented JSON.
+func WriteGroupOffsets(path string, o *GroupOffsets) error {
+	b, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// ReadGroupOffsets loads a file written by WriteGroupOffsets.
+func ReadGroupOffsets(path string) (*GroupOffsets, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var o GroupOffsets
+	if err := json.Unmarshal(b, &o); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if o.Topic == "" {
+		return nil, fmt.Errorf("%s: no topic", path)
+	}
+	return &o, nil
+}
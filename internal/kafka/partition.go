@@ -0,0 +1,117 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// PartitionInput reads one partition of a sorted topic from its first offset up to the
+// end offset observed when it was opened. Every partition of a sorted topic is in key
+// order on its own, so each one is a separate input to a k-way merge (it satisfies
+// sort.MergeInput). Repair markers and run metadata are skipped.
+type PartitionInput struct {
+	r       *gokafka.Reader
+	name    string
+	pos     int64
+	end     int64
+	bytes   int64
+	timeout time.Duration
+}
+
+// OpenPartitionInputs opens one PartitionInput per non-empty partition of topic.
+func OpenPartitionInputs(ctx context.Context, brokers []string, topic string) ([]*PartitionInput, error) {
+	offsets, err := partitionOffsets(ctx, brokers, topic)
+	if err != nil {
+		return nil, err
+	}
+	var inputs []*PartitionInput
+	for _, po := range offsets {
+		if po.LastOffset <= po.FirstOffset {
+			continue
+		}
+		r := gokafka.NewReader(gokafka.ReaderConfig{
+			Brokers:   brokers,
+			Topic:     topic,
+			Partition: po.Partition,
+			MinBytes:  1,
+			MaxBytes:  32 * 1024 * 1024,
+		})
+		if err := r.SetOffset(po.FirstOffset); err != nil {
+			r.Close()
+			for _, in := range inputs {
+				in.Close()
+			}
+			return nil, err
+		}
+		inputs = append(inputs, &PartitionInput{
+			r:       r,
+			name:    fmt.Sprintf("%s/%d", topic, po.Partition),
+			pos:     po.FirstOffset,
+			end:     po.LastOffset,
+			timeout: 30 * time.Second,
+		})
+	}
+	return inputs, nil
+}
+
+// Name returns topic/partition.
+func (p *PartitionInput) Name() string { return p.name }
+
+// Next returns the next record value, or io.EOF at the end offset.
+func (p *PartitionInput) Next() ([]byte, error) {
+	for p.pos < p.end {
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+		msg, err := p.r.ReadMessage(ctx)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		p.pos = msg.Offset + 1
+		if IsControl(msg) {
+			continue
+		}
+		p.bytes += int64(len(msg.Value))
+		return msg.Value, nil
+	}
+	return nil, io.EOF
+}
+
+// BytesRead returns the value bytes read so far.
+func (p *PartitionInput) BytesRead() int64 { return p.bytes }
+
+// Close closes the partition reader.
+func (p *PartitionInput) Close() error { return p.r.Close() }
+
+// EndOffsets returns the current end offset (one past the last record) of every
+// partition of topic that still has records for group to read, starting from the
+// group's committed offset or, for partitions without one, the first offset.
+// Empty and already consumed partitions are left out.
+func EndOffsets(ctx context.Context, brokers []string, topic, group string) (map[int]int64, error) {
+	offsets, err := partitionOffsets(ctx, brokers, topic)
+	if err != nil {
+		return nil, err
+	}
+	committed, err := ExportGroupOffsets(ctx, brokers, group, topic)
+	if err != nil {
+		return nil, err
+	}
+	start := make(map[int]int64, len(committed.Partitions))
+	for _, p := range committed.Partitions {
+		start[p.Partition] = p.Offset
+	}
+	ends := make(map[int]int64)
+	for _, po := range offsets {
+		from := po.FirstOffset
+		if c, ok := start[po.Partition]; ok && c > from {
+			from = c
+		}
+		if po.LastOffset > from {
+			ends[po.Partition] = po.LastOffset
+		}
+	}
+	return ends, nil
+}
@@ -0,0 +1,186 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// maxQuotaDelay caps the pause QuotaThrottle puts before a write.
+const maxQuotaDelay = 10 * time.Second
+
+// QuotaThrottle paces writes while brokers enforce client quotas. A broker over a
+// produce quota delays its responses and reports the delay as throttle time; kafka-go
+// records it (WriterStats.WaitTime) but keeps sending, so an async writer piles up
+// batches the broker holds back until requests time out and the run fails. The
+// throttle polls the writers' stats every second and, while throttling is reported,
+// pauses before every write: at first by the longest throttle time seen, growing by
+// half each second the brokers still throttle and halving each second they don't.
+// Quota events are logged under heading as they start, worsen and end.
+type QuotaThrottle struct {
+	next    MessageWriter
+	writers []*gokafka.Writer
+	heading string
+
+	delay  atomic.Int64 // nanoseconds slept before each write
+	paced  atomic.Int64 // nanoseconds slept in total
+	pauses atomic.Int64
+
+	mu          sync.Mutex
+	counts      map[*gokafka.Writer]*gokafka.WriterStats // counters taken by polling
+	events      int64
+	throttled   time.Duration // broker throttle time reported, summed over responses
+	maxThrottle time.Duration
+	peakDelay   time.Duration
+	since       time.Time // start of the current event, zero if none
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewQuotaThrottle wraps next, which writes through writers, and starts watching them.
+// Polling takes the writers' stats, which kafka-go resets on every read, so callers
+// must read them through WriterStats instead.
+func NewQuotaThrottle(next MessageWriter, heading string, writers ...*gokafka.Writer) *QuotaThrottle {
+	q := &QuotaThrottle{next: next, writers: writers, heading: heading, counts: map[*gokafka.Writer]*gokafka.WriterStats{},
+		stop: make(chan struct{}), done: make(chan struct{})}
+	go q.poll(time.Second)
+	return q
+}
+
+// WriteMessages implements MessageWriter.
+func (q *QuotaThrottle) WriteMessages(ctx context.Context, msgs ...gokafka.Message) error {
+	if d := time.Duration(q.delay.Load()); d > 0 {
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+		q.paced.Add(int64(d))
+		q.pauses.Add(1)
+	}
+	return q.next.WriteMessages(ctx, msgs...)
+}
+
+func (q *QuotaThrottle) poll(every time.Duration) {
+	defer close(q.done)
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			var window gokafka.DurationStats
+			q.mu.Lock()
+			for _, w := range q.writers {
+				s := q.take(w)
+				window.Count += s.WaitTime.Count
+				window.Sum += s.WaitTime.Sum
+				window.Max = max(window.Max, s.WaitTime.Max)
+			}
+			q.mu.Unlock()
+			q.observe(window)
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// take reads w's stats and adds the counters the caller won't see to counts. q.mu is held.
+func (q *QuotaThrottle) take(w *gokafka.Writer) gokafka.WriterStats {
+	s := w.Stats()
+	c := q.counts[w]
+	if c == nil {
+		c = &gokafka.WriterStats{}
+		q.counts[w] = c
+	}
+	c.Writes += s.Writes
+	c.Messages += s.Messages
+	c.Bytes += s.Bytes
+	c.Errors += s.Errors
+	c.Retries += s.Retries
+	return s
+}
+
+// observe adjusts the delay to one polling window's throttle times.
+func (q *QuotaThrottle) observe(window gokafka.DurationStats) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delay := time.Duration(q.delay.Load())
+	if window.Max > 0 {
+		q.throttled += window.Sum
+		q.maxThrottle = max(q.maxThrottle, window.Max)
+		next := min(max(delay*3/2, window.Max), maxQuotaDelay)
+		switch {
+		case q.since.IsZero():
+			q.events++
+			q.since = time.Now()
+			fmt.Printf("%s Quota: brokers are throttling produce requests (up to %v, %v avg over %d responses); pausing %v before each write\n",
+				q.heading, window.Max, window.Sum/time.Duration(window.Count), window.Count, next)
+		case next > delay:
+			fmt.Printf("%s Quota: still throttled (up to %v); pausing %v before each write\n", q.heading, window.Max, next)
+		}
+		q.delay.Store(int64(next))
+		q.peakDelay = max(q.peakDelay, next)
+		return
+	}
+	if q.since.IsZero() {
+		return
+	}
+	if delay /= 2; delay < time.Millisecond {
+		delay = 0
+		fmt.Printf("%s Quota: throttling ended after %v; writing at full rate\n", q.heading, time.Since(q.since).Round(time.Second))
+		q.since = time.Time{}
+	}
+	q.delay.Store(int64(delay))
+}
+
+// Stop stops watching the writers. A nil throttle does nothing.
+func (q *QuotaThrottle) Stop() {
+	if q == nil {
+		return
+	}
+	select {
+	case <-q.stop:
+	default:
+		close(q.stop)
+		<-q.done
+	}
+}
+
+// WriterStats returns w's stats like w.Stats would, with the counters (writes,
+// messages, bytes, errors and retries) covering everything since the throttle started.
+func (q *QuotaThrottle) WriterStats(w *gokafka.Writer) gokafka.WriterStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := q.take(w)
+	c := q.counts[w]
+	s.Writes, s.Messages, s.Bytes, s.Errors, s.Retries = c.Writes, c.Messages, c.Bytes, c.Errors, c.Retries
+	return s
+}
+
+// QuotaStats summarizes the quota throttling of a run.
+type QuotaStats struct {
+	Events      int64         // episodes of throttling
+	Throttled   time.Duration // throttle time the brokers reported
+	MaxThrottle time.Duration
+	Pauses      int64         // writes paused
+	Paced       time.Duration // time writes were paused
+	PeakDelay   time.Duration
+}
+
+// Stats returns what the throttle saw and did so far; zero for a nil throttle.
+func (q *QuotaThrottle) Stats() QuotaStats {
+	if q == nil {
+		return QuotaStats{}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return QuotaStats{Events: q.events, Throttled: q.throttled, MaxThrottle: q.maxThrottle,
+		Pauses: q.pauses.Load(), Paced: time.Duration(q.paced.Load()), PeakDelay: q.peakDelay}
+}
@@ -0,0 +1,87 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	gokafka "github.com/segmentio/kafka-go"
+	metadataAPI "github.com/segmentio/kafka-go/protocol/metadata"
+)
+
+// maxReadyBackoff caps the doubling delay between WaitForKafka attempts.
+const maxReadyBackoff = 10 * time.Second
+
+// WaitForKafka blocks until the cluster is ready for a run that performs op (read or
+// write) on topics: the brokers answer metadata and have a controller, every topic has
+// a leader for each partition, and the client is authorized for op on it. Brokers
+// older than Kafka 2.3 don't report authorized operations, so there the ACL check is
+// skipped. A failed attempt is reported through logf and retried after backoff,
+// doubled every time up to 10s, until ctx is done. It returns the attempts made.
+func WaitForKafka(ctx context.Context, brokers, topics []string, op gokafka.ACLOperationType, backoff time.Duration, logf func(format string, args ...any)) (int, error) {
+	delay := backoff
+	for attempt := 1; ; attempt++ {
+		stage, err := kafkaReady(ctx, brokers, topics, op)
+		if err == nil {
+			return attempt, nil
+		}
+		if deadline, ok := ctx.Deadline(); ctx.Err() != nil || ok && time.Until(deadline) < delay {
+			return attempt, fmt.Errorf("%s not ready after %d attempts: %v", stage, attempt, err)
+		}
+		logf("attempt %d: %s not ready: %v; retrying in %v", attempt, stage, err, delay)
+		select {
+		case <-ctx.Done():
+			return attempt, fmt.Errorf("%s not ready after %d attempts: %v", stage, attempt, err)
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReadyBackoff)
+	}
+}
+
+// kafkaReady makes one readiness check, returning the stage that failed: brokers,
+// topic or ACLs.
+func kafkaReady(ctx context.Context, brokers, topics []string, op gokafka.ACLOperationType) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	m, err := gokafka.DefaultTransport.RoundTrip(ctx, gokafka.TCP(brokers...), &metadataAPI.Request{
+		TopicNames:                       append([]string{}, topics...), // not nil, which asks for every topic
+		IncludeTopicAuthorizedOperations: true,
+	})
+	if err != nil {
+		return "brokers", err
+	}
+	res := m.(*metadataAPI.Response)
+	if len(res.Brokers) == 0 {
+		return "brokers", fmt.Errorf("metadata lists no brokers")
+	}
+	if res.ControllerID < 0 {
+		return "brokers", fmt.Errorf("no controller elected")
+	}
+	for _, t := range res.Topics {
+		if t.ErrorCode != 0 {
+			err := gokafka.Error(t.ErrorCode)
+			if err == gokafka.TopicAuthorizationFailed {
+				return "ACLs", fmt.Errorf("topic %q: %w", t.Name, err)
+			}
+			return "topic", fmt.Errorf("topic %q: %w", t.Name, err)
+		}
+		if len(t.Partitions) == 0 {
+			return "topic", fmt.Errorf("topic %q has no partitions", t.Name)
+		}
+		for _, p := range t.Partitions {
+			if p.ErrorCode != 0 && gokafka.Error(p.ErrorCode) != gokafka.ReplicaNotAvailable {
+				return "topic", fmt.Errorf("topic %q partition %d: %w", t.Name, p.PartitionIndex, gokafka.Error(p.ErrorCode))
+			}
+			if p.LeaderID < 0 {
+				return "topic", fmt.Errorf("topic %q partition %d has no leader", t.Name, p.PartitionIndex)
+			}
+		}
+		// A bit per operation; 0 or MinInt32 when the broker did not report them
+		if ops := t.TopicAuthorizedOperations; ops != 0 && ops != math.MinInt32 && ops&(1<<op) == 0 {
+			return "ACLs", fmt.Errorf("not authorized to %s topic %q", strings.ToLower(op.String()), t.Name)
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,188 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"core-infra-project/internal/fastnum"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// TruncateHeader marks a repair boundary. A message carrying it (with a null value)
+// invalidates every earlier record of the same partition whose sequence number is at
+// or above the header value; Kafka cannot truncate the tail of a partition in place.
+const TruncateHeader = "kss-truncate"
+
+// SeqScan summarizes the sequence numbers found in a sorted topic.
+type SeqScan struct {
+	Records int64 // data records read, markers excluded
+	Prefix  int64 // every sequence number below Prefix is present
+	Max     int64 // highest valid sequence number seen, -1 if none
+}
+
+// ScanSeqPrefix reads every partition of topic up to its current end and returns the
+// longest gap-free prefix of the sequence numbers stored in header. Records invalidated
+// by an earlier TruncateHeader marker in the same partition are ignored.
+func ScanSeqPrefix(ctx context.Context, brokers []string, topic, header string) (SeqScan, error) {
+	scan := SeqScan{Max: -1}
+	offsets, err := partitionOffsets(ctx, brokers, topic)
+	if err != nil {
+		return scan, err
+	}
+
+	var seen bitset
+	for _, po := range offsets {
+		if po.LastOffset <= po.FirstOffset {
+			continue
+		}
+		// Per-partition bits, so a marker only invalidates records written before it here
+		var part bitset
+		r := gokafka.NewReader(gokafka.ReaderConfig{
+			Brokers:   brokers,
+			Topic:     topic,
+			Partition: po.Partition,
+			MinBytes:  1,
+			MaxBytes:  32 * 1024 * 1024,
+		})
+		if err := r.SetOffset(po.FirstOffset); err != nil {
+			r.Close()
+			return scan, err
+		}
+		for {
+			msg, err := r.ReadMessage(ctx)
+			if err != nil {
+				r.Close()
+				return scan, fmt.Errorf("partition %d: %w", po.Partition, err)
+			}
+			if bound, ok, err := headerInt(msg, TruncateHeader); err != nil {
+				r.Close()
+				return scan, err
+			} else if ok {
+				part.clearFrom(bound)
+			} else if !IsControl(msg) {
+				seq, ok, err := headerInt(msg, header)
+				if err != nil || !ok {
+					r.Close()
+					return scan, fmt.Errorf("partition %d offset %d: no valid %s header (was the topic written with sequence headers?)", po.Partition, msg.Offset, header)
+				}
+				part.set(seq)
+				scan.Records++
+			}
+			if msg.Offset >= po.LastOffset-1 {
+				break
+			}
+		}
+		r.Close()
+		seen.or(part)
+	}
+
+	scan.Prefix = seen.firstUnset()
+	scan.Max = seen.last()
+	return scan, nil
+}
+
+// WriteTruncateMarkers appends a TruncateHeader marker for below to every partition of topic.
+func WriteTruncateMarkers(ctx context.Context, brokers []string, topic string, below int64) (int, error) {
+	offsets, err := partitionOffsets(ctx, brokers, topic)
+	if err != nil {
+		return 0, err
+	}
+	marker := gokafka.Message{Headers: []gokafka.Header{{Key: TruncateHeader, Value: []byte(strconv.FormatInt(below, 10))}}}
+	return writeToPartitions(ctx, brokers, topic, offsets, marker)
+}
+
+func partitionOffsets(ctx context.Context, brokers []string, topic string) ([]gokafka.PartitionOffsets, error) {
+	client := &gokafka.Client{Addr: gokafka.TCP(brokers...)}
+	meta, err := client.Metadata(ctx, &gokafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return nil, err
+	}
+	if err := topicReady(meta, topic); err != nil {
+		return nil, err
+	}
+	var reqs []gokafka.OffsetRequest
+	for _, p := range meta.Topics[0].Partitions {
+		reqs = append(reqs, gokafka.FirstOffsetOf(p.ID), gokafka.LastOffsetOf(p.ID))
+	}
+	res, err := client.ListOffsets(ctx, &gokafka.ListOffsetsRequest{Topics: map[string][]gokafka.OffsetRequest{topic: reqs}})
+	if err != nil {
+		return nil, fmt.Errorf("list offsets of %q: %w", topic, err)
+	}
+	for _, po := range res.Topics[topic] {
+		if po.Error != nil {
+			return nil, fmt.Errorf("list offsets of %q partition %d: %w", topic, po.Partition, po.Error)
+		}
+	}
+	return res.Topics[topic], nil
+}
+
+func headerInt(msg gokafka.Message, key string) (int64, bool, error) {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			n, err := fastnum.ParseInt(h.Value)
+			if err != nil {
+				return 0, false, fmt.Errorf("offset %d: invalid %s header %q", msg.Offset, key, h.Value)
+			}
+			return n, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// bitset is a growable set of non-negative integers.
+type bitset []uint64
+
+func (b *bitset) set(i int64) {
+	for int(i/64) >= len(*b) {
+		*b = append(*b, 0)
+	}
+	(*b)[i/64] |= 1 << (i % 64)
+}
+
+func (b *bitset) clearFrom(i int64) {
+	if i < 0 {
+		i = 0
+	}
+	w := int(i / 64)
+	if w >= len(*b) {
+		return
+	}
+	(*b)[w] &= 1<<(i%64) - 1
+	*b = (*b)[:w+1]
+}
+
+func (b *bitset) or(o bitset) {
+	for i, w := range o {
+		if i >= len(*b) {
+			*b = append(*b, w)
+		} else {
+			(*b)[i] |= w
+		}
+	}
+}
+
+func (b bitset) firstUnset() int64 {
+	for i, w := range b {
+		if w != ^uint64(0) {
+			for j := int64(0); j < 64; j++ {
+				if w&(1<<j) == 0 {
+					return int64(i)*64 + j
+				}
+			}
+		}
+	}
+	return int64(len(b)) * 64
+}
+
+func (b bitset) last() int64 {
+	for i := len(b) - 1; i >= 0; i-- {
+		for j := int64(63); j >= 0; j-- {
+			if b[i]&(1<<j) != 0 {
+				return int64(i)*64 + j
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,83 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// LogEnds returns the end offset (one past the last record) of every partition of topic.
+func LogEnds(ctx context.Context, brokers []string, topic string) (map[int]int64, error) {
+	offsets, err := partitionOffsets(ctx, brokers, topic)
+	if err != nil {
+		return nil, err
+	}
+	ends := make(map[int]int64, len(offsets))
+	for _, po := range offsets {
+		ends[po.Partition] = po.LastOffset
+	}
+	return ends, nil
+}
+
+// ScanRunIndices reads every partition of topic from its offset in from (the first
+// offset for partitions missing from it) up to its current end, and calls f with the
+// RecordIndexHeader of every record whose ProducerRunHeader is runID. It returns the
+// number of records read. kafka-go has no idempotent or transactional producer, so
+// this is how a resumed run finds what its predecessor delivered unacknowledged.
+func ScanRunIndices(ctx context.Context, brokers []string, topic, runID string, from map[int]int64, f func(index int64)) (int64, error) {
+	offsets, err := partitionOffsets(ctx, brokers, topic)
+	if err != nil {
+		return 0, err
+	}
+	var read int64
+	for _, po := range offsets {
+		start := max(po.FirstOffset, from[po.Partition])
+		if po.LastOffset <= start {
+			continue
+		}
+		r := gokafka.NewReader(gokafka.ReaderConfig{
+			Brokers:   brokers,
+			Topic:     topic,
+			Partition: po.Partition,
+			MinBytes:  1,
+			MaxBytes:  32 * 1024 * 1024,
+		})
+		if err := r.SetOffset(start); err != nil {
+			r.Close()
+			return read, err
+		}
+		for {
+			msg, err := r.ReadMessage(ctx)
+			if err != nil {
+				r.Close()
+				return read, fmt.Errorf("partition %d: %w", po.Partition, err)
+			}
+			read++
+			if headerValue(msg, ProducerRunHeader) == runID {
+				i, ok, err := headerInt(msg, RecordIndexHeader)
+				if err != nil {
+					r.Close()
+					return read, fmt.Errorf("partition %d %w", po.Partition, err)
+				}
+				if ok {
+					f(i)
+				}
+			}
+			if msg.Offset >= po.LastOffset-1 {
+				break
+			}
+		}
+		r.Close()
+	}
+	return read, nil
+}
+
+func headerValue(msg gokafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,102 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// Retention holds a topic's time and size retention limits.
+// -1 means unlimited, matching Kafka's own convention.
+type Retention struct {
+	Ms    int64
+	Bytes int64
+}
+
+// Satisfies reports whether r keeps at least as much data as required.
+// Zero fields in required are not checked.
+func (r Retention) Satisfies(required Retention) bool {
+	return covers(r.Ms, required.Ms) && covers(r.Bytes, required.Bytes)
+}
+
+func covers(have, want int64) bool {
+	return want == 0 || have == -1 || (want != -1 && have >= want)
+}
+
+func (r Retention) String() string {
+	return fmt.Sprintf("retention.ms=%d retention.bytes=%d", r.Ms, r.Bytes)
+}
+
+// DescribeRetention reads the effective retention.ms and retention.bytes of topic.
+func DescribeRetention(ctx context.Context, brokers []string, topic string) (Retention, error) {
+	client := &gokafka.Client{Addr: gokafka.TCP(brokers...)}
+	res, err := client.DescribeConfigs(ctx, &gokafka.DescribeConfigsRequest{
+		Resources: []gokafka.DescribeConfigRequestResource{{
+			ResourceType: gokafka.ResourceTypeTopic,
+			ResourceName: topic,
+			ConfigNames:  []string{"retention.ms", "retention.bytes"},
+		}},
+	})
+	if err != nil {
+		return Retention{}, fmt.Errorf("describe configs of %q: %w", topic, err)
+	}
+	if len(res.Resources) != 1 {
+		return Retention{}, fmt.Errorf("describe configs of %q: got %d resources", topic, len(res.Resources))
+	}
+	if err := res.Resources[0].Error; err != nil {
+		return Retention{}, fmt.Errorf("describe configs of %q: %w", topic, err)
+	}
+
+	var r Retention
+	for _, e := range res.Resources[0].ConfigEntries {
+		n, err := strconv.ParseInt(e.ConfigValue, 10, 64)
+		if err != nil {
+			return Retention{}, fmt.Errorf("topic %q: invalid %s %q", topic, e.ConfigName, e.ConfigValue)
+		}
+		switch e.ConfigName {
+		case "retention.ms":
+			r.Ms = n
+		case "retention.bytes":
+			r.Bytes = n
+		}
+	}
+	return r, nil
+}
+
+// SetRetention overrides the non-zero fields of r on topic.
+func SetRetention(ctx context.Context, brokers []string, topic string, r Retention) error {
+	var configs []gokafka.IncrementalAlterConfigsRequestConfig
+	if r.Ms != 0 {
+		configs = append(configs, gokafka.IncrementalAlterConfigsRequestConfig{
+			Name: "retention.ms", Value: strconv.FormatInt(r.Ms, 10), ConfigOperation: gokafka.ConfigOperationSet,
+		})
+	}
+	if r.Bytes != 0 {
+		configs = append(configs, gokafka.IncrementalAlterConfigsRequestConfig{
+			Name: "retention.bytes", Value: strconv.FormatInt(r.Bytes, 10), ConfigOperation: gokafka.ConfigOperationSet,
+		})
+	}
+	if len(configs) == 0 {
+		return nil
+	}
+
+	client := &gokafka.Client{Addr: gokafka.TCP(brokers...)}
+	res, err := client.IncrementalAlterConfigs(ctx, &gokafka.IncrementalAlterConfigsRequest{
+		Resources: []gokafka.IncrementalAlterConfigsRequestResource{{
+			ResourceType: gokafka.ResourceTypeTopic,
+			ResourceName: topic,
+			Configs:      configs,
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("alter configs of %q: %w", topic, err)
+	}
+	for _, rr := range res.Resources {
+		if rr.Error != nil {
+			return fmt.Errorf("alter configs of %q: %w", topic, rr.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// RunPointer records where one run of a sorter wrote its output. Pointers are keyed
+// by the base destination topic, so the latest pointer per key names the latest run.
+type RunPointer struct {
+	RunID      string    `json:"run_id"`
+	BaseTopic  string    `json:"base_topic"`
+	Topic      string    `json:"topic"`
+	SortKey    string    `json:"sort_key"`
+	Records    int64     `json:"records"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// RunPointerTopic returns the topic holding the run pointers of base.
+func RunPointerTopic(base string) string { return base + "-runs" }
+
+// PublishRunPointer appends p to the pointer topic of its base topic, creating the
+// pointer topic if needed.
+func PublishRunPointer(ctx context.Context, brokers []string, p RunPointer) error {
+	topic := RunPointerTopic(p.BaseTopic)
+	if err := CreateTopicLike(ctx, brokers, "", topic); err != nil {
+		return err
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	w := &gokafka.Writer{
+		Addr:         gokafka.TCP(brokers...),
+		Topic:        topic,
+		RequiredAcks: gokafka.RequireAll,
+		Balancer:     &gokafka.Hash{},
+	}
+	defer w.Close()
+	return w.WriteMessages(ctx, gokafka.Message{Key: []byte(p.BaseTopic), Value: b})
+}
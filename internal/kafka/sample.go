@@ -0,0 +1,155 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// SampledPair is a record drawn by SamplePairs and the record after it in its
+// partition, control messages skipped.
+type SampledPair struct {
+	Partition int
+	Offset    int64
+	Value     []byte
+	Next      []byte
+}
+
+// SamplePairs draws size(pairs) of the adjacent record pairs in topic uniformly from
+// every partition, without replacement, and returns them ordered by partition and
+// offset, along with the number of pairs the topic holds. Positions are reservoir-sampled over the
+// offset ranges (Algorithm L), so only the sampled records are fetched: one fetch per
+// sample at most, fewer where samples share a record batch.
+func SamplePairs(ctx context.Context, brokers []string, topic string, size func(pairs int64) int, rng *rand.Rand) ([]SampledPair, int64, error) {
+	offsets, err := partitionOffsets(ctx, brokers, topic)
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i].Partition < offsets[j].Partition })
+	var total int64
+	for _, po := range offsets {
+		total += max(po.LastOffset-po.FirstOffset-1, 0)
+	}
+	positions := reservoir(total, size(total), rng)
+
+	var pairs []SampledPair
+	var base int64
+	for _, po := range offsets {
+		count := max(po.LastOffset-po.FirstOffset-1, 0)
+		var mine []int64
+		for len(positions) > 0 && positions[0] < base+count {
+			mine = append(mine, po.FirstOffset+positions[0]-base)
+			positions = positions[1:]
+		}
+		base += count
+		if len(mine) == 0 {
+			continue
+		}
+		got, err := readPairs(ctx, brokers[0], topic, po, mine)
+		if err != nil {
+			return nil, total, fmt.Errorf("%s partition %d: %w", topic, po.Partition, err)
+		}
+		pairs = append(pairs, got...)
+	}
+	return pairs, total, nil
+}
+
+// reservoir returns min(n, total) distinct positions in [0, total), ascending.
+func reservoir(total int64, n int, rng *rand.Rand) []int64 {
+	if int64(n) >= total {
+		all := make([]int64, total)
+		for i := range all {
+			all[i] = int64(i)
+		}
+		return all
+	}
+	res := make([]int64, n)
+	for i := range res {
+		res[i] = int64(i)
+	}
+	// Skips straight to the next replaced position instead of drawing for every one
+	w := math.Exp(math.Log(rng.Float64()) / float64(n))
+	for i := int64(n) - 1; ; {
+		i += int64(math.Log(rng.Float64())/math.Log(1-w)) + 1
+		if i >= total || i < 0 {
+			break
+		}
+		res[rng.Intn(n)] = i
+		w *= math.Exp(math.Log(rng.Float64()) / float64(n))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// readPairs reads the pair at each of offsets, ascending, from the partition leader.
+// Records fetched for one pair serve the next ones they cover.
+func readPairs(ctx context.Context, broker, topic string, po gokafka.PartitionOffsets, offsets []int64) ([]SampledPair, error) {
+	conn, err := gokafka.DialLeader(ctx, "tcp", broker, topic, po.Partition)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var fetched []gokafka.Message // records only, ascending
+	// fetch appends the records of one fetch from offset from and returns the offset
+	// after the last message read
+	fetch := func(from int64) (int64, error) {
+		if _, err := conn.Seek(from, gokafka.SeekAbsolute); err != nil {
+			return from, err
+		}
+		conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		batch := conn.ReadBatch(1, 256<<10)
+		next := from
+		for {
+			msg, err := batch.ReadMessage()
+			if err != nil {
+				batch.Close()
+				if err == io.EOF {
+					return next, nil
+				}
+				return next, err
+			}
+			if msg.Offset < from {
+				continue // the fetch starts at its record batch
+			}
+			next = msg.Offset + 1
+			if !IsControl(msg) {
+				fetched = append(fetched, msg)
+			}
+			if next >= po.LastOffset {
+				return next, batch.Close()
+			}
+		}
+	}
+	pairs := make([]SampledPair, 0, len(offsets))
+	for _, off := range offsets {
+		next := off
+		if len(fetched) > 0 {
+			next = max(next, fetched[len(fetched)-1].Offset+1)
+		}
+		fetched = fetched[sort.Search(len(fetched), func(i int) bool { return fetched[i].Offset >= off }):]
+		for len(fetched) < 2 && next < po.LastOffset {
+			after, err := fetch(next)
+			if err != nil {
+				return nil, err
+			}
+			// An empty fetch (e.g. a compacted gap) steps over the offset instead of retrying it
+			next = max(after, next+1)
+		}
+		if len(fetched) == 0 {
+			break
+		}
+		p := SampledPair{Partition: po.Partition, Offset: fetched[0].Offset, Value: fetched[0].Value}
+		if len(fetched) > 1 {
+			p.Next = fetched[1].Value
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs, nil
+}
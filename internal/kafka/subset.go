@@ -0,0 +1,161 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// PartitionSetReader reads a fixed set of partitions of a topic from their first
+// offsets, without a consumer group, so several sorter instances can each take a
+// disjoint share of the partitions. Records of one partition arrive in offset order;
+// partitions are interleaved. It satisfies sort.Source and sort.Reconnector.
+type PartitionSetReader struct {
+	brokers []string
+	topic   string
+	ends    map[int]int64
+	msgs    chan gokafka.Message
+	errs    chan error
+	ctx     context.Context // of the pumps
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	readers map[int]*gokafka.Reader
+	stalled map[int]int64 // next offset of partitions whose pump failed
+}
+
+// OpenPartitionSet starts reading partitions of topic. Every partition must exist.
+func OpenPartitionSet(ctx context.Context, brokers []string, topic string, partitions []int) (*PartitionSetReader, error) {
+	offsets, err := partitionOffsets(ctx, brokers, topic)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]gokafka.PartitionOffsets, len(offsets))
+	for _, po := range offsets {
+		byID[po.Partition] = po
+	}
+
+	readCtx, cancel := context.WithCancel(context.Background())
+	r := &PartitionSetReader{
+		brokers: brokers,
+		topic:   topic,
+		ends:    make(map[int]int64),
+		msgs:    make(chan gokafka.Message, 1024),
+		errs:    make(chan error, len(partitions)),
+		ctx:     readCtx,
+		cancel:  cancel,
+		readers: make(map[int]*gokafka.Reader),
+		stalled: make(map[int]int64),
+	}
+	for _, p := range partitions {
+		po, ok := byID[p]
+		if !ok {
+			r.Close()
+			return nil, fmt.Errorf("topic %q has no partition %d (it has %d)", topic, p, len(offsets))
+		}
+		if po.LastOffset <= po.FirstOffset {
+			continue
+		}
+		if err := r.start(p, po.FirstOffset); err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.ends[p] = po.LastOffset
+	}
+	return r, nil
+}
+
+// start opens a reader of partition at offset and pumps its records. r.mu is held or
+// the reader not yet shared.
+func (r *PartitionSetReader) start(partition int, offset int64) error {
+	rd := gokafka.NewReader(gokafka.ReaderConfig{
+		Brokers:   r.brokers,
+		Topic:     r.topic,
+		Partition: partition,
+		MinBytes:  1, // the end offset is known, so never wait for a fuller fetch
+		MaxBytes:  32 * 1024 * 1024,
+	})
+	if err := rd.SetOffset(offset); err != nil {
+		rd.Close()
+		return err
+	}
+	r.readers[partition] = rd
+	r.wg.Add(1)
+	go r.pump(rd, partition, offset)
+	return nil
+}
+
+func (r *PartitionSetReader) pump(rd *gokafka.Reader, partition int, next int64) {
+	defer r.wg.Done()
+	for {
+		msg, err := rd.ReadMessage(r.ctx)
+		if err != nil {
+			if r.ctx.Err() != nil {
+				return
+			}
+			r.mu.Lock()
+			r.stalled[partition] = next
+			r.mu.Unlock()
+			select {
+			case r.errs <- fmt.Errorf("partition %d: %w", partition, err):
+			case <-r.ctx.Done():
+			}
+			return
+		}
+		select {
+		case r.msgs <- msg:
+			next = msg.Offset + 1
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// Reconnect implements sort.Reconnector: partitions whose reader failed are read
+// again, by new readers, from the offset after the last record delivered.
+func (r *PartitionSetReader) Reconnect(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for p, next := range r.stalled {
+		r.readers[p].Close()
+		if err := r.start(p, next); err != nil {
+			return fmt.Errorf("partition %d: %w", p, err)
+		}
+		delete(r.stalled, p)
+	}
+	return nil
+}
+
+// EndOffsets returns the end offset of every non-empty selected partition at open,
+// for sort.Options.EndOffsets.
+func (r *PartitionSetReader) EndOffsets() map[int]int64 { return r.ends }
+
+// ReadMessage implements sort.Source.
+func (r *PartitionSetReader) ReadMessage(ctx context.Context) (gokafka.Message, error) {
+	select {
+	case msg := <-r.msgs:
+		return msg, nil
+	case err := <-r.errs:
+		return gokafka.Message{}, err
+	case <-ctx.Done():
+		return gokafka.Message{}, ctx.Err()
+	}
+}
+
+// Close stops the partition readers.
+func (r *PartitionSetReader) Close() error {
+	r.cancel()
+	r.wg.Wait()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var first error
+	for _, rd := range r.readers {
+		if err := rd.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
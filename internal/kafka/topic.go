@@ -0,0 +1,114 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// WaitForTopic polls cluster metadata until every partition of topic has a leader,
+// returning the partitions. It keeps polling while the topic does not exist yet
+// (e.g. auto-creation or a script still creating it) until ctx is done.
+func WaitForTopic(ctx context.Context, brokers []string, topic string, poll time.Duration) ([]gokafka.Partition, error) {
+	client := &gokafka.Client{Addr: gokafka.TCP(brokers...)}
+	lastErr := fmt.Errorf("no metadata received")
+	for {
+		res, err := client.Metadata(ctx, &gokafka.MetadataRequest{Topics: []string{topic}})
+		if err == nil {
+			lastErr = topicReady(res, topic)
+			if lastErr == nil {
+				return res.Topics[0].Partitions, nil
+			}
+		} else {
+			lastErr = err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("topic %q not ready: %w (last error: %v)", topic, ctx.Err(), lastErr)
+		case <-time.After(poll):
+		}
+	}
+}
+
+func topicReady(res *gokafka.MetadataResponse, topic string) error {
+	if len(res.Topics) != 1 {
+		return fmt.Errorf("metadata returned %d topics", len(res.Topics))
+	}
+	t := res.Topics[0]
+	if t.Error != nil {
+		return t.Error
+	}
+	if len(t.Partitions) == 0 {
+		return fmt.Errorf("topic %q has no partitions", topic)
+	}
+	for _, p := range t.Partitions {
+		if p.Error != nil {
+			return fmt.Errorf("partition %d: %w", p.ID, p.Error)
+		}
+		if p.Leader.Host == "" {
+			return fmt.Errorf("partition %d has no leader", p.ID)
+		}
+	}
+	return nil
+}
+
+// PrewarmBrokers sends a metadata request to every partition leader through the
+// default transport, which the writers share, so the connections are already
+// established when the timed run begins. It returns the number of brokers warmed.
+func PrewarmBrokers(ctx context.Context, partitions []gokafka.Partition) (int, error) {
+	client := &gokafka.Client{}
+	seen := map[int]bool{}
+	for _, p := range partitions {
+		if seen[p.Leader.ID] {
+			continue
+		}
+		seen[p.Leader.ID] = true
+		addr := gokafka.TCP(net.JoinHostPort(p.Leader.Host, strconv.Itoa(p.Leader.Port)))
+		if _, err := client.Metadata(ctx, &gokafka.MetadataRequest{Addr: addr, Topics: []string{p.Topic}}); err != nil {
+			return len(seen) - 1, fmt.Errorf("prewarm broker %d (%s): %w", p.Leader.ID, addr, err)
+		}
+	}
+	return len(seen), nil
+}
+
+// CreateTopicLike creates topic with the partition count and replication factor of
+// template, falling back to broker defaults when template is empty or does not exist.
+// An already existing topic is not an error.
+func CreateTopicLike(ctx context.Context, brokers []string, template, topic string) error {
+	client := &gokafka.Client{Addr: gokafka.TCP(brokers...)}
+	cfg := gokafka.TopicConfig{Topic: topic, NumPartitions: -1, ReplicationFactor: -1}
+	if template != "" {
+		if res, err := client.Metadata(ctx, &gokafka.MetadataRequest{Topics: []string{template}}); err == nil && topicReady(res, template) == nil {
+			parts := res.Topics[0].Partitions
+			cfg.NumPartitions = len(parts)
+			cfg.ReplicationFactor = len(parts[0].Replicas)
+		}
+	}
+	res, err := client.CreateTopics(ctx, &gokafka.CreateTopicsRequest{Topics: []gokafka.TopicConfig{cfg}})
+	if err != nil {
+		return fmt.Errorf("create topic %q: %w", topic, err)
+	}
+	if err := res.Errors[topic]; err != nil && !errors.Is(err, gokafka.TopicAlreadyExists) {
+		return fmt.Errorf("create topic %q: %w", topic, err)
+	}
+	return nil
+}
+
+// DeleteTopic deletes topic. A topic that does not exist is not an error.
+func DeleteTopic(ctx context.Context, brokers []string, topic string) error {
+	client := &gokafka.Client{Addr: gokafka.TCP(brokers...)}
+	res, err := client.DeleteTopics(ctx, &gokafka.DeleteTopicsRequest{Topics: []string{topic}})
+	if err != nil {
+		return fmt.Errorf("delete topic %q: %w", topic, err)
+	}
+	if err := res.Errors[topic]; err != nil && !errors.Is(err, gokafka.UnknownTopicOrPartition) {
+		return fmt.Errorf("delete topic %q: %w", topic, err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Package metrics names the Prometheus metrics the binaries serve at /metrics and
+// writes them in the text exposition format, so the dashboards kss generates query
+// exactly what is emitted.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Producer metrics, labelled with run_id.
+const (
+	ProducerGenerated   = "kss_producer_records_generated_total"
+	ProducerBatches     = "kss_producer_batches_total"
+	ProducerWritten     = "kss_producer_records_written_total"
+	ProducerFailed      = "kss_producer_records_failed_total"
+	ProducerWriteErrors = "kss_producer_write_errors_total"
+	ProducerSpooled     = "kss_producer_records_spooled_total"
+	ProducerThroughput  = "kss_producer_throughput_records_per_second"
+	ProducerQueueLength = "kss_producer_queue_length" // with a queue label
+	ProducerQueueCap    = "kss_producer_queue_capacity"
+)
+
+// Sorter metrics, labelled with run_id and key. The phase metrics add a phase label.
+const (
+	SorterRecordsRead    = "kss_sorter_records_read_total"
+	SorterRecordsMerged  = "kss_sorter_records_merged_total"
+	SorterSpillRawBytes  = "kss_sorter_spill_raw_bytes_total"
+	SorterSpillDiskBytes = "kss_sorter_spill_disk_bytes_total"
+	SorterPhase          = "kss_sorter_phase"                  // 1 for the current phase
+	SorterPhaseDuration  = "kss_sorter_phase_duration_seconds" // of the last completed chunk and merge phases
+)
+
+// Labels renders name="value" pairs, in the order given, as a label set.
+func Labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+"="+strconv.Quote(pairs[i+1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Family writes the HELP and TYPE lines of a metric; its samples follow with Sample.
+func Family(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Sample writes one sample of name with the labels rendered by Labels.
+func Sample(w io.Writer, name, labels string, v float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, strconv.FormatFloat(v, 'f', -1, 64))
+}
+
+// Write writes a metric with a single sample.
+func Write(w io.Writer, name, kind, help, labels string, v float64) {
+	Family(w, name, kind, help)
+	Sample(w, name, labels, v)
+}
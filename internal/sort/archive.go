@@ -0,0 +1,189 @@
+package sort
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	gokafka "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/compress"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ArchiveSource reads newline-delimited CSV records from a local archive: a gzip or
+// zstd compressed CSV file, or a tarball (plain, gzip or zstd compressed) whose regular
+// files are read as CSV in archive order. Formats are detected from the content, not
+// the file name, and everything is decompressed as it streams, so "-" can read an
+// export piped straight from object storage. Messages carry the record's position in
+// the archive as Offset; ReadMessage returns io.EOF once the archive is exhausted.
+type ArchiveSource struct {
+	name       string
+	format     string
+	file       io.Closer
+	dec        io.ReadCloser // nil when the archive is not compressed
+	tar        *tar.Reader   // nil for a single compressed file
+	lines      *bufio.Reader // current CSV file; nil between tar entries
+	entryBuf   *bufio.Reader // reused for every tar entry
+	skipHeader bool
+	header     bool // the next line of the current file is its header
+	records    int64
+	files      int
+}
+
+// OpenArchive opens the archive at name ("-" reads stdin). With skipHeader the first
+// line of every CSV file in it is dropped.
+func OpenArchive(name string, skipHeader bool) (*ArchiveSource, error) {
+	s := &ArchiveSource{name: name, skipHeader: skipHeader}
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		s.file, r = f, f
+	}
+
+	br := bufio.NewReaderSize(r, 1<<20)
+	magic, _ := br.Peek(len(zstdMagic))
+	body := br
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		s.dec, s.format = compress.Gzip.Codec().NewReader(br), "gzip"
+	case bytes.HasPrefix(magic, zstdMagic):
+		s.dec, s.format = compress.Zstd.Codec().NewReader(br), "zstd"
+	}
+	if s.dec != nil {
+		body = bufio.NewReaderSize(s.dec, 1<<20)
+	}
+
+	// A tar header block has the "ustar" magic at offset 257
+	hdr, err := body.Peek(512)
+	if err != nil && err != io.EOF {
+		s.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(hdr) >= 262 && string(hdr[257:262]) == "ustar" {
+		s.tar = tar.NewReader(body)
+		s.format = strings.TrimSuffix("tar+"+s.format, "+")
+	} else {
+		s.lines, s.header, s.files = body, skipHeader, 1
+	}
+	if s.format == "" {
+		s.format = "plain"
+	}
+	return s, nil
+}
+
+// Format names the detected container and compression, e.g. "tar+gzip" or "zstd".
+func (s *ArchiveSource) Format() string { return s.format }
+
+// Records returns the number of records read so far.
+func (s *ArchiveSource) Records() int64 { return s.records }
+
+// Files returns the number of CSV files opened so far.
+func (s *ArchiveSource) Files() int { return s.files }
+
+// ReadMessage implements Source. Empty lines are skipped.
+func (s *ArchiveSource) ReadMessage(ctx context.Context) (gokafka.Message, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return gokafka.Message{}, err
+		}
+		if s.lines == nil {
+			if err := s.nextFile(); err != nil {
+				return gokafka.Message{}, err
+			}
+		}
+		line, err := s.lines.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return gokafka.Message{}, fmt.Errorf("%s: %w", s.name, err)
+		}
+		if err == io.EOF && len(line) == 0 {
+			if s.tar == nil {
+				return gokafka.Message{}, io.EOF
+			}
+			s.lines = nil
+			continue
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if s.header {
+			s.header = false
+			continue
+		}
+		if len(line) == 0 {
+			continue
+		}
+		s.records++
+		return gokafka.Message{Value: line, Offset: s.records - 1}, nil
+	}
+}
+
+// nextFile advances to the next regular file of the tarball, skipping directories and
+// hidden files (such as the ._ resource forks macOS adds to tarballs).
+func (s *ArchiveSource) nextFile() error {
+	for {
+		h, err := s.tar.Next()
+		if err == io.EOF {
+			return io.EOF
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.name, err)
+		}
+		if h.Typeflag != tar.TypeReg || strings.HasPrefix(path.Base(h.Name), ".") {
+			continue
+		}
+		if s.entryBuf == nil {
+			s.entryBuf = bufio.NewReaderSize(s.tar, 1<<20)
+		} else {
+			s.entryBuf.Reset(s.tar)
+		}
+		s.lines = s.entryBuf
+		s.header = s.skipHeader
+		s.files++
+		return nil
+	}
+}
+
+// Reopen implements Reopener: it opens the archive again and skips the records already
+// read, so a read failing on a stale handle (e.g. a remounted NFS volume) carries on
+// where it stopped. Stdin can't be reopened.
+func (s *ArchiveSource) Reopen(ctx context.Context) error {
+	if s.name == "-" {
+		return errors.New("stdin cannot be reopened")
+	}
+	fresh, err := OpenArchive(s.name, s.skipHeader)
+	if err != nil {
+		return err
+	}
+	for fresh.records < s.records {
+		if _, err := fresh.ReadMessage(ctx); err != nil {
+			fresh.Close()
+			return fmt.Errorf("skipping the %d records read before: %w", s.records, err)
+		}
+	}
+	s.Close()
+	*s = *fresh
+	return nil
+}
+
+// Close releases the decompressor and closes the archive file.
+func (s *ArchiveSource) Close() error {
+	if s.dec != nil {
+		s.dec.Close()
+	}
+	if s.file != nil {
+		return s.file.Close()
+	}
+	return nil
+}
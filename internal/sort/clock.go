@@ -0,0 +1,77 @@
+package sort
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the time source of a sort: phase timings, per-chunk read deadlines, drain
+// stall detection and retry backoff all go through it, so tests can substitute a
+// manual clock (testutil.FakeClock) and simulate deadlines and stalls without sleeping.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine once d has elapsed on this clock.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending AfterFunc call. Stop reports whether it prevented the call.
+type Timer interface {
+	Stop() bool
+}
+
+// SystemClock is the wall clock, used when no Clock is configured.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+func (o Options) clock() Clock {
+	if o.Clock != nil {
+		return o.Clock
+	}
+	return SystemClock
+}
+
+// sleepOn blocks for d on clock c.
+func sleepOn(c Clock, d time.Duration) {
+	done := make(chan struct{})
+	c.AfterFunc(d, func() { close(done) })
+	<-done
+}
+
+// withDeadline is context.WithDeadline driven by clock c: the context is done, with
+// context.DeadlineExceeded, once c reaches deadline.
+func withDeadline(parent context.Context, c Clock, deadline time.Time) (context.Context, context.CancelFunc) {
+	if _, ok := c.(systemClock); ok {
+		return context.WithDeadline(parent, deadline)
+	}
+	inner, cancel := context.WithCancel(parent)
+	ctx := &deadlineCtx{Context: inner, deadline: deadline}
+	t := c.AfterFunc(deadline.Sub(c.Now()), func() {
+		ctx.expired.Store(true)
+		cancel()
+	})
+	return ctx, func() {
+		t.Stop()
+		cancel()
+	}
+}
+
+type deadlineCtx struct {
+	context.Context
+	deadline time.Time
+	expired  atomic.Bool
+}
+
+func (c *deadlineCtx) Deadline() (time.Time, bool) { return c.deadline, true }
+
+func (c *deadlineCtx) Err() error {
+	if c.expired.Load() {
+		return context.DeadlineExceeded
+	}
+	return c.Context.Err()
+}
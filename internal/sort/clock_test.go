@@ -0,0 +1,145 @@
+package sort_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	extSort "core-infra-project/internal/sort"
+	"core-infra-project/internal/testutil"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// stallingSource delivers its records, then blocks until the read's context is done,
+// like a consumer of an idle topic.
+type stallingSource struct{ msgs []gokafka.Message }
+
+func (s *stallingSource) ReadMessage(ctx context.Context) (gokafka.Message, error) {
+	if len(s.msgs) > 0 {
+		m := s.msgs[0]
+		s.msgs = s.msgs[1:]
+		return m, nil
+	}
+	<-ctx.Done()
+	return gokafka.Message{}, ctx.Err()
+}
+
+func records(n int) []gokafka.Message {
+	msgs := make([]gokafka.Message, n)
+	for i := range msgs {
+		msgs[i] = gokafka.Message{Partition: 0, Offset: int64(i), Value: []byte(fmt.Sprintf("%d,name-%d,address,Europe", i, n-i))}
+	}
+	return msgs
+}
+
+// sortOnClock runs a sort of source timed by clock, advancing clock by step whenever
+// the sort waits on it, and returns its report and how far the clock moved.
+func sortOnClock(t *testing.T, clock *testutil.FakeClock, source extSort.Source, opts extSort.Options, step time.Duration) (*extSort.Report, time.Duration) {
+	t.Helper()
+	opts.Clock = clock
+	type result struct {
+		report *extSort.Report
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		report, err := extSort.ExternalSort(source, &extSort.DiscardSink{}, 1, t.TempDir(), opts)
+		done <- result{report, err}
+	}()
+	var elapsed time.Duration
+	for {
+		select {
+		case r := <-done:
+			if r.err != nil {
+				t.Fatal(r.err)
+			}
+			return r.report, elapsed
+		case <-time.After(time.Millisecond):
+		}
+		if clock.Pending() > 0 {
+			clock.Advance(step)
+			elapsed += step
+		}
+		if elapsed > time.Hour {
+			t.Fatal("sort still waiting after an hour")
+		}
+	}
+}
+
+func newClock() *testutil.FakeClock {
+	return testutil.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+}
+
+func TestReadDeadlineEndsChunkPhase(t *testing.T) {
+	report, elapsed := sortOnClock(t, newClock(), &stallingSource{msgs: records(3)}, extSort.Options{}, time.Second)
+	if report.RecordsRead != 3 {
+		t.Errorf("read %d records, want 3", report.RecordsRead)
+	}
+	if elapsed != 5*time.Second {
+		t.Errorf("chunk phase ended after %v, want the fixed 5s deadline", elapsed)
+	}
+}
+
+func TestDrainStall(t *testing.T) {
+	// The end offset is never reached, e.g. because the last records were compacted away
+	opts := extSort.Options{EndOffsets: map[int]int64{0: 10}}
+	report, elapsed := sortOnClock(t, newClock(), &stallingSource{msgs: records(3)}, opts, 5*time.Second)
+	if report.RecordsRead != 3 {
+		t.Errorf("read %d records, want 3", report.RecordsRead)
+	}
+	if elapsed <= time.Minute || elapsed > time.Minute+10*time.Second {
+		t.Errorf("stalled drain ended after %v, want just over a minute", elapsed)
+	}
+}
+
+// fetchingSource delivers its records in fetched batches, each once the clock reaches
+// its time, then blocks like stallingSource.
+type fetchingSource struct {
+	clock *testutil.FakeClock
+	start time.Time
+	at    []time.Duration // of each batch
+	size  int
+	msgs  []gokafka.Message
+}
+
+func (s *fetchingSource) ReadMessage(ctx context.Context) (gokafka.Message, error) {
+	next := len(s.at)*s.size - len(s.msgs)
+	if len(s.msgs) == 0 {
+		<-ctx.Done()
+		return gokafka.Message{}, ctx.Err()
+	}
+	if wait := s.start.Add(s.at[next/s.size]).Sub(s.clock.Now()); wait > 0 {
+		ready := make(chan struct{})
+		timer := s.clock.AfterFunc(wait, func() { close(ready) })
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			timer.Stop()
+			return gokafka.Message{}, ctx.Err()
+		}
+	}
+	m := s.msgs[0]
+	s.msgs = s.msgs[1:]
+	return m, nil
+}
+
+func TestAdaptiveReadDeadlineWaitsOutSlowFetches(t *testing.T) {
+	clock := newClock()
+	// Fetches 2-3s apart, each delivering its records at once
+	at := []time.Duration{0, 2 * time.Second, 4 * time.Second, 6 * time.Second, 8 * time.Second, 11 * time.Second}
+	source := &fetchingSource{clock: clock, start: clock.Now(), at: at, size: 100, msgs: records(600)}
+	opts := extSort.Options{ReadDeadlineMin: time.Second, ReadDeadlineMax: 30 * time.Second}
+	report, elapsed := sortOnClock(t, clock, source, opts, 100*time.Millisecond)
+	if report.RecordsRead != 600 {
+		t.Fatalf("read %d records, want 600", report.RecordsRead)
+	}
+	if report.ReadDeadline < 4*time.Second || report.ReadDeadline >= 30*time.Second {
+		t.Errorf("read deadline %v, want one fitted to 2-3s fetch gaps", report.ReadDeadline)
+	}
+	// The last chunk ends after the fitted deadline, the empty one after the maximum
+	if elapsed < 11*time.Second+report.ReadDeadline+30*time.Second-time.Second {
+		t.Errorf("chunk phase ended after %v, before an empty chunk waited 30s", elapsed)
+	}
+}
@@ -0,0 +1,173 @@
+package sort
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"core-infra-project/internal/fastnum"
+)
+
+// coalesceFraction makes a run small when it holds fewer than 1/coalesceFraction of
+// a full chunk's records.
+const coalesceFraction = 4
+
+// coalesceRuns rewrites every stretch of two or more adjacent small runs (e.g. chunks
+// cut short by read timeouts) as one run of at most chunkSize records, keeping the
+// fan-in of the final merge low and its heap small enough to stay in cache. A stretch
+// fits in memory like any chunk, so it is read back, re-sorted and spilled in place
+// of its runs, whose files are then removed. Runs keep their relative order, so equal
+// keys still prefer earlier input. It also returns the number of runs coalesced.
+func coalesceRuns(runs []Run, chunkSize int, tempDir string, sortKeyIndex int) ([]Run, int, error) {
+	small := func(r Run) bool { return r.Records < chunkSize/coalesceFraction }
+	var out []Run
+	var folded int
+	for i := 0; i < len(runs); {
+		j, records := i, 0
+		for j < len(runs) && small(runs[j]) && records+runs[j].Records <= chunkSize {
+			records += runs[j].Records
+			j++
+		}
+		if j-i < 2 {
+			out = append(out, runs[i])
+			i++
+			continue
+		}
+		r, err := coalesce(runs[i:j], records, filepath.Join(tempDir, fmt.Sprintf("coalesced_%d.tmp", i)), sortKeyIndex)
+		if err != nil {
+			return nil, folded, err
+		}
+		folded += j - i
+		fmt.Printf("[Phase 1] Coalesced chunks %s..%s: %d records into %s\n",
+			filepath.Base(runs[i].Path), filepath.Base(runs[j-1].Path), records, filepath.Base(r.Path))
+		out = append(out, r)
+		i = j
+	}
+	return out, folded, nil
+}
+
+// coalesce spills the records of runs, holding n in total, as one run at fpath.
+func coalesce(runs []Run, n int, fpath string, sortKeyIndex int) (Run, error) {
+	set := runs[0].set
+	opts := set.opts
+	keys := newKeyExtractor(sortKeyIndex, opts)
+	records := make([]recordWithKey, 0, n)
+	for _, r := range runs {
+		var err error
+		if records, err = loadRun(records, r, sortKeyIndex); err != nil {
+			return Run{}, err
+		}
+	}
+	sortChunk(records, keys.intKey, opts.Ties)
+
+	codec := opts.SpillCompression.Codec()
+	var err error
+	if opts.Payloads != nil {
+		err = writeRefChunk(fpath, records, keys.intKey, codec, set.key, opts.spillFormat(), opts.ioBufferSize())
+	} else {
+		err = writeChunk(fpath, records, codec, set.key, opts.spillFormat(), opts.ioBufferSize(), opts.BinaryValues)
+	}
+	if err != nil {
+		return Run{}, err
+	}
+	if set.latest != nil {
+		if err := writeSeqs(seqPath(fpath), records); err != nil {
+			return Run{}, err
+		}
+	}
+	if opts.CarryHeaders {
+		if err := writeMetas(metaPath(fpath), records); err != nil {
+			return Run{}, err
+		}
+	}
+	if err := Cleanup(runs); err != nil {
+		return Run{}, err
+	}
+	info := chunkInfo(fpath, records, keys, opts.spillFormat())
+	if set.key != nil {
+		info.MinKey, info.MaxKey = "", ""
+	}
+	return Run{Path: fpath, ChunkInfo: info, set: set}, nil
+}
+
+// loadRun appends the records of r, with their keys and sidecar fields, to records.
+func loadRun(records []recordWithKey, r Run, sortKeyIndex int) ([]recordWithKey, error) {
+	set := r.set
+	opts := set.opts
+	sc, err := openChunk(r.Path, opts.SpillCompression.Codec(), set.key, opts)
+	if err != nil {
+		return records, err
+	}
+	defer sc.close()
+	sc.unescape = opts.BinaryValues
+	var sidecarFiles []*os.File
+	defer func() {
+		for _, f := range sidecarFiles {
+			f.Close()
+		}
+	}()
+	openSidecar := func(path string) (*bufio.Reader, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		sidecarFiles = append(sidecarFiles, f)
+		return bufio.NewReaderSize(f, 64<<10), nil
+	}
+	var seqs, metas *bufio.Reader
+	if set.latest != nil {
+		if seqs, err = openSidecar(seqPath(r.Path)); err != nil {
+			return records, err
+		}
+	}
+	if opts.CarryHeaders {
+		if metas, err = openSidecar(metaPath(r.Path)); err != nil {
+			return records, err
+		}
+	}
+
+	keys := newKeyExtractor(sortKeyIndex, opts)
+	for {
+		line, err := sc.next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, fmt.Errorf("chunk %s: %w", sc.Name(), err)
+		}
+		var rec recordWithKey
+		if opts.Payloads != nil {
+			off, n, key, err := parseRef(line)
+			if err != nil {
+				return records, fmt.Errorf("chunk %s: %w", sc.Name(), err)
+			}
+			if rec.data, err = opts.Payloads.readAt(off, n); err != nil {
+				return records, err
+			}
+			rec.off = off
+			if keys.intKey {
+				rec.keyInt = fastnum.LeadingInt(key)
+			} else {
+				rec.keyStr = string(key)
+			}
+		} else {
+			rec.data = line
+			if err := keys.fill(&rec); err != nil {
+				return records, fmt.Errorf("chunk %s: %w", sc.Name(), err)
+			}
+		}
+		if seqs != nil {
+			if rec.seq, err = readSeq(seqs); err != nil {
+				return records, fmt.Errorf("chunk %s sequence sidecar: %w", sc.Name(), err)
+			}
+		}
+		if metas != nil {
+			if rec.meta, err = readMeta(metas); err != nil {
+				return records, fmt.Errorf("chunk %s metadata sidecar: %w", sc.Name(), err)
+			}
+		}
+		records = append(records, rec)
+	}
+}
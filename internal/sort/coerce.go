@@ -0,0 +1,117 @@
+package sort
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"core-infra-project/internal/fastnum"
+)
+
+// KeyCoercionPolicy selects how the chunk phase handles CSV records whose numeric sort
+// key field does not parse (e.g. "12a", "" or a name where an id belongs). An integer
+// key is read up to its first non-digit, so it sorts as that prefix, or as 0 without
+// one, and a float key sorts as 0: a data-quality problem that would otherwise only
+// show as a wrong order. A JSON integer key that is missing or null sorts as 0 too and
+// is handled the same way; JSON keys that do not parse and decoded keys fail the sort
+// instead, as they always have.
+type KeyCoercionPolicy int
+
+const (
+	// CoercionWarn sorts such records by the prefix, counting them and logging the
+	// first few. It is the default.
+	CoercionWarn KeyCoercionPolicy = iota
+	// CoercionFail fails the sort at the first one.
+	CoercionFail
+	// CoercionDLQ forwards them to Options.DeadLetters, when set, with a
+	// DeadLetterReasonHeader, and drops them from the sort.
+	CoercionDLQ
+)
+
+// UnmarshalText parses warn, fail or dlq.
+func (p *KeyCoercionPolicy) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "warn":
+		*p = CoercionWarn
+	case "fail":
+		*p = CoercionFail
+	case "dlq":
+		*p = CoercionDLQ
+	default:
+		return fmt.Errorf("unknown key coercion policy %q (want warn, fail or dlq)", b)
+	}
+	return nil
+}
+
+func (p KeyCoercionPolicy) String() string {
+	return [...]string{"warn", "fail", "dlq"}[p]
+}
+
+// badKey is a numeric key field that does not parse, or a JSON one that is missing.
+type badKey struct {
+	field   []byte
+	kind    string // integer or float
+	sortsAs string
+	path    string // the missing JSON key path
+}
+
+func (b badKey) String() string {
+	if b.path != "" {
+		return fmt.Sprintf("%s key %s is missing or null", b.kind, b.path)
+	}
+	return fmt.Sprintf("%s key %q does not parse", b.kind, b.field)
+}
+
+// uncoercible returns the first numeric key field of the CSV record val that does not
+// parse, or the integer key path of a JSON record that it lacks, and whether there is
+// one. Tombstones (nil values) have no key to coerce.
+func (k keyExtractor) uncoercible(val []byte) (badKey, bool) {
+	if val == nil || len(val) < k.skip {
+		return badKey{}, false
+	}
+	val = val[k.skip:]
+	if k.path != nil {
+		if !k.intKey || k.decoder != nil {
+			return badKey{}, false
+		}
+		// A path that does not parse fails the sort when the key is extracted
+		if raw, err := jsonPath(val, k.path); err == nil && raw == nil {
+			return badKey{kind: "integer", sortsAs: "0", path: strings.Join(k.path, ".")}, true
+		}
+		return badKey{}, false
+	}
+	if k.parts != nil {
+		for _, p := range k.parts {
+			if b, bad := checkNumeric(csvField(val, p.Index), p.Int, p.Float); bad {
+				return b, true
+			}
+		}
+		return badKey{}, false
+	}
+	if !k.intKey {
+		return badKey{}, false
+	}
+	field := csvField(val, 0)
+	if k.typed {
+		field = csvField(val, k.sortKeyIndex)
+	}
+	return checkNumeric(field, !k.float, k.float)
+}
+
+func checkNumeric(field []byte, isInt, isFloat bool) (badKey, bool) {
+	switch {
+	case isInt && !wholeInt(field):
+		return badKey{field: field, kind: "integer", sortsAs: strconv.FormatInt(fastnum.LeadingInt(field), 10)}, true
+	case isFloat:
+		if _, ok := parseFloat(field); !ok {
+			return badKey{field: field, kind: "float", sortsAs: "0"}, true
+		}
+	}
+	return badKey{}, false
+}
+
+// wholeInt reports whether field is an integer that fastnum.LeadingInt reads in full.
+func wholeInt(field []byte) bool {
+	n, err := fastnum.ParseInt(field)
+	return err == nil && n == fastnum.LeadingInt(field)
+}
@@ -0,0 +1,62 @@
+package sort
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// collator turns string keys into keys that sort in a locale's collation order when
+// compared as bytes (Options.Locale). Collation keys are computed once per record, as
+// the key is extracted, so comparisons in the chunk sort and the merge heap stay plain
+// string comparisons; computing them costs about as much as a few comparisons.
+//
+// An encoded key is the hex digits of the collation key, then '.', which sorts before
+// any digit so that shorter collation keys come first, then the key itself: keys that
+// collate equal (e.g. under the root locale, differing only in ignorable characters)
+// are ordered by their bytes, and display strips the prefix again.
+type collator struct {
+	locale string
+	states sync.Pool // of *collatorState; a collate.Collator is not safe for concurrent use
+}
+
+type collatorState struct {
+	c   *collate.Collator
+	buf collate.Buffer
+}
+
+// newCollator returns the collator of a BCP 47 language tag such as "de", "sv" or
+// "und" (the root collation, for no locale in particular).
+func newCollator(locale string) (*collator, error) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return nil, fmt.Errorf("invalid locale %q: %w", locale, err)
+	}
+	c := &collator{locale: locale}
+	c.states.New = func() any { return &collatorState{c: collate.New(tag)} }
+	return c, nil
+}
+
+// key encodes s as described on collator.
+func (c *collator) key(s string) string {
+	st := c.states.Get().(*collatorState)
+	ck := st.c.KeyFromString(&st.buf, s)
+	buf := make([]byte, 0, 2*len(ck)+1+len(s))
+	for _, b := range ck {
+		buf = append(buf, hexDigits[b>>4], hexDigits[b&15])
+	}
+	buf = append(buf, ascEnd)
+	buf = append(buf, s...)
+	st.buf.Reset()
+	c.states.Put(st)
+	return string(buf)
+}
+
+// display returns the key an encoded key was made from.
+func (c *collator) display(key string) string {
+	_, s, _ := strings.Cut(key, string(ascEnd))
+	return s
+}
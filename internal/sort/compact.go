@@ -0,0 +1,169 @@
+package sort
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// TombstonePolicy selects how null-value records (tombstones of compacted topics)
+// are handled during the chunk phase.
+type TombstonePolicy int
+
+const (
+	// TombstonesInclude sorts tombstones as empty records, the historical behavior.
+	TombstonesInclude TombstonePolicy = iota
+	// TombstonesSkip drops tombstones.
+	TombstonesSkip
+	// TombstonesDLQ forwards tombstones to Options.DeadLetters and drops them from the sort.
+	TombstonesDLQ
+)
+
+// UnmarshalText parses include, skip or dlq.
+func (p *TombstonePolicy) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "include":
+		*p = TombstonesInclude
+	case "skip":
+		*p = TombstonesSkip
+	case "dlq":
+		*p = TombstonesDLQ
+	default:
+		return fmt.Errorf("unknown tombstone policy %q (want include, skip or dlq)", b)
+	}
+	return nil
+}
+
+func (p TombstonePolicy) String() string {
+	return [...]string{"include", "skip", "dlq"}[p]
+}
+
+// latestFilter keeps only the latest value per Kafka message key. Phase 1 numbers every
+// message read and marks a key's earlier occurrences superseded as newer ones arrive;
+// since a later occurrence may land in a later chunk, the merge does the actual
+// dropping, using a sequence sidecar written next to each chunk.
+// Messages without a key are never superseded.
+type latestFilter struct {
+	latest     map[string]int64
+	superseded []uint64 // bitset indexed by sequence number
+}
+
+func newLatestFilter() *latestFilter {
+	return &latestFilter{latest: make(map[string]int64)}
+}
+
+// observe records that message seq carries key, superseding the key's previous message.
+func (f *latestFilter) observe(key []byte, seq int64) {
+	if len(key) == 0 {
+		return
+	}
+	if prev, ok := f.latest[string(key)]; ok {
+		for int(prev/64) >= len(f.superseded) {
+			f.superseded = append(f.superseded, 0)
+		}
+		f.superseded[prev/64] |= 1 << (prev % 64)
+	}
+	f.latest[string(key)] = seq
+}
+
+func (f *latestFilter) isSuperseded(seq int64) bool {
+	i := int(seq / 64)
+	return i < len(f.superseded) && f.superseded[i]&(1<<(seq%64)) != 0
+}
+
+// seqPath returns the sequence sidecar of a chunk file.
+func seqPath(chunk string) string { return chunk + ".seq" }
+
+// writeSeqs writes the sequence numbers of a sorted chunk in record order.
+func writeSeqs(path string, records []recordWithKey) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bw := bufio.NewWriterSize(f, 1<<20)
+	var buf [8]byte
+	for _, r := range records {
+		binary.LittleEndian.PutUint64(buf[:], uint64(r.seq))
+		if _, err := bw.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// countSuperseded returns how many records of chunks the merge will drop as
+// superseded, reading their sequence sidecars.
+func (f *latestFilter) countSuperseded(chunks []string) (int64, error) {
+	var n int64
+	for _, chunk := range chunks {
+		sf, err := os.Open(seqPath(chunk))
+		if err != nil {
+			return 0, err
+		}
+		r := bufio.NewReaderSize(sf, 64<<10)
+		for {
+			seq, err := readSeq(r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				sf.Close()
+				return 0, fmt.Errorf("%s: %w", seqPath(chunk), err)
+			}
+			if f.isSuperseded(seq) {
+				n++
+			}
+		}
+		sf.Close()
+	}
+	return n, nil
+}
+
+// readSeq reads the next sequence number from a sidecar written by writeSeqs.
+func readSeq(r io.Reader) (int64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(buf[:])), nil
+}
+
+// DeadLetterReasonHeader tells why a record other than a tombstone was dead-lettered
+// (e.g. "oversized").
+const DeadLetterReasonHeader = "kss-dlq-reason"
+
+// deadLetterBatch buffers tombstones and rejected records for Options.DeadLetters.
+type deadLetterBatch struct {
+	sink Sink
+	msgs []gokafka.Message
+}
+
+// add queues m, tagged with reason unless it is empty.
+func (d *deadLetterBatch) add(ctx context.Context, m gokafka.Message, reason string) error {
+	dead := gokafka.Message{Key: m.Key, Value: m.Value, Headers: m.Headers, Time: m.Time}
+	if reason != "" {
+		dead.Headers = append(m.Headers[:len(m.Headers):len(m.Headers)], gokafka.Header{Key: DeadLetterReasonHeader, Value: []byte(reason)})
+	}
+	d.msgs = append(d.msgs, dead)
+	if len(d.msgs) >= 1000 {
+		return d.flush(ctx)
+	}
+	return nil
+}
+
+func (d *deadLetterBatch) flush(ctx context.Context) error {
+	if len(d.msgs) == 0 {
+		return nil
+	}
+	if err := d.sink.WriteMessages(ctx, d.msgs...); err != nil {
+		return fmt.Errorf("dead letter sink: %w", err)
+	}
+	d.msgs = d.msgs[:0]
+	return nil
+}
@@ -0,0 +1,87 @@
+package sort
+
+import "time"
+
+// fixedReadDeadline is how long a chunk reads before a silent source counts as
+// drained when Options.ReadDeadlineMin and ReadDeadlineMax are not set.
+const fixedReadDeadline = 5 * time.Second
+
+// fetchGap is the shortest gap between two records that counts as a wait for the
+// broker. Readers deliver whole fetched batches, whose records arrive microseconds
+// apart; those gaps say nothing about how long the next fetch takes.
+const fetchGap = time.Millisecond
+
+// readDeadline decides how long the chunk phase waits for the next record. With
+// bounds set it is an idle timeout derived from the gaps between fetches seen so
+// far, the way TCP derives its retransmission timeout from round trips: a smoothed
+// gap plus four times its smoothed variation, but at least twice the gap so a
+// steady stream's jitter does not end it, clamped to [min, max]. A timeout only ends
+// the chunk, which is spilled; the source counts as drained once a chunk saw no
+// record for max, so a broker that pauses for seconds is not mistaken for an empty
+// one. Without bounds it is the fixed 5s per chunk, after which the source counts as
+// drained.
+type readDeadline struct {
+	min, max time.Duration // both 0 for the fixed deadline
+
+	gap, dev time.Duration // smoothed gap between fetches and its variation
+	sampled  bool
+	last     time.Time // last arrival, zero after restart
+	idle     time.Time // last arrival or restart
+}
+
+func newReadDeadline(opts Options) *readDeadline {
+	return &readDeadline{min: opts.ReadDeadlineMin, max: opts.ReadDeadlineMax}
+}
+
+func (d *readDeadline) adaptive() bool { return d.max > 0 }
+
+// timeout returns how long to wait for the next record: max until a gap was seen.
+func (d *readDeadline) timeout() time.Duration {
+	if !d.adaptive() {
+		return fixedReadDeadline
+	}
+	if !d.sampled {
+		return d.max
+	}
+	return min(max(d.gap+4*d.dev, 2*d.gap, d.min), d.max)
+}
+
+// restart starts a chunk's reads, or resumes them after a recovery backoff, at now:
+// the time since the last arrival is not a gap between fetches.
+func (d *readDeadline) restart(now time.Time) time.Time {
+	d.last, d.idle = time.Time{}, now
+	return now.Add(d.timeout())
+}
+
+// arrived records a message read at now and returns the deadline for the next read,
+// which is deadline itself unless adaptive.
+func (d *readDeadline) arrived(now, deadline time.Time) time.Time {
+	if !d.adaptive() {
+		return deadline
+	}
+	if gap := now.Sub(d.last); !d.last.IsZero() && gap >= fetchGap {
+		if !d.sampled {
+			d.gap, d.dev, d.sampled = gap, gap/2, true
+		} else {
+			diff := gap - d.gap
+			if diff < 0 {
+				diff = -diff
+			}
+			d.dev += (diff - d.dev) / 4
+			d.gap += (gap - d.gap) / 8
+		}
+	}
+	d.last, d.idle = now, now
+	return now.Add(d.timeout())
+}
+
+// holdOut returns when an empty chunk whose read timed out at now may take the
+// source as drained, and whether that is still ahead: an adaptive deadline waits
+// max since the last record (or the chunk's start) first.
+func (d *readDeadline) holdOut(now time.Time) (time.Time, bool) {
+	if !d.adaptive() {
+		return time.Time{}, false
+	}
+	until := d.idle.Add(d.max)
+	return until, now.Before(until)
+}
@@ -0,0 +1,272 @@
+package sort
+
+import (
+	"context"
+	"fmt"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// DiffKind is how a record differs between two generations of a dataset.
+type DiffKind int
+
+const (
+	DiffAdded   DiffKind = iota // only in the new generation
+	DiffRemoved                 // only in the old generation
+	DiffChanged                 // the key's record differs between them
+)
+
+func (k DiffKind) String() string {
+	return [...]string{"added", "removed", "changed"}[k]
+}
+
+// DiffEntry is one difference found by DiffSorted.
+type DiffEntry struct {
+	Kind DiffKind
+	Key  string // as in logs and the manifest
+	Old  []byte // nil when added
+	New  []byte // nil when removed
+}
+
+// DiffStats counts what DiffSorted compared and found.
+type DiffStats struct {
+	OldRecords, NewRecords int64
+	Unchanged              int64
+	Added, Removed         int64
+	Changed                int64
+}
+
+// DiffSorted compares two sorted generations of a dataset, each given as sorted inputs
+// (as for MergeSorted), and calls emit with their differences in key order. Both sides
+// are k-way merged and walked together a key at a time, so memory holds one key's
+// records rather than a generation. Within a key, records present in both are
+// unchanged; the others pair up in order as changed, and the rest are added or
+// removed. A key with more than maxGroup records on either side fails the diff, since
+// its records are held in memory: diff by a key that is unique, such as id. It closes
+// the inputs.
+func DiffSorted(ctx context.Context, older, newer []MergeInput, sortKeyIndex int, opts Options, maxGroup int, emit func(DiffEntry) error) (DiffStats, error) {
+	defer func() {
+		for _, in := range older {
+			_ = in.Close()
+		}
+		for _, in := range newer {
+			_ = in.Close()
+		}
+	}()
+	if err := checkKey(sortKeyIndex, opts); err != nil {
+		return DiffStats{}, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	keys := newKeyExtractor(sortKeyIndex, opts)
+	a := startDiffSide(ctx, "old", older, sortKeyIndex, opts, keys)
+	b := startDiffSide(ctx, "new", newer, sortKeyIndex, opts, keys)
+	defer func() {
+		cancel()
+		a.wait()
+		b.wait()
+	}()
+
+	var stats DiffStats
+	for {
+		if err := a.fill(); err != nil {
+			return stats, err
+		}
+		if err := b.fill(); err != nil {
+			return stats, err
+		}
+		if a.head == nil && b.head == nil {
+			break
+		}
+		c := compareDiffHeads(a.head, b.head, keys.intKey)
+		var olds, news []recordWithKey
+		var err error
+		if c <= 0 {
+			if olds, err = a.group(maxGroup); err != nil {
+				return stats, err
+			}
+		}
+		if c >= 0 {
+			if news, err = b.group(maxGroup); err != nil {
+				return stats, err
+			}
+		}
+		stats.OldRecords += int64(len(olds))
+		stats.NewRecords += int64(len(news))
+		if err := diffGroup(olds, news, keys, &stats, emit); err != nil {
+			return stats, err
+		}
+	}
+	if err := a.wait(); err != nil {
+		return stats, err
+	}
+	return stats, b.wait()
+}
+
+// compareDiffHeads orders two sides' next records by key; an exhausted side (nil)
+// sorts after every key.
+func compareDiffHeads(a, b *recordWithKey, intKey bool) int {
+	switch {
+	case b == nil:
+		return -1
+	case a == nil:
+		return 1
+	case intKey && a.keyInt != b.keyInt:
+		if a.keyInt < b.keyInt {
+			return -1
+		}
+		return 1
+	case !intKey && a.keyStr != b.keyStr:
+		if a.keyStr < b.keyStr {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// diffGroup emits the differences between the records one key has in the old and the
+// new generation.
+func diffGroup(olds, news []recordWithKey, keys keyExtractor, stats *DiffStats, emit func(DiffEntry) error) error {
+	r := olds
+	if len(r) == 0 {
+		r = news
+	}
+	key := displayKey(r[0], keys)
+
+	if len(olds) == 1 && len(news) == 1 && string(olds[0].data) == string(news[0].data) {
+		stats.Unchanged++
+		return nil
+	}
+	var removed, added [][]byte
+	left := make(map[string]int, len(olds))
+	for _, o := range olds {
+		left[string(o.data)]++
+	}
+	for _, n := range news {
+		if left[string(n.data)] > 0 {
+			left[string(n.data)]--
+			stats.Unchanged++
+		} else {
+			added = append(added, n.data)
+		}
+	}
+	for _, o := range olds {
+		if left[string(o.data)] > 0 {
+			left[string(o.data)]--
+			removed = append(removed, o.data)
+		}
+	}
+
+	for len(removed) > 0 && len(added) > 0 {
+		stats.Changed++
+		if err := emit(DiffEntry{Kind: DiffChanged, Key: key, Old: removed[0], New: added[0]}); err != nil {
+			return err
+		}
+		removed, added = removed[1:], added[1:]
+	}
+	for _, o := range removed {
+		stats.Removed++
+		if err := emit(DiffEntry{Kind: DiffRemoved, Key: key, Old: o}); err != nil {
+			return err
+		}
+	}
+	for _, n := range added {
+		stats.Added++
+		if err := emit(DiffEntry{Kind: DiffAdded, Key: key, New: n}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffSide is one generation's merged record stream. The merge runs in its own
+// goroutine, writing batches to a channel the diff reads from.
+type diffSide struct {
+	name    string
+	keys    keyExtractor
+	batches chan [][]byte
+	done    chan struct{}
+	err     error // of the merge, once done is closed
+
+	batch [][]byte
+	head  *recordWithKey // next record, nil once exhausted
+	eof   bool
+}
+
+func startDiffSide(ctx context.Context, name string, inputs []MergeInput, sortKeyIndex int, opts Options, keys keyExtractor) *diffSide {
+	s := &diffSide{name: name, keys: keys, batches: make(chan [][]byte, 4), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		defer close(s.batches)
+		_, s.err = mergeInputs(ctx, inputs, sidecars{}, diffSink{s.batches}, sortKeyIndex, opts, nil, nil)
+		if s.err != nil {
+			s.err = fmt.Errorf("%s generation: %w", name, s.err)
+		}
+	}()
+	return s
+}
+
+// diffSink hands merged batches to a diffSide, copying the records, which the merge
+// reuses.
+type diffSink struct{ out chan<- [][]byte }
+
+func (d diffSink) WriteMessages(ctx context.Context, msgs ...gokafka.Message) error {
+	batch := make([][]byte, len(msgs))
+	for i, m := range msgs {
+		batch[i] = append([]byte(nil), m.Value...)
+	}
+	select {
+	case d.out <- batch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fill loads the next record into head, if there is one.
+func (s *diffSide) fill() error {
+	for s.head == nil && !s.eof {
+		if len(s.batch) == 0 {
+			batch, ok := <-s.batches
+			if !ok {
+				s.eof = true
+				return s.wait()
+			}
+			s.batch = batch
+			continue
+		}
+		r := &recordWithKey{data: s.batch[0]}
+		s.batch = s.batch[1:]
+		if err := s.keys.fill(r); err != nil {
+			return fmt.Errorf("%s generation: %w", s.name, err)
+		}
+		s.head = r
+	}
+	return nil
+}
+
+// group takes the records sharing head's key, up to maxGroup.
+func (s *diffSide) group(maxGroup int) ([]recordWithKey, error) {
+	first := *s.head
+	group := []recordWithKey{first}
+	for {
+		s.head = nil
+		if err := s.fill(); err != nil {
+			return nil, err
+		}
+		if s.head == nil || compareDiffHeads(&first, s.head, s.keys.intKey) != 0 {
+			return group, nil
+		}
+		if len(group) == maxGroup {
+			return nil, fmt.Errorf("key %s has more than %d records in the %s generation; diff by a unique key such as id, or raise the limit", displayKey(first, s.keys), maxGroup, s.name)
+		}
+		group = append(group, *s.head)
+	}
+}
+
+// wait returns the merge's error once it has finished.
+func (s *diffSide) wait() error {
+	<-s.done
+	return s.err
+}
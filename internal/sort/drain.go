@@ -0,0 +1,77 @@
+package sort
+
+import (
+	"sort"
+	"time"
+)
+
+// drainIdleLimit bounds how long the chunk phase keeps waiting for partitions that
+// have not reached their end offsets. Offsets of aborted transactions or of records
+// compacted away are never delivered, so an end offset is not always reachable.
+const drainIdleLimit = time.Minute
+
+// drainTracker decides when the source is fully read from the end offset of each of
+// its partitions (Options.EndOffsets) instead of from read timeouts, which fire early
+// when some partitions are idle and others are still being fetched.
+type drainTracker struct {
+	pending  map[int]int64 // partition -> end offset, removed once reached
+	clock    Clock
+	progress time.Time // last time any record arrived
+}
+
+func newDrainTracker(end map[int]int64, clock Clock) *drainTracker {
+	pending := make(map[int]int64, len(end))
+	for p, e := range end {
+		pending[p] = e
+	}
+	return &drainTracker{pending: pending, clock: clock, progress: clock.Now()}
+}
+
+// observe records that the message at offset of partition was read.
+func (d *drainTracker) observe(partition int, offset int64) {
+	d.progress = d.clock.Now()
+	if end, ok := d.pending[partition]; ok && offset+1 >= end {
+		delete(d.pending, partition)
+	}
+}
+
+// done reports whether every partition has been read to its end offset.
+func (d *drainTracker) done() bool { return len(d.pending) == 0 }
+
+// stalled reports whether no record arrived for drainIdleLimit.
+func (d *drainTracker) stalled() bool { return d.clock.Now().Sub(d.progress) > drainIdleLimit }
+
+// pendingPartitions lists the partitions still short of their end offsets.
+func (d *drainTracker) pendingPartitions() []int {
+	parts := make([]int, 0, len(d.pending))
+	for p := range d.pending {
+		parts = append(parts, p)
+	}
+	sort.Ints(parts)
+	return parts
+}
+
+// markerTracker decides when the source is fully read from the end-of-stream markers
+// of its partitions (Options.EndMarkers).
+type markerTracker struct {
+	want     int
+	seen     map[int]bool
+	clock    Clock
+	progress time.Time // last time any message arrived
+}
+
+func newMarkerTracker(want int, clock Clock) *markerTracker {
+	return &markerTracker{want: want, seen: make(map[int]bool, want), clock: clock, progress: clock.Now()}
+}
+
+// observe records the marker of partition.
+func (m *markerTracker) observe(partition int) {
+	m.progress = m.clock.Now()
+	m.seen[partition] = true
+}
+
+// done reports whether every partition delivered its marker.
+func (m *markerTracker) done() bool { return len(m.seen) >= m.want }
+
+// stalled reports whether no message arrived for drainIdleLimit.
+func (m *markerTracker) stalled() bool { return m.clock.Now().Sub(m.progress) > drainIdleLimit }
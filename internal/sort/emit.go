@@ -0,0 +1,44 @@
+package sort
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Emit selects what the merge writes for each merged record.
+type Emit int
+
+const (
+	// EmitRecords writes the records themselves, the historical behavior.
+	EmitRecords Emit = iota
+	// EmitKeys writes only each record's sort key, as compared (after normalization).
+	EmitKeys
+	// EmitKeyCounts writes each distinct key once, as "key,count".
+	EmitKeyCounts
+)
+
+// UnmarshalText parses records, keys or counts.
+func (e *Emit) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "records":
+		*e = EmitRecords
+	case "keys":
+		*e = EmitKeys
+	case "counts":
+		*e = EmitKeyCounts
+	default:
+		return fmt.Errorf("unknown emit mode %q (want records, keys or counts)", b)
+	}
+	return nil
+}
+
+func (e Emit) String() string {
+	return [...]string{"records", "keys", "counts"}[e]
+}
+
+// appendKeyCount formats an EmitKeyCounts record.
+func appendKeyCount(dst []byte, key string, count int64) []byte {
+	dst = append(dst, key...)
+	dst = append(dst, ',')
+	return strconv.AppendInt(dst, count, 10)
+}
@@ -0,0 +1,146 @@
+package sort
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	gokafka "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/compress"
+)
+
+// ValueEncoding is an ordered list of per-record wrapping steps (base64, gzip, snappy,
+// lz4, zstd) applied by the producer of a topic. Decode undoes them in the listed order,
+// e.g. "base64,gzip" base64-decodes and then gunzips; Encode reapplies them in reverse.
+// This is independent of Kafka's batch compression, which the client handles itself.
+type ValueEncoding struct {
+	steps []string
+}
+
+// UnmarshalText parses a comma-separated list of steps.
+func (e *ValueEncoding) UnmarshalText(b []byte) error {
+	e.steps = nil
+	if len(b) == 0 {
+		return nil
+	}
+	for _, step := range strings.Split(string(b), ",") {
+		step = strings.TrimSpace(step)
+		if step != "base64" {
+			var c compress.Compression
+			if err := c.UnmarshalText([]byte(step)); err != nil || c == compress.None {
+				return fmt.Errorf("unknown value encoding step %q (want base64, gzip, snappy, lz4 or zstd)", step)
+			}
+		}
+		e.steps = append(e.steps, step)
+	}
+	return nil
+}
+
+func (e ValueEncoding) String() string { return strings.Join(e.steps, ",") }
+
+// IsZero reports whether e has no steps.
+func (e ValueEncoding) IsZero() bool { return len(e.steps) == 0 }
+
+// Decode unwraps v. Null values (tombstones) are returned unchanged.
+func (e ValueEncoding) Decode(v []byte) ([]byte, error) {
+	for _, step := range e.steps {
+		if v == nil {
+			return nil, nil
+		}
+		var err error
+		if step == "base64" {
+			out := make([]byte, base64.StdEncoding.DecodedLen(len(v)))
+			var n int
+			n, err = base64.StdEncoding.Decode(out, v)
+			v = out[:n]
+		} else {
+			r := codecFor(step).NewReader(bytes.NewReader(v))
+			v, err = io.ReadAll(r)
+			r.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s decode: %w", step, err)
+		}
+	}
+	return v, nil
+}
+
+// Encode wraps v with the steps in reverse order, inverting Decode.
+func (e ValueEncoding) Encode(v []byte) ([]byte, error) {
+	for i := len(e.steps) - 1; i >= 0 && v != nil; i-- {
+		step := e.steps[i]
+		if step == "base64" {
+			out := make([]byte, base64.StdEncoding.EncodedLen(len(v)))
+			base64.StdEncoding.Encode(out, v)
+			v = out
+			continue
+		}
+		var buf bytes.Buffer
+		w := codecFor(step).NewWriter(&buf)
+		if _, err := w.Write(v); err != nil {
+			return nil, fmt.Errorf("%s encode: %w", step, err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("%s encode: %w", step, err)
+		}
+		v = buf.Bytes()
+	}
+	return v, nil
+}
+
+func codecFor(step string) compress.Codec {
+	var c compress.Compression
+	_ = c.UnmarshalText([]byte(step)) // validated by UnmarshalText of ValueEncoding
+	return c.Codec()
+}
+
+// DecodingSource unwraps every value read from the wrapped source before it is sorted.
+type DecodingSource struct {
+	src Source
+	enc ValueEncoding
+}
+
+// NewDecodingSource wraps src, decoding values with enc.
+func NewDecodingSource(src Source, enc ValueEncoding) *DecodingSource {
+	return &DecodingSource{src: src, enc: enc}
+}
+
+// ReadMessage implements Source.
+func (d *DecodingSource) ReadMessage(ctx context.Context) (gokafka.Message, error) {
+	msg, err := d.src.ReadMessage(ctx)
+	if err != nil {
+		return msg, err
+	}
+	if msg.Value, err = d.enc.Decode(msg.Value); err != nil {
+		return msg, fmt.Errorf("partition %d offset %d: %w", msg.Partition, msg.Offset, err)
+	}
+	return msg, nil
+}
+
+// EncodingSink re-wraps every value before handing it to the wrapped sink.
+type EncodingSink struct {
+	next Sink
+	enc  ValueEncoding
+	out  []gokafka.Message
+}
+
+// NewEncodingSink wraps next, encoding values with enc.
+func NewEncodingSink(next Sink, enc ValueEncoding) *EncodingSink {
+	return &EncodingSink{next: next, enc: enc}
+}
+
+// WriteMessages implements Sink.
+func (s *EncodingSink) WriteMessages(ctx context.Context, msgs ...gokafka.Message) error {
+	s.out = s.out[:0]
+	for _, m := range msgs {
+		var err error
+		if m.Value, err = s.enc.Encode(m.Value); err != nil {
+			return err
+		}
+		s.out = append(s.out, m)
+	}
+	return s.next.WriteMessages(ctx, s.out...)
+}
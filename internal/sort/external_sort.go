@@ -11,11 +11,15 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
-	"sort"
-	"strings"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"core-infra-project/internal/fastnum"
+
 	gokafka "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/compress"
 )
 
 // recordWithKey stores a CSV record along with its pre-extracted sort key.
@@ -25,27 +29,235 @@ type recordWithKey struct {
 	data   []byte // The raw CSV record
 	keyStr string // Precomputed string key (for name/continent sorts)
 	keyInt int64  // Precomputed numeric key (for id sort)
+	off    int64  // Payload store offset (only with Options.Payloads)
+	seq    int64  // Source read order (only with Options.LatestPerKey)
+	meta   []byte // Encoded timestamp and headers (only with Options.CarryHeaders)
+}
+
+// Options configures optional ExternalSort behavior.
+// The zero value matches the default pipeline behavior.
+type Options struct {
+	// LogChunkRanges logs the min/max key and byte size of every spilled chunk.
+	// The same information is always recorded in the temp directory manifest.
+	LogChunkRanges bool
+
+	// SpillCompression compresses chunk files on disk (compress.None by default).
+	// It trades CPU for spill volume bandwidth and space.
+	SpillCompression compress.Compression
+
+	// SpillFormat is the format version chunks and the manifest are written in, from
+	// MinSpillFormat to SpillFormatVersion (0 means SpillFormatVersion). Pin it to the
+	// oldest binary's version while a rolling upgrade has several reading the chunks.
+	SpillFormat int
+
+	// IndexEvery tags every Nth merged record with its sort key in Message.WriterData
+	// (0 disables), so a writer Completion callback can build a key index.
+	IndexEvery int
+
+	// Payloads stores record payloads once for sorts of the same dataset on several keys.
+	// Chunk files then hold payload references; a sealed store replaces the source.
+	Payloads *PayloadStore
+
+	// KeyPath extracts the sort key from a dotted path in a JSON value (e.g. "after.id"
+	// for Debezium) instead of a CSV field. Records are written out unchanged.
+	KeyPath string
+
+	// Decoder, when set, decodes values (e.g. Avro) and the key is the field named
+	// KeyPath instead of a CSV or JSON field.
+	Decoder ValueDecoder
+
+	// BinaryValues marks values that may contain newlines (Avro, Confluent framing);
+	// chunk files then escape them, since records are newline-delimited on disk.
+	BinaryValues bool
+
+	// ValuePrefixBytes skips a fixed-size value prefix (e.g. 5 bytes of Confluent
+	// framing) before extracting the key.
+	ValuePrefixBytes int
+
+	// Normalize rewrites string sort keys (trim, case fold, zero-pad numerics).
+	Normalize KeyNormalization
+
+	// Locale collates string sort keys by the conventions of this BCP 47 language tag
+	// (e.g. "de", "sv", "und" for the root collation) instead of comparing their bytes,
+	// so accented and non-Latin names sort where readers expect them. It applies after
+	// Normalize and cannot be combined with Keys.
+	Locale string
+
+	// Recovery retries failed source reads and sink writes in place after running the
+	// recovery hooks (Reconnector, Reauthenticator, Reopener) of the source or sink.
+	Recovery RecoveryPolicy
+
+	// ReadDeadlineMin and ReadDeadlineMax bound how long the chunk phase waits for the
+	// next record before ending the chunk, adapting the wait to the gaps between the
+	// fetches seen so far; it waits ReadDeadlineMax until it has seen one. The source
+	// counts as drained once a chunk saw no record for ReadDeadlineMax. Both 0 keep
+	// the fixed 5s deadline per chunk.
+	ReadDeadlineMin time.Duration
+	ReadDeadlineMax time.Duration
+
+	// KeyType reads the sort key index as a field of any CSV layout, compared as a
+	// string or an integer, instead of the producer's id,name,address,continent.
+	KeyType KeyType
+
+	// Keys sorts CSV records by several fields in turn, each ascending or descending,
+	// instead of by the sort key index alone (pass the first part's index, for the
+	// report). KeyType does not apply; Normalize applies to the string parts.
+	Keys []KeyPart
+
+	// Tombstones selects the handling of null-value records; DeadLetters receives
+	// them with TombstonesDLQ.
+	Tombstones  TombstonePolicy
+	DeadLetters Sink
+
+	// MaxRecordBytes drops records whose value is larger (0 disables), a sign of
+	// upstream corruption or a format change that would otherwise inflate the chunks
+	// far beyond the memory their record count allows for. They are forwarded to
+	// DeadLetters, when set, with a DeadLetterReasonHeader.
+	MaxRecordBytes int
+
+	// KeyCoercion selects the handling of CSV records whose integer sort key is not a
+	// whole integer, or whose float sort key does not parse; they are counted in
+	// Report.BadNumericKeys whatever the policy.
+	KeyCoercion KeyCoercionPolicy
+
+	// LatestPerKey keeps only the last record per Kafka message key, as a compacted
+	// topic eventually would. A tombstone supersedes earlier values of its key.
+	LatestPerKey bool
+
+	// Ties orders records with equal keys (unspecified by default); see TieBreak for
+	// the orders matching GNU sort. TiesRecord cannot be used with Payloads, whose
+	// chunks hold no records to compare.
+	Ties TieBreak
+
+	// SpillMedium selects the chunk layout for the spill directory's medium, detected
+	// by default; see SpillMedium. MediumMemory overrides SpillCompression with none.
+	SpillMedium SpillMedium
+
+	// Emit selects what the merge writes per record: the record (default), only its
+	// key, or one "key,count" message per distinct key. Key output never reads the
+	// payload store. Output positions (SeqHeaders, ResumeFrom) count records, so they
+	// cannot be used with EmitKeyCounts, which also drops carried headers.
+	Emit Emit
+
+	// Quantiles lists quantiles of the sort key, each in (0, 1] (e.g. 0.5, 0.9, 0.99),
+	// to compute exactly as the merge emits keys in order; they are returned in
+	// MergeStats.Quantiles. They need the output size up front, which neither
+	// LatestPerKey nor MergeSorted knows.
+	Quantiles []float64
+
+	// CarryHeaders carries every record's source timestamp and headers (e.g. the
+	// producer's provenance headers) through to its output message, via a metadata
+	// sidecar next to each chunk. It cannot be combined with Payloads or EncryptSpill.
+	CarryHeaders bool
+
+	// SeqHeaders stamps every output record with its merge position in the SeqHeader
+	// header, which lets a repair find the valid prefix of a partially written topic.
+	SeqHeaders bool
+
+	// ResumeFrom suppresses the first ResumeFrom merged records (used by ResumeMerge).
+	ResumeFrom int64
+
+	// IOBufferSize sizes the chunk write and merge read buffers (default 4MB) and
+	// BatchSize the merge output batches (default 1000); both are set by auto-tuning.
+	IOBufferSize int
+	BatchSize    int
+
+	// BatchLinger, when positive, also writes a partial merge batch once its first
+	// record has waited this long, so a slow merge input cannot hold records back.
+	BatchLinger time.Duration
+
+	// Writers, when above 1, writes merge batches from that many goroutines so the
+	// merge runs ahead of slow writes (e.g. a high-latency broker). A SeqSink gets
+	// them concurrently and in any order; any other sink still gets them one call at
+	// a time, in order, so it only helps a sink whose writes block until delivered
+	// (not an async gokafka.Writer, whose writes only queue).
+	Writers int
+
+	// EncryptSpill encrypts chunk files under a key that lives only in memory for this
+	// call, and ShredSpill overwrites spill files before unlinking them, so spilled
+	// records cannot be recovered from reclaimed disk blocks. Encrypted chunks cannot
+	// be resumed or merged by another process.
+	EncryptSpill bool
+	ShredSpill   bool
+
+	// EndOffsets maps every source partition with records left to read to its end
+	// offset at start (see kafka.EndOffsets). When set, the chunk phase ends once each
+	// partition has been read to its end instead of at the first 5s read timeout, so
+	// idle partitions cannot end the read while busy ones still have data.
+	EndOffsets map[int]int64
+
+	// EndMarkers, when positive, is the number of source partitions that end with an
+	// end-of-stream marker, a message IsEndMarker recognizes and returns the producer's
+	// record count for. The chunk phase then ends once all of them delivered their
+	// marker, so the sort can start while the topic is still being filled. It takes
+	// precedence over EndOffsets. Markers are never sorted, even when EndMarkers is 0.
+	EndMarkers  int
+	IsEndMarker func(msg gokafka.Message) (records int64, ok bool)
+
+	// IsControl, when set, recognizes control messages in the source (e.g. run metadata
+	// the producer wrote), which are skipped like end-of-stream markers rather than
+	// sorted as records, before tombstone and key handling.
+	IsControl func(msg gokafka.Message) bool
+
+	// OnMerge, when set, is called before Phase 2 with the number of records the merge
+	// will write: those in the chunks, less the ones LatestPerKey drops (e.g. to size
+	// range partitions of the output or to announce the run on the destination); an
+	// error aborts the sort.
+	OnMerge func(records int64) error
+
+	// Clock drives phase timings, read deadlines and stall detection (SystemClock
+	// by default); tests substitute a manual clock.
+	Clock Clock
+}
+
+const (
+	defaultIOBufferSize = 4 << 20
+	defaultBatchSize    = 1000
+)
+
+func (o Options) ioBufferSize() int {
+	if o.IOBufferSize > 0 {
+		return o.IOBufferSize
+	}
+	return defaultIOBufferSize
+}
+
+func (o Options) batchSize() int {
+	if o.BatchSize > 0 {
+		return o.BatchSize
+	}
+	return defaultBatchSize
 }
 
+// SeqHeader is the output header carrying a record's position in the merged output.
+const SeqHeader = "kss-seq"
+
 // calculateAdaptiveChunkSize determines the optimal chunk size based on available memory.
 // It ensures we don't exceed memory limits while maximizing in-memory sort efficiency.
 // The chunk size is dynamically adjusted based on system memory stats.
-func calculateAdaptiveChunkSize() int {
+//
+// On a memory-backed spill directory (memory true) chunks may be twice as large, but
+// every spilled chunk takes RAM as well, so the chunk being sorted gets at most half
+// of the filesystem's free space (spillFree, 0 when unlimited).
+func calculateAdaptiveChunkSize(memory bool, spillFree uint64) int {
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
 
 	// Available memory = system allocated - currently in use
 	// We use a conservative 60% of available memory for chunk sorting to leave headroom
 	availableBytes := (m.Sys - m.Alloc) * 6 / 10
+	if memory && spillFree > 0 {
+		availableBytes = min(availableBytes, spillFree/2)
+	}
 
-	// Estimate: each record ~53 bytes + key overhead ~20 bytes = ~73 bytes total
-	estimatedRecordSize := uint64(73)
-	chunkSize := int(availableBytes / estimatedRecordSize)
+	chunkSize := int(availableBytes / estimatedRecordBytes)
 
 	// Enforce bounds: minimum 500k records (fewer chunks = less merge memory), maximum 2M records
 	// Larger chunks reduce merge file count and prevent OOM during k-way merge
-	const minChunkSize = 500_000
-	const maxChunkSize = 2_000_000
+	minChunkSize, maxChunkSize := 500_000, 2_000_000
+	if memory {
+		minChunkSize, maxChunkSize = 1_000_000, 4_000_000
+	}
 
 	if chunkSize < minChunkSize {
 		chunkSize = minChunkSize
@@ -58,58 +270,313 @@ func calculateAdaptiveChunkSize() int {
 	return chunkSize
 }
 
-// ExternalSort reads from kafkaReader, sorts by key index, and writes sorted records to kafkaWriter.
-// sortKeyIndex: 0=id (numeric), 1=name (lexicographic), 3=continent (lexicographic)
+// ExternalSort reads from source, sorts by key index, and writes sorted records to sink.
+// sortKeyIndex: 0=id (numeric), 1=name (lexicographic), 3=continent (lexicographic),
+// or any field with Options.KeyType, or several with Options.Keys
 //
 // Algorithm: Two-phase external merge sort
 // Phase 1 (Chunking): Read chunks that fit in memory, precompute sort keys, sort, spill to temp files
 // Phase 2 (Merging): K-way merge using min-heap, streaming results directly to output Kafka topic
 //
-// Performance is tracked with detailed per-phase timing logs for bottleneck analysis.
-func ExternalSort(kafkaReader *gokafka.Reader, kafkaWriter *gokafka.Writer, sortKeyIndex int, tempDir string) error {
-	phaseStart := time.Now()
+// ExternalSort runs ChunkAndSpill, Merge and Cleanup in sequence; callers that need to
+// schedule the phases themselves can call them directly.
+//
+// Performance is tracked with detailed per-phase timing logs for bottleneck analysis,
+// and returned as a Report including merge work counters. On failure the report
+// reflects the progress made before the error.
+func ExternalSort(source Source, sink Sink, sortKeyIndex int, tempDir string, opts Options) (*Report, error) {
+	return ExternalSortContext(context.Background(), source, sink, sortKeyIndex, tempDir, opts)
+}
+
+// ExternalSortContext is ExternalSort stopping early, with ctx's error, once ctx is
+// done. Chunk files of a cancelled sort are left in tempDir.
+func ExternalSortContext(ctx context.Context, source Source, sink Sink, sortKeyIndex int, tempDir string, opts Options) (*Report, error) {
+	clock := opts.clock()
+	phaseStart := clock.Now()
+	report := &Report{SortKeyIndex: sortKeyIndex}
+
+	runs, err := chunkAndSpill(ctx, source, sortKeyIndex, tempDir, opts, report)
+	if err != nil {
+		return report, err
+	}
+	if len(runs) == 0 {
+		fmt.Println("[Phase 2] No data to merge, exiting")
+		report.TotalDuration = clock.Now().Sub(phaseStart)
+		return report, nil
+	}
 
-	if sortKeyIndex != 0 && sortKeyIndex != 1 && sortKeyIndex != 3 {
-		return fmt.Errorf("invalid sortKeyIndex: %d", sortKeyIndex)
+	mergePhaseStart := clock.Now()
+	stats, err := Merge(ctx, runs, sink, opts)
+	report.Merge = stats
+	if err != nil {
+		return report, err
+	}
+	mergePhaseDuration := clock.Now().Sub(mergePhaseStart)
+	report.MergeDuration = mergePhaseDuration
+
+	// Cleanup: remove temporary chunk files
+	fmt.Println("[Phase 3] Cleaning up temporary files...")
+	expvarPhase.Set("cleanup")
+	if err := Cleanup(runs); err != nil {
+		fmt.Printf("[Phase 3] Warning: %v\n", err)
+	}
+
+	totalDuration := clock.Now().Sub(phaseStart)
+	report.TotalDuration = totalDuration
+	// Performance benchmark summary (requirement #7)
+	fmt.Printf("[Summary] Total sort time: %v (chunk: %v, merge: %v, cleanup: %v)\n",
+		totalDuration, report.ChunkDuration, mergePhaseDuration, clock.Now().Sub(mergePhaseStart.Add(mergePhaseDuration)))
+
+	return report, nil
+}
+
+// chunkAndSpill is Phase 1: it reads source until drained, sorting and spilling chunks
+// of records into tempDir, and records its counters in report as it goes.
+func chunkAndSpill(ctx context.Context, source Source, sortKeyIndex int, tempDir string, opts Options, report *Report) ([]Run, error) {
+	if err := checkKey(sortKeyIndex, opts); err != nil {
+		return nil, err
+	}
+	if err := checkSpillFormat(opts.spillFormat()); err != nil {
+		return nil, err
+	}
+	if lo, hi := opts.ReadDeadlineMin, opts.ReadDeadlineMax; (lo != 0 || hi != 0) && (lo <= 0 || lo > hi) {
+		return nil, fmt.Errorf("read deadline bounds %v-%v: the minimum must be positive and at most the maximum", opts.ReadDeadlineMin, opts.ReadDeadlineMax)
+	}
+
+	if opts.BinaryValues && opts.Payloads != nil {
+		return nil, fmt.Errorf("the payload store is newline-delimited and cannot hold binary values")
+	}
+	if opts.Ties == TiesRecord && opts.Payloads != nil {
+		return nil, fmt.Errorf("ties broken by record bytes cannot be used with the payload store (chunks hold references)")
+	}
+	if opts.CarryHeaders && (opts.Payloads != nil || opts.EncryptSpill) {
+		return nil, fmt.Errorf("carried headers cannot be used with the payload store (which keeps no headers) or encrypted spill (the sidecars are plaintext)")
 	}
 
 	if err := os.MkdirAll(tempDir, 0o755); err != nil {
-		return err
+		return nil, err
 	}
 
+	clock := opts.clock()
+	spillFree, _ := memoryBacked(tempDir)
+	if opts.SpillMedium = opts.SpillMedium.resolve(tempDir); opts.SpillMedium == MediumMemory {
+		fmt.Printf("[Memory] %s is memory-backed: spilled chunks stay in RAM and count against the same memory limit as the sort (keeping them uncompressed and memory-mapping them for the merge)\n", tempDir)
+		if opts.SpillCompression != compress.None {
+			fmt.Printf("[Memory] Spill compression %s disabled on a memory-backed spill directory\n", opts.SpillCompression)
+			opts.SpillCompression = compress.None
+		}
+	}
+	report.SpillMedium = opts.SpillMedium.String()
+	set := &runSet{sortKeyIndex: sortKeyIndex, opts: opts}
+
 	// Dynamically calculate chunk size based on available memory (requirement #1)
-	chunkSize := calculateAdaptiveChunkSize()
+	chunkSize := calculateAdaptiveChunkSize(opts.SpillMedium == MediumMemory, spillFree)
 
-	baseCtx := context.Background()
-	ctx := baseCtx
-	var tempFiles []string
+	var runs []Run
 	var totalRecordsRead int64
+	manifest := &Manifest{
+		SortKeyIndex:     sortKeyIndex,
+		CreatedAt:        clock.Now(),
+		SpillCompression: opts.SpillCompression.String(),
+		PayloadRefs:      opts.Payloads != nil,
+		LatestPerKey:     opts.LatestPerKey,
+		KeyNormalization: opts.Normalize.String(),
+		KeyType:          opts.KeyType.String(),
+		Keys:             FormatKeys(opts.Keys),
+		Locale:           opts.Locale,
+		Encrypted:        opts.EncryptSpill,
+		Escaped:          opts.BinaryValues,
+		Headers:          opts.CarryHeaders,
+		Ties:             opts.Ties.String(),
+	}
+	if opts.spillFormat() > 1 {
+		manifest.Format, manifest.FormatVersion = manifestMagic, opts.spillFormat()
+	}
+	if opts.EncryptSpill {
+		var err error
+		if set.key, err = newSpillKey(); err != nil {
+			return nil, err
+		}
+	}
+	spill := set.key
+
+	keys := newKeyExtractor(sortKeyIndex, opts)
+	if opts.LatestPerKey {
+		set.latest = newLatestFilter()
+	}
+	latest := set.latest
+	deadLetters := &deadLetterBatch{sink: opts.DeadLetters}
+	var seq int64 // every message read, tombstones included
+	store := opts.Payloads
+	reusePayloads := store != nil && store.Sealed()
+	if reusePayloads {
+		meta := store.Meta()
+		fmt.Printf("[Phase 1] Reusing payload store (%d records, %d bytes); building key files only\n", meta.Records, meta.Bytes)
+		source = store.source()
+	} else if store != nil {
+		// Drop whatever a failed earlier attempt appended
+		if err := store.reset(); err != nil {
+			return nil, err
+		}
+	}
+	var recovery *recoverer
+	if !reusePayloads {
+		recovery = newRecoverer(opts, source, opts.Recovery.Source, "source")
+	}
+	failures := 0 // consecutive failed reads
+
+	var drain *drainTracker
+	var markers *markerTracker
+	switch {
+	case reusePayloads:
+	case opts.EndMarkers > 0:
+		markers = newMarkerTracker(opts.EndMarkers, clock)
+	case opts.EndOffsets != nil:
+		drain = newDrainTracker(opts.EndOffsets, clock)
+	}
+	drained := drain != nil && drain.done()
+	reads := newReadDeadline(opts)
 
 	fmt.Println("[Phase 1] Starting chunking and spill phase...")
-	chunkPhaseStart := time.Now()
+	expvarPhase.Set("chunk")
+	expvarRecordsRead.Set(0)
+	expvarSpillRaw.Set(0)
+	expvarSpillDisk.Set(0)
+	chunkPhaseStart := clock.Now()
 
 	// Chunking phase: read records, precompute keys, sort in-memory, spill to disk
-	for {
+	for !drained {
 		// Pre-allocate with keys to avoid re-extraction during sort (requirement #2)
 		records := make([]recordWithKey, 0, chunkSize)
-		deadline := time.Now().Add(5 * time.Second)
+		deadline := reads.restart(clock.Now())
+		lull := false // the chunk ended on an adaptive read timeout
 
-		for len(records) < chunkSize {
+		for len(records) < chunkSize && !drained {
 			// Use a timeout context per read (kafka-go Reader supports per-call context deadline)
-			readCtx, cancel := context.WithDeadline(baseCtx, deadline)
-			msg, err := kafkaReader.ReadMessage(readCtx)
+			readCtx, cancel := withDeadline(ctx, clock, deadline)
+			msg, err := source.ReadMessage(readCtx)
+			// Only the deadline set here ends the wait; a broker or network timeout
+			// is a failed read like any other
+			expired := errors.Is(readCtx.Err(), context.DeadlineExceeded)
 			cancel()
 
 			if err != nil {
-				if errors.Is(err, context.DeadlineExceeded) || isTimeout(err) {
+				if ctx.Err() != nil {
+					// Cancelled by the caller, not a read timeout
+					return nil, ctx.Err()
+				}
+				if expired || errors.Is(err, io.EOF) {
+					if markers != nil && !errors.Is(err, io.EOF) {
+						if !markers.stalled() {
+							// The producer has not finished every partition yet
+							deadline = clock.Now().Add(reads.timeout())
+							continue
+						}
+						fmt.Printf("[Phase 1] Warning: no records for %v; end-of-stream markers seen on %d of %d partitions, treating the topic as drained\n",
+							drainIdleLimit, len(markers.seen), opts.EndMarkers)
+						drained = true
+						break
+					}
+					if drain != nil && !errors.Is(err, io.EOF) {
+						if !drain.stalled() {
+							// Some partitions are still short of their end offsets; keep waiting
+							deadline = clock.Now().Add(reads.timeout())
+							continue
+						}
+						fmt.Printf("[Phase 1] Warning: no records for %v; partitions %v never reached their end offsets, treating the topic as drained\n",
+							drainIdleLimit, drain.pendingPartitions())
+					}
+					if drain == nil && !errors.Is(err, io.EOF) && reads.adaptive() {
+						if len(records) > 0 {
+							// Spill what was read; only an empty chunk can end the phase
+							lull = true
+							break
+						}
+						if until, ok := reads.holdOut(clock.Now()); ok {
+							deadline = until
+							continue
+						}
+						fmt.Printf("[Phase 1] No records for %v, treating the topic as drained\n", reads.max)
+					}
 					// Assume topic drained for this chunk
+					drained = drain != nil
 					break
 				}
-				// If EOF-like or timeout, break; else return error
-				if isTemporary(err) {
-					break
+				if failures++; recovery.attempt(ctx, failures, err) {
+					deadline = reads.restart(clock.Now()) // the backoff is not idle time
+					continue
+				}
+				return nil, err
+			}
+			if failures > 0 {
+				report.Recoveries++
+				failures = 0
+			}
+			deadline = reads.arrived(clock.Now(), deadline)
+
+			if drain != nil {
+				drain.observe(msg.Partition, msg.Offset)
+				drained = drain.done()
+			}
+			if opts.IsEndMarker != nil {
+				if n, ok := opts.IsEndMarker(msg); ok {
+					// Not a record, whether or not the read stops on markers
+					if markers != nil {
+						markers.observe(msg.Partition)
+						report.EndMarkerRecords = n
+						drained = markers.done()
+					}
+					continue
+				}
+			}
+			if markers != nil {
+				markers.progress = clock.Now()
+			}
+			if opts.IsControl != nil && opts.IsControl(msg) {
+				continue
+			}
+			if latest != nil {
+				latest.observe(msg.Key, seq)
+			}
+			seq++
+			if msg.Value == nil && opts.Tombstones != TombstonesInclude {
+				report.Tombstones++
+				if opts.Tombstones == TombstonesDLQ {
+					if err := deadLetters.add(ctx, msg, ""); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			}
+
+			report.RecordSizes.observe(len(msg.Value))
+			if opts.MaxRecordBytes > 0 && len(msg.Value) > opts.MaxRecordBytes {
+				if report.Oversized++; report.Oversized <= 10 {
+					fmt.Printf("[Phase 1] Warning: partition %d offset %d: %d byte record exceeds the %d byte limit\n",
+						msg.Partition, msg.Offset, len(msg.Value), opts.MaxRecordBytes)
+				}
+				if opts.DeadLetters != nil {
+					if err := deadLetters.add(ctx, msg, "oversized"); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			}
+			if b, bad := keys.uncoercible(msg.Value); bad {
+				if report.BadNumericKeys++; report.BadNumericKeys <= 10 {
+					fmt.Printf("[Phase 1] Warning: partition %d offset %d: %s; it sorts as %s\n", msg.Partition, msg.Offset, b, b.sortsAs)
+				}
+				switch opts.KeyCoercion {
+				case CoercionFail:
+					return nil, fmt.Errorf("partition %d offset %d: %s", msg.Partition, msg.Offset, b)
+				case CoercionDLQ:
+					if opts.DeadLetters != nil {
+						if err := deadLetters.add(ctx, msg, "bad-numeric-key"); err != nil {
+							return nil, err
+						}
+					}
+					continue
 				}
-				return err
 			}
 
 			// Copy value to prevent reuse and precompute the sort key
@@ -121,245 +588,748 @@ func ExternalSort(kafkaReader *gokafka.Reader, kafkaWriter *gokafka.Writer, sort
 			// improving performance by ~30-40% for large sorts
 			var recWithKey recordWithKey
 			recWithKey.data = rec
-			if sortKeyIndex == 0 {
-				recWithKey.keyInt = extractID(rec)
-			} else {
-				recWithKey.keyStr = extractKeyString(rec, sortKeyIndex)
+			recWithKey.seq = seq - 1
+			if opts.CarryHeaders {
+				recWithKey.meta = appendMeta(nil, msg)
+			}
+			if reusePayloads {
+				recWithKey.off = msg.Offset
+			} else if store != nil {
+				if recWithKey.off, err = store.append(rec); err != nil {
+					return nil, err
+				}
+			}
+			if err := keys.fill(&recWithKey); err != nil {
+				return nil, fmt.Errorf("partition %d offset %d: %w", msg.Partition, msg.Offset, err)
 			}
 			records = append(records, recWithKey)
 			totalRecordsRead++
+			expvarRecordsRead.Set(totalRecordsRead)
 		}
 
+		if err := deadLetters.flush(ctx); err != nil {
+			return nil, err
+		}
 		if len(records) == 0 {
 			break
 		}
 
 		// Sort in-memory using precomputed keys (no re-parsing needed)
-		if sortKeyIndex == 0 {
-			// Numeric comparison for id field
-			sort.Slice(records, func(i, j int) bool {
-				return records[i].keyInt < records[j].keyInt
-			})
-		} else {
-			// Lexicographic comparison for name/continent
-			sort.Slice(records, func(i, j int) bool {
-				return records[i].keyStr < records[j].keyStr
-			})
-		}
+		sortChunk(records, keys.intKey, opts.Ties)
 
 		// Spill sorted chunk to temp file
-		fpath := filepath.Join(tempDir, fmt.Sprintf("chunk_%d.tmp", len(tempFiles)))
-		if err := writeChunk(fpath, records); err != nil {
-			return err
+		fpath := filepath.Join(tempDir, fmt.Sprintf("chunk_%d.tmp", len(runs)))
+		var err error
+		if store != nil {
+			err = writeRefChunk(fpath, records, keys.intKey, opts.SpillCompression.Codec(), spill, opts.spillFormat(), opts.ioBufferSize())
+		} else {
+			err = writeChunk(fpath, records, opts.SpillCompression.Codec(), spill, opts.spillFormat(), opts.ioBufferSize(), opts.BinaryValues)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if latest != nil {
+			if err := writeSeqs(seqPath(fpath), records); err != nil {
+				return nil, err
+			}
+		}
+		if opts.CarryHeaders {
+			if err := writeMetas(metaPath(fpath), records); err != nil {
+				return nil, err
+			}
+		}
+		info := chunkInfo(fpath, records, keys, opts.spillFormat())
+		if spill != nil {
+			// Keys are record contents; keep them out of the plaintext manifest
+			info.MinKey, info.MaxKey = "", ""
 		}
-		tempFiles = append(tempFiles, fpath)
+		manifest.Chunks = append(manifest.Chunks, info)
+		runs = append(runs, Run{Path: fpath, ChunkInfo: info, set: set})
+		report.SpillRawBytes += info.Bytes
+		report.SpillDiskBytes += info.DiskBytes
+		expvarSpillRaw.Add(info.Bytes)
+		expvarSpillDisk.Add(info.DiskBytes)
 
 		// Checkpoint logging (requirement #4)
 		fmt.Printf("[Phase 1] Chunk %d: sorted %d records, spilled to %s\n",
-			len(tempFiles), len(records), filepath.Base(fpath))
+			len(runs), len(records), filepath.Base(fpath))
+		if opts.LogChunkRanges {
+			fmt.Printf("[Phase 1] Chunk %d: keys [%q .. %q], %d bytes (%d on disk)\n",
+				len(runs), info.MinKey, info.MaxKey, info.Bytes, info.DiskBytes)
+		}
 
-		if len(records) < chunkSize {
+		if len(records) < chunkSize && !lull {
 			// Drained topic
 			break
 		}
 	}
 
-	chunkPhaseDuration := time.Since(chunkPhaseStart)
+	chunkPhaseDuration := clock.Now().Sub(chunkPhaseStart)
+	report.RecordsRead = totalRecordsRead
+	report.Chunks = len(runs)
+	report.ChunkDuration = chunkPhaseDuration
+	expvarChunkSeconds.Set(chunkPhaseDuration.Seconds())
 	fmt.Printf("[Phase 1] Completed: %d chunks created, %d records read in %v\n",
-		len(tempFiles), totalRecordsRead, chunkPhaseDuration)
-
-	if len(tempFiles) == 0 {
-		fmt.Println("[Phase 2] No data to merge, exiting")
-		return nil
+		len(runs), totalRecordsRead, chunkPhaseDuration)
+	if report.Tombstones > 0 {
+		fmt.Printf("[Phase 1] Tombstones (%s): %d\n", opts.Tombstones, report.Tombstones)
+	}
+	if reads.adaptive() {
+		report.ReadDeadline = reads.timeout()
+		fmt.Printf("[Phase 1] Read deadline: %v (records %v apart, deviation %v; bounds %v-%v)\n",
+			report.ReadDeadline, reads.gap, reads.dev, reads.min, reads.max)
+	}
+	if sizes := &report.RecordSizes; sizes.Records > 0 {
+		fmt.Printf("[Phase 1] Record sizes: mean %.0f, max %d bytes (%s)\n", sizes.Mean(), sizes.Max, sizes)
+		if sizes.Mean()+keyOverheadBytes > 2*estimatedRecordBytes {
+			fmt.Printf("[Phase 1] Warning: records average %.0f bytes, well above the %d the chunk size assumes; chunks took more memory than planned\n",
+				sizes.Mean(), estimatedRecordBytes-keyOverheadBytes)
+		}
+	}
+	if report.Oversized > 0 {
+		fate := "dropped"
+		if opts.DeadLetters != nil {
+			fate = "dead-lettered"
+		}
+		fmt.Printf("[Phase 1] Oversized records (> %d bytes): %d %s\n", opts.MaxRecordBytes, report.Oversized, fate)
+	}
+	if report.BadNumericKeys > 0 {
+		fate := "sorted as their leading digits (floats as 0)"
+		if opts.KeyCoercion == CoercionDLQ {
+			fate = "dropped"
+			if opts.DeadLetters != nil {
+				fate = "dead-lettered"
+			}
+		}
+		fmt.Printf("[Phase 1] Numeric keys that do not parse: %d %s\n", report.BadNumericKeys, fate)
+	}
+	if opts.SpillCompression != compress.None && report.SpillDiskBytes > 0 {
+		fmt.Printf("[Phase 1] Spill compression (%s): %d -> %d bytes (ratio %.2f)\n",
+			opts.SpillCompression, report.SpillRawBytes, report.SpillDiskBytes, report.SpillCompressionRatio())
 	}
 
-	// Merge phase: k-way merge using min-heap
-	fmt.Printf("[Phase 2] Starting k-way merge of %d chunks...\n", len(tempFiles))
-	mergePhaseStart := time.Now()
+	if store != nil && !reusePayloads {
+		if err := store.seal(); err != nil {
+			return nil, err
+		}
+		meta := store.Meta()
+		fmt.Printf("[Phase 1] Payload store sealed: %d records, %d bytes\n", meta.Records, meta.Bytes)
+	}
 
-	mergedCount, err := kWayMergeToKafka(ctx, tempFiles, kafkaWriter, sortKeyIndex)
+	if len(runs) == 0 {
+		return nil, nil
+	}
+	coalesced, folded, err := coalesceRuns(runs, chunkSize, tempDir, sortKeyIndex)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	mergePhaseDuration := time.Since(mergePhaseStart)
-	fmt.Printf("[Phase 2] Completed: merged %d records from %d chunks in %v\n",
-		mergedCount, len(tempFiles), mergePhaseDuration)
-
-	// Cleanup: remove temporary chunk files
-	fmt.Println("[Phase 3] Cleaning up temporary files...")
-	for _, f := range tempFiles {
-		_ = os.Remove(f)
+	if folded > 0 {
+		report.Coalesced = folded
+		report.Chunks = len(coalesced)
+		manifest.Chunks = manifest.Chunks[:0]
+		for _, r := range coalesced {
+			manifest.Chunks = append(manifest.Chunks, r.ChunkInfo)
+		}
+		runs = coalesced
 	}
 
-	totalDuration := time.Since(phaseStart)
-	// Performance benchmark summary (requirement #7)
-	fmt.Printf("[Summary] Total sort time: %v (chunk: %v, merge: %v, cleanup: %v)\n",
-		totalDuration, chunkPhaseDuration, mergePhaseDuration, time.Since(mergePhaseStart.Add(mergePhaseDuration)))
-
-	return nil
+	// The manifest is kept after cleanup so chunk key ranges remain available for debugging
+	manifestPath, err := writeManifest(tempDir, manifest)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("[Phase 1] Chunk manifest written to %s\n", manifestPath)
+	return runs, nil
 }
 
 // writeChunk writes sorted records to a temporary file with buffered I/O.
-// Uses a large 4MB buffer to reduce syscalls and improve write throughput.
-func writeChunk(path string, records []recordWithKey) error {
-	f, err := os.Create(path)
+// Uses a large buffer (4MB by default) to reduce syscalls and improve write throughput.
+// A non-nil codec compresses the file contents and a non-nil key encrypts them;
+// escape protects newlines inside binary records (see appendEscaped).
+func writeChunk(path string, records []recordWithKey, codec compress.Codec, key *spillKey, version, bufSize int, escape bool) error {
+	f, err := createChunk(path, version)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
+	w, err := key.writer(f)
+	if err != nil {
+		return err
+	}
+	var cw io.WriteCloser
+	if codec != nil {
+		cw = codec.NewWriter(w)
+		w = cw
+	}
+
 	// Increase buffer size to reduce syscalls during spill
-	bw := bufio.NewWriterSize(f, 4<<20)
+	bw := bufio.NewWriterSize(w, bufSize)
+	var esc []byte
 	for _, r := range records {
-		if _, err := bw.Write(r.data); err != nil {
+		data := r.data
+		if escape {
+			esc = appendEscaped(esc[:0], data)
+			data = esc
+		}
+		if _, err := bw.Write(data); err != nil {
 			return err
 		}
 		if err := bw.WriteByte('\n'); err != nil {
 			return err
 		}
 	}
-	return bw.Flush()
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if cw != nil {
+		return cw.Close()
+	}
+	return nil
+}
+
+// writeRefChunk is writeChunk for payload store runs: each line references the
+// record's payload (offset,length,key) instead of holding the record itself.
+func writeRefChunk(path string, records []recordWithKey, intKey bool, codec compress.Codec, key *spillKey, version, bufSize int) error {
+	f, err := createChunk(path, version)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := key.writer(f)
+	if err != nil {
+		return err
+	}
+	var cw io.WriteCloser
+	if codec != nil {
+		cw = codec.NewWriter(w)
+		w = cw
+	}
+
+	bw := bufio.NewWriterSize(w, bufSize)
+	line := make([]byte, 0, 64)
+	for _, r := range records {
+		line = append(appendRef(line[:0], r, intKey), '\n')
+		if _, err := bw.Write(line); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if cw != nil {
+		return cw.Close()
+	}
+	return nil
 }
 
 // fileScanner provides buffered reading of records from a temporary chunk file.
 type fileScanner struct {
-	f  *os.File
-	br *bufio.Reader
+	path      string
+	f         *os.File
+	mapped    []byte        // the chunk, mapped instead of read through f
+	cr        io.ReadCloser // decompressor, nil for uncompressed chunks
+	br        *bufio.Reader
+	bytesRead int64
+	unescape  bool // records were written with escape
+	format    int  // spill format version of a chunk; 1 for other record files
 }
 
-// newFileScanner creates a new scanner with a large read buffer (4MB)
+// newFileScanner creates a new scanner with a large read buffer (4MB by default)
 // to minimize syscalls during the merge phase.
-func newFileScanner(path string) (*fileScanner, error) {
+// A non-nil codec decompresses chunks written with the same codec, and a non-nil key
+// decrypts chunks written with the same key.
+func newFileScanner(path string, codec compress.Codec, key *spillKey, bufSize int) (*fileScanner, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	sc := &fileScanner{path: path, f: f}
+	if sc.format, err = readChunkFormat(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	r, err := key.reader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	if codec != nil {
+		sc.cr = codec.NewReader(r)
+		r = sc.cr
+	}
 	// Larger read buffer reduces read syscalls during merge
-	return &fileScanner{f: f, br: bufio.NewReaderSize(f, 4<<20)}, nil
+	sc.br = bufio.NewReaderSize(r, bufSize)
+	return sc, nil
 }
 
 // next reads the next record from the file scanner.
 func (s *fileScanner) next() ([]byte, error) {
 	line, err := s.br.ReadBytes('\n')
-	if err != nil {
-		if err == io.EOF && len(line) > 0 {
-			// Last line without newline
-			return bytes.TrimRight(line, "\n"), nil
-		}
+	s.bytesRead += int64(len(line))
+	if err != nil && (err != io.EOF || len(line) == 0) {
 		return nil, err
 	}
-	return bytes.TrimRight(line, "\n"), nil
+	// The last line may lack its newline
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	if s.unescape {
+		return unescapeRecord(line), nil
+	}
+	return line, nil
+}
+
+func (s *fileScanner) close() error {
+	if s.cr != nil {
+		s.cr.Close()
+	}
+	if s.mapped != nil {
+		return unmap(s.mapped)
+	}
+	return s.f.Close()
 }
 
-func (s *fileScanner) close() error { return s.f.Close() }
+// sortKey is the type of a merge key: int64 for id, string for name/continent.
+type sortKey interface {
+	int64 | string
+}
 
 // heapItem represents a single item in the min-heap for k-way merge.
-// It stores either a string key or numeric key based on sort type.
-type heapItem struct {
-	keyStr string // String sort key (for name/continent)
-	keyInt int64  // Numeric sort key (for id)
-	useInt bool   // Flag to indicate which key type to use
-	val    []byte // The actual CSV record
-	i      int    // Index of file scanner this item came from
+type heapItem[K sortKey] struct {
+	key  K      // Precomputed sort key
+	val  []byte // The actual CSV record (nil for payload references)
+	off  int64  // Payload store offset of the record (payload references only)
+	n    int    // Payload length (payload references only)
+	seq  int64  // Source read order (latest-per-key only)
+	meta []byte // Encoded timestamp and headers (CarryHeaders only)
+	i    int    // Index of the merge input this item came from
+}
+
+// displayKey renders the item's key the same way as the chunk manifest.
+func (it heapItem[K]) displayKey(keys keyExtractor) string {
+	switch k := any(it.key).(type) {
+	case int64:
+		return keys.displayInt(k)
+	case string:
+		return keys.display(k)
+	}
+	return ""
 }
 
 // minHeap implements heap.Interface for k-way merge.
-// It maintains the invariant that the smallest item is always at the root.
-type minHeap []heapItem
+// It maintains the invariant that the smallest item is always at the root,
+// and counts key comparisons for the merge stats. The key type is fixed per merge,
+// so comparisons never branch on it; ties only consult the tie break.
+type minHeap[K sortKey] struct {
+	items       []heapItem[K]
+	comparisons int64
+	ties        TieBreak
+}
 
-func (h minHeap) Len() int { return len(h) }
+func (h *minHeap[K]) Len() int { return len(h.items) }
 
-func (h minHeap) Less(i, j int) bool {
-	if h[i].useInt || h[j].useInt {
-		// When sorting ids, both will have useInt=true
-		return h[i].keyInt < h[j].keyInt
+func (h *minHeap[K]) Less(i, j int) bool {
+	h.comparisons++
+	a, b := &h.items[i], &h.items[j]
+	if a.key != b.key {
+		return a.key < b.key
 	}
-	return h[i].keyStr < h[j].keyStr
+	switch h.ties {
+	case TiesRecord:
+		return bytes.Compare(a.val, b.val) < 0
+	case TiesInput:
+		// Inputs are in read order (chunks are numbered as they are spilled)
+		return a.i < b.i
+	}
+	return false
+}
+
+// OrderError reports a merged record whose key sorts before the previously emitted
+// one, which means a chunk was spilled unsorted or the comparator is inconsistent.
+type OrderError struct {
+	Record    int64  // 0-based position in the merged output
+	Key       string // key of the offending record
+	Chunk     string // chunk file it was read from
+	PrevKey   string
+	PrevChunk string
+}
+
+func (e *OrderError) Error() string {
+	return fmt.Sprintf("merge order violated at output record %d: key %q from %s sorts before previous key %q from %s",
+		e.Record, e.Key, e.Chunk, e.PrevKey, e.PrevChunk)
 }
 
-func (h minHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
-func (h *minHeap) Push(x interface{}) { *h = append(*h, x.(heapItem)) }
+func (h *minHeap[K]) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *minHeap[K]) Push(x interface{}) { h.items = append(h.items, x.(heapItem[K])) }
 
-func (h *minHeap) Pop() interface{} {
-	old := *h
+func (h *minHeap[K]) Pop() interface{} {
+	old := h.items
 	n := len(old)
 	x := old[n-1]
-	*h = old[:n-1]
+	h.items = old[:n-1]
 	return x
 }
 
 // kWayMergeToKafka performs a k-way merge of sorted chunk files using a min-heap.
 // It streams merged records directly to the output Kafka topic for memory efficiency.
-// Returns the total number of records merged.
-func kWayMergeToKafka(ctx context.Context, files []string, writer *gokafka.Writer, sortKeyIndex int) (int64, error) {
-	scanners := make([]*fileScanner, len(files))
-	for i, f := range files {
-		sc, err := newFileScanner(f)
+// Returns the merge work counters, including the total number of records merged.
+// With opts.IndexEvery > 0, every IndexEvery-th record carries its key as WriterData,
+// and with opts.Payloads the chunks hold payload references resolved on output.
+// A non-nil latest drops records superseded by a later one with the same message key,
+// and a non-nil quantiles picks the keys at its quantile positions.
+func kWayMergeToKafka(ctx context.Context, files []string, writer Sink, sortKeyIndex int, opts Options, key *spillKey, latest *latestFilter, quantiles *quantileTracker) (MergeStats, error) {
+	codec := opts.SpillCompression.Codec()
+	inputs := make([]MergeInput, 0, len(files))
+	var sidecarFiles []*os.File
+	defer func() {
+		for _, in := range inputs {
+			_ = in.Close()
+		}
+		for _, f := range sidecarFiles {
+			_ = f.Close()
+		}
+	}()
+	formats := map[int]int{}
+	for _, f := range files {
+		sc, err := openChunk(f, codec, key, opts)
 		if err != nil {
-			return 0, err
+			return MergeStats{}, err
 		}
-		scanners[i] = sc
+		sc.unescape = opts.BinaryValues
+		inputs = append(inputs, sc)
+		formats[sc.format]++
 	}
-	defer func() {
-		for _, sc := range scanners {
-			if sc != nil {
-				_ = sc.close()
+	if spread := formatSpread(formats); spread != "" {
+		fmt.Printf("[Phase 2] Chunk spill formats: %s (written by different binary versions)\n", spread)
+	}
+	var side sidecars
+	openSidecars := func(path func(string) string) ([]*bufio.Reader, error) {
+		readers := make([]*bufio.Reader, len(files))
+		for i, f := range files {
+			sf, err := os.Open(path(f))
+			if err != nil {
+				return nil, err
 			}
+			sidecarFiles = append(sidecarFiles, sf)
+			readers[i] = bufio.NewReaderSize(sf, 64<<10)
 		}
-	}()
+		return readers, nil
+	}
+	var err error
+	if latest != nil {
+		if side.seqs, err = openSidecars(seqPath); err != nil {
+			return MergeStats{}, err
+		}
+	}
+	if opts.CarryHeaders {
+		if side.metas, err = openSidecars(metaPath); err != nil {
+			return MergeStats{}, err
+		}
+	}
+	return mergeInputs(ctx, inputs, side, writer, sortKeyIndex, opts, latest, quantiles)
+}
+
+// sidecars holds a reader per merge input for each sidecar its chunks were written
+// with; a nil slice means the chunks have no such sidecar.
+type sidecars struct {
+	seqs  []*bufio.Reader // sequence numbers (latest-per-key)
+	metas []*bufio.Reader // record metadata (CarryHeaders)
+}
+
+// mergeKeys turns the keys of records and payload references into heap keys.
+type mergeKeys[K sortKey] struct {
+	fromRecord func(r *recordWithKey) K
+	fromRef    func(key []byte) K // references store the bare key
+}
+
+var (
+	intKeys = mergeKeys[int64]{
+		fromRecord: func(r *recordWithKey) int64 { return r.keyInt },
+		// Parse it like a leading id field
+		fromRef: fastnum.LeadingInt,
+	}
+	stringKeys = mergeKeys[string]{
+		fromRecord: func(r *recordWithKey) string { return r.keyStr },
+		fromRef:    func(key []byte) string { return string(key) },
+	}
+)
+
+// mergeInputs is the k-way merge itself, over any already-sorted inputs, reading the
+// sidecars in side alongside them.
+func mergeInputs(ctx context.Context, inputs []MergeInput, side sidecars, writer Sink, sortKeyIndex int, opts Options, latest *latestFilter, quantiles *quantileTracker) (MergeStats, error) {
+	keys := newKeyExtractor(sortKeyIndex, opts)
+	if keys.intKey {
+		return mergeTyped(ctx, inputs, side, writer, keys, intKeys, opts, latest, quantiles)
+	}
+	return mergeTyped(ctx, inputs, side, writer, keys, stringKeys, opts, latest, quantiles)
+}
+
+// mergeTyped runs the merge with keys of type K.
+func mergeTyped[K sortKey](ctx context.Context, inputs []MergeInput, side sidecars, writer Sink, keys keyExtractor, mk mergeKeys[K], opts Options, latest *latestFilter, quantiles *quantileTracker) (MergeStats, error) {
+	stats := MergeStats{ChunkBytesRead: make([]int64, len(inputs))}
+	indexEvery := opts.IndexEvery
+	if opts.Emit == EmitKeyCounts && (opts.SeqHeaders || opts.ResumeFrom > 0) {
+		return stats, fmt.Errorf("key counts have no per-record output positions for sequence headers or resuming")
+	}
+	var recovered atomic.Int64
+	writer = newRecoveringSink(writer, opts, &recovered)
+	var writers *parallelSink
+	if opts.Writers > 1 {
+		writers = newParallelSink(ctx, writer, opts.Writers)
+		defer writers.abort()
+		writer = writers
+	}
 
-	// Initialize min-heap with first record from each chunk file
-	h := &minHeap{}
+	// Initialize min-heap with first record from each input
+	h := &minHeap[K]{ties: opts.Ties}
 	heap.Init(h)
-	for i, sc := range scanners {
-		if rec, err := sc.next(); err == nil {
-			if sortKeyIndex == 0 {
-				heap.Push(h, heapItem{keyInt: extractID(rec), useInt: true, val: rec, i: i})
-			} else {
-				heap.Push(h, heapItem{keyStr: extractKeyString(rec, sortKeyIndex), val: rec, i: i})
+	push := func(rec []byte, i int) error {
+		item := heapItem[K]{val: rec, i: i}
+		if side.seqs != nil {
+			var err error
+			if item.seq, err = readSeq(side.seqs[i]); err != nil {
+				return fmt.Errorf("chunk %s sequence sidecar: %w", inputs[i].Name(), err)
+			}
+		}
+		if side.metas != nil {
+			var err error
+			if item.meta, err = readMeta(side.metas[i]); err != nil {
+				return fmt.Errorf("chunk %s metadata sidecar: %w", inputs[i].Name(), err)
+			}
+		}
+		key := rec
+		if opts.Payloads != nil {
+			var err error
+			if item.off, item.n, key, err = parseRef(rec); err != nil {
+				return fmt.Errorf("chunk %s: %w", inputs[i].Name(), err)
+			}
+			item.val = nil
+			item.key = mk.fromRef(key)
+		} else {
+			r := recordWithKey{data: rec}
+			if err := keys.fill(&r); err != nil {
+				return fmt.Errorf("input %s: %w", inputs[i].Name(), err)
 			}
+			item.key = mk.fromRecord(&r)
+		}
+		heap.Push(h, item)
+		stats.HeapPushes++
+		return nil
+	}
+	// pull reads the next record of input i into the heap; exhausted inputs just drop out
+	pull := func(i int) error {
+		rec, err := inputs[i].Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("input %s: %w", inputs[i].Name(), err)
+		}
+		return push(rec, i)
+	}
+	for i := range inputs {
+		if err := pull(i); err != nil {
+			return stats, err
 		}
 	}
 
 	// Batch writes to Kafka for better throughput
-	batch := make([]gokafka.Message, 0, 1000)
-	var mergedCount int64
+	batch := make([]gokafka.Message, 0, opts.batchSize())
 
-	flush := func() error {
+	// With BatchLinger a timer armed by the first record of a batch writes it if it is
+	// still pending; mu guards the batch between the merge loop and the timer. gen
+	// counts writes, so a timer that lost the race to a full batch does nothing, and a
+	// failed timed write is returned by the next add or flush.
+	var (
+		mu        sync.Mutex
+		gen       int64
+		lingering Timer
+		lingered  int64
+		lingerErr error
+	)
+	clock := opts.clock()
+	write := func() error { // mu held
+		if lingering != nil {
+			lingering.Stop()
+			lingering = nil
+		}
+		gen++
 		if len(batch) == 0 {
 			return nil
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if err := writer.WriteMessages(ctx, batch...); err != nil {
 			return err
 		}
 		batch = batch[:0]
 		return nil
 	}
+	linger := func(g int64) func() {
+		return func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if g != gen || lingerErr != nil {
+				return
+			}
+			lingered++
+			lingerErr = write()
+		}
+	}
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if lingering != nil {
+			lingering.Stop()
+		}
+		gen++
+	}()
+
+	collect := func() {
+		stats.Comparisons = h.comparisons
+		stats.Recoveries = recovered.Load()
+		for i, in := range inputs {
+			stats.ChunkBytesRead[i] = in.BytesRead()
+		}
+		stats.publish()
+	}
+	// add appends msg to the batch and reports whether the batch is full
+	add := func(msg gokafka.Message) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if lingerErr != nil {
+			return false, lingerErr
+		}
+		batch = append(batch, msg)
+		if len(batch) == 1 && opts.BatchLinger > 0 {
+			lingering = clock.AfterFunc(opts.BatchLinger, linger(gen))
+		}
+		return len(batch) >= cap(batch), nil
+	}
+	flush := func() error {
+		collect()
+		mu.Lock()
+		defer mu.Unlock()
+		stats.LingerFlushes = lingered
+		if lingerErr != nil {
+			return lingerErr
+		}
+		return write()
+	}
+
+	// With EmitKeyCounts, counted records with the key of countItem are pending
+	var countItem heapItem[K]
+	var counted int64
+	emitCount := func() error {
+		if counted == 0 {
+			return nil
+		}
+		msg := gokafka.Message{Value: appendKeyCount(nil, countItem.displayKey(keys), counted)}
+		if indexEvery > 0 && stats.Keys%int64(indexEvery) == 0 {
+			msg.WriterData = countItem.displayKey(keys)
+		}
+		stats.Keys++
+		counted = 0
+		full, err := add(msg)
+		if err != nil || !full {
+			return err
+		}
+		return flush()
+	}
 
-	// Main merge loop: pop smallest, write to Kafka, pull next from same file
+	// Main merge loop: pop smallest, pull next from same file, write to Kafka
+	var prev heapItem[K]
 	for h.Len() > 0 {
-		item := heap.Pop(h).(heapItem)
-		batch = append(batch, gokafka.Message{Value: append([]byte(nil), item.val...)})
-		mergedCount++
+		item := heap.Pop(h).(heapItem[K])
+		stats.HeapPops++
+		// Inline assertion: one comparison per record catches bad chunks before the run completes
+		if stats.HeapPops > 1 && item.key < prev.key {
+			return stats, &OrderError{
+				Record: stats.Records, Key: item.displayKey(keys), Chunk: inputs[item.i].Name(),
+				PrevKey: prev.displayKey(keys), PrevChunk: inputs[prev.i].Name(),
+			}
+		}
+		prev = item
 
-		if len(batch) >= cap(batch) {
-			if err := flush(); err != nil {
-				return mergedCount, err
+		// Pull next record from the same input and push back into heap
+		if err := pull(item.i); err != nil {
+			return stats, err
+		}
+
+		if latest != nil && latest.isSuperseded(item.seq) {
+			stats.Superseded++
+			continue
+		}
+		if quantiles != nil && quantiles.due(stats.Records) {
+			quantiles.record(item.displayKey(keys))
+		}
+		if stats.Records < opts.ResumeFrom {
+			// Already in the destination topic from the run being repaired
+			stats.Records++
+			stats.Skipped++
+			continue
+		}
+		if opts.Emit == EmitKeyCounts {
+			// Equal keys are adjacent, so a key's count is complete once the key changes
+			if counted == 0 || item.key != countItem.key {
+				if err := emitCount(); err != nil {
+					return stats, err
+				}
+				countItem = item
+			}
+			counted++
+			stats.Records++
+			continue
+		}
+		var val []byte
+		switch {
+		case opts.Emit == EmitKeys:
+			val = []byte(item.displayKey(keys))
+		case opts.Payloads != nil:
+			var err error
+			if val, err = opts.Payloads.readAt(item.off, item.n); err != nil {
+				return stats, err
 			}
+		default:
+			val = append([]byte(nil), item.val...)
+		}
+		msg := gokafka.Message{Value: val}
+		if item.meta != nil {
+			if err := decodeMeta(item.meta, &msg); err != nil {
+				return stats, fmt.Errorf("chunk %s metadata sidecar: %w", inputs[item.i].Name(), err)
+			}
+		}
+		if opts.SeqHeaders {
+			msg.Headers = append(msg.Headers, gokafka.Header{Key: SeqHeader, Value: strconv.AppendInt(nil, stats.Records, 10)})
 		}
+		if indexEvery > 0 && stats.Records%int64(indexEvery) == 0 {
+			msg.WriterData = item.displayKey(keys)
+		}
+		full, err := add(msg)
+		if err != nil {
+			return stats, err
+		}
+		stats.Records++
 
-		// Pull next record from the same file and push back into heap
-		if rec, err := scanners[item.i].next(); err == nil {
-			if sortKeyIndex == 0 {
-				heap.Push(h, heapItem{keyInt: extractID(rec), useInt: true, val: rec, i: item.i})
-			} else {
-				heap.Push(h, heapItem{keyStr: extractKeyString(rec, sortKeyIndex), val: rec, i: item.i})
+		if full {
+			if err := flush(); err != nil {
+				return stats, err
 			}
 		}
 	}
 
-	return mergedCount, flush()
+	if err := emitCount(); err != nil {
+		return stats, err
+	}
+	if quantiles != nil {
+		stats.Quantiles = quantiles.result()
+	}
+	err := flush()
+	if err == nil && writers != nil {
+		err = writers.wait()
+	}
+	return stats, err
 }
 
 // extractKeyString extracts a string field from a CSV record by field index.
@@ -397,38 +1367,18 @@ func extractKeyString(rec []byte, idx int) string {
 	return string(rec)
 }
 
-// extractID parses the leading integer id (before first comma) as int64.
-// Uses manual parsing to avoid fmt.Sscanf allocations and improve performance.
-func extractID(rec []byte) int64 {
-	var n int64
-	neg := false
-	for i := 0; i < len(rec); i++ {
-		c := rec[i]
-		if c == ',' {
-			break
-		}
-		if c == '-' && i == 0 {
-			neg = true
-			continue
-		}
-		if c >= '0' && c <= '9' {
-			n = n*10 + int64(c-'0')
-		} else {
-			// Unexpected char, stop
-			break
+// csvField returns field idx of a CSV record, counted from the start, or nil if the
+// record has fewer fields.
+func csvField(rec []byte, idx int) []byte {
+	for ; idx > 0; idx-- {
+		i := bytes.IndexByte(rec, ',')
+		if i == -1 {
+			return nil
 		}
+		rec = rec[i+1:]
 	}
-	if neg {
-		n = -n
+	if i := bytes.IndexByte(rec, ','); i != -1 {
+		return rec[:i]
 	}
-	return n
-}
-
-// isTimeout checks if an error is a timeout-related error.
-func isTimeout(err error) bool {
-	// kafka-go wraps context deadline exceeded; simple string check fallback
-	return strings.Contains(err.Error(), "deadline") || strings.Contains(err.Error(), "timeout")
+	return rec
 }
-
-// isTemporary checks if an error is temporary/retryable.
-func isTemporary(err error) bool { return isTimeout(err) }
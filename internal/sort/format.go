@@ -0,0 +1,129 @@
+package sort
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Spill format versions. Chunk files and manifests say which version wrote them, so
+// that while a rolling upgrade runs several binaries side by side (e.g. shards of a
+// distributed job spilling into one directory, or a newer binary resuming an older
+// run's merge), each merge can tell whether it reads every chunk it is given. A binary
+// reads every version from MinSpillFormat to SpillFormatVersion and writes the one its
+// Options.SpillFormat pins; pin it to the oldest version still deployed until the
+// upgrade completes.
+const (
+	// MinSpillFormat is the oldest format this package reads: the unversioned chunks
+	// and manifests of binaries from before versioning, which it treats as version 1.
+	MinSpillFormat = 1
+	// SpillFormatVersion is the newest format this package reads, and writes by
+	// default. Version 2 adds the chunk header and the manifest's format fields.
+	SpillFormatVersion = 2
+)
+
+// Chunk files of version 2 and later start with chunkMagic and their version byte,
+// written ahead of any encryption or compression so a chunk's version can be read
+// without either. 0xff never starts the UTF-8 text of a version 1 chunk, and the
+// compression formats' magic numbers differ too.
+const (
+	chunkMagic      = "\xffKSS"
+	chunkHeaderSize = len(chunkMagic) + 1
+)
+
+// manifestMagic is the Manifest.Format of versioned manifests.
+const manifestMagic = "kss-sort-manifest"
+
+// spillFormat returns the version chunks and manifests are written in.
+func (o Options) spillFormat() int {
+	if o.SpillFormat == 0 {
+		return SpillFormatVersion
+	}
+	return o.SpillFormat
+}
+
+// checkSpillFormat rejects a version this package cannot read or write.
+func checkSpillFormat(version int) error {
+	if version < MinSpillFormat || version > SpillFormatVersion {
+		return fmt.Errorf("spill format version %d is not supported (this binary reads and writes versions %d to %d)", version, MinSpillFormat, SpillFormatVersion)
+	}
+	return nil
+}
+
+// createChunk creates a chunk file of the given version, writing its header.
+func createChunk(path string, version int) (*os.File, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if version > 1 {
+		if _, err := f.Write(append([]byte(chunkMagic), byte(version))); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// chunkFormat reads the version of a chunk from its first bytes, returning it and the
+// size of the header to skip. Chunks without a header are version 1.
+func chunkFormat(head []byte) (version, skip int, err error) {
+	if len(head) < chunkHeaderSize || !strings.HasPrefix(string(head), chunkMagic) {
+		return 1, 0, nil
+	}
+	version = int(head[len(chunkMagic)])
+	switch {
+	case version > SpillFormatVersion:
+		return 0, 0, fmt.Errorf("chunk was written in spill format version %d by a newer binary; this one reads up to version %d (merge with the newer binary, or have it write version %d)", version, SpillFormatVersion, SpillFormatVersion)
+	case version < 2:
+		return 0, 0, fmt.Errorf("chunk header names invalid spill format version %d", version)
+	}
+	return version, chunkHeaderSize, nil
+}
+
+// readChunkFormat reads the version of the chunk file f and positions f after its
+// header.
+func readChunkFormat(f *os.File) (int, error) {
+	head := make([]byte, chunkHeaderSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return 0, err
+	}
+	version, skip, err := chunkFormat(head[:n])
+	if err != nil {
+		return 0, err
+	}
+	_, err = f.Seek(int64(skip), io.SeekStart)
+	return version, err
+}
+
+// manifestFormat returns the version of a manifest read from disk.
+func manifestFormat(m *Manifest) (int, error) {
+	switch {
+	case m.Format == "" && m.FormatVersion == 0:
+		return 1, nil
+	case m.Format != manifestMagic:
+		return 0, fmt.Errorf("%s is not a sort manifest (format %q)", manifestFile, m.Format)
+	case m.FormatVersion > SpillFormatVersion:
+		return 0, fmt.Errorf("%s was written in spill format version %d by a newer binary; this one reads up to version %d", manifestFile, m.FormatVersion, SpillFormatVersion)
+	case m.FormatVersion < 2:
+		return 0, fmt.Errorf("%s names invalid spill format version %d", manifestFile, m.FormatVersion)
+	}
+	return m.FormatVersion, nil
+}
+
+// formatSpread describes the chunk versions a merge reads, for its log: "" when all
+// are the current version.
+func formatSpread(versions map[int]int) string {
+	if len(versions) == 1 && versions[SpillFormatVersion] > 0 {
+		return ""
+	}
+	var parts []string
+	for v := MinSpillFormat; v <= SpillFormatVersion; v++ {
+		if n := versions[v]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d of version %d", n, v))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
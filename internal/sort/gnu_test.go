@@ -0,0 +1,140 @@
+package sort_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	datagen "core-infra-project/internal/data"
+	extSort "core-infra-project/internal/sort"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// TestGNUSortTies checks that the record and input tie breaks reproduce
+// `LC_ALL=C sort -t, -k` and `sort -s` exactly. The sample is sorted in parts that
+// are then merged, so both the chunk sort and the merge's tie breaking are covered,
+// and a share of records reuse earlier keys so there are ties to break.
+func TestGNUSortTies(t *testing.T) {
+	if out, err := exec.Command("sort", "--version").Output(); err != nil || !bytes.Contains(out, []byte("GNU")) {
+		t.Skip("GNU sort is not installed")
+	}
+	const seed = 42
+	gnuKeys := map[string]string{"id": "1,1n", "name": "2,2", "continent": "4,4"}
+	for key, sortIdx := range map[string]int{"id": 0, "name": 1, "continent": 3} {
+		for _, ties := range []extSort.TieBreak{extSort.TiesRecord, extSort.TiesInput} {
+			t.Run(fmt.Sprintf("%s/%s", key, ties), func(t *testing.T) {
+				sample := sampleRecords(20_000, seed, sortIdx, 0.2)
+				gnuArgs := []string{"-t,", "-k" + gnuKeys[key]}
+				if ties == extSort.TiesInput {
+					gnuArgs = append(gnuArgs, "-s")
+				}
+				want := gnuSort(t, sample, gnuArgs)
+				got := sortInParts(t, sample, sortIdx, extSort.Options{Ties: ties}, 4)
+				for i := 0; i < max(len(got), len(want)); i++ {
+					if i >= len(got) || i >= len(want) || !bytes.Equal(got[i], want[i]) {
+						t.Fatalf("output differs from LC_ALL=C sort %s at line %d:\n  sorter: %s\n  GNU:    %s",
+							strings.Join(gnuArgs, " "), i+1, lineAt(got, i), lineAt(want, i))
+					}
+				}
+			})
+		}
+	}
+}
+
+// sampleRecords generates n seeded CSV records. With probability dupRate a record
+// takes the key field of a random earlier one.
+func sampleRecords(n int, seed int64, sortIdx int, dupRate float64) [][]byte {
+	rng := rand.New(rand.NewSource(seed))
+	recs := make([][]byte, n)
+	for i := range recs {
+		recs[i] = datagen.CSV.Seeded(seed, int64(i))
+		if i > 0 && rng.Float64() < dupRate {
+			fields := bytes.Split(recs[i], []byte(","))
+			fields[sortIdx] = bytes.Split(recs[rng.Intn(i)], []byte(","))[sortIdx]
+			recs[i] = bytes.Join(fields, []byte(","))
+		}
+	}
+	return recs
+}
+
+func gnuSort(t *testing.T, records [][]byte, args []string) [][]byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sample.csv")
+	if err := os.WriteFile(path, append(bytes.Join(records, []byte("\n")), '\n'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sort", append(args, path)...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("sort: %v", err)
+	}
+	return bytes.Split(bytes.TrimSuffix(out, []byte("\n")), []byte("\n"))
+}
+
+// sortInParts sorts every part of records separately, then merges the sorted parts
+// in input order.
+func sortInParts(t *testing.T, records [][]byte, sortIdx int, opts extSort.Options, parts int) [][]byte {
+	t.Helper()
+	dir := t.TempDir()
+	var inputs []extSort.MergeInput
+	for p := 0; p < parts; p++ {
+		part := records[p*len(records)/parts : (p+1)*len(records)/parts]
+		var out bytes.Buffer
+		sink := extSort.FuncSink(func(_ context.Context, m gokafka.Message) error {
+			out.Write(m.Value)
+			out.WriteByte('\n')
+			return nil
+		})
+		if _, err := extSort.ExternalSort(&sliceSource{records: part}, sink, sortIdx, filepath.Join(dir, fmt.Sprintf("chunks-%d", p)), opts); err != nil {
+			t.Fatalf("part %d: %v", p, err)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("part-%d.csv", p))
+		if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		in, err := extSort.OpenFileInput(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, in)
+	}
+	var got [][]byte
+	sink := extSort.FuncSink(func(_ context.Context, m gokafka.Message) error {
+		got = append(got, append([]byte(nil), m.Value...))
+		return nil
+	})
+	if _, err := extSort.MergeSorted(inputs, sink, sortIdx, opts); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func lineAt(lines [][]byte, i int) string {
+	if i >= len(lines) {
+		return "(end of output)"
+	}
+	return string(lines[i])
+}
+
+// sliceSource reads records from memory; ReadMessage returns io.EOF after the last.
+type sliceSource struct {
+	records [][]byte
+	next    int
+}
+
+func (s *sliceSource) ReadMessage(context.Context) (gokafka.Message, error) {
+	if s.next == len(s.records) {
+		return gokafka.Message{}, io.EOF
+	}
+	s.next++
+	return gokafka.Message{Value: s.records[s.next-1], Offset: int64(s.next - 1)}, nil
+}
@@ -0,0 +1,551 @@
+package sort
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"core-infra-project/internal/fastnum"
+)
+
+// ValueDecoder reads a named field from record values in a format this package does
+// not parse itself (Confluent-framed Avro, see avro.Decoder). Field returns an int64,
+// a float64, a bool, a string, or nil when the field is null.
+type ValueDecoder interface {
+	Field(val []byte, name string) (interface{}, error)
+}
+
+// KeyType selects how the sort key is read from CSV records and compared.
+type KeyType int
+
+const (
+	// KeyAuto reads the producer's id,name,address,continent layout: index 0 (id) is
+	// an integer, 1 (name) and 3 (continent, the last field) are strings.
+	KeyAuto KeyType = iota
+	// KeyString compares the field at the sort key index, any index, as bytes.
+	KeyString
+	// KeyInt compares the field at the sort key index, any index, as an integer;
+	// fields that don't start with one sort as 0, like malformed ids.
+	KeyInt
+	// KeyFloat compares the field at the sort key index, any index, as a float64 (e.g.
+	// prices or coordinates, which KeyString would order 10.5 before 9.75); fields that
+	// don't parse as one sort as 0.
+	KeyFloat
+)
+
+// UnmarshalText parses string, int or float.
+func (t *KeyType) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "string":
+		*t = KeyString
+	case "int":
+		*t = KeyInt
+	case "float":
+		*t = KeyFloat
+	default:
+		return fmt.Errorf("unknown key type %q (want string, int or float)", b)
+	}
+	return nil
+}
+
+func (t KeyType) String() string {
+	return [...]string{"auto", "string", "int", "float"}[t]
+}
+
+// intKey reports whether keys at sortKeyIndex are precomputed as integers: ids and
+// KeyInt fields, and KeyFloat fields as their floatKey.
+func (t KeyType) intKey(sortKeyIndex int) bool {
+	if t == KeyAuto {
+		return sortKeyIndex == 0
+	}
+	return t == KeyInt || t == KeyFloat
+}
+
+// floatKey returns the integer that orders like f among the keys of other floats:
+// the bits of f, with the sign bit flipped for positive numbers and every bit for
+// negative ones. -0 sorts as 0 and NaN after +Inf.
+func floatKey(f float64) int64 {
+	if f == 0 {
+		f = 0
+	} else if f != f {
+		f = math.NaN()
+	}
+	u := math.Float64bits(f)
+	if u>>63 == 1 {
+		u = ^u
+	} else {
+		u |= 1 << 63
+	}
+	return int64(u ^ 1<<63)
+}
+
+// floatOfKey inverts floatKey.
+func floatOfKey(k int64) float64 {
+	u := uint64(k) ^ 1<<63
+	if u>>63 == 1 {
+		u &^= 1 << 63
+	} else {
+		u = ^u
+	}
+	return math.Float64frombits(u)
+}
+
+// parseFloat reads a float field, returning 0 and false when it does not parse.
+// Integers, the common case, skip strconv's allocation.
+func parseFloat(field []byte) (float64, bool) {
+	if n, err := fastnum.ParseInt(field); err == nil {
+		return float64(n), true
+	}
+	f, err := strconv.ParseFloat(string(field), 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// checkIndex rejects a sort key index t cannot read.
+func (t KeyType) checkIndex(sortKeyIndex int) error {
+	if sortKeyIndex < 0 || t == KeyAuto && sortKeyIndex != 0 && sortKeyIndex != 1 && sortKeyIndex != 3 {
+		return fmt.Errorf("invalid sortKeyIndex: %d", sortKeyIndex)
+	}
+	return nil
+}
+
+// KeyPart is one field of a composite sort key (Options.Keys).
+type KeyPart struct {
+	Index int  // CSV field, from 0
+	Int   bool // compared as an integer (fields not starting with one as 0), else as bytes
+	Float bool // compared as a float64 (fields that don't parse as 0); excludes Int
+	Desc  bool // descending
+}
+
+// String formats p as its index, with :int for integers, :float for floats and a
+// leading - when descending, e.g. "-0:int".
+func (p KeyPart) String() string {
+	s := strconv.Itoa(p.Index)
+	if p.Desc {
+		s = "-" + s
+	}
+	switch {
+	case p.Int:
+		s += ":int"
+	case p.Float:
+		s += ":float"
+	}
+	return s
+}
+
+// FormatKeys formats composite key parts, e.g. "3,-0:int" for the manifest.
+func FormatKeys(parts []KeyPart) string {
+	strs := make([]string, len(parts))
+	for i, p := range parts {
+		strs[i] = p.String()
+	}
+	return strings.Join(strs, ",")
+}
+
+// checkKey rejects a sort key the options can't read: a bad sort key index,
+// composite parts with negative indices or a key path, or a locale that is invalid or
+// given for a key that is not a single string.
+func checkKey(sortKeyIndex int, opts Options) error {
+	if opts.Locale != "" {
+		if len(opts.Keys) > 0 || opts.KeyType.intKey(sortKeyIndex) {
+			return fmt.Errorf("locale collation applies to single string keys")
+		}
+		if _, err := newCollator(opts.Locale); err != nil {
+			return err
+		}
+	}
+	if len(opts.Keys) == 0 {
+		if opts.KeyType == KeyFloat && (opts.KeyPath != "" || opts.Decoder != nil) {
+			return fmt.Errorf("float keys read CSV fields and cannot be used with a key path")
+		}
+		return opts.KeyType.checkIndex(sortKeyIndex)
+	}
+	if opts.KeyPath != "" || opts.Decoder != nil {
+		return fmt.Errorf("composite keys read CSV fields and cannot be used with a key path")
+	}
+	for _, p := range opts.Keys {
+		if p.Index < 0 {
+			return fmt.Errorf("invalid composite key field index: %d", p.Index)
+		}
+		if p.Int && p.Float {
+			return fmt.Errorf("composite key field %d cannot be both an integer and a float", p.Index)
+		}
+	}
+	return nil
+}
+
+// Composite keys are encoded as text that sorts like the tuple of their fields, so
+// they compare, spill and merge as plain string keys: integers as 16 hex digits of
+// their bits with the sign bit flipped (floats likewise, of their floatKey), strings
+// as the hex digits of their bytes and a terminator, which sorts before any digit so
+// that prefixes come first. Descending parts invert the bits, and their terminator
+// sorts after any digit.
+const (
+	hexDigits = "0123456789abcdef"
+	ascEnd    = '.'
+	descEnd   = '~'
+)
+
+// composite encodes the composite key of the CSV record val.
+func (k keyExtractor) composite(val []byte) string {
+	buf := make([]byte, 0, 64)
+	for _, p := range k.parts {
+		field := csvField(val, p.Index)
+		if p.Int || p.Float {
+			n := fastnum.LeadingInt(field)
+			if p.Float {
+				f, _ := parseFloat(field)
+				n = floatKey(f)
+			}
+			u := uint64(n) ^ 1<<63
+			if p.Desc {
+				u = ^u
+			}
+			for shift := 60; shift >= 0; shift -= 4 {
+				buf = append(buf, hexDigits[u>>shift&15])
+			}
+			continue
+		}
+		if k.norm != (KeyNormalization{}) {
+			field = []byte(k.norm.apply(string(field)))
+		}
+		flip, end := byte(0), byte(ascEnd)
+		if p.Desc {
+			flip, end = 0xff, descEnd
+		}
+		for _, b := range field {
+			b ^= flip
+			buf = append(buf, hexDigits[b>>4], hexDigits[b&15])
+		}
+		buf = append(buf, end)
+	}
+	return string(buf)
+}
+
+// displayInt renders an integer key for logs, the manifest and the key index: floats
+// as the field's value, ids as they are.
+func (k keyExtractor) displayInt(n int64) string {
+	if k.float {
+		return strconv.FormatFloat(floatOfKey(n), 'g', -1, 64)
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// display renders a key for logs, the manifest and the key index: composite keys as
+// their comma-separated fields, collated keys without their collation key, others as
+// they are.
+func (k keyExtractor) display(key string) string {
+	if k.collator != nil {
+		return k.collator.display(key)
+	}
+	if k.parts == nil {
+		return key
+	}
+	out := make([]byte, 0, len(key)/2)
+	for i, p := range k.parts {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		if p.Int || p.Float {
+			if len(key) < 16 {
+				return key // not a composite key
+			}
+			u, _ := strconv.ParseUint(key[:16], 16, 64)
+			if p.Desc {
+				u = ^u
+			}
+			if p.Float {
+				out = strconv.AppendFloat(out, floatOfKey(int64(u^1<<63)), 'g', -1, 64)
+			} else {
+				out = strconv.AppendInt(out, int64(u^1<<63), 10)
+			}
+			key = key[16:]
+			continue
+		}
+		var flip byte
+		if p.Desc {
+			flip = 0xff
+		}
+		for len(key) >= 2 && key[0] != ascEnd && key[0] != descEnd {
+			out = append(out, (unhex(key[0])<<4|unhex(key[1]))^flip)
+			key = key[2:]
+		}
+		if key != "" {
+			key = key[1:]
+		}
+	}
+	return string(out)
+}
+
+func unhex(c byte) byte {
+	if c >= 'a' {
+		return c - 'a' + 10
+	}
+	return c - '0'
+}
+
+// keyExtractor computes a record's sort key. By default it reads the CSV field at
+// the sort key index (of any CSV layout with Options.KeyType), or with Options.Keys
+// the encoded tuple of several fields (see composite); with Options.KeyPath
+// it walks a JSON document instead (e.g. "after.id" in a Debezium envelope), after
+// skipping Options.ValuePrefixBytes, or, with Options.Decoder, decodes the field
+// named KeyPath.
+// The record itself is never modified, so envelopes pass through to the output intact.
+type keyExtractor struct {
+	sortKeyIndex int
+	intKey       bool
+	typed        bool // KeyType set: the field at the index of any CSV layout
+	path         []string
+	skip         int
+	norm         KeyNormalization
+	decoder      ValueDecoder
+	float        bool      // KeyFloat: keyInt holds the field's floatKey
+	parts        []KeyPart // composite key, nil for a single field
+	collator     *collator // Options.Locale, nil for byte order
+}
+
+func newKeyExtractor(sortKeyIndex int, opts Options) keyExtractor {
+	k := keyExtractor{sortKeyIndex: sortKeyIndex, intKey: opts.KeyType.intKey(sortKeyIndex), typed: opts.KeyType != KeyAuto, skip: opts.ValuePrefixBytes, norm: opts.Normalize, decoder: opts.Decoder}
+	if opts.KeyPath != "" {
+		k.path = strings.Split(opts.KeyPath, ".")
+	}
+	if len(opts.Keys) > 0 {
+		k.parts, k.intKey = opts.Keys, false
+	} else {
+		k.float = opts.KeyType == KeyFloat
+	}
+	if opts.Locale != "" {
+		k.collator, _ = newCollator(opts.Locale) // checked by checkKey
+	}
+	return k
+}
+
+// fill sets r's precomputed key from r.data.
+func (k keyExtractor) fill(r *recordWithKey) error {
+	if err := k.extract(r); err != nil {
+		return err
+	}
+	if !k.intKey && k.parts == nil {
+		r.keyStr = k.norm.apply(r.keyStr)
+		if k.collator != nil {
+			r.keyStr = k.collator.key(r.keyStr)
+		}
+	}
+	return nil
+}
+
+func (k keyExtractor) extract(r *recordWithKey) error {
+	val := r.data
+	if k.skip > 0 {
+		if len(val) < k.skip {
+			return fmt.Errorf("value of %d bytes is shorter than the %d-byte prefix", len(val), k.skip)
+		}
+		val = val[k.skip:]
+	}
+	if k.path == nil {
+		switch {
+		case k.parts != nil:
+			r.keyStr = k.composite(val)
+		case k.float:
+			f, _ := parseFloat(csvField(val, k.sortKeyIndex))
+			r.keyInt = floatKey(f)
+		case k.typed && k.intKey:
+			r.keyInt = fastnum.LeadingInt(csvField(val, k.sortKeyIndex))
+		case k.typed:
+			r.keyStr = string(csvField(val, k.sortKeyIndex))
+		case k.intKey:
+			r.keyInt = fastnum.LeadingInt(val)
+		default:
+			r.keyStr = extractKeyString(val, k.sortKeyIndex)
+		}
+		return nil
+	}
+
+	if k.decoder != nil {
+		return k.decoded(r, val)
+	}
+
+	raw, err := jsonPath(val, k.path)
+	if err != nil {
+		return err
+	}
+	if k.intKey {
+		var ok bool
+		if r.keyInt, ok = jsonInt(raw); !ok && raw != nil {
+			err = fmt.Errorf("%s is not an integer", raw)
+		}
+	} else {
+		r.keyStr, err = jsonString(raw)
+	}
+	if err != nil {
+		return fmt.Errorf("key path %s: %w", strings.Join(k.path, "."), err)
+	}
+	return nil
+}
+
+// decoded sets r's key from the field the decoder returns. Null fields sort first,
+// like missing JSON paths.
+func (k keyExtractor) decoded(r *recordWithKey, val []byte) error {
+	field := strings.Join(k.path, ".")
+	v, err := k.decoder.Field(val, field)
+	if err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case nil:
+	case int64:
+		if k.intKey {
+			r.keyInt = v
+		} else {
+			r.keyStr = strconv.FormatInt(v, 10)
+		}
+	case string:
+		if k.intKey {
+			if r.keyInt, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return fmt.Errorf("field %s: %w", field, err)
+			}
+		} else {
+			r.keyStr = v
+		}
+	default:
+		if k.intKey {
+			return fmt.Errorf("field %s: %T is not an integer key", field, v)
+		}
+		r.keyStr = fmt.Sprint(v)
+	}
+	return nil
+}
+
+// jsonPath returns the raw JSON at path, or nil when a field is missing or null
+// (e.g. "after" in a Debezium delete event). Such records sort first.
+func jsonPath(doc []byte, path []string) (json.RawMessage, error) {
+	raw := json.RawMessage(doc)
+	for _, field := range path {
+		if isJSONNull(raw) {
+			return nil, nil
+		}
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return nil, fmt.Errorf("key path field %q: %w", field, err)
+		}
+		var ok bool
+		if raw, ok = obj[field]; !ok {
+			return nil, nil
+		}
+	}
+	if isJSONNull(raw) {
+		return nil, nil
+	}
+	return raw, nil
+}
+
+func isJSONNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
+
+// jsonInt accepts integers encoded as JSON numbers or strings (Debezium encodes
+// some numeric types as strings). It returns 0 and false for a missing or null field
+// (raw nil), which sorts first, and for one that is not an integer.
+func jsonInt(raw json.RawMessage) (int64, bool) {
+	if raw == nil {
+		return 0, false
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		s = string(raw)
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
+
+// jsonString returns JSON strings unquoted and any other value as its JSON text.
+func jsonString(raw json.RawMessage) (string, error) {
+	if raw == nil {
+		return "", nil
+	}
+	if raw[0] == '"' {
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	}
+	return string(raw), nil
+}
+
+// KeyNormalization rewrites string sort keys before comparison, so datasets such as
+// numbers stored in a text column sort as intended. Records are written out unchanged.
+// Steps apply in order: trim, fold, pad.
+type KeyNormalization struct {
+	Trim     bool // strip leading and trailing whitespace
+	Fold     bool // compare case-insensitively (lower case)
+	PadWidth int  // left-pad all-digit keys with zeros to this width, so they sort numerically
+}
+
+// UnmarshalText parses a comma-separated spec such as "trim,fold,pad=10".
+func (n *KeyNormalization) UnmarshalText(b []byte) error {
+	*n = KeyNormalization{}
+	if len(b) == 0 {
+		return nil
+	}
+	for _, step := range strings.Split(string(b), ",") {
+		switch name, arg, _ := strings.Cut(strings.TrimSpace(step), "="); name {
+		case "trim":
+			n.Trim = true
+		case "fold":
+			n.Fold = true
+		case "pad":
+			w, err := strconv.Atoi(arg)
+			if err != nil || w < 1 || w > 64 {
+				return fmt.Errorf("pad width must be in [1, 64], got %q", arg)
+			}
+			n.PadWidth = w
+		default:
+			return fmt.Errorf("unknown key normalization %q (want trim, fold or pad=W)", step)
+		}
+	}
+	return nil
+}
+
+// String formats n in the syntax accepted by UnmarshalText.
+func (n KeyNormalization) String() string {
+	var steps []string
+	if n.Trim {
+		steps = append(steps, "trim")
+	}
+	if n.Fold {
+		steps = append(steps, "fold")
+	}
+	if n.PadWidth > 0 {
+		steps = append(steps, "pad="+strconv.Itoa(n.PadWidth))
+	}
+	return strings.Join(steps, ",")
+}
+
+func (n KeyNormalization) apply(key string) string {
+	if n.Trim {
+		key = strings.TrimSpace(key)
+	}
+	if n.Fold {
+		key = strings.ToLower(key)
+	}
+	if n.PadWidth > len(key) && isDigits(key) {
+		key = strings.Repeat("0", n.PadWidth-len(key)) + key
+	}
+	return key
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package sort
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// manifestFile is the name of the chunk manifest written into the temp directory.
+const manifestFile = "manifest.json"
+
+// ChunkInfo describes one spilled, sorted chunk file.
+type ChunkInfo struct {
+	File      string `json:"file"`
+	Records   int    `json:"records"`
+	Bytes     int64  `json:"bytes"`
+	DiskBytes int64  `json:"disk_bytes"` // differs from Bytes when spill compression is enabled
+	MinKey    string `json:"min_key"`
+	MaxKey    string `json:"max_key"`
+	Format    int    `json:"format,omitempty"` // spill format version, omitted for version 1
+}
+
+// Manifest records the chunks produced by Phase 1, so the inputs of a merge can be
+// inspected after the fact (e.g. when a merge produced unexpected ordering).
+type Manifest struct {
+	// manifestMagic and the spill format version, both omitted by version 1
+	Format        string `json:"format,omitempty"`
+	FormatVersion int    `json:"format_version,omitempty"`
+
+	SortKeyIndex int         `json:"sort_key_index"`
+	CreatedAt    time.Time   `json:"created_at"`
+	Chunks       []ChunkInfo `json:"chunks"`
+
+	// Chunk format settings a resumed merge must match
+	SpillCompression string `json:"spill_compression"`
+	PayloadRefs      bool   `json:"payload_refs,omitempty"`
+	LatestPerKey     bool   `json:"latest_per_key,omitempty"`
+	KeyNormalization string `json:"key_normalization,omitempty"`
+	KeyType          string `json:"key_type,omitempty"`
+	Keys             string `json:"keys,omitempty"`      // composite key parts (Options.Keys)
+	Locale           string `json:"locale,omitempty"`    // collation (Options.Locale)
+	Encrypted        bool   `json:"encrypted,omitempty"` // per-job key, discarded with the job; key ranges omitted
+	Escaped          bool   `json:"escaped,omitempty"`   // binary records with newlines escaped
+	Headers          bool   `json:"headers,omitempty"`   // record metadata sidecars (CarryHeaders)
+	Ties             string `json:"ties,omitempty"`
+}
+
+// writeManifest writes m as indented JSON via a temp file + rename, so a crash
+// never leaves a truncated manifest behind.
+func writeManifest(dir string, m *Manifest) (string, error) {
+	path := filepath.Join(dir, manifestFile)
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
+
+// readManifest loads the manifest a previous run left in dir.
+func readManifest(dir string) (*Manifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", manifestFile, err)
+	}
+	if _, err := manifestFormat(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// chunkInfo summarizes a sorted chunk of the given spill format version: record count,
+// raw and on-disk size, and key range.
+func chunkInfo(path string, records []recordWithKey, keys keyExtractor, version int) ChunkInfo {
+	info := ChunkInfo{File: filepath.Base(path), Records: len(records)}
+	if version > 1 {
+		info.Format = version
+	}
+	for _, r := range records {
+		info.Bytes += int64(len(r.data)) + 1 // newline
+	}
+	if st, err := os.Stat(path); err == nil {
+		info.DiskBytes = st.Size()
+	}
+	if len(records) > 0 {
+		info.MinKey = displayKey(records[0], keys)
+		info.MaxKey = displayKey(records[len(records)-1], keys)
+	}
+	return info
+}
+
+// displayKey renders a record's precomputed key for logs and the manifest.
+func displayKey(r recordWithKey, keys keyExtractor) string {
+	if keys.intKey {
+		return keys.displayInt(r.keyInt)
+	}
+	return keys.display(r.keyStr)
+}
@@ -0,0 +1,181 @@
+package sort
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// maskFields are the CSV fields a mask rule can name, in record order.
+var maskFields = []string{"id", "name", "address", "continent"}
+
+// Mask redacts fields of CSV records on their way out of the merge, so sorted copies
+// of production data can be shared: rules like "address=null,name=hash,id=truncate:3"
+// empty a field, replace it with the first 16 hex digits of its HMAC-SHA256 under the
+// mask key (plain SHA-256 without one), or keep its first n characters. Hashing is
+// deterministic, so masked records still join and group on the hashed field.
+type Mask struct {
+	rules []maskRule // ordered by field
+	key   []byte
+}
+
+type maskRule struct {
+	field int
+	kind  string // hash, truncate or null
+	keep  int    // characters kept by truncate
+}
+
+// ParseMask parses comma-separated field=action rules; key, if any, keys the hash.
+func ParseMask(spec string, key []byte) (*Mask, error) {
+	m := &Mask{key: key}
+	seen := map[int]bool{}
+	for _, rule := range strings.Split(spec, ",") {
+		name, action, ok := strings.Cut(strings.TrimSpace(rule), "=")
+		if !ok {
+			return nil, fmt.Errorf("mask rule %q is not field=action", rule)
+		}
+		field := -1
+		for i, f := range maskFields {
+			if f == name {
+				field = i
+			}
+		}
+		if field < 0 {
+			return nil, fmt.Errorf("unknown field %q in mask rule (want id, name, address or continent)", name)
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("field %s is masked twice", name)
+		}
+		seen[field] = true
+		r := maskRule{field: field, kind: action}
+		if n, ok := strings.CutPrefix(action, "truncate:"); ok {
+			keep, err := strconv.Atoi(n)
+			if err != nil || keep < 0 {
+				return nil, fmt.Errorf("mask rule %q: truncate takes a non-negative length, e.g. truncate:3", rule)
+			}
+			r.kind, r.keep = "truncate", keep
+		} else if action != "hash" && action != "null" {
+			return nil, fmt.Errorf("mask rule %q: unknown action %q (want hash, truncate:N or null)", rule, action)
+		}
+		m.rules = append(m.rules, r)
+	}
+	// Applied in one pass over the record's fields
+	for i := 1; i < len(m.rules); i++ {
+		for j := i; j > 0 && m.rules[j].field < m.rules[j-1].field; j-- {
+			m.rules[j], m.rules[j-1] = m.rules[j-1], m.rules[j]
+		}
+	}
+	return m, nil
+}
+
+func (m *Mask) String() string {
+	parts := make([]string, len(m.rules))
+	for i, r := range m.rules {
+		action := r.kind
+		if r.kind == "truncate" {
+			action += ":" + strconv.Itoa(r.keep)
+		}
+		parts[i] = maskFields[r.field] + "=" + action
+	}
+	return strings.Join(parts, ",")
+}
+
+// Apply appends the masked record to dst. Fields a short (malformed) record lacks are
+// left alone; null values (tombstones) stay null.
+func (m *Mask) Apply(dst, rec []byte) []byte {
+	if rec == nil {
+		return dst
+	}
+	rules := m.rules
+	for field := 0; ; field++ {
+		end := bytes.IndexByte(rec, ',')
+		value := rec
+		if end >= 0 {
+			value = rec[:end]
+		}
+		if len(rules) > 0 && rules[0].field == field {
+			dst = rules[0].apply(dst, value, m.key)
+			rules = rules[1:]
+		} else {
+			dst = append(dst, value...)
+		}
+		if end < 0 {
+			return dst
+		}
+		dst = append(dst, ',')
+		rec = rec[end+1:]
+	}
+}
+
+func (r maskRule) apply(dst, value, key []byte) []byte {
+	switch r.kind {
+	case "hash":
+		var sum []byte
+		if len(key) > 0 {
+			h := hmac.New(sha256.New, key)
+			h.Write(value)
+			sum = h.Sum(nil)
+		} else {
+			s := sha256.Sum256(value)
+			sum = s[:]
+		}
+		n := len(dst)
+		dst = append(dst, make([]byte, 16)...)
+		hex.Encode(dst[n:], sum[:8])
+		return dst
+	case "truncate":
+		n := 0
+		for i := 0; i < r.keep && n < len(value); i++ {
+			_, size := utf8.DecodeRune(value[n:])
+			n += size
+		}
+		return append(dst, value[:n]...)
+	}
+	return dst // null
+}
+
+// MaskSink masks every value before handing it to the wrapped sink.
+type MaskSink struct {
+	next Sink
+	mask *Mask
+	out  []gokafka.Message
+}
+
+// NewMaskSink wraps next, masking values with mask.
+func NewMaskSink(next Sink, mask *Mask) *MaskSink {
+	return &MaskSink{next: next, mask: mask}
+}
+
+// WriteMessages implements Sink.
+func (s *MaskSink) WriteMessages(ctx context.Context, msgs ...gokafka.Message) error {
+	s.out = s.out[:0]
+	// One buffer per batch, not reused: async writers hold the values after returning
+	size := 0
+	for _, m := range msgs {
+		size += len(m.Value)
+	}
+	buf := make([]byte, 0, size)
+	ends := make([]int, len(msgs))
+	for i, m := range msgs {
+		buf = s.mask.Apply(buf, m.Value)
+		ends[i] = len(buf)
+	}
+	// Sliced once the buffer has stopped growing
+	start := 0
+	for i, m := range msgs {
+		if m.Value != nil {
+			m.Value = buf[start:ends[i]:ends[i]]
+		}
+		start = ends[i]
+		s.out = append(s.out, m)
+	}
+	return s.next.WriteMessages(ctx, s.out...)
+}
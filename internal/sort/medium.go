@@ -0,0 +1,108 @@
+package sort
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/segmentio/kafka-go/compress"
+)
+
+// SpillMedium is what backs the spill directory. On a memory-backed one (tmpfs such
+// as /dev/shm, or ramfs) a spilled chunk still occupies RAM, charged to the same
+// cgroup as the sorter, so spilling saves no memory and compressing it buys little
+// for its CPU cost; the sort instead keeps chunks uncompressed, makes them larger
+// (fewer merge inputs, with no disk latency to hide) within the space left on the
+// filesystem, and memory-maps them for the merge.
+type SpillMedium int
+
+const (
+	// MediumAuto detects the medium from the spill directory's filesystem.
+	MediumAuto SpillMedium = iota
+	// MediumDisk uses the layout for block storage, the historical behavior.
+	MediumDisk
+	// MediumMemory uses the layout for memory-backed files.
+	MediumMemory
+)
+
+// UnmarshalText parses auto, disk or memory.
+func (m *SpillMedium) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "auto":
+		*m = MediumAuto
+	case "disk":
+		*m = MediumDisk
+	case "memory":
+		*m = MediumMemory
+	default:
+		return fmt.Errorf("unknown spill medium %q (want auto, disk or memory)", b)
+	}
+	return nil
+}
+
+func (m SpillMedium) String() string {
+	return [...]string{"auto", "disk", "memory"}[m]
+}
+
+// resolve returns m, or for MediumAuto the medium detected for dir.
+func (m SpillMedium) resolve(dir string) SpillMedium {
+	if m != MediumAuto {
+		return m
+	}
+	if _, ok := memoryBacked(dir); ok {
+		return MediumMemory
+	}
+	return MediumDisk
+}
+
+// openChunk opens a chunk file for the merge, memory-mapped on MediumMemory where
+// the platform supports it.
+func openChunk(path string, codec compress.Codec, key *spillKey, opts Options) (*fileScanner, error) {
+	if opts.SpillMedium == MediumMemory {
+		sc, err := newMappedScanner(path, codec, key)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return sc, err
+		}
+	}
+	return newFileScanner(path, codec, key, opts.ioBufferSize())
+}
+
+// newMappedScanner is newFileScanner over a read-only mapping of path: the pages are
+// already in memory, so reads are copies out of the page cache with no syscalls, and
+// only a small buffer sits in front of them.
+func newMappedScanner(path string, codec compress.Codec, key *spillKey) (*fileScanner, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // the mapping outlives the descriptor
+	data, err := mmapFile(f)
+	if err != nil {
+		return nil, err
+	}
+	sc := &fileScanner{path: path, mapped: data}
+	version, skip, err := chunkFormat(data)
+	if err != nil {
+		sc.close()
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	sc.format = version
+	r, err := key.reader(bytes.NewReader(data[skip:]))
+	if err != nil {
+		sc.close()
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	if codec != nil {
+		sc.cr = codec.NewReader(r)
+		r = sc.cr
+	}
+	sc.br = bufio.NewReaderSize(r, mappedBufferSize)
+	return sc, nil
+}
+
+// mappedBufferSize buffers reads from mapped chunks, which only need enough to
+// amortize the per-call overhead.
+const mappedBufferSize = 64 << 10
@@ -0,0 +1,46 @@
+package sort
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	tmpfsMagic = 0x01021994 // TMPFS_MAGIC, also /dev/shm
+	ramfsMagic = 0x858458f6 // RAMFS_MAGIC
+)
+
+// memoryBacked reports whether dir is on tmpfs or ramfs, and the bytes still free
+// there (0 on ramfs, which has no size limit).
+func memoryBacked(dir string) (free uint64, ok bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, false
+	}
+	switch uint32(st.Type) {
+	case tmpfsMagic:
+		return st.Bavail * uint64(st.Bsize), true
+	case ramfsMagic:
+		return 0, true
+	}
+	return 0, false
+}
+
+// mmapFile maps f read-only; unmap releases it.
+func mmapFile(f *os.File) ([]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() == 0 {
+		return []byte{}, nil // mmap rejects empty lengths
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func unmap(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return syscall.Munmap(b)
+}
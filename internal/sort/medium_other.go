@@ -0,0 +1,17 @@
+//go:build !linux
+
+package sort
+
+import (
+	"errors"
+	"os"
+)
+
+// memoryBacked reports no memory-backed spill directories where they cannot be
+// detected; MediumMemory can still be chosen explicitly.
+func memoryBacked(dir string) (free uint64, ok bool) { return 0, false }
+
+// mmapFile is unsupported here, so chunks are read through a buffer instead.
+func mmapFile(f *os.File) ([]byte, error) { return nil, errors.ErrUnsupported }
+
+func unmap(b []byte) error { return nil }
@@ -0,0 +1,86 @@
+package sort
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+)
+
+// MergeInput is one already-sorted stream of records for MergeSorted. Next returns io.EOF
+// once the input is exhausted. Chunk files, plain record files and Kafka partitions
+// (see the kafka package) all satisfy it.
+type MergeInput interface {
+	Name() string
+	Next() ([]byte, error)
+	BytesRead() int64
+	Close() error
+}
+
+// Name implements MergeInput.
+func (s *fileScanner) Name() string { return filepath.Base(s.path) }
+
+// Next implements MergeInput.
+func (s *fileScanner) Next() ([]byte, error) { return s.next() }
+
+// BytesRead implements MergeInput.
+func (s *fileScanner) BytesRead() int64 { return s.bytesRead }
+
+// Close implements MergeInput.
+func (s *fileScanner) Close() error { return s.close() }
+
+// OpenFileInput opens a newline-delimited record file sorted by the merge key.
+func OpenFileInput(path string) (MergeInput, error) {
+	return newFileScanner(path, nil, nil, defaultIOBufferSize)
+}
+
+// OpenChunkDir opens every chunk listed in the manifest a sort left in dir, using the
+// manifest's spill compression. Chunks holding payload references or written with
+// latest-per-key cannot be merged standalone.
+func OpenChunkDir(dir string) ([]MergeInput, error) {
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if m.Encrypted {
+		return nil, fmt.Errorf("chunks in %s are encrypted with a key that only existed in their sort run", dir)
+	}
+	if m.PayloadRefs || m.LatestPerKey {
+		return nil, fmt.Errorf("chunks in %s need the state of their sort run (payload store or latest-per-key)", dir)
+	}
+	var opts Options
+	if err := opts.SpillCompression.UnmarshalText([]byte(m.SpillCompression)); err != nil {
+		return nil, fmt.Errorf("%s: %w", manifestFile, err)
+	}
+	var inputs []MergeInput
+	for _, c := range m.Chunks {
+		sc, err := newFileScanner(filepath.Join(dir, c.File), opts.SpillCompression.Codec(), nil, defaultIOBufferSize)
+		if err != nil {
+			for _, in := range inputs {
+				in.Close()
+			}
+			return nil, err
+		}
+		sc.unescape = m.Escaped
+		inputs = append(inputs, sc)
+	}
+	return inputs, nil
+}
+
+// MergeSorted k-way merges already-sorted inputs into sink without a chunk phase. It
+// closes the inputs. The merge verifies order as it goes, so unsorted inputs fail with an
+// OrderError naming the input.
+func MergeSorted(inputs []MergeInput, sink Sink, sortKeyIndex int, opts Options) (MergeStats, error) {
+	defer func() {
+		for _, in := range inputs {
+			_ = in.Close()
+		}
+	}()
+	if err := checkKey(sortKeyIndex, opts); err != nil {
+		return MergeStats{}, err
+	}
+	if opts.Payloads != nil || opts.LatestPerKey || opts.CarryHeaders || len(opts.Quantiles) > 0 {
+		return MergeStats{}, fmt.Errorf("payload store, latest-per-key, carried headers and quantiles need a full sort run")
+	}
+	fmt.Printf("[Merge] Merging %d sorted inputs...\n", len(inputs))
+	return mergeInputs(context.Background(), inputs, sidecars{}, sink, sortKeyIndex, opts, nil, nil)
+}
@@ -0,0 +1,134 @@
+package sort
+
+import (
+	"context"
+	"sync"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// SeqSink is a Sink that also accepts merge batches out of order: seq numbers the
+// batches of a merge from 0 in output order, and the sink puts each one in place
+// itself (e.g. a file part named by seq). With Options.Writers above 1 its batches
+// are written concurrently.
+type SeqSink interface {
+	Sink
+	WriteBatch(ctx context.Context, seq int64, msgs []gokafka.Message) error
+}
+
+// parallelSink hands merge batches to writer goroutines so the merge runs ahead of
+// slow writes. A SeqSink gets them concurrently; any other sink gets them one at a
+// time in sequence order, each writer waiting its turn, so its output is exactly
+// what a single writer would produce. The first error is returned by the next
+// WriteMessages and by wait.
+type parallelSink struct {
+	next   Sink
+	seq    SeqSink // next, if it accepts batches out of order
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   chan seqBatch
+	wg     sync.WaitGroup
+	sent   int64 // batches handed out, the seq of the next one
+	closed sync.Once
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	turn int64 // next batch an ordered sink gets
+	err  error
+}
+
+type seqBatch struct {
+	seq  int64
+	msgs []gokafka.Message
+}
+
+func newParallelSink(ctx context.Context, next Sink, writers int) *parallelSink {
+	p := &parallelSink{next: next, jobs: make(chan seqBatch, writers)}
+	p.seq, _ = next.(SeqSink)
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	p.cond = sync.NewCond(&p.mu)
+	p.wg.Add(writers)
+	for i := 0; i < writers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for b := range p.jobs {
+				p.write(b)
+			}
+		}()
+	}
+	return p
+}
+
+// WriteMessages queues a copy of msgs as the next batch; the merge reuses its slice.
+func (p *parallelSink) WriteMessages(ctx context.Context, msgs ...gokafka.Message) error {
+	if err := p.failed(); err != nil {
+		return err
+	}
+	b := seqBatch{seq: p.sent, msgs: append([]gokafka.Message(nil), msgs...)}
+	select {
+	case p.jobs <- b:
+		p.sent++
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.ctx.Done():
+		if err := p.failed(); err != nil {
+			return err
+		}
+		return p.ctx.Err()
+	}
+}
+
+func (p *parallelSink) write(b seqBatch) {
+	if p.seq != nil {
+		if p.failed() == nil {
+			p.fail(p.seq.WriteBatch(p.ctx, b.seq, b.msgs))
+		}
+		return
+	}
+	p.mu.Lock()
+	for p.turn != b.seq {
+		p.cond.Wait()
+	}
+	err := p.err
+	p.mu.Unlock()
+	if err == nil {
+		p.fail(p.next.WriteMessages(p.ctx, b.msgs...))
+	}
+	p.mu.Lock()
+	p.turn++
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+func (p *parallelSink) fail(err error) {
+	if err == nil {
+		return
+	}
+	p.mu.Lock()
+	if p.err == nil {
+		p.err = err
+		p.cancel()
+	}
+	p.mu.Unlock()
+}
+
+func (p *parallelSink) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// wait lets the writers finish the queued batches and returns the first error.
+func (p *parallelSink) wait() error {
+	p.closed.Do(func() { close(p.jobs) })
+	p.wg.Wait()
+	p.cancel()
+	return p.failed()
+}
+
+// abort cancels the writes still queued or in flight and waits for the writers.
+func (p *parallelSink) abort() {
+	p.cancel()
+	p.wait()
+}
@@ -0,0 +1,64 @@
+package sort
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// PartSink writes every merge batch to its own file in Dir, one value per line, named
+// by the batch's sequence number (part-000000000042), so listing the directory in
+// name order reads the sorted output. Parts are renamed into place once complete, so
+// a reader never sees a partial one. As a SeqSink it takes batches in any order.
+type PartSink struct {
+	Dir  string
+	next int64 // seq of the next WriteMessages batch
+}
+
+// NewPartSink creates dir if needed and returns a sink writing parts into it.
+func NewPartSink(dir string) (*PartSink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &PartSink{Dir: dir}, nil
+}
+
+// WriteMessages writes msgs as the part after the previous one.
+func (s *PartSink) WriteMessages(ctx context.Context, msgs ...gokafka.Message) error {
+	err := s.WriteBatch(ctx, s.next, msgs)
+	s.next++
+	return err
+}
+
+// WriteBatch writes msgs as part seq. It may be called concurrently.
+func (s *PartSink) WriteBatch(ctx context.Context, seq int64, msgs []gokafka.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	name := filepath.Join(s.Dir, fmt.Sprintf("part-%012d", seq))
+	f, err := os.Create(name + ".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, m := range msgs {
+		w.Write(m.Value)
+		w.WriteByte('\n')
+	}
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(name+".tmp", name)
+	}
+	if err != nil {
+		os.Remove(name + ".tmp")
+		return fmt.Errorf("part %d: %w", seq, err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package sort
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"core-infra-project/internal/fastnum"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+const (
+	payloadLogFile  = "payloads.log"
+	payloadMetaFile = "payloads.json"
+)
+
+// PayloadStore is an append-only log of record payloads shared by sorts of the same
+// dataset on different keys. With a store, chunk files hold only (offset, length, key)
+// references and the merge reads payloads back from the log. Once sealed, later sorts
+// read the log instead of the source, so only their key files are built.
+type PayloadStore struct {
+	dir  string
+	f    *os.File
+	w    *bufio.Writer
+	meta PayloadMeta
+}
+
+// PayloadMeta is persisted next to the log; a log without Sealed set is incomplete
+// and is discarded on open.
+type PayloadMeta struct {
+	Records int64     `json:"records"`
+	Bytes   int64     `json:"bytes"`
+	Sealed  bool      `json:"sealed"`
+	SavedAt time.Time `json:"saved_at"`
+}
+
+// OpenPayloadStore opens the store in dir, creating it if needed.
+func OpenPayloadStore(dir string) (*PayloadStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &PayloadStore{dir: dir}
+	if b, err := os.ReadFile(filepath.Join(dir, payloadMetaFile)); err == nil {
+		if err := json.Unmarshal(b, &s.meta); err != nil {
+			return nil, fmt.Errorf("payload store %s: %w", dir, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, payloadLogFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+	if !s.meta.Sealed {
+		if err := s.reset(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Sealed reports whether the store holds a complete dataset.
+func (s *PayloadStore) Sealed() bool { return s.meta.Sealed }
+
+// Meta returns the store's record and byte counts.
+func (s *PayloadStore) Meta() PayloadMeta { return s.meta }
+
+// reset truncates an incomplete log, e.g. left behind by a failed attempt.
+func (s *PayloadStore) reset() error {
+	if err := s.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.meta = PayloadMeta{}
+	s.w = bufio.NewWriterSize(s.f, 4<<20)
+	return nil
+}
+
+// append writes rec to the log and returns its offset.
+func (s *PayloadStore) append(rec []byte) (int64, error) {
+	off := s.meta.Bytes
+	if _, err := s.w.Write(rec); err != nil {
+		return 0, err
+	}
+	if err := s.w.WriteByte('\n'); err != nil {
+		return 0, err
+	}
+	s.meta.Bytes += int64(len(rec)) + 1
+	s.meta.Records++
+	return off, nil
+}
+
+// seal flushes the log and marks it complete via temp file + rename.
+func (s *PayloadStore) seal() error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	s.meta.Sealed = true
+	s.meta.SavedAt = time.Now()
+	b, err := json.MarshalIndent(s.meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, payloadMetaFile)
+	if err := os.WriteFile(path+".tmp", b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// readAt returns the n-byte payload at off.
+func (s *PayloadStore) readAt(off int64, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := s.f.ReadAt(buf, off); err != nil {
+		return nil, fmt.Errorf("payload at offset %d: %w", off, err)
+	}
+	return buf, nil
+}
+
+// Close closes the log file.
+func (s *PayloadStore) Close() error { return s.f.Close() }
+
+// source returns a Source replaying the sealed log. Message.Offset carries the payload's
+// log offset rather than a Kafka offset, and io.EOF marks the end of the dataset.
+func (s *PayloadStore) source() Source {
+	return &payloadSource{br: bufio.NewReaderSize(io.NewSectionReader(s.f, 0, s.meta.Bytes), 4<<20)}
+}
+
+type payloadSource struct {
+	br  *bufio.Reader
+	off int64
+}
+
+func (p *payloadSource) ReadMessage(context.Context) (gokafka.Message, error) {
+	line, err := p.br.ReadBytes('\n')
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return gokafka.Message{}, err
+	}
+	msg := gokafka.Message{Value: line[:len(line)-1], Offset: p.off}
+	p.off += int64(len(line))
+	return msg, nil
+}
+
+// appendRef formats a chunk line referencing a payload: offset,length,key. The key
+// is the last field, so its commas need no escaping; newlines and backslashes, which
+// keys read from JSON paths or decoders may hold, are escaped like spilled records.
+func appendRef(dst []byte, r recordWithKey, intKey bool) []byte {
+	dst = fastnum.AppendInt(dst, r.off)
+	dst = append(dst, ',')
+	dst = fastnum.AppendInt(dst, int64(len(r.data)))
+	dst = append(dst, ',')
+	if intKey {
+		return fastnum.AppendInt(dst, r.keyInt)
+	}
+	if strings.ContainsAny(r.keyStr, "\n\\") {
+		return appendEscaped(dst, []byte(r.keyStr))
+	}
+	return append(dst, r.keyStr...)
+}
+
+// parseRef parses a chunk line written by appendRef, unescaping the key in place.
+func parseRef(line []byte) (off int64, n int, key []byte, err error) {
+	parts := bytes.SplitN(line, []byte{','}, 3)
+	if len(parts) != 3 {
+		return 0, 0, nil, fmt.Errorf("malformed payload reference %q", line)
+	}
+	if off, err = fastnum.ParseInt(parts[0]); err != nil {
+		return 0, 0, nil, fmt.Errorf("malformed payload reference %q: %w", line, err)
+	}
+	length, err := fastnum.ParseInt(parts[1])
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("malformed payload reference %q: %w", line, err)
+	}
+	return off, int(length), unescapeRecord(parts[2]), nil
+}
@@ -0,0 +1,56 @@
+package sort_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	extSort "core-infra-project/internal/sort"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// TestPayloadRefKeySeparators sorts through a payload store on keys holding the
+// commas, newlines and backslashes of the chunk lines that reference payloads.
+func TestPayloadRefKeySeparators(t *testing.T) {
+	names := []string{"d", "a,b", "c\n", `b\x`, "a\nz", "a,", `a\`}
+	want := []string{"a\nz", "a,", "a,b", `a\`, `b\x`, "c\n", "d"}
+	var records [][]byte
+	for _, name := range names {
+		b, err := json.Marshal(map[string]string{"name": name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, b)
+	}
+
+	store, err := extSort.OpenPayloadStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var got []string
+	sink := extSort.FuncSink(func(_ context.Context, m gokafka.Message) error {
+		var v struct{ Name string }
+		if err := json.Unmarshal(m.Value, &v); err != nil {
+			return err
+		}
+		got = append(got, v.Name)
+		return nil
+	})
+	if _, err := extSort.ExternalSort(&sliceSource{records: records}, sink, 0, t.TempDir(), extSort.Options{
+		Payloads: store,
+		KeyPath:  "name",
+		KeyType:  extSort.KeyString,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("sorted %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sorted %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package sort
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Run is one sorted chunk file spilled by ChunkAndSpill. Runs of the same call share
+// the state their merge needs (sort key, chunk format, spill key, latest-per-key
+// filter), so they can only be merged with runs from that call.
+type Run struct {
+	Path string
+	ChunkInfo
+
+	set *runSet
+}
+
+// runSet is the state shared by the runs of one ChunkAndSpill call.
+type runSet struct {
+	sortKeyIndex int
+	opts         Options // chunk phase options; the format fields bind the merge
+	key          *spillKey
+	latest       *latestFilter
+}
+
+// mergeOptions returns opts with the fields that describe how the runs were written
+// taken from the chunk phase, so a merge cannot misread them.
+func (s *runSet) mergeOptions(opts Options) Options {
+	opts.SpillCompression = s.opts.SpillCompression
+	opts.Payloads = s.opts.Payloads
+	opts.KeyPath = s.opts.KeyPath
+	opts.Decoder = s.opts.Decoder
+	opts.ValuePrefixBytes = s.opts.ValuePrefixBytes
+	opts.Normalize = s.opts.Normalize
+	opts.KeyType = s.opts.KeyType
+	opts.Keys = s.opts.Keys
+	opts.Locale = s.opts.Locale
+	opts.LatestPerKey = s.opts.LatestPerKey
+	opts.BinaryValues = s.opts.BinaryValues
+	opts.CarryHeaders = s.opts.CarryHeaders
+	opts.Ties = s.opts.Ties
+	opts.SpillMedium = s.opts.SpillMedium
+	return opts
+}
+
+// ChunkAndSpill is Phase 1 of ExternalSort on its own: it reads source until drained,
+// sorts chunks of records in memory and spills them into tempDir (writing the chunk
+// manifest), returning one Run per chunk. It returns no runs if source was empty.
+// The runs stay on disk until Cleanup; with EncryptSpill they are only readable by
+// this process.
+func ChunkAndSpill(ctx context.Context, source Source, sortKeyIndex int, tempDir string, opts Options) ([]Run, error) {
+	return chunkAndSpill(ctx, source, sortKeyIndex, tempDir, opts, &Report{SortKeyIndex: sortKeyIndex})
+}
+
+// Merge is Phase 2 of ExternalSort on its own: it k-way merges runs from one
+// ChunkAndSpill call into sink. Merging a subset of the runs yields the sorted order
+// of that subset. opts.OnMerge is called first with the number of records it will
+// write: those in runs, less the ones latest-per-key drops.
+// The runs are left on disk, so a failed merge can be retried.
+func Merge(ctx context.Context, runs []Run, sink Sink, opts Options) (MergeStats, error) {
+	if len(runs) == 0 {
+		return MergeStats{}, nil
+	}
+	set := runs[0].set
+	var records int64
+	files := make([]string, len(runs))
+	for i, r := range runs {
+		if r.set == nil {
+			return MergeStats{}, fmt.Errorf("run %s was not produced by ChunkAndSpill", r.Path)
+		}
+		if r.set != set {
+			return MergeStats{}, fmt.Errorf("runs %s and %s come from different ChunkAndSpill calls", runs[0].Path, r.Path)
+		}
+		files[i] = r.Path
+		records += int64(r.Records)
+	}
+	opts = set.mergeOptions(opts)
+	for _, q := range opts.Quantiles {
+		if !(q > 0 && q <= 1) {
+			return MergeStats{}, fmt.Errorf("quantile %g is outside (0, 1]", q)
+		}
+	}
+	if len(opts.Quantiles) > 0 && set.latest != nil {
+		return MergeStats{}, fmt.Errorf("quantiles need the output size up front, which latest-per-key only knows after the merge")
+	}
+
+	// Merge phase: k-way merge using min-heap
+	fmt.Printf("[Phase 2] Starting k-way merge of %d chunks...\n", len(runs))
+	expvarPhase.Set("merge")
+	expvarRecords.Set(0)
+	if opts.OnMerge != nil {
+		if set.latest != nil {
+			superseded, err := set.latest.countSuperseded(files)
+			if err != nil {
+				return MergeStats{}, err
+			}
+			records -= superseded
+		}
+		if err := opts.OnMerge(records); err != nil {
+			return MergeStats{}, err
+		}
+	}
+	clock := opts.clock()
+	start := clock.Now()
+	quantiles := newQuantileTracker(opts.Quantiles, records)
+	stats, err := kWayMergeToKafka(ctx, files, sink, set.sortKeyIndex, opts, set.key, set.latest, quantiles)
+	if err != nil {
+		return stats, err
+	}
+	elapsed := clock.Now().Sub(start)
+	expvarMergeSeconds.Set(elapsed.Seconds())
+	fmt.Printf("[Phase 2] Completed: merged %d records from %d chunks in %v\n",
+		stats.Records, len(runs), elapsed)
+	fmt.Printf("[Phase 2] Heap: %d pushes, %d pops, %d comparisons (%.1f per record)\n",
+		stats.HeapPushes, stats.HeapPops, stats.Comparisons, float64(stats.Comparisons)/float64(max(stats.Records, 1)))
+	if len(stats.Quantiles) > 0 {
+		fmt.Printf("[Phase 2] Key quantiles: %s\n", formatQuantiles(stats.Quantiles))
+	}
+	if opts.Emit != EmitRecords {
+		fmt.Printf("[Phase 2] Emit %s: %d records", opts.Emit, stats.Records)
+		if opts.Emit == EmitKeyCounts {
+			fmt.Printf(" as %d distinct keys", stats.Keys)
+		}
+		fmt.Println()
+	}
+	if stats.LingerFlushes > 0 {
+		fmt.Printf("[Phase 2] Batch linger: wrote %d partial batches after %v\n", stats.LingerFlushes, opts.BatchLinger)
+	}
+	if set.latest != nil {
+		fmt.Printf("[Phase 2] Latest per key: dropped %d superseded records (%d distinct keys)\n", stats.Superseded, len(set.latest.latest))
+	}
+	return stats, nil
+}
+
+// Cleanup removes the chunk files of runs (shredding them with ShredSpill) and their
+// sidecars. It removes as many as it can and returns the errors it hit. The manifest
+// is kept for debugging.
+func Cleanup(runs []Run) error {
+	var errs []error
+	for _, r := range runs {
+		shred := r.set != nil && r.set.opts.ShredSpill
+		if err := removeSpillFile(r.Path, shred); err != nil {
+			errs = append(errs, fmt.Errorf("removing %s: %w", filepath.Base(r.Path), err))
+		}
+		_ = os.Remove(seqPath(r.Path))
+		if r.set != nil && r.set.opts.CarryHeaders {
+			// Headers are record contents, so they are shredded like the chunk
+			if err := removeSpillFile(metaPath(r.Path), shred); err != nil {
+				errs = append(errs, fmt.Errorf("removing %s: %w", filepath.Base(metaPath(r.Path)), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
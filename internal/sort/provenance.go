@@ -0,0 +1,114 @@
+package sort
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// With Options.CarryHeaders every record's timestamp and headers are encoded when it is
+// read and written to a metadata sidecar next to its chunk, in record order, the same
+// way latest-per-key keeps sequence numbers; the merge reads them back into the output
+// message. Chunk files themselves stay plain values.
+
+var errMetaTruncated = errors.New("record metadata truncated")
+
+// metaPath returns the metadata sidecar of a chunk file.
+func metaPath(chunk string) string { return chunk + ".meta" }
+
+// appendMeta appends the encoded timestamp (0 when unset) and headers of msg to dst.
+func appendMeta(dst []byte, msg gokafka.Message) []byte {
+	var ts int64
+	if !msg.Time.IsZero() {
+		ts = msg.Time.UnixNano()
+	}
+	dst = binary.AppendVarint(dst, ts)
+	dst = binary.AppendUvarint(dst, uint64(len(msg.Headers)))
+	for _, h := range msg.Headers {
+		dst = binary.AppendUvarint(dst, uint64(len(h.Key)))
+		dst = append(dst, h.Key...)
+		dst = binary.AppendUvarint(dst, uint64(len(h.Value)))
+		dst = append(dst, h.Value...)
+	}
+	return dst
+}
+
+// decodeMeta sets the timestamp and headers of msg from b, which header values then
+// share.
+func decodeMeta(b []byte, msg *gokafka.Message) error {
+	ts, n := binary.Varint(b)
+	if n <= 0 {
+		return errMetaTruncated
+	}
+	b = b[n:]
+	if ts != 0 {
+		msg.Time = time.Unix(0, ts)
+	}
+	count, n := binary.Uvarint(b)
+	if n <= 0 || count > uint64(len(b)) {
+		return errMetaTruncated
+	}
+	b = b[n:]
+	msg.Headers = make([]gokafka.Header, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var key, val []byte
+		var ok bool
+		if key, b, ok = cutField(b); !ok {
+			return errMetaTruncated
+		}
+		if val, b, ok = cutField(b); !ok {
+			return errMetaTruncated
+		}
+		msg.Headers = append(msg.Headers, gokafka.Header{Key: string(key), Value: val})
+	}
+	return nil
+}
+
+// cutField splits a length-prefixed field off b.
+func cutField(b []byte) (field, rest []byte, ok bool) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || l > uint64(len(b)-n) {
+		return nil, b, false
+	}
+	b = b[n:]
+	return b[:l], b[l:], true
+}
+
+// writeMetas writes the metadata of a sorted chunk in record order.
+func writeMetas(path string, records []recordWithKey) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bw := bufio.NewWriterSize(f, 1<<20)
+	var buf [binary.MaxVarintLen64]byte
+	for _, r := range records {
+		n := binary.PutUvarint(buf[:], uint64(len(r.meta)))
+		if _, err := bw.Write(buf[:n]); err != nil {
+			return err
+		}
+		if _, err := bw.Write(r.meta); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// readMeta reads the next record's metadata from a sidecar written by writeMetas.
+func readMeta(r *bufio.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
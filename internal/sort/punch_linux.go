@@ -0,0 +1,17 @@
+package sort
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	fallocKeepSize  = 0x1 // FALLOC_FL_KEEP_SIZE
+	fallocPunchHole = 0x2 // FALLOC_FL_PUNCH_HOLE
+)
+
+// punchHole deallocates the blocks of f, which issues a discard on filesystems
+// mounted with TRIM support. Errors are ignored: not every filesystem supports it.
+func punchHole(f *os.File, size int64) {
+	_ = syscall.Fallocate(int(f.Fd()), fallocPunchHole|fallocKeepSize, 0, size)
+}
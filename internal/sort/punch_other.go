@@ -0,0 +1,9 @@
+//go:build !linux
+
+package sort
+
+import "os"
+
+// punchHole is a no-op where hole punching is not available; shredFile still
+// overwrites and unlinks the file.
+func punchHole(f *os.File, size int64) {}
@@ -0,0 +1,75 @@
+package sort
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Quantile is the sort key at quantile Q of the merged output.
+type Quantile struct {
+	Q   float64 `json:"q"`
+	Key string  `json:"key"`
+}
+
+// String formats q as e.g. "p90=12345".
+func (q Quantile) String() string {
+	return fmt.Sprintf("p%g=%s", q.Q*100, q.Key)
+}
+
+// quantileTracker picks the keys at exact quantile positions while the merge emits
+// keys in order. With the output size known up front, quantile q is the key at
+// nearest-rank position ceil(q*total)-1, so no keys are buffered.
+type quantileTracker struct {
+	want []Quantile // ascending by Q; Key filled in as positions are reached
+	pos  []int64
+	next int
+}
+
+// newQuantileTracker returns a tracker for qs (each in (0, 1]) over total records, or
+// nil when there is nothing to track.
+func newQuantileTracker(qs []float64, total int64) *quantileTracker {
+	if len(qs) == 0 || total <= 0 {
+		return nil
+	}
+	t := &quantileTracker{}
+	for _, q := range qs {
+		t.want = append(t.want, Quantile{Q: q})
+	}
+	sort.Slice(t.want, func(i, j int) bool { return t.want[i].Q < t.want[j].Q })
+	for _, w := range t.want {
+		// The epsilon keeps products like 0.7*10 = 7.000000000000001 on their rank
+		p := int64(math.Ceil(w.Q*float64(total)-1e-9)) - 1
+		t.pos = append(t.pos, min(max(p, 0), total-1))
+	}
+	return t
+}
+
+// due reports whether the merged record at output position is at the next quantile.
+func (t *quantileTracker) due(position int64) bool {
+	return t.next < len(t.pos) && t.pos[t.next] == position
+}
+
+// record sets key as the key of every quantile at the position due reported.
+func (t *quantileTracker) record(key string) {
+	p := t.pos[t.next]
+	for t.next < len(t.pos) && t.pos[t.next] == p {
+		t.want[t.next].Key = key
+		t.next++
+	}
+}
+
+// result returns the quantiles reached so far.
+func (t *quantileTracker) result() []Quantile {
+	return t.want[:t.next]
+}
+
+// formatQuantiles joins qs for logs, e.g. "p50=a p90=b".
+func formatQuantiles(qs []Quantile) string {
+	parts := make([]string, len(qs))
+	for i, q := range qs {
+		parts[i] = q.String()
+	}
+	return strings.Join(parts, " ")
+}
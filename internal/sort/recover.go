@@ -0,0 +1,250 @@
+package sort
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// maxRecoveryBackoff caps the delay between recovery attempts.
+const maxRecoveryBackoff = 30 * time.Second
+
+// ErrCredentialsExpired marks errors of expired credentials (e.g. an OAuth token past
+// its lifetime). Sources and sinks wrap it so the failure classifies as ErrorAuth.
+var ErrCredentialsExpired = errors.New("credentials expired")
+
+// ErrorClass is what a failed source read or sink write points at, and so which
+// recovery hook may fix it.
+type ErrorClass int
+
+const (
+	// ErrorOther is anything no hook addresses; it fails the read or write as before.
+	ErrorOther ErrorClass = iota
+	// ErrorConnection is a broken or refused connection or a broker that lost
+	// leadership, e.g. while brokers restart. Reconnector handles it.
+	ErrorConnection
+	// ErrorAuth is rejected or expired credentials. Reauthenticator handles it.
+	ErrorAuth
+	// ErrorFile is a failed read or write of a file, e.g. a stale NFS handle or a
+	// rotated file. Reopener handles it.
+	ErrorFile
+)
+
+func (c ErrorClass) String() string {
+	return [...]string{"other", "connection", "auth", "file"}[c]
+}
+
+// ClassifyError returns the class of a source or sink error. Cancellation, expired
+// contexts and io.EOF are ErrorOther: the pipeline handles them itself. A network
+// timeout is ErrorConnection: the pipeline's own read deadlines expire the context.
+func ClassifyError(err error) ErrorClass {
+	var werrs gokafka.WriteErrors
+	if errors.As(err, &werrs) {
+		for _, e := range werrs {
+			if e != nil {
+				return ClassifyError(e)
+			}
+		}
+		return ErrorOther
+	}
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	var pathErr *fs.PathError
+	var kerr gokafka.Error
+	switch {
+	case err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.EOF):
+		return ErrorOther
+	case errors.Is(err, ErrCredentialsExpired) || errors.Is(err, gokafka.SASLAuthenticationFailed) || errors.Is(err, gokafka.IllegalSASLState):
+		return ErrorAuth
+	case errors.As(err, &pathErr) || errors.Is(err, fs.ErrClosed) || errors.Is(err, syscall.ESTALE):
+		return ErrorFile
+	case errors.As(err, &opErr) || errors.As(err, &dnsErr) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorConnection
+	case errors.As(err, &kerr):
+		if kerr.Temporary() || kerr == gokafka.BrokerNotAvailable {
+			return ErrorConnection
+		}
+	}
+	return ErrorOther
+}
+
+// Reconnector is a Source or Sink that can re-establish its connections, e.g. by
+// reopening its readers where they stopped once brokers are back.
+type Reconnector interface {
+	Reconnect(ctx context.Context) error
+}
+
+// Reauthenticator is a Source or Sink that can renew its credentials, e.g. by fetching
+// a fresh token after the old one expired.
+type Reauthenticator interface {
+	Reauthenticate(ctx context.Context) error
+}
+
+// Reopener is a Source or Sink that can reopen the file it reads or writes, carrying
+// on where it stopped.
+type Reopener interface {
+	Reopen(ctx context.Context) error
+}
+
+// RecoveryPolicy lets long sorts survive broker restarts, credential rotation and
+// flaky file systems without rerunning the job: when a source read or sink write
+// fails with an error ClassifyError maps to a hook the source or sink implements, the
+// pipeline waits out the backoff, runs the hook and retries the read or write. A
+// sink retry only resends the messages a gokafka.WriteErrors reports as failed.
+type RecoveryPolicy struct {
+	Attempts int           // per failed read or write; 0 disables recovery
+	Backoff  time.Duration // before the first attempt, doubled after each (up to 30s); 1s if 0
+
+	// Source and Sink hold the hooks when the Source or Sink passed to the sort wraps
+	// the value implementing them; by default the Source and Sink themselves are used.
+	Source, Sink any
+}
+
+// hook returns the function recovering target from class, or nil if it has none.
+func hook(target any, class ErrorClass) (string, func(context.Context) error) {
+	switch class {
+	case ErrorConnection:
+		if r, ok := target.(Reconnector); ok {
+			return "reconnecting", r.Reconnect
+		}
+	case ErrorAuth:
+		if r, ok := target.(Reauthenticator); ok {
+			return "re-authenticating", r.Reauthenticate
+		}
+	case ErrorFile:
+		if r, ok := target.(Reopener); ok {
+			return "reopening", r.Reopen
+		}
+	}
+	return "", nil
+}
+
+// recoverer runs the hooks of one source or sink.
+type recoverer struct {
+	policy RecoveryPolicy
+	target any
+	side   string // source or sink, for logs
+	clock  Clock
+}
+
+// newRecoverer returns the recoverer of target (policy.Source or policy.Sink, if set,
+// instead), or nil if recovery is disabled.
+func newRecoverer(opts Options, target, hooks any, side string) *recoverer {
+	if opts.Recovery.Attempts <= 0 {
+		return nil
+	}
+	if hooks == nil {
+		hooks = target
+	}
+	return &recoverer{policy: opts.Recovery, target: hooks, side: side, clock: opts.clock()}
+}
+
+// attempt runs the hook for err after the backoff of the nth consecutive failure (from
+// 1) and reports whether the failed read or write should be retried. A nil recoverer
+// never retries.
+func (r *recoverer) attempt(ctx context.Context, n int, err error) bool {
+	if r == nil || n > r.policy.Attempts || ctx.Err() != nil {
+		return false
+	}
+	class := ClassifyError(err)
+	action, fn := hook(r.target, class)
+	if fn == nil {
+		return false
+	}
+	backoff := r.policy.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	backoff = min(backoff<<(n-1), maxRecoveryBackoff)
+	fmt.Printf("[Recover] %s %s error: %v; %s in %v (attempt %d/%d)\n", r.side, class, summarize(err), action, backoff, n, r.policy.Attempts)
+	sleepOn(r.clock, backoff)
+	if herr := fn(ctx); herr != nil {
+		// The retry still runs: it fails into the next attempt if the hook was needed
+		fmt.Printf("[Recover] %s: %s failed: %v\n", r.side, action, herr)
+	}
+	return true
+}
+
+// summarize shortens a gokafka.WriteErrors, which lists every message's error, for logs.
+func summarize(err error) string {
+	var werrs gokafka.WriteErrors
+	if errors.As(err, &werrs) {
+		for _, e := range werrs {
+			if e != nil {
+				return fmt.Sprintf("%d of %d messages failed, first: %v", werrs.Count(), len(werrs), e)
+			}
+		}
+	}
+	return err.Error()
+}
+
+// recoveringSink retries failed writes to a Sink after running its hooks.
+type recoveringSink struct {
+	Sink
+	rec       *recoverer
+	recovered *atomic.Int64
+}
+
+// recoveringSeqSink keeps a SeqSink's out-of-order writes.
+type recoveringSeqSink struct {
+	*recoveringSink
+	seq SeqSink
+}
+
+// newRecoveringSink wraps sink, as a SeqSink if it is one, adding the writes that
+// succeeded after a recovery to recovered. Without recovery it returns sink.
+func newRecoveringSink(sink Sink, opts Options, recovered *atomic.Int64) Sink {
+	rec := newRecoverer(opts, sink, opts.Recovery.Sink, "sink")
+	if rec == nil {
+		return sink
+	}
+	s := &recoveringSink{Sink: sink, rec: rec, recovered: recovered}
+	if seq, ok := sink.(SeqSink); ok {
+		return recoveringSeqSink{s, seq}
+	}
+	return s
+}
+
+// WriteMessages implements Sink.
+func (s *recoveringSink) WriteMessages(ctx context.Context, msgs ...gokafka.Message) error {
+	return s.write(ctx, msgs, func(msgs []gokafka.Message) error { return s.Sink.WriteMessages(ctx, msgs...) })
+}
+
+// WriteBatch implements SeqSink.
+func (s recoveringSeqSink) WriteBatch(ctx context.Context, seq int64, msgs []gokafka.Message) error {
+	return s.write(ctx, msgs, func(msgs []gokafka.Message) error { return s.seq.WriteBatch(ctx, seq, msgs) })
+}
+
+func (s *recoveringSink) write(ctx context.Context, msgs []gokafka.Message, write func([]gokafka.Message) error) error {
+	for n := 1; ; n++ {
+		err := write(msgs)
+		if err == nil {
+			if n > 1 {
+				s.recovered.Add(1)
+			}
+			return nil
+		}
+		if !s.rec.attempt(ctx, n, err) {
+			return err
+		}
+		var werrs gokafka.WriteErrors
+		if errors.As(err, &werrs) && len(werrs) == len(msgs) {
+			// The rest were delivered
+			failed := make([]gokafka.Message, 0, werrs.Count())
+			for i, e := range werrs {
+				if e != nil {
+					failed = append(failed, msgs[i])
+				}
+			}
+			msgs = failed
+		}
+	}
+}
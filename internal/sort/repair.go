@@ -0,0 +1,92 @@
+package sort
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/segmentio/kafka-go/compress"
+)
+
+// ResumeMerge re-runs Phase 2 over the chunk files a failed run left in tempDir,
+// writing only the merged records from opts.ResumeFrom on. The merge is deterministic
+// for a given set of chunks, so record N of the resumed merge is record N of the
+// original output. Chunk files are removed only after the resumed merge succeeds.
+func ResumeMerge(sink Sink, sortKeyIndex int, tempDir string, opts Options) (*Report, error) {
+	clock := opts.clock()
+	start := clock.Now()
+	report := &Report{SortKeyIndex: sortKeyIndex}
+
+	m, err := readManifest(tempDir)
+	if err != nil {
+		return report, fmt.Errorf("no chunks to resume from: %w", err)
+	}
+	// As in the failed run, which spilled uncompressed to a memory-backed directory
+	if opts.SpillMedium = opts.SpillMedium.resolve(tempDir); opts.SpillMedium == MediumMemory {
+		opts.SpillCompression = compress.None
+	}
+	report.SpillMedium = opts.SpillMedium.String()
+	switch {
+	case m.SortKeyIndex != sortKeyIndex:
+		return report, fmt.Errorf("chunks in %s were sorted by key index %d, not %d", tempDir, m.SortKeyIndex, sortKeyIndex)
+	case m.SpillCompression != opts.SpillCompression.String():
+		return report, fmt.Errorf("chunks in %s use spill compression %s, not %s", tempDir, m.SpillCompression, opts.SpillCompression)
+	case m.PayloadRefs != (opts.Payloads != nil):
+		return report, fmt.Errorf("chunks in %s and this run disagree on the payload store", tempDir)
+	case m.KeyNormalization != opts.Normalize.String():
+		return report, fmt.Errorf("chunks in %s use key normalization %q, not %q", tempDir, m.KeyNormalization, opts.Normalize)
+	case m.KeyType != "" && m.KeyType != opts.KeyType.String():
+		return report, fmt.Errorf("chunks in %s compare %s keys, not %s", tempDir, m.KeyType, opts.KeyType)
+	case m.Keys != FormatKeys(opts.Keys):
+		return report, fmt.Errorf("chunks in %s were sorted by composite key %q, not %q", tempDir, m.Keys, FormatKeys(opts.Keys))
+	case m.Locale != opts.Locale:
+		return report, fmt.Errorf("chunks in %s were collated for locale %q, not %q", tempDir, m.Locale, opts.Locale)
+	case m.Escaped != opts.BinaryValues:
+		return report, fmt.Errorf("chunks in %s and this run disagree on binary values", tempDir)
+	case m.Ties != "" && m.Ties != opts.Ties.String():
+		return report, fmt.Errorf("chunks in %s break ties by %s, not %s", tempDir, m.Ties, opts.Ties)
+	case m.Headers != opts.CarryHeaders:
+		return report, fmt.Errorf("chunks in %s and this run disagree on carried headers", tempDir)
+	case m.Encrypted:
+		return report, fmt.Errorf("chunks in %s are encrypted with the key of the failed run, which is gone", tempDir)
+	case m.LatestPerKey:
+		// The superseded set lives only in memory during the original run
+		return report, fmt.Errorf("chunks in %s were written with latest-per-key and cannot be resumed", tempDir)
+	}
+
+	files := make([]string, len(m.Chunks))
+	for i, c := range m.Chunks {
+		files[i] = filepath.Join(tempDir, c.File)
+		if _, err := os.Stat(files[i]); err != nil {
+			return report, fmt.Errorf("chunk %s: %w", c.File, err)
+		}
+		report.RecordsRead += int64(c.Records)
+	}
+	report.Chunks = len(files)
+
+	fmt.Printf("[Phase 2] Resuming merge of %d chunks at output record %d...\n", len(files), opts.ResumeFrom)
+	expvarPhase.Set("merge")
+	expvarRecords.Set(0)
+	quantiles := newQuantileTracker(opts.Quantiles, report.RecordsRead)
+	stats, err := kWayMergeToKafka(context.Background(), files, sink, sortKeyIndex, opts, nil, nil, quantiles)
+	report.Merge = stats
+	if err != nil {
+		return report, err
+	}
+	report.MergeDuration = clock.Now().Sub(start)
+	expvarMergeSeconds.Set(report.MergeDuration.Seconds())
+	fmt.Printf("[Phase 2] Completed: skipped %d and wrote %d records from %d chunks in %v\n",
+		stats.Skipped, stats.Records-stats.Skipped, len(files), report.MergeDuration)
+
+	fmt.Println("[Phase 3] Cleaning up temporary files...")
+	expvarPhase.Set("cleanup")
+	for _, f := range files {
+		_ = removeSpillFile(f, opts.ShredSpill)
+		if opts.CarryHeaders {
+			_ = removeSpillFile(metaPath(f), opts.ShredSpill)
+		}
+	}
+	report.TotalDuration = clock.Now().Sub(start)
+	return report, nil
+}
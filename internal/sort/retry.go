@@ -0,0 +1,89 @@
+package sort
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy re-runs a whole sort job on retryable failures with exponential backoff.
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts including the first; <= 1 disables retries
+	Backoff     time.Duration // delay before the second attempt, doubled after each failure
+	MaxBackoff  time.Duration // upper bound on the delay; 0 means unbounded
+	Clock       Clock         // waits out the backoff; SystemClock when nil
+}
+
+// expvarAttempts records the outcome of every attempt, keyed by attempt number.
+var expvarAttempts = expvar.NewMap("sort_attempts")
+
+// Do calls fn with attempt numbers starting at 1 until it succeeds, returns an error
+// for which retryable reports false, or MaxAttempts is reached.
+func (p RetryPolicy) Do(fn func(attempt int) error, retryable func(error) bool) error {
+	backoff := p.Backoff
+	clock := p.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	for attempt := 1; ; attempt++ {
+		key := strconv.Itoa(attempt)
+		expvarAttempts.Set(key, expvarString("running"))
+		err := fn(attempt)
+		if err == nil {
+			expvarAttempts.Set(key, expvarString("succeeded"))
+			return nil
+		}
+		expvarAttempts.Set(key, expvarString("failed: "+err.Error()))
+
+		if attempt >= p.MaxAttempts {
+			if p.MaxAttempts > 1 {
+				return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			}
+			return err
+		}
+		if !retryable(err) {
+			return fmt.Errorf("attempt %d failed with non-retryable error: %w", attempt, err)
+		}
+
+		fmt.Printf("[Retry] Attempt %d/%d failed: %v; retrying in %v\n", attempt, p.MaxAttempts, err, backoff)
+		sleepOn(clock, backoff)
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+func expvarString(s string) *expvar.String {
+	v := new(expvar.String)
+	v.Set(s)
+	return v
+}
+
+// IsRetryable reports whether err looks transient: network failures, broker errors
+// flagged as temporary, and anything implementing Temporary() or Timeout().
+// Context cancellation is never retryable.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var temp interface{ Temporary() bool }
+	if errors.As(err, &temp) && temp.Temporary() {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,64 @@
+package sort
+
+import (
+	"context"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// Sink receives merged records in sorted order.
+// *gokafka.Writer satisfies this interface, so the Kafka writer can be passed directly.
+type Sink interface {
+	WriteMessages(ctx context.Context, msgs ...gokafka.Message) error
+}
+
+// DiscardSink drops every message it receives while counting records and bytes.
+// It is used by the sorter's --discard-output mode to measure sort performance
+// without the destination broker in the loop.
+type DiscardSink struct {
+	Records int64
+	Bytes   int64
+}
+
+// WriteMessages counts msgs and discards them.
+func (d *DiscardSink) WriteMessages(_ context.Context, msgs ...gokafka.Message) error {
+	for _, m := range msgs {
+		d.Records++
+		d.Bytes += int64(len(m.Value))
+	}
+	return nil
+}
+
+// FuncSink calls the function for every merged record, in order, so a service embedding
+// the sorter can consume the sorted stream in-process (e.g. to build an index) without
+// a Kafka round trip. Calls are never concurrent, and each message owns its Value, so
+// it may be retained. An error aborts the merge.
+type FuncSink func(ctx context.Context, msg gokafka.Message) error
+
+// WriteMessages calls f for each of msgs until one fails.
+func (f FuncSink) WriteMessages(ctx context.Context, msgs ...gokafka.Message) error {
+	for _, m := range msgs {
+		if err := f(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChanSink sends every merged record to the channel, in order. A full channel blocks
+// the merge, so a slow consumer applies backpressure instead of the sorted stream
+// piling up in memory; cancelling the sort's context unblocks it. The channel is not
+// closed; the caller closes it once the sort returns.
+type ChanSink chan<- gokafka.Message
+
+// WriteMessages sends msgs to the channel.
+func (c ChanSink) WriteMessages(ctx context.Context, msgs ...gokafka.Message) error {
+	for _, m := range msgs {
+		select {
+		case c <- m:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
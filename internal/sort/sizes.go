@@ -0,0 +1,70 @@
+package sort
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// estimatedRecordBytes is the in-memory size of a record, key included, that the
+// adaptive chunk size assumes: about 53 bytes of CSV plus keyOverheadBytes.
+const (
+	estimatedRecordBytes = 73
+	keyOverheadBytes     = 20
+)
+
+// SizeHistogram counts record value sizes in power-of-two buckets: Buckets[0] counts
+// empty values and Buckets[i] values of [2^(i-1), 2^i) bytes.
+type SizeHistogram struct {
+	Buckets []int64 `json:"buckets"`
+	Records int64   `json:"records"`
+	Bytes   int64   `json:"bytes"`
+	Max     int     `json:"max"`
+}
+
+func (h *SizeHistogram) observe(n int) {
+	b := bits.Len(uint(n))
+	for b >= len(h.Buckets) {
+		h.Buckets = append(h.Buckets, 0)
+	}
+	h.Buckets[b]++
+	h.Records++
+	h.Bytes += int64(n)
+	h.Max = max(h.Max, n)
+}
+
+// Mean returns the mean size, or 0 with no records.
+func (h *SizeHistogram) Mean() float64 {
+	if h.Records == 0 {
+		return 0
+	}
+	return float64(h.Bytes) / float64(h.Records)
+}
+
+// String lists the nonempty buckets by upper bound, e.g. "<64B 10, <128B 990".
+func (h *SizeHistogram) String() string {
+	var parts []string
+	for i, n := range h.Buckets {
+		if n == 0 {
+			continue
+		}
+		bound := "0B"
+		if i > 0 {
+			bound = "<" + formatBytes(int64(1)<<i)
+		}
+		parts = append(parts, fmt.Sprintf("%s %d", bound, n))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30 && n%(1<<30) == 0:
+		return fmt.Sprintf("%dGiB", n>>30)
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMiB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKiB", n>>10)
+	}
+	return fmt.Sprintf("%dB", n)
+}
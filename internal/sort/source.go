@@ -0,0 +1,13 @@
+package sort
+
+import (
+	"context"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// Source yields the records to sort, one message per call.
+// *gokafka.Reader satisfies this interface, so the Kafka reader can be passed directly.
+type Source interface {
+	ReadMessage(ctx context.Context) (gokafka.Message, error)
+}
@@ -0,0 +1,159 @@
+package sort
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// spillKey encrypts chunk files with AES-256-CTR. The key is generated per
+// ExternalSort call and held only in memory, so every job (and every retry attempt)
+// spills under a fresh key, and chunks left behind after the job are unreadable.
+// Each file starts with its own random IV. A nil *spillKey leaves files in plaintext.
+type spillKey struct {
+	block cipher.Block
+}
+
+func newSpillKey() (*spillKey, error) {
+	k := make([]byte, 32)
+	if _, err := rand.Read(k); err != nil {
+		return nil, fmt.Errorf("spill key: %w", err)
+	}
+	block, err := aes.NewCipher(k)
+	clear(k)
+	if err != nil {
+		return nil, err
+	}
+	return &spillKey{block: block}, nil
+}
+
+// writer writes a fresh IV to w and returns a writer encrypting into w.
+func (k *spillKey) writer(w io.Writer) (io.Writer, error) {
+	if k == nil {
+		return w, nil
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(iv); err != nil {
+		return nil, err
+	}
+	return cipher.StreamWriter{S: cipher.NewCTR(k.block, iv), W: w}, nil
+}
+
+// reader reads the IV written by writer and returns a reader decrypting r.
+func (k *spillKey) reader(r io.Reader) (io.Reader, error) {
+	if k == nil {
+		return r, nil
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(r, iv); err != nil {
+		return nil, fmt.Errorf("encrypted chunk header: %w", err)
+	}
+	return cipher.StreamReader{S: cipher.NewCTR(k.block, iv), R: r}, nil
+}
+
+// shredFile overwrites path with zeros, syncs it, releases its blocks where the
+// platform supports it (so an SSD mounted with discard can TRIM them) and unlinks it.
+// Overwriting is best effort: copy-on-write filesystems and SSD remapping can keep old
+// blocks around, which is what spill encryption covers.
+func shredFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	zeros := make([]byte, 1<<20)
+	for left := info.Size(); left > 0; {
+		n := int64(len(zeros))
+		if left < n {
+			n = left
+		}
+		if _, err := f.Write(zeros[:n]); err != nil {
+			f.Close()
+			return err
+		}
+		left -= n
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	punchHole(f, info.Size())
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// removeSpillFile deletes a chunk or sidecar file, shredding it first if asked.
+func removeSpillFile(path string, shred bool) error {
+	if shred {
+		return shredFile(path)
+	}
+	return os.Remove(path)
+}
+
+// RemoveSpillDir deletes a sort temp directory, shredding every regular file in it
+// first when shred is set.
+func RemoveSpillDir(dir string, shred bool) error {
+	if shred {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				if err := shredFile(filepath.Join(dir, e.Name())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return os.RemoveAll(dir)
+}
+
+// appendEscaped appends rec to dst with every newline written as `\n` and every
+// backslash as `\\`, so a binary record stays on one line of a chunk file.
+func appendEscaped(dst, rec []byte) []byte {
+	for _, c := range rec {
+		switch c {
+		case '\n':
+			dst = append(dst, '\\', 'n')
+		case '\\':
+			dst = append(dst, '\\', '\\')
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+// unescapeRecord reverses appendEscaped in place.
+func unescapeRecord(line []byte) []byte {
+	if bytes.IndexByte(line, '\\') < 0 {
+		return line
+	}
+	out := line[:0]
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '\\' && i+1 < len(line) {
+			i++
+			if c = line[i]; c == 'n' {
+				c = '\n'
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+package sort
+
+import (
+	"encoding/json"
+	"expvar"
+	"os"
+	"time"
+)
+
+// MergeStats counts the algorithmic work done by the k-way merge, so regressions
+// such as degenerate comparison counts show up independently of wall-clock time.
+type MergeStats struct {
+	Records        int64      `json:"records"`
+	HeapPushes     int64      `json:"heap_pushes"`
+	HeapPops       int64      `json:"heap_pops"`
+	Comparisons    int64      `json:"comparisons"`
+	Superseded     int64      `json:"superseded,omitempty"`     // dropped by latest-per-key
+	Skipped        int64      `json:"skipped,omitempty"`        // not re-emitted by ResumeMerge
+	LingerFlushes  int64      `json:"linger_flushes,omitempty"` // partial batches written by BatchLinger
+	Keys           int64      `json:"keys,omitempty"`           // distinct keys written by EmitKeyCounts
+	Recoveries     int64      `json:"recoveries,omitempty"`     // writes that succeeded after a recovery hook ran
+	Quantiles      []Quantile `json:"quantiles,omitempty"`      // of the sort key (Options.Quantiles)
+	ChunkBytesRead []int64    `json:"chunk_bytes_read"`
+}
+
+// Report summarizes a completed ExternalSort run.
+type Report struct {
+	// Set by the caller: the run's id and the producer runs its source came from
+	RunID        string   `json:"run_id,omitempty"`
+	ProducerRuns []string `json:"producer_runs,omitempty"`
+
+	SortKeyIndex   int           `json:"sort_key_index"`
+	Attempt        int           `json:"attempt,omitempty"`
+	RecordsRead    int64         `json:"records_read"`
+	Tombstones     int64         `json:"tombstones,omitempty"`       // skipped or dead-lettered
+	Oversized      int64         `json:"oversized,omitempty"`        // over Options.MaxRecordBytes, dropped or dead-lettered
+	BadNumericKeys int64         `json:"bad_numeric_keys,omitempty"` // numeric keys that do not parse, or JSON ones missing (Options.KeyCoercion)
+	RecordSizes    SizeHistogram `json:"record_sizes"`               // of the values read, tombstones excluded
+	Chunks         int           `json:"chunks"`
+	Coalesced      int           `json:"coalesced,omitempty"`        // small chunks folded into larger ones before the merge
+	Recoveries     int64         `json:"recoveries,omitempty"`       // source reads that succeeded after a recovery hook ran
+	ReadDeadline   time.Duration `json:"read_deadline_ns,omitempty"` // adaptive read deadline at the end of the chunk phase
+	ChunkDuration  time.Duration `json:"chunk_duration_ns"`
+	MergeDuration  time.Duration `json:"merge_duration_ns"`
+	TotalDuration  time.Duration `json:"total_duration_ns"`
+	Merge          MergeStats    `json:"merge"`
+
+	// The record count the producer's end-of-stream markers carry (Options.EndMarkers)
+	EndMarkerRecords int64 `json:"end_marker_records,omitempty"`
+
+	// Spill bytes before and after spill compression
+	SpillRawBytes  int64  `json:"spill_raw_bytes"`
+	SpillDiskBytes int64  `json:"spill_disk_bytes"`
+	SpillMedium    string `json:"spill_medium,omitempty"` // disk or memory, as resolved
+	// Output bytes before compression and the client-side estimate after it (set by the caller)
+	OutputRawBytes        int64 `json:"output_raw_bytes,omitempty"`
+	OutputCompressedBytes int64 `json:"output_compressed_bytes,omitempty"`
+}
+
+// SpillCompressionRatio returns raw/on-disk spill bytes (1 when uncompressed).
+func (r *Report) SpillCompressionRatio() float64 { return ratio(r.SpillRawBytes, r.SpillDiskBytes) }
+
+// OutputCompressionRatio returns raw/compressed output bytes, or 0 when not measured.
+func (r *Report) OutputCompressionRatio() float64 {
+	return ratio(r.OutputRawBytes, r.OutputCompressedBytes)
+}
+
+func ratio(raw, compressed int64) float64 {
+	if compressed <= 0 {
+		return 0
+	}
+	return float64(raw) / float64(compressed)
+}
+
+// WriteFile writes the report as indented JSON to path.
+func (r *Report) WriteFile(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// Live merge counters, served at /debug/vars alongside the pprof endpoints.
+var (
+	expvarMerge       = expvar.NewMap("sort_merge")
+	expvarRecords     = new(expvar.Int)
+	expvarHeapPushes  = new(expvar.Int)
+	expvarHeapPops    = new(expvar.Int)
+	expvarComparisons = new(expvar.Int)
+	expvarBytesRead   = new(expvar.Int)
+)
+
+// The current phase (chunk, merge or cleanup) and the records Phase 1 has read so far.
+var (
+	expvarPhase       = expvar.NewString("sort_phase")
+	expvarRecordsRead = expvar.NewInt("sort_records_read")
+)
+
+// Progress returns the phase of the sort running in this process ("" before the first
+// one starts), the records its chunk phase has read and the records its merge has
+// written so far, for status reporting.
+func Progress() (phase string, read, merged int64) {
+	return expvarPhase.Value(), expvarRecordsRead.Value(), expvarRecords.Value()
+}
+
+// Spill bytes of the current chunk phase and the durations of the last completed chunk
+// and merge phases.
+var (
+	expvarSpill        = expvar.NewMap("sort_spill")
+	expvarSpillRaw     = new(expvar.Int)
+	expvarSpillDisk    = new(expvar.Int)
+	expvarPhaseSeconds = expvar.NewMap("sort_phase_seconds")
+	expvarChunkSeconds = new(expvar.Float)
+	expvarMergeSeconds = new(expvar.Float)
+)
+
+// Counters are the live counters of the sort running in this process, served by the
+// sorter as Prometheus metrics.
+type Counters struct {
+	Phase          string
+	RecordsRead    int64
+	RecordsMerged  int64
+	SpillRawBytes  int64
+	SpillDiskBytes int64
+	ChunkSeconds   float64 // of the last completed chunk phase, 0 before one completes
+	MergeSeconds   float64 // likewise for the merge phase
+}
+
+// LiveCounters returns the current Counters.
+func LiveCounters() Counters {
+	return Counters{
+		Phase:          expvarPhase.Value(),
+		RecordsRead:    expvarRecordsRead.Value(),
+		RecordsMerged:  expvarRecords.Value(),
+		SpillRawBytes:  expvarSpillRaw.Value(),
+		SpillDiskBytes: expvarSpillDisk.Value(),
+		ChunkSeconds:   expvarChunkSeconds.Value(),
+		MergeSeconds:   expvarMergeSeconds.Value(),
+	}
+}
+
+func init() {
+	expvarSpill.Set("raw_bytes", expvarSpillRaw)
+	expvarSpill.Set("disk_bytes", expvarSpillDisk)
+	expvarPhaseSeconds.Set("chunk", expvarChunkSeconds)
+	expvarPhaseSeconds.Set("merge", expvarMergeSeconds)
+	expvarMerge.Set("records", expvarRecords)
+	expvarMerge.Set("heap_pushes", expvarHeapPushes)
+	expvarMerge.Set("heap_pops", expvarHeapPops)
+	expvarMerge.Set("comparisons", expvarComparisons)
+	expvarMerge.Set("bytes_read", expvarBytesRead)
+}
+
+// publish copies the current merge counters to the expvar metrics.
+// It is called once per output batch rather than per record to keep the merge loop cheap.
+func (s *MergeStats) publish() {
+	expvarRecords.Set(s.Records)
+	expvarHeapPushes.Set(s.HeapPushes)
+	expvarHeapPops.Set(s.HeapPops)
+	expvarComparisons.Set(s.Comparisons)
+	var total int64
+	for _, b := range s.ChunkBytesRead {
+		total += b
+	}
+	expvarBytesRead.Set(total)
+}
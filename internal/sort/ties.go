@@ -0,0 +1,79 @@
+package sort
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// TieBreak orders records whose sort keys are equal. The non-default orders make the
+// output match `LC_ALL=C sort -t, -k<field>,<field>` (with n for id), so shell
+// pipelines can move to the sorter without diffs: keys are compared as bytes (ids as
+// numbers) either way, and GNU sort breaks ties by comparing whole lines as bytes, or
+// keeps input order with -s.
+type TieBreak int
+
+const (
+	// TiesAny leaves the order of equal keys unspecified, the historical behavior.
+	TiesAny TieBreak = iota
+	// TiesRecord orders equal keys by the whole record's bytes (GNU sort's default).
+	TiesRecord
+	// TiesInput keeps equal keys in the order they were read (GNU sort -s).
+	TiesInput
+)
+
+// UnmarshalText parses any, record or input.
+func (t *TieBreak) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "any":
+		*t = TiesAny
+	case "record":
+		*t = TiesRecord
+	case "input":
+		*t = TiesInput
+	default:
+		return fmt.Errorf("unknown tie break %q (want any, record or input)", b)
+	}
+	return nil
+}
+
+func (t TieBreak) String() string {
+	return [...]string{"any", "record", "input"}[t]
+}
+
+// sortChunk sorts records in memory by their precomputed keys, breaking ties with t.
+// TiesInput sorts stably; the merge then prefers earlier chunks on ties, so input
+// order holds across chunks too.
+func sortChunk(records []recordWithKey, intKey bool, t TieBreak) {
+	var less func(i, j int) bool
+	if intKey {
+		// Numeric comparison for id field
+		less = func(i, j int) bool { return records[i].keyInt < records[j].keyInt }
+		if t == TiesRecord {
+			less = func(i, j int) bool {
+				a, b := &records[i], &records[j]
+				if a.keyInt != b.keyInt {
+					return a.keyInt < b.keyInt
+				}
+				return bytes.Compare(a.data, b.data) < 0
+			}
+		}
+	} else {
+		// Lexicographic comparison for name/continent
+		less = func(i, j int) bool { return records[i].keyStr < records[j].keyStr }
+		if t == TiesRecord {
+			less = func(i, j int) bool {
+				a, b := &records[i], &records[j]
+				if a.keyStr != b.keyStr {
+					return a.keyStr < b.keyStr
+				}
+				return bytes.Compare(a.data, b.data) < 0
+			}
+		}
+	}
+	if t == TiesInput {
+		sort.SliceStable(records, less)
+		return
+	}
+	sort.Slice(records, less)
+}
@@ -0,0 +1,95 @@
+package testutil
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	extSort "core-infra-project/internal/sort"
+)
+
+// FakeClock is a manual extSort.Clock: time stands still until Advance moves it, which
+// fires every timer that has come due, in deadline order. With it a test can expire a
+// sort's read deadlines, trigger drain stalls, skip retry backoff and time heartbeats
+// without sleeping.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFakeClock returns a clock reading start.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now implements extSort.Clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc implements extSort.Clock. A non-positive d fires on the next Advance.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) extSort.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d and runs the callbacks of the timers due by then.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due, rest []*fakeTimer
+	for _, t := range c.timers {
+		if !t.at.After(c.now) {
+			due = append(due, t)
+		} else {
+			rest = append(rest, t)
+		}
+	}
+	c.timers = rest
+	c.mu.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+	for _, t := range due {
+		go t.f()
+	}
+}
+
+// Pending returns the number of timers that have not fired or been stopped, so a test
+// can wait until the code under test is blocked on the clock before advancing it.
+func (c *FakeClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// WaitPending polls until at least n timers are pending.
+func (c *FakeClock) WaitPending(n int) {
+	for c.Pending() < n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type fakeTimer struct {
+	c  *FakeClock
+	at time.Time
+	f  func()
+}
+
+// Stop implements extSort.Timer.
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	for i, p := range t.c.timers {
+		if p == t {
+			t.c.timers = append(t.c.timers[:i], t.c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+// Package testutil provides fault-injecting Source and Sink implementations used to
+// exercise the sorter's error handling, both from unit tests and via the sorter's
+// hidden --inject-faults flag.
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	extSort "core-infra-project/internal/sort"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// ErrInjected is returned (wrapped) for every injected failure. It reports itself
+// as temporary so the sorter's retry policy treats it like a transient broker error.
+var ErrInjected error = injectedError{}
+
+type injectedError struct{}
+
+func (injectedError) Error() string   { return "testutil: injected fault" }
+func (injectedError) Temporary() bool { return true }
+
+// FaultConfig controls how often and how badly the faulty wrappers misbehave.
+type FaultConfig struct {
+	FailFirst        int           // the first FailFirst calls fail without side effects
+	ErrorRate        float64       // probability per call of failing without side effects
+	PartialWriteRate float64       // sink only: probability of writing a prefix of the batch, then failing
+	Latency          time.Duration // fixed delay added to every call
+	Jitter           time.Duration // random extra delay in [0, Jitter)
+	Seed             int64         // RNG seed; 0 uses the current time
+	Clock            extSort.Clock // times the delays; SystemClock when nil (not part of the spec)
+	Cause            error         // also wrapped by failures, e.g. syscall.ECONNRESET to exercise recovery (not part of the spec)
+}
+
+// ParseFaultConfig parses a spec such as "error=0.01,partial=0.05,latency=2ms,jitter=1ms,seed=42"
+// (or "fail-first=3").
+func ParseFaultConfig(spec string) (FaultConfig, error) {
+	var cfg FaultConfig
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			return cfg, fmt.Errorf("invalid fault spec %q: expected key=value", part)
+		}
+		var err error
+		switch k {
+		case "fail-first":
+			cfg.FailFirst, err = strconv.Atoi(v)
+		case "error":
+			cfg.ErrorRate, err = strconv.ParseFloat(v, 64)
+		case "partial":
+			cfg.PartialWriteRate, err = strconv.ParseFloat(v, 64)
+		case "latency":
+			cfg.Latency, err = time.ParseDuration(v)
+		case "jitter":
+			cfg.Jitter, err = time.ParseDuration(v)
+		case "seed":
+			cfg.Seed, err = strconv.ParseInt(v, 10, 64)
+		default:
+			return cfg, fmt.Errorf("unknown fault spec key %q", k)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("invalid fault spec %q: %w", part, err)
+		}
+	}
+	return cfg, nil
+}
+
+// FaultStats counts calls and injected failures.
+type FaultStats struct {
+	Calls         int64
+	Errors        int64
+	PartialWrites int64
+}
+
+// injector holds the shared RNG and stats of a faulty wrapper.
+type injector struct {
+	cfg   FaultConfig
+	mu    sync.Mutex
+	rng   *rand.Rand
+	stats FaultStats
+}
+
+func newInjector(cfg FaultConfig) *injector {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &injector{cfg: cfg, rng: rand.New(rand.NewSource(seed))}
+}
+
+// roll returns the delay to apply and a uniform sample in [0, 1) for fault decisions,
+// which is -1 while the calls of FailFirst last, so they fail.
+func (in *injector) roll() (time.Duration, float64) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.stats.Calls++
+	delay := in.cfg.Latency
+	if in.cfg.Jitter > 0 {
+		delay += time.Duration(in.rng.Int63n(int64(in.cfg.Jitter)))
+	}
+	p := in.rng.Float64()
+	if in.stats.Calls <= int64(in.cfg.FailFirst) {
+		p = -1
+	}
+	return delay, p
+}
+
+// failure returns an injected failure of op.
+func (in *injector) failure(op string) error {
+	if in.cfg.Cause != nil {
+		return fmt.Errorf("%s: %w: %w", op, ErrInjected, in.cfg.Cause)
+	}
+	return fmt.Errorf("%s: %w", op, ErrInjected)
+}
+
+func (in *injector) count(f func(s *FaultStats)) {
+	in.mu.Lock()
+	f(&in.stats)
+	in.mu.Unlock()
+}
+
+func (in *injector) snapshot() FaultStats {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.stats
+}
+
+// sleep waits for d on the configured clock or until ctx is done.
+func (in *injector) sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	clock := in.cfg.Clock
+	if clock == nil {
+		clock = extSort.SystemClock
+	}
+	fired := make(chan struct{})
+	t := clock.AfterFunc(d, func() { close(fired) })
+	defer t.Stop()
+	select {
+	case <-fired:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FaultySource wraps a Source, adding latency and randomly failing reads.
+type FaultySource struct {
+	src extSort.Source
+	in  *injector
+}
+
+// NewFaultySource wraps src with the given fault configuration.
+func NewFaultySource(src extSort.Source, cfg FaultConfig) *FaultySource {
+	return &FaultySource{src: src, in: newInjector(cfg)}
+}
+
+// ReadMessage implements extSort.Source.
+func (s *FaultySource) ReadMessage(ctx context.Context) (gokafka.Message, error) {
+	delay, p := s.in.roll()
+	if err := s.in.sleep(ctx, delay); err != nil {
+		return gokafka.Message{}, err
+	}
+	if p < s.in.cfg.ErrorRate {
+		s.in.count(func(st *FaultStats) { st.Errors++ })
+		return gokafka.Message{}, s.in.failure("read")
+	}
+	return s.src.ReadMessage(ctx)
+}
+
+// Stats returns a snapshot of the calls and injected failures so far.
+func (s *FaultySource) Stats() FaultStats { return s.in.snapshot() }
+
+// FaultySink wraps a Sink, adding latency, failing writes and partial writes.
+type FaultySink struct {
+	sink extSort.Sink
+	in   *injector
+}
+
+// NewFaultySink wraps sink with the given fault configuration.
+func NewFaultySink(sink extSort.Sink, cfg FaultConfig) *FaultySink {
+	return &FaultySink{sink: sink, in: newInjector(cfg)}
+}
+
+// WriteMessages implements extSort.Sink. A partial write forwards a strict prefix of
+// msgs to the wrapped sink before returning an error, like a broker failing mid-batch.
+func (s *FaultySink) WriteMessages(ctx context.Context, msgs ...gokafka.Message) error {
+	delay, p := s.in.roll()
+	if err := s.in.sleep(ctx, delay); err != nil {
+		return err
+	}
+	switch {
+	case p < s.in.cfg.ErrorRate:
+		s.in.count(func(st *FaultStats) { st.Errors++ })
+		return s.in.failure("write")
+	case p < s.in.cfg.ErrorRate+s.in.cfg.PartialWriteRate && len(msgs) > 1:
+		s.in.count(func(st *FaultStats) { st.PartialWrites++ })
+		n := len(msgs) / 2
+		if err := s.sink.WriteMessages(ctx, msgs[:n]...); err != nil {
+			return err
+		}
+		return fmt.Errorf("partial write (%d of %d messages): %w", n, len(msgs), ErrInjected)
+	}
+	return s.sink.WriteMessages(ctx, msgs...)
+}
+
+// Stats returns a snapshot of the calls and injected failures so far.
+func (s *FaultySink) Stats() FaultStats { return s.in.snapshot() }
@@ -0,0 +1,174 @@
+package testutil_test
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	extSort "core-infra-project/internal/sort"
+	"core-infra-project/internal/testutil"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// sliceSource reads the records of ids in order, then io.EOF.
+type sliceSource struct{ ids []int }
+
+func (s *sliceSource) ReadMessage(context.Context) (gokafka.Message, error) {
+	if len(s.ids) == 0 {
+		return gokafka.Message{}, io.EOF
+	}
+	id := s.ids[0]
+	s.ids = s.ids[1:]
+	return gokafka.Message{Value: []byte(strconv.Itoa(id) + ",name,address,Europe")}, nil
+}
+
+// recordingSink keeps the values written to it.
+type recordingSink struct {
+	mu  sync.Mutex
+	got []string
+}
+
+func (s *recordingSink) WriteMessages(_ context.Context, msgs ...gokafka.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range msgs {
+		s.got = append(s.got, string(m.Value))
+	}
+	return nil
+}
+
+// reconnectingSource is a FaultySource whose connection errors Reconnect handles.
+type reconnectingSource struct {
+	*testutil.FaultySource
+	reconnects int
+}
+
+func (s *reconnectingSource) Reconnect(context.Context) error {
+	s.reconnects++
+	return nil
+}
+
+// reopeningSink is a FaultySink whose file errors Reopen handles.
+type reopeningSink struct {
+	*testutil.FaultySink
+	reopens int
+}
+
+func (s *reopeningSink) Reopen(context.Context) error {
+	s.reopens++
+	return nil
+}
+
+var unsorted = []int{5, 3, 9, 1, 7, 2, 8, 4, 6}
+
+func checkSorted(t *testing.T, got []string) {
+	t.Helper()
+	if len(got) != len(unsorted) {
+		t.Fatalf("%d records written, want %d: %q", len(got), len(unsorted), got)
+	}
+	for i, v := range got {
+		if want := strconv.Itoa(i+1) + ",name,address,Europe"; v != want {
+			t.Fatalf("record %d is %q, want %q", i, v, want)
+		}
+	}
+}
+
+func TestRetryPolicyRerunsSortAfterSinkFailures(t *testing.T) {
+	out := &recordingSink{}
+	sink := testutil.NewFaultySink(out, testutil.FaultConfig{FailFirst: 2, Seed: 1})
+	policy := extSort.RetryPolicy{MaxAttempts: 3}
+	attempts := 0
+	err := policy.Do(func(attempt int) error {
+		attempts = attempt
+		out.got = nil
+		source := &sliceSource{ids: append([]int(nil), unsorted...)}
+		_, err := extSort.ExternalSort(source, sink, 0, t.TempDir(), extSort.Options{})
+		return err
+	}, extSort.IsRetryable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Fatalf("%d attempts, want 3", attempts)
+	}
+	if st := sink.Stats(); st.Errors != 2 {
+		t.Fatalf("%d injected errors, want 2", st.Errors)
+	}
+	checkSorted(t, out.got)
+}
+
+func TestRetryPolicyGivesUp(t *testing.T) {
+	sink := testutil.NewFaultySink(&recordingSink{}, testutil.FaultConfig{ErrorRate: 1, Seed: 1})
+	policy := extSort.RetryPolicy{MaxAttempts: 2}
+	attempts := 0
+	err := policy.Do(func(attempt int) error {
+		attempts = attempt
+		_, err := extSort.ExternalSort(&sliceSource{ids: unsorted[:3]}, sink, 0, t.TempDir(), extSort.Options{})
+		return err
+	}, extSort.IsRetryable)
+	if err == nil {
+		t.Fatal("sort succeeded with a sink that always fails")
+	}
+	if attempts != 2 {
+		t.Fatalf("%d attempts, want 2", attempts)
+	}
+}
+
+func TestRecoveryReconnectsSource(t *testing.T) {
+	source := &reconnectingSource{FaultySource: testutil.NewFaultySource(&sliceSource{ids: unsorted},
+		testutil.FaultConfig{FailFirst: 2, Seed: 1, Cause: syscall.ECONNRESET})}
+	out := &recordingSink{}
+	report, err := extSort.ExternalSort(source, out, 0, t.TempDir(), extSort.Options{
+		Recovery: extSort.RecoveryPolicy{Attempts: 3, Backoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.reconnects != 2 {
+		t.Fatalf("%d reconnects, want 2", source.reconnects)
+	}
+	if report.Recoveries == 0 {
+		t.Fatal("no recoveries reported")
+	}
+	checkSorted(t, out.got)
+}
+
+func TestRecoveryReopensSink(t *testing.T) {
+	out := &recordingSink{}
+	sink := &reopeningSink{FaultySink: testutil.NewFaultySink(out, testutil.FaultConfig{FailFirst: 1, Seed: 1,
+		Cause: &fs.PathError{Op: "write", Path: "sorted.csv", Err: syscall.ESTALE}})}
+	if _, err := extSort.ExternalSort(&sliceSource{ids: unsorted}, sink, 0, t.TempDir(), extSort.Options{
+		Recovery: extSort.RecoveryPolicy{Attempts: 1, Backoff: time.Millisecond},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if sink.reopens != 1 {
+		t.Fatalf("%d reopens, want 1", sink.reopens)
+	}
+	checkSorted(t, out.got)
+}
+
+func TestRecoveryReconnectsAfterNetworkTimeout(t *testing.T) {
+	// An i/o timeout of the connection, not the expiry of the sort's read deadline
+	timeout := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	source := &reconnectingSource{FaultySource: testutil.NewFaultySource(&sliceSource{ids: unsorted},
+		testutil.FaultConfig{FailFirst: 1, Seed: 1, Cause: timeout})}
+	out := &recordingSink{}
+	if _, err := extSort.ExternalSort(source, out, 0, t.TempDir(), extSort.Options{
+		Recovery: extSort.RecoveryPolicy{Attempts: 1, Backoff: time.Millisecond},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if source.reconnects != 1 {
+		t.Fatalf("%d reconnects, want 1", source.reconnects)
+	}
+	checkSorted(t, out.got)
+}
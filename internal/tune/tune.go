@@ -0,0 +1,237 @@
+// Package tune runs short calibration probes at startup and derives worker counts,
+// buffer sizes and batch sizes from the measured machine, replacing fixed constants.
+package tune
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	datagen "core-infra-project/internal/data"
+	extSort "core-infra-project/internal/sort"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// Probes holds the calibration measurements. Zero values mean the probe was not run.
+type Probes struct {
+	Generator     []GenSample // generator throughput by worker count, ascending
+	DiskWriteMBps float64
+	DiskReadMBps  float64
+	BrokerRTT     time.Duration
+}
+
+// GenSample is the generator throughput measured with Workers goroutines.
+type GenSample struct {
+	Workers       int
+	RecordsPerSec float64
+}
+
+// Settings are the tunables chosen from the probes.
+type Settings struct {
+	Workers      int // generator goroutines
+	QueueSize    int // generator -> publisher channel capacity
+	BatchSize    int // messages per WriteMessages call
+	IOBufferSize int // chunk write / merge read buffer bytes
+}
+
+// Defaults returns the settings the binaries use without auto-tuning.
+func Defaults() Settings {
+	return Settings{
+		Workers:      runtime.NumCPU() * 3,
+		QueueSize:    100_000,
+		BatchSize:    1000,
+		IOBufferSize: 4 << 20,
+	}
+}
+
+// ProbeGenerator measures generator throughput at 1x, 2x and 3x NumCPU workers,
+// spending roughly d on each.
+func ProbeGenerator(d time.Duration) []GenSample {
+	var res []GenSample
+	for _, mult := range []int{1, 2, 3} {
+		workers := runtime.NumCPU() * mult
+		var n int64
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(workers)
+		start := time.Now()
+		for i := 0; i < workers; i++ {
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						datagen.GenerateRandomRecord()
+						atomic.AddInt64(&n, 1)
+					}
+				}
+			}()
+		}
+		time.Sleep(d)
+		close(stop)
+		wg.Wait()
+		res = append(res, GenSample{Workers: workers, RecordsPerSec: float64(n) / time.Since(start).Seconds()})
+	}
+	return res
+}
+
+// ProbeDisk runs a small spill benchmark in dir and returns write/read MB/sec.
+func ProbeDisk(dir string, records int) (write, read float64, err error) {
+	recs := make([][]byte, records)
+	for i := range recs {
+		recs[i] = datagen.GenerateRandomRecord()
+	}
+	res, err := extSort.BenchmarkDisk(dir, recs, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	return res.WriteMBps(), res.ReadMBps(), nil
+}
+
+// ProbeBroker returns the median round-trip time of metadata requests to brokers.
+func ProbeBroker(ctx context.Context, brokers []string, samples int) (time.Duration, error) {
+	client := &gokafka.Client{Addr: gokafka.TCP(brokers...)}
+	var rtts []time.Duration
+	for i := 0; i < samples; i++ {
+		start := time.Now()
+		if _, err := client.Metadata(ctx, &gokafka.MetadataRequest{}); err != nil {
+			return 0, err
+		}
+		rtts = append(rtts, time.Since(start))
+	}
+	// Insertion sort; samples is small
+	for i := 1; i < len(rtts); i++ {
+		for j := i; j > 0 && rtts[j] < rtts[j-1]; j-- {
+			rtts[j], rtts[j-1] = rtts[j-1], rtts[j]
+		}
+	}
+	return rtts[len(rtts)/2], nil
+}
+
+// Trial is the end-to-end throughput of one calibration run of the real pipeline.
+type Trial struct {
+	Workers       int
+	BatchSize     int
+	RecordsPerSec float64
+}
+
+// BatchSizes are the batch sizes Sweep tries.
+var BatchSizes = []int{500, 1000, 2000, 5000, 10_000}
+
+// WorkerCounts returns the worker counts Sweep tries: half, 1x, 2x, 3x and 4x NumCPU.
+func WorkerCounts() []int {
+	cpus := runtime.NumCPU()
+	counts := []int{max(cpus/2, 1)}
+	for _, mult := range []int{1, 2, 3, 4} {
+		if n := cpus * mult; n > counts[len(counts)-1] {
+			counts = append(counts, n)
+		}
+	}
+	return counts
+}
+
+// Sweep picks workers and batch size from trials of the pipeline itself, which unlike
+// the probes see every bottleneck at once: it runs each of workers at batchSize, then
+// each of batchSizes at the best worker count, rather than the whole grid. Both lists
+// ascend, and a larger value must give a clear (>5%) gain to be picked, so ties go to
+// the cheaper setting. trial returns records/sec; batchSizes is nil to keep batchSize.
+// It fails without trying batch sizes when no worker count delivered any records.
+func Sweep(workers, batchSizes []int, batchSize int, trial func(workers, batchSize int) float64) (best Trial, trials []Trial, err error) {
+	run := func(w, b int) {
+		t := Trial{Workers: w, BatchSize: b, RecordsPerSec: trial(w, b)}
+		trials = append(trials, t)
+		if t.RecordsPerSec > best.RecordsPerSec*1.05 {
+			best = t
+		}
+	}
+	for _, w := range workers {
+		run(w, batchSize)
+	}
+	if best.RecordsPerSec == 0 || best.Workers < 1 {
+		return best, trials, fmt.Errorf("no trial of %d worker counts delivered any records", len(workers))
+	}
+	for _, b := range batchSizes {
+		if b != batchSize {
+			run(best.Workers, b)
+		}
+	}
+	return best, trials, nil
+}
+
+// Choose derives settings from the probes, starting from Defaults for anything not probed.
+func Choose(p Probes) Settings {
+	s := Defaults()
+
+	var best float64
+	for _, g := range p.Generator {
+		// Samples ascend by worker count: more workers must give a clear (>5%) gain
+		if g.RecordsPerSec > best*1.05 {
+			best, s.Workers = g.RecordsPerSec, g.Workers
+		}
+	}
+	if best > 0 {
+		// Absorb ~100ms of generator output between generators and publisher
+		s.QueueSize = clamp(int(best/10), 10_000, 500_000)
+	}
+
+	if p.DiskWriteMBps > 0 {
+		// Buffer ~20ms of sequential write bandwidth per chunk file
+		s.IOBufferSize = clamp(int(p.DiskWriteMBps*(1<<20)/50), 1<<20, 16<<20)
+	}
+
+	if p.BrokerRTT > 0 {
+		// Keep enough records per batch that round trips do not dominate: ~a batch per RTT
+		// at the generator rate when known, otherwise scale the default with latency.
+		rate := best
+		if rate == 0 {
+			rate = 1_000_000
+		}
+		s.BatchSize = clamp(int(rate*p.BrokerRTT.Seconds()), 500, 10_000)
+	}
+	return s
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// Print logs the probes and the chosen settings.
+func Print(heading string, p Probes, s Settings) {
+	fmt.Printf("%s Auto-tune probes:\n", heading)
+	for _, g := range p.Generator {
+		fmt.Printf("  - generator, %d workers: %.0f records/sec\n", g.Workers, g.RecordsPerSec)
+	}
+	if p.DiskWriteMBps > 0 {
+		fmt.Printf("  - disk: write %.1f MB/sec, read %.1f MB/sec\n", p.DiskWriteMBps, p.DiskReadMBps)
+	}
+	if p.BrokerRTT > 0 {
+		fmt.Printf("  - broker round trip: %v\n", p.BrokerRTT)
+	}
+	fmt.Printf("%s Auto-tuned: workers=%d queue=%d batch=%d io-buffer=%dKB\n",
+		heading, s.Workers, s.QueueSize, s.BatchSize, s.IOBufferSize>>10)
+}
+
+// PrintSweep logs the trials of a Sweep and the pick.
+func PrintSweep(heading string, trials []Trial, best Trial) {
+	fmt.Printf("%s Calibration trials:\n", heading)
+	for _, t := range trials {
+		mark := ""
+		if t == best {
+			mark = "  <- best"
+		}
+		fmt.Printf("  - %3d workers, batch %5d: %.0f records/sec%s\n", t.Workers, t.BatchSize, t.RecordsPerSec, mark)
+	}
+	fmt.Printf("%s Calibrated: workers=%d batch=%d (%.0f records/sec)\n", heading, best.Workers, best.BatchSize, best.RecordsPerSec)
+}
@@ -0,0 +1,41 @@
+package tune
+
+import "testing"
+
+func TestSweepFailsWithoutRecords(t *testing.T) {
+	var calls []Trial
+	best, trials, err := Sweep([]int{1, 2, 4}, BatchSizes, 1000, func(workers, batchSize int) float64 {
+		calls = append(calls, Trial{Workers: workers, BatchSize: batchSize})
+		return 0
+	})
+	if err == nil {
+		t.Fatalf("no error, best %+v", best)
+	}
+	if len(trials) != 3 || len(calls) != 3 {
+		t.Fatalf("%d trials, %d calls; want only the 3 worker counts", len(trials), len(calls))
+	}
+	for _, c := range calls {
+		if c.Workers < 1 {
+			t.Fatalf("trial with %d workers", c.Workers)
+		}
+	}
+}
+
+func TestSweepPicksClearGains(t *testing.T) {
+	rates := map[int]float64{1: 100, 2: 200, 4: 205}
+	best, trials, err := Sweep([]int{1, 2, 4}, []int{500, 1000, 2000}, 1000, func(workers, batchSize int) float64 {
+		if batchSize == 2000 {
+			return rates[workers] * 2
+		}
+		return rates[workers]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Trial{Workers: 2, BatchSize: 2000, RecordsPerSec: 400}); best != want {
+		t.Fatalf("best %+v, want %+v", best, want)
+	}
+	if len(trials) != 5 {
+		t.Fatalf("%d trials, want 5", len(trials))
+	}
+}
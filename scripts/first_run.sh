@@ -85,11 +85,11 @@ echo ""
 echo "[Step 6/6] Running sorters sequentially..."
 echo "  Note: Sorters must run one at a time so each reads the complete source topic"
 echo "  Tip: To monitor sorter with pprof, run manually with port mapping:"
-echo "       docker compose run --rm -p 6061:6061 pipeline_app ./sorter id"
+echo "       docker compose run --rm -p 6061:6061 pipeline_app ./sorter --key id"
 echo ""
 
 echo "  Running Name sorter first (seems to work more reliably)..."
-docker compose run --rm -T pipeline_app ./sorter name
+docker compose run --rm -T pipeline_app ./sorter --key name
 EXIT_CODE=$?
 if [ $EXIT_CODE -ne 0 ]; then
     echo "  ✗ Name sorter failed with exit code: $EXIT_CODE"
@@ -103,7 +103,7 @@ echo "  Waiting 10 seconds to ensure Kafka writes are flushed..."
 sleep 10
 
 echo "  Running ID sorter..."
-docker compose run --rm -T pipeline_app ./sorter id
+docker compose run --rm -T pipeline_app ./sorter --key id
 EXIT_CODE=$?
 if [ $EXIT_CODE -ne 0 ]; then
     echo "  ✗ ID sorter failed with exit code: $EXIT_CODE"
@@ -117,7 +117,7 @@ echo "  Waiting 10 seconds to ensure Kafka writes are flushed..."
 sleep 10
 
 echo "  Running Continent sorter..."
-docker compose run --rm -T pipeline_app ./sorter continent
+docker compose run --rm -T pipeline_app ./sorter --key continent
 EXIT_CODE=$?
 if [ $EXIT_CODE -ne 0 ]; then
     echo "  ✗ Continent sorter failed with exit code: $EXIT_CODE"
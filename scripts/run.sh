@@ -30,9 +30,9 @@ echo "Running producer..."
 docker-compose run --rm pipeline_app ./producer | cat
 
 echo "Running sorters sequentially (each must read complete source topic)..."
-docker-compose run --rm -T pipeline_app ./sorter id
-docker-compose run --rm -T pipeline_app ./sorter name
-docker-compose run --rm -T pipeline_app ./sorter continent
+docker-compose run --rm -T pipeline_app ./sorter --key id
+docker-compose run --rm -T pipeline_app ./sorter --key name
+docker-compose run --rm -T pipeline_app ./sorter --key continent
 
 end_time=$(date +%s)
 echo "Total pipeline runtime: $((end_time - start_time)) seconds"
@@ -0,0 +1,343 @@
+// Package sortedtopic reads topics the sorter wrote, for services that want to use
+// their order rather than scan them: SeekToKey finds where the records with a key at
+// or above a given one start, and IterateRange visits the records with keys in a
+// range, fetching only the partitions and offsets the range covers. It handles both
+// layouts the sorter writes, one partition holding the whole order and, with
+// --range-partitions, contiguous key ranges in partition order; each partition is
+// binary-searched by fetching single records, narrowed by the sorter's --index-topic
+// when given. Like sortjob, it is meant to be imported from outside this module.
+package sortedtopic
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"core-infra-project/internal/fastnum"
+	kclient "core-infra-project/internal/kafka"
+	"core-infra-project/sortjob"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// ErrPastEnd is returned by SeekToKey when every record's key is below the key sought.
+var ErrPastEnd = errors.New("no record at or above the key")
+
+// ErrStop ends IterateRange early, without an error, when returned by its callback.
+var ErrStop = errors.New("stop iterating")
+
+// Config names a sorted topic and how it was sorted.
+type Config struct {
+	Brokers []string
+	Topic   string
+	// Key is the field the topic is sorted by, as the sorter's --key. Keys are compared
+	// the way the sorter compares them without --key-normalize: ids as integers, names
+	// and continents as bytes.
+	Key sortjob.Key
+	// IndexTopic is the sorter's --index-topic for the run that wrote Topic, if any. Its
+	// entries bound every binary search to the records between two of them, so seeks
+	// fetch a few records instead of one per halving of the partition. An index of
+	// another run gives wrong answers.
+	IndexTopic string
+}
+
+// Position is where a record is in a sorted topic, or where reading would find the
+// next one.
+type Position struct {
+	Partition int
+	Offset    int64
+}
+
+// Reader seeks and iterates a sorted topic. Its offsets are taken at Open: records
+// written later are not seen. It is not safe for concurrent use.
+type Reader struct {
+	cfg   Config
+	parts []*partition // by partition number, which is key order
+}
+
+type partition struct {
+	id         int
+	first, end int64
+	index      []kclient.IndexEntry // by offset, so by key
+	conn       *gokafka.Conn        // to the leader, dialed on the first fetch
+}
+
+// key is a parsed sort key: n for ids, s otherwise.
+type key struct {
+	n int64
+	s string
+}
+
+// Open reads the partitions' offsets and, with cfg.IndexTopic, the key index.
+func Open(ctx context.Context, cfg Config) (*Reader, error) {
+	if len(cfg.Brokers) == 0 || cfg.Topic == "" {
+		return nil, fmt.Errorf("brokers and topic are required")
+	}
+	if cfg.Key < sortjob.KeyID || cfg.Key > sortjob.KeyContinent {
+		return nil, fmt.Errorf("unknown sort key %d", cfg.Key)
+	}
+	offsets, err := kclient.PartitionBounds(ctx, cfg.Brokers, cfg.Topic)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{cfg: cfg}
+	byID := map[int]*partition{}
+	for _, po := range offsets {
+		p := &partition{id: po.Partition, first: po.FirstOffset, end: po.LastOffset}
+		r.parts = append(r.parts, p)
+		byID[p.id] = p
+	}
+	if cfg.IndexTopic != "" {
+		entries, err := kclient.LoadKeyIndex(ctx, cfg.Brokers, cfg.IndexTopic)
+		if err != nil {
+			return nil, fmt.Errorf("key index %s: %w", cfg.IndexTopic, err)
+		}
+		for _, e := range entries {
+			if p := byID[e.Partition]; p != nil && e.Offset >= p.first && e.Offset < p.end {
+				p.index = append(p.index, e)
+			}
+		}
+	}
+	return r, nil
+}
+
+// Close closes the connections of past fetches.
+func (r *Reader) Close() error {
+	var first error
+	for _, p := range r.parts {
+		if p.conn != nil {
+			if err := p.conn.Close(); err != nil && first == nil {
+				first = err
+			}
+			p.conn = nil
+		}
+	}
+	return first
+}
+
+// SeekToKey returns the position of the first record whose key is at or above k:
+// reading from it yields the records from k on in order, partition after partition.
+// Positions may be offsets of control messages (run metadata) preceding the record.
+func (r *Reader) SeekToKey(ctx context.Context, k string) (Position, error) {
+	target, err := r.parse(k)
+	if err != nil {
+		return Position{}, err
+	}
+	return r.seek(ctx, target)
+}
+
+func (r *Reader) seek(ctx context.Context, target key) (Position, error) {
+	for _, p := range r.parts {
+		off, err := r.lowerBound(ctx, p, target)
+		if err != nil {
+			return Position{}, fmt.Errorf("%s partition %d: %w", r.cfg.Topic, p.id, err)
+		}
+		if off < p.end {
+			return Position{Partition: p.id, Offset: off}, nil
+		}
+	}
+	return Position{}, ErrPastEnd
+}
+
+// IterateRange calls fn with every record whose key is in [from, to], in key order,
+// skipping control messages. It stops at the first error fn returns, which it returns
+// unless it is ErrStop.
+func (r *Reader) IterateRange(ctx context.Context, from, to string, fn func(gokafka.Message) error) error {
+	lo, err := r.parse(from)
+	if err != nil {
+		return err
+	}
+	hi, err := r.parse(to)
+	if err != nil {
+		return err
+	}
+	if r.compare(hi, lo) < 0 {
+		return fmt.Errorf("empty range: %s sorts after %s", from, to)
+	}
+	pos, err := r.seek(ctx, lo)
+	if err == ErrPastEnd {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, p := range r.parts {
+		if p.id < pos.Partition {
+			continue
+		}
+		start := p.first
+		if p.id == pos.Partition {
+			start = pos.Offset
+		}
+		done, err := r.scan(ctx, p, start, hi, fn)
+		if err == ErrStop {
+			return nil
+		}
+		if err != nil || done {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan calls fn with the records of p from offset start while their keys are at most
+// hi, and reports whether it stopped at a key above hi.
+func (r *Reader) scan(ctx context.Context, p *partition, start int64, hi key, fn func(gokafka.Message) error) (bool, error) {
+	if start >= p.end {
+		return false, nil
+	}
+	rd := gokafka.NewReader(gokafka.ReaderConfig{
+		Brokers:   r.cfg.Brokers,
+		Topic:     r.cfg.Topic,
+		Partition: p.id,
+		MinBytes:  1,
+		MaxBytes:  8 << 20,
+	})
+	defer rd.Close()
+	if err := rd.SetOffset(start); err != nil {
+		return false, err
+	}
+	for {
+		msg, err := rd.ReadMessage(ctx)
+		if err != nil {
+			return false, fmt.Errorf("%s partition %d: %w", r.cfg.Topic, p.id, err)
+		}
+		if !kclient.IsControl(msg) {
+			if r.compare(r.keyOf(msg.Value), hi) > 0 {
+				return true, nil
+			}
+			if err := fn(msg); err != nil {
+				return false, err
+			}
+		}
+		if msg.Offset >= p.end-1 {
+			return false, nil
+		}
+	}
+}
+
+// lowerBound returns the offset reading p from finds the first record with a key at
+// or above target, or p.end if there is none.
+func (r *Reader) lowerBound(ctx context.Context, p *partition, target key) (int64, error) {
+	lo, hi := p.first, p.end
+	// Index entries narrow the search to the records between the last entry below the
+	// target and the first at or above it
+	i := sort.Search(len(p.index), func(i int) bool {
+		k, err := r.parse(p.index[i].Key)
+		return err != nil || r.compare(k, target) >= 0
+	})
+	if i > 0 {
+		lo = p.index[i-1].Offset + 1
+	}
+	if i < len(p.index) {
+		hi = p.index[i].Offset
+	}
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		msg, ok, err := r.recordFrom(ctx, p, mid, hi)
+		if err != nil {
+			return 0, err
+		}
+		if ok && r.compare(r.keyOf(msg.Value), target) < 0 {
+			lo = msg.Offset + 1
+		} else {
+			// Offsets from mid up to the record hold no records below the target
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// recordFrom returns the first record of p at or after offset from and before until,
+// skipping control messages; ok is false if there is none.
+func (r *Reader) recordFrom(ctx context.Context, p *partition, from, until int64) (gokafka.Message, bool, error) {
+	if p.conn == nil {
+		conn, err := gokafka.DialLeader(ctx, "tcp", r.cfg.Brokers[0], r.cfg.Topic, p.id)
+		if err != nil {
+			return gokafka.Message{}, false, err
+		}
+		p.conn = conn
+	}
+	for from < until {
+		if _, err := p.conn.Seek(from, gokafka.SeekAbsolute); err != nil {
+			return gokafka.Message{}, false, err
+		}
+		p.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		batch := p.conn.ReadBatch(1, 1<<20)
+		next := from
+		for {
+			msg, err := batch.ReadMessage()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				batch.Close()
+				return gokafka.Message{}, false, err
+			}
+			if msg.Offset < from {
+				continue // the fetch starts at its record batch
+			}
+			if msg.Offset >= until {
+				batch.Close()
+				return gokafka.Message{}, false, nil
+			}
+			next = msg.Offset + 1
+			if !kclient.IsControl(msg) {
+				batch.Close()
+				return msg, true, nil
+			}
+		}
+		if err := batch.Close(); err != nil {
+			return gokafka.Message{}, false, err
+		}
+		if next == from {
+			break // nothing fetched: the rest of the range was compacted or is markers
+		}
+		from = next
+	}
+	return gokafka.Message{}, false, nil
+}
+
+// parse reads a key given as text: ids must be integers.
+func (r *Reader) parse(s string) (key, error) {
+	if r.cfg.Key != sortjob.KeyID {
+		return key{s: s}, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return key{}, fmt.Errorf("id key %q is not an integer", s)
+	}
+	return key{n: n}, nil
+}
+
+// keyOf extracts a record's key the way the sorter does.
+func (r *Reader) keyOf(value []byte) key {
+	switch r.cfg.Key {
+	case sortjob.KeyID:
+		return key{n: fastnum.LeadingInt(value)}
+	case sortjob.KeyName:
+		field := value
+		if i := bytes.IndexByte(field, ','); i != -1 {
+			field = field[i+1:]
+		}
+		if i := bytes.IndexByte(field, ','); i != -1 {
+			field = field[:i]
+		}
+		return key{s: string(field)}
+	}
+	// The continent is the last field
+	return key{s: string(value[bytes.LastIndexByte(value, ',')+1:])}
+}
+
+func (r *Reader) compare(a, b key) int {
+	if r.cfg.Key == sortjob.KeyID {
+		return cmp.Compare(a.n, b.n)
+	}
+	return cmp.Compare(a.s, b.s)
+}
@@ -0,0 +1,223 @@
+// Package sortjob runs external sort jobs from Go, for services that would otherwise
+// shell out to the sorter binary: a JobSpec names the source, the sink, the sort key
+// and the options, Validate reports every problem with it at once, and Run executes
+// it. It is the one package of this module meant to be imported from outside.
+package sortjob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"core-infra-project/internal/config"
+	kclient "core-infra-project/internal/kafka"
+	extSort "core-infra-project/internal/sort"
+
+	gokafka "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/compress"
+)
+
+// Key is the record field a job sorts by.
+type Key int
+
+const (
+	KeyID        Key = iota // numeric, the first CSV field
+	KeyName                 // lexicographic, the second
+	KeyContinent            // lexicographic, the fourth
+)
+
+// UnmarshalText parses id, name or continent.
+func (k *Key) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "id":
+		*k = KeyID
+	case "name":
+		*k = KeyName
+	case "continent":
+		*k = KeyContinent
+	default:
+		return fmt.Errorf("unknown sort key %q (want id, name or continent)", b)
+	}
+	return nil
+}
+
+func (k Key) String() string {
+	return [...]string{"id", "name", "continent"}[k]
+}
+
+// index returns the CSV field index the sort package takes for k.
+func (k Key) index() int {
+	return [...]int{0, 1, 3}[k]
+}
+
+// TieBreak orders records with equal keys; see the constants.
+type TieBreak = extSort.TieBreak
+
+const (
+	TiesAny    = extSort.TiesAny    // unspecified order (default)
+	TiesRecord = extSort.TiesRecord // whole record bytes, like GNU sort
+	TiesInput  = extSort.TiesInput  // read order, like sort -s
+)
+
+// Report summarizes a completed (or, on error, partial) job.
+type Report = extSort.Report
+
+// Source is where a job reads its records: a Kafka topic, or an archive.
+type Source struct {
+	Brokers []string
+	Topic   string
+	// Partitions restricts the read to these partitions, from their first offsets and
+	// without a consumer group; nil reads every partition through a fresh group.
+	Partitions []int
+	// Archive reads a compressed CSV file or a tarball of them (see the sorter's
+	// --source-archive) instead of Kafka.
+	Archive string
+}
+
+// Sink is where a job writes the sorted records.
+type Sink struct {
+	Brokers     []string
+	Topic       string
+	Compression compress.Compression // of the output batches; none by default
+	// Discard drops the output, counting it in the report, to time the sort alone.
+	Discard bool
+}
+
+// JobSpec is a sort job. The zero values of the options are the sorter's defaults.
+type JobSpec struct {
+	Source Source
+	Sink   Sink
+	Key    Key
+	// KeyPath reads the key from this dotted path of JSON records (e.g. "after.id")
+	// instead of the CSV field of Key, which then only selects numeric or text order.
+	KeyPath string
+	// TempDir holds the spilled chunks; by default extsort_<key> in os.TempDir().
+	TempDir string
+
+	Ties             TieBreak
+	SpillCompression compress.Compression
+	EncryptSpill     bool
+	LatestPerKey     bool
+	BatchSize        int       // records per output write; 0 uses the default
+	Quantiles        []float64 // of the sort key, returned in Report.Merge.Quantiles
+}
+
+// Validate returns every problem with s as one error, or nil.
+func (s *JobSpec) Validate() error {
+	var v config.Validator
+	src, dst := s.Source, s.Sink
+	v.Check(s.Key >= KeyID && s.Key <= KeyContinent, "unknown sort key %d", s.Key)
+	if src.Archive == "" {
+		v.Check(len(src.Brokers) > 0, "source: brokers are required unless an archive is read")
+		v.Check(src.Topic != "", "source: topic is required unless an archive is read")
+	} else {
+		v.Check(src.Partitions == nil, "source: partitions cannot be combined with an archive")
+	}
+	seen := map[int]bool{}
+	for _, p := range src.Partitions {
+		v.Check(p >= 0, "source: partition %d is negative", p)
+		v.Check(!seen[p], "source: partition %d is listed twice", p)
+		seen[p] = true
+	}
+	if !dst.Discard {
+		v.Check(len(dst.Brokers) > 0, "sink: brokers are required unless the output is discarded")
+		v.Check(dst.Topic != "", "sink: topic is required unless the output is discarded")
+		v.Check(dst.Topic == "" || src.Archive != "" || dst.Topic != src.Topic, "sink: topic %s is also the source", dst.Topic)
+	}
+	v.Check(s.Ties >= TiesAny && s.Ties <= TiesInput, "unknown tie break %d", s.Ties)
+	v.IntRange("batch size", int64(s.BatchSize), 0, 1_000_000)
+	for _, q := range s.Quantiles {
+		v.Check(q > 0 && q <= 1, "quantile %g is outside (0, 1]", q)
+	}
+	v.Check(len(s.Quantiles) == 0 || !s.LatestPerKey, "quantiles need the output size up front, which latest-per-key only knows after the merge")
+	return v.Err()
+}
+
+// Run validates s and sorts its source into its sink, stopping early with ctx's
+// error once ctx is done. Delivery failures reported by the sink's writer after the
+// merge are returned too. Spilled chunks are removed unless the job fails.
+func (s *JobSpec) Run(ctx context.Context) (*Report, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	tempDir := s.TempDir
+	if tempDir == "" {
+		tempDir = filepath.Join(os.TempDir(), "extsort_"+s.Key.String())
+	}
+	opts := extSort.Options{
+		KeyPath:          s.KeyPath,
+		Ties:             s.Ties,
+		SpillCompression: s.SpillCompression,
+		EncryptSpill:     s.EncryptSpill,
+		LatestPerKey:     s.LatestPerKey,
+		BatchSize:        s.BatchSize,
+		Quantiles:        s.Quantiles,
+		IsEndMarker:      kclient.EndMarker,
+		IsControl:        kclient.IsControl,
+	}
+
+	source, closeSource, err := s.openSource(ctx, &opts)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSource()
+
+	if s.Sink.Discard {
+		return extSort.ExternalSortContext(ctx, source, &extSort.DiscardSink{}, s.Key.index(), tempDir, opts)
+	}
+	writer := kclient.NewWriter(s.Sink.Brokers, s.Sink.Topic)
+	writer.Compression = s.Sink.Compression
+	// The writer is async, so delivery errors only reach its Completion
+	var mu sync.Mutex
+	var deliveryErr error
+	writer.Completion = func(msgs []gokafka.Message, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil && deliveryErr == nil {
+			deliveryErr = fmt.Errorf("writing %d records to %s: %w", len(msgs), s.Sink.Topic, err)
+		}
+	}
+	report, err := extSort.ExternalSortContext(ctx, source, writer, s.Key.index(), tempDir, opts)
+	// Closing flushes the writer, so every completion has been seen
+	closeErr := writer.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	return report, errors.Join(err, deliveryErr, closeErr)
+}
+
+// openSource opens the source of s, setting the end offsets in opts that tell the
+// sort when a Kafka source is drained.
+func (s *JobSpec) openSource(ctx context.Context, opts *extSort.Options) (extSort.Source, func(), error) {
+	src := s.Source
+	if src.Archive != "" {
+		archive, err := extSort.OpenArchive(src.Archive, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		return archive, func() { archive.Close() }, nil
+	}
+	lookup, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if src.Partitions != nil {
+		ps, err := kclient.OpenPartitionSet(lookup, src.Brokers, src.Topic, src.Partitions)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts.EndOffsets = ps.EndOffsets()
+		return ps, func() { ps.Close() }, nil
+	}
+	// A fresh group starts from the earliest offsets
+	group := "sortjob-" + s.Key.String() + "-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	ends, err := kclient.EndOffsets(lookup, src.Brokers, src.Topic, group)
+	if err != nil {
+		return nil, nil, fmt.Errorf("end offsets of %s: %w", src.Topic, err)
+	}
+	opts.EndOffsets = ends
+	reader := kclient.NewReader(src.Brokers, src.Topic, group)
+	return reader, func() { reader.Close() }, nil
+}